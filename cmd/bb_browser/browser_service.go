@@ -3,8 +3,11 @@ package main
 import (
 	"archive/tar"
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
@@ -12,10 +15,16 @@ import (
 	"io"
 	"log"
 	"math/rand"
+	"mime"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -23,8 +32,11 @@ import (
 	"github.com/buildbarn/bb-remote-execution/pkg/builder"
 	"github.com/buildbarn/bb-remote-execution/pkg/filesystem/access"
 	cas_proto "github.com/buildbarn/bb-remote-execution/pkg/proto/cas"
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
 	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
 	bb_http "github.com/buildbarn/bb-storage/pkg/http"
 	"github.com/buildbarn/bb-storage/pkg/proto/fsac"
@@ -33,12 +45,16 @@ import (
 	"github.com/buildkite/terminal-to-html"
 	"github.com/gorilla/mux"
 	"github.com/kballard/go-shellquote"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuin/goldmark"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
@@ -46,7 +62,29 @@ import (
 	"gonum.org/v1/plot/vg/draw"
 )
 
-var digestFunctionStrings = map[string]remoteexecution.DigestFunction_Value{}
+// maximumLogSizeBytes is the maximum size of logs that are loaded from
+// the Content Addressable Storage for display or download.
+const maximumLogSizeBytes = 100000
+
+var (
+	digestFunctionStrings = map[string]remoteexecution.DigestFunction_Value{}
+
+	// ansiEscapeSequence matches ANSI escape sequences, such as the
+	// ones used to change text color, so that they can be removed
+	// from logs that are downloaded as plain text.
+	ansiEscapeSequence = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+	browserServicePrometheusMetrics sync.Once
+
+	browserServiceLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "browser",
+			Name:      "lookups_total",
+			Help:      "Number of objects looked up by the web service, by entity type and whether the object was found.",
+		},
+		[]string{"entity_type", "outcome"})
+)
 
 func init() {
 	for _, digestFunction := range digest.SupportedDigestFunctions {
@@ -54,27 +92,118 @@ func init() {
 	}
 }
 
-func getDigestFromRequest(req *http.Request) (digest.Digest, error) {
-	vars := mux.Vars(req)
-	instanceNameStr := strings.TrimSuffix(vars["instanceName"], "/")
+// instanceNameCookie is the name of the cookie in which the instance
+// name that was used most recently is stored.
+const instanceNameCookie = "instance_name"
+
+// getInstanceNameFromRequest returns the instance name that is
+// embedded in the URL of a request. URLs that do not contain an
+// instance name refer to the empty instance name.
+func (s *BrowserService) getInstanceNameFromRequest(req *http.Request) (digest.InstanceName, error) {
+	instanceNameStr := strings.TrimSuffix(mux.Vars(req)["instanceName"], "/")
 	instanceName, err := digest.NewInstanceName(instanceNameStr)
 	if err != nil {
-		return digest.BadDigest, util.StatusWrapf(err, "Invalid instance name %#v", instanceNameStr)
+		return digest.EmptyInstanceName, util.StatusWrapf(err, "Invalid instance name %#v", instanceNameStr)
+	}
+	if s.allowedInstanceNames != nil && !s.allowedInstanceNames.ContainsExact(instanceName) {
+		return digest.EmptyInstanceName, status.Errorf(codes.PermissionDenied, "Instance name %#v may not be browsed", instanceNameStr)
+	}
+	if s.isDownloadRequest(req) {
+		if err := auth.AuthorizeSingleInstanceName(extractContextFromRequest(req), s.downloadAuthorizer, instanceName); err != nil {
+			return digest.EmptyInstanceName, util.StatusWrapf(err, "Objects under instance name %#v may not be downloaded", instanceNameStr)
+		}
+	} else {
+		if err := auth.AuthorizeSingleInstanceName(extractContextFromRequest(req), s.browseAuthorizer, instanceName); err != nil {
+			return digest.EmptyInstanceName, util.StatusWrapf(err, "Instance name %#v may not be browsed", instanceNameStr)
+		}
+	}
+	return instanceName, nil
+}
+
+// timeLocationCookie is the name of the cookie in which users may
+// store the time zone in which timestamps are displayed.
+const timeLocationCookie = "tz"
+
+// getTimeLocationFromRequest returns the time zone in which timestamps
+// should be displayed, which may be provided through the "tz" query
+// parameter or cookie as an IANA time zone name. Timestamps are
+// displayed in UTC by default, or if the time zone is invalid. In the
+// latter case, an error is returned that may be displayed.
+func getTimeLocationFromRequest(req *http.Request) (*time.Location, error) {
+	name := req.URL.Query().Get("tz")
+	if name == "" {
+		if cookie, err := req.Cookie(timeLocationCookie); err == nil {
+			name = cookie.Value
+		}
+	}
+	if name == "" {
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC, status.Errorf(codes.InvalidArgument, "Unknown time zone %#v", name)
+	}
+	return location, nil
+}
+
+// rememberInstanceName is a middleware that stores the instance name
+// of requests in a cookie, so that subsequent requests whose URL does
+// not contain an instance name use the same instance.
+func (s *BrowserService) rememberInstanceName(base http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if instanceNameStr := strings.TrimSuffix(mux.Vars(req)["instanceName"], "/"); instanceNameStr != "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     instanceNameCookie,
+				Value:    instanceNameStr,
+				Path:     s.getRoutePrefix(req),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		base.ServeHTTP(w, req)
+	})
+}
+
+// getDigestFunctionFromRequest returns the instance name and digest
+// function that are embedded in the URL of a request.
+func (s *BrowserService) getDigestFunctionFromRequest(req *http.Request) (digest.Function, error) {
+	instanceName, err := s.getInstanceNameFromRequest(req)
+	if err != nil {
+		return digest.Function{}, err
 	}
-	digestFunctionStr := vars["digestFunction"]
+	digestFunctionStr := mux.Vars(req)["digestFunction"]
 	digestFunctionEnum, ok := digestFunctionStrings[digestFunctionStr]
 	if !ok {
-		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Unknown digest function %#v", digestFunctionStr)
+		return digest.Function{}, status.Errorf(codes.InvalidArgument, "Unknown digest function %#v", digestFunctionStr)
 	}
-	digestFunction, err := instanceName.GetDigestFunction(digestFunctionEnum, 0)
+	return instanceName.GetDigestFunction(digestFunctionEnum, 0)
+}
+
+func (s *BrowserService) getDigestFromRequest(req *http.Request) (digest.Digest, error) {
+	vars := mux.Vars(req)
+	digestFunction, err := s.getDigestFunctionFromRequest(req)
 	if err != nil {
 		return digest.BadDigest, err
 	}
 	sizeBytes, err := strconv.ParseInt(vars["sizeBytes"], 10, 64)
 	if err != nil {
-		return digest.BadDigest, util.StatusWrapf(err, "Invalid blob size %#v", vars["sizeBytes"])
+		return digest.BadDigest, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid blob size %#v", vars["sizeBytes"])
 	}
-	return digestFunction.NewDigest(vars["hash"], sizeBytes)
+	blobDigest, err := digestFunction.NewDigest(vars["hash"], sizeBytes)
+	if err != nil {
+		return digest.BadDigest, err
+	}
+
+	// Digests of empty blobs can only have a single hash. Any other
+	// hash indicates that the client computed the digest
+	// incorrectly, which would otherwise lead to confusing errors
+	// returned by storage.
+	if sizeBytes == 0 {
+		if emptyDigest := digestFunction.NewGenerator(0).Sum(); blobDigest.GetHashString() != emptyDigest.GetHashString() {
+			return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Blob has size 0, but its hash is not equal to %#v, which is the hash of empty content", emptyDigest.GetHashString())
+		}
+	}
+	return blobDigest, nil
 }
 
 // Generates a Context from an incoming HTTP request, forwarding any
@@ -94,38 +223,199 @@ func extractContextFromRequest(req *http.Request) context.Context {
 // can show the details of actions and download their input and output
 // files.
 type BrowserService struct {
-	contentAddressableStorage    blobstore.BlobAccess
-	actionCache                  blobstore.BlobAccess
-	initialSizeClassCache        blobstore.BlobAccess
-	fileSystemAccessCache        blobstore.BlobAccess
-	maximumMessageSizeBytes      int
-	templates                    *template.Template
-	bbClientdInstanceNamePatcher digest.InstanceNamePatcher
+	contentAddressableStorage      blobstore.BlobAccess
+	actionCache                    blobstore.BlobAccess
+	initialSizeClassCache          blobstore.BlobAccess
+	fileSystemAccessCache          blobstore.BlobAccess
+	maximumMessageSizeBytes        int
+	templates                      TemplateExecutor
+	bbClientdInstanceNamePatcher   digest.InstanceNamePatcher
+	clock                          clock.Clock
+	routePrefix                    string
+	basePath                       string
+	collapsedLogMinimumLines       int
+	defaultInstanceName            digest.InstanceName
+	rememberLastInstanceName       bool
+	allowedInstanceNames           *digest.InstanceNameTrie
+	contentSniffingPrefixSizeBytes int
+	maximumCompressedFileSizeBytes int64
+	previewedOutputFilePatterns    []string
+	standardInputPath              string
+	browseAuthorizer               auth.Authorizer
+	downloadAuthorizer             auth.Authorizer
+
+	listTarballGenerationsAuthorizer  auth.Authorizer
+	cancelTarballGenerationAuthorizer auth.Authorizer
+
+	// Routes of endpoints that are considered downloads. This map is
+	// only mutated while routes are registered.
+	downloadRoutes map[*mux.Route]bool
+
+	directoryStatsCacheLock        sync.Mutex
+	directoryStatsCache            map[string]*treeStats
+	directoryStatsCacheEvictionSet eviction.Set[string]
+
+	tarballGenerationsLock   sync.Mutex
+	nextTarballGenerationID  uint64
+	activeTarballGenerations map[uint64]*activeTarballGeneration
+
+	tarballGenerationSlots                   chan struct{}
+	maximumTarballGenerationQueueingDuration time.Duration
+}
+
+// BrowserServiceOptions contains the settings of BrowserService that
+// may be adjusted through the configuration file.
+type BrowserServiceOptions struct {
+	// The path under which the router is exposed. It must start and
+	// end with a slash.
+	RoutePrefix string
+	// The path prefix that a reverse proxy strips from requests
+	// before forwarding them. It must either be empty, or start with
+	// a slash and not end with one.
+	BasePath string
+	// Logs consisting of more than this number of lines are
+	// rendered collapsed. Zero disables collapsing.
+	CollapsedLogMinimumLines int
+	// The instance name used to construct the URLs shown on the
+	// welcome page.
+	DefaultInstanceName digest.InstanceName
+	// Whether the instance name of the most recent request should
+	// be used instead of the default instance name on the welcome
+	// page.
+	RememberLastInstanceName bool
+	// If set, requests for instance names not contained in this
+	// set are rejected.
+	AllowedInstanceNames *digest.InstanceNameTrie
+	// The number of bytes of a file that are read to detect its
+	// content type.
+	ContentSniffingPrefixSizeBytes int
+	// Compressed files larger than this size are not decompressed.
+	MaximumCompressedFileSizeBytes int64
+	// Output files whose filename matches one of these patterns are
+	// displayed inline on action pages.
+	PreviewedOutputFilePatterns []string
+	// If set, the file at this path in the input root of an action
+	// is displayed as its standard input.
+	StandardInputPath string
+	// If set, requests for tarballs exceeding this number of
+	// concurrent generations are queued for at most
+	// MaximumTarballGenerationQueueingDuration, after which they
+	// are rejected.
+	MaximumConcurrentTarballGenerations      int
+	MaximumTarballGenerationQueueingDuration time.Duration
+	// Authorizers that are invoked for each of the operations
+	// offered by the service.
+	BrowseAuthorizer                  auth.Authorizer
+	DownloadAuthorizer                auth.Authorizer
+	ListTarballGenerationsAuthorizer  auth.Authorizer
+	CancelTarballGenerationAuthorizer auth.Authorizer
+	// The clock used to record when tarball generations started.
+	Clock clock.Clock
 }
 
 // NewBrowserService constructs a BrowserService that accesses storage
-// through a set of handles.
-func NewBrowserService(contentAddressableStorage, actionCache, initialSizeClassCache, fileSystemAccessCache blobstore.BlobAccess, maximumMessageSizeBytes int, templates *template.Template, bbClientdInstanceNamePatcher digest.InstanceNamePatcher, router *mux.Router) *BrowserService {
+// through a set of handles, and registers its routes with a router.
+// Additional settings, such as limits and authorizers, are provided
+// through BrowserServiceOptions.
+func NewBrowserService(contentAddressableStorage, actionCache, initialSizeClassCache, fileSystemAccessCache blobstore.BlobAccess, maximumMessageSizeBytes int, templates TemplateExecutor, bbClientdInstanceNamePatcher digest.InstanceNamePatcher, options *BrowserServiceOptions, router *mux.Router) *BrowserService {
+	browserServicePrometheusMetrics.Do(func() {
+		prometheus.MustRegister(browserServiceLookupsTotal)
+	})
+
 	s := &BrowserService{
-		contentAddressableStorage:    contentAddressableStorage,
-		actionCache:                  actionCache,
-		initialSizeClassCache:        initialSizeClassCache,
-		fileSystemAccessCache:        fileSystemAccessCache,
-		maximumMessageSizeBytes:      maximumMessageSizeBytes,
-		templates:                    templates,
-		bbClientdInstanceNamePatcher: bbClientdInstanceNamePatcher,
+		contentAddressableStorage:      contentAddressableStorage,
+		actionCache:                    actionCache,
+		initialSizeClassCache:          initialSizeClassCache,
+		fileSystemAccessCache:          fileSystemAccessCache,
+		maximumMessageSizeBytes:        maximumMessageSizeBytes,
+		templates:                      templates,
+		bbClientdInstanceNamePatcher:   bbClientdInstanceNamePatcher,
+		clock:                          options.Clock,
+		routePrefix:                    options.RoutePrefix,
+		basePath:                       options.BasePath,
+		collapsedLogMinimumLines:       options.CollapsedLogMinimumLines,
+		defaultInstanceName:            options.DefaultInstanceName,
+		rememberLastInstanceName:       options.RememberLastInstanceName,
+		allowedInstanceNames:           options.AllowedInstanceNames,
+		contentSniffingPrefixSizeBytes: options.ContentSniffingPrefixSizeBytes,
+		maximumCompressedFileSizeBytes: options.MaximumCompressedFileSizeBytes,
+		previewedOutputFilePatterns:    options.PreviewedOutputFilePatterns,
+		standardInputPath:              options.StandardInputPath,
+		browseAuthorizer:               options.BrowseAuthorizer,
+		downloadAuthorizer:             options.DownloadAuthorizer,
+
+		listTarballGenerationsAuthorizer:  options.ListTarballGenerationsAuthorizer,
+		cancelTarballGenerationAuthorizer: options.CancelTarballGenerationAuthorizer,
+
+		downloadRoutes:                 map[*mux.Route]bool{},
+		directoryStatsCache:            map[string]*treeStats{},
+		directoryStatsCacheEvictionSet: eviction.NewLRUSet[string](),
+		activeTarballGenerations:       map[uint64]*activeTarballGeneration{},
+
+		maximumTarballGenerationQueueingDuration: options.MaximumTarballGenerationQueueingDuration,
+	}
+	if options.MaximumConcurrentTarballGenerations > 0 {
+		s.tarballGenerationSlots = make(chan struct{}, options.MaximumConcurrentTarballGenerations)
 	}
+	if options.RememberLastInstanceName {
+		router.Use(s.rememberInstanceName)
+	}
+	router.NotFoundHandler = http.HandlerFunc(s.handleNotFound)
 	router.HandleFunc("/", s.handleWelcome)
+	router.HandleFunc("/admin/tarballs/", s.handleListTarballGenerations).Methods(http.MethodGet)
+	router.HandleFunc("/admin/tarballs/{id}/cancel", s.handleCancelTarballGeneration).Methods(http.MethodPost)
+	router.HandleFunc("/permalink/{instanceName:(?:.*?/)?}blobs/{digestFunction}/{pageType}/{hash}-{sizeBytes}/{suffix:.*}", s.handlePermalink)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/action/{hash}-{sizeBytes}/", s.handleAction)
+	s.handleDownloadFunc(router, "/{instanceName:(?:.*?/)?}blobs/{digestFunction}/action/{hash}-{sizeBytes}/output/{outputPath:.+}", s.handleActionOutputFile)
+	s.handleDownloadFunc(router, "/{instanceName:(?:.*?/)?}blobs/{digestFunction}/batch_download/", s.handleBatchDownload).Methods(http.MethodPost)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/blob/{hash}-{sizeBytes}/", s.handleBlob)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/command/{hash}-{sizeBytes}/", s.handleCommand)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/command_of/{hash}-{sizeBytes}/", s.handleCommandOfAction)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/diff_action/{hash}-{sizeBytes}/{otherHash}-{otherSizeBytes}/", s.handleActionDiff)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/directory/{hash}-{sizeBytes}/", s.handleDirectory)
-	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/file/{hash}-{sizeBytes}/{name}", s.handleFile)
+	s.handleDownloadFunc(router, "/{instanceName:(?:.*?/)?}blobs/{digestFunction}/file/{hash}-{sizeBytes}/{name}", s.handleFile)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/previous_execution_stats/{hash}-{sizeBytes}/", s.handlePreviousExecutionStats)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/tree/{hash}-{sizeBytes}/{subdirectory:(?:.*/)?}", s.handleTree)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/historical_execute_response/{hash}-{sizeBytes}/", s.handleHistoricalExecuteResponse)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/input_manifest/{hash}-{sizeBytes}/", s.handleInputManifest)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/log/{hash}-{sizeBytes}/", s.handleLog)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/missing_blobs/{hash}-{sizeBytes}/", s.handleMissingBlobs)
+
+	// Redirect URLs of pages that lack a trailing slash to their
+	// canonical form. This is not done for routes of files and
+	// trees, as the final pathname components of those are
+	// meaningful.
+	for _, pageType := range []string{"action", "command", "directory", "historical_execute_response"} {
+		router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/"+pageType+"/{hash}-{sizeBytes}", handleRedirectToTrailingSlash)
+	}
 	return s
 }
 
+// handleDownloadFunc registers a route for an endpoint that may cause
+// large amounts of data to be read from storage. Requests for such
+// routes are subject to the download authorizer and rate limiter,
+// instead of the ones for browsing pages.
+func (s *BrowserService) handleDownloadFunc(router *mux.Router, pathTemplate string, f func(http.ResponseWriter, *http.Request)) *mux.Route {
+	route := router.HandleFunc(pathTemplate, f)
+	s.downloadRoutes[route] = true
+	return route
+}
+
+// handleRedirectToTrailingSlash permanently redirects a request to the
+// same URL with a trailing slash appended, preserving the query. The
+// Location header is relative to the current URL, so that the redirect
+// also works when bb_browser is placed behind a reverse proxy that
+// strips a path prefix.
+func handleRedirectToTrailingSlash(w http.ResponseWriter, req *http.Request) {
+	escapedPath := req.URL.EscapedPath()
+	location := escapedPath[strings.LastIndexByte(escapedPath, '/')+1:] + "/"
+	if rawQuery := req.URL.RawQuery; rawQuery != "" {
+		location += "?" + rawQuery
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
 var (
 	invalidReplacementComponent = path.MustNewComponent("???")
 	commandDirectoryComponent   = path.MustNewComponent("command")
@@ -134,15 +424,163 @@ var (
 	treeDirectoryComponent      = path.MustNewComponent("tree")
 )
 
-func (s *BrowserService) renderError(w http.ResponseWriter, err error) {
+// observeLookup increments Prometheus metrics for an object that was
+// requested to be displayed, tracking whether it was present in
+// storage. This makes it possible to see how often users browse to
+// objects that have been evicted.
+func observeLookup(entityType string, err error) {
+	if err == nil {
+		browserServiceLookupsTotal.WithLabelValues(entityType, "found").Inc()
+	} else if status.Code(err) == codes.NotFound {
+		browserServiceLookupsTotal.WithLabelValues(entityType, "not_found").Inc()
+	}
+}
+
+// addPreloadLink adds a Link header to an HTTP response, indicating
+// that the client should fetch the provided URL ahead of time. As the
+// linked pages are not a specific kind of subresource, they are
+// preloaded as if they were requested through fetch().
+func addPreloadLink(h http.Header, url string) {
+	h.Add("Link", fmt.Sprintf("<%s>; rel=preload; as=fetch", url))
+}
+
+// writeJSON writes a value to an HTTP response, encoded as JSON. If
+// the client provided a comma separated list of field names through
+// the "fields" query parameter, only those top-level fields of the
+// object are returned.
+func writeJSON(w http.ResponseWriter, req *http.Request, v interface{}) {
+	if fields := req.URL.Query().Get("fields"); fields != "" {
+		filtered, err := filterJSONFields(v, strings.Split(fields, ","))
+		if err != nil {
+			log.Print(err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+		v = filtered
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+	}
+}
+
+// writeProtoJSON writes a Protobuf message to an HTTP response, using
+// the canonical JSON representation of Protobuf.
+func writeProtoJSON(w http.ResponseWriter, req *http.Request, m proto.Message) {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, req, json.RawMessage(data))
+}
+
+// filterJSONFields reduces the JSON representation of a value to a
+// subset of its top-level fields. Field names that are not present are
+// reported through a "warnings" field, as opposed to causing the
+// request to fail. Values that are not encoded as JSON objects are
+// returned as is.
+func filterJSONFields(v interface{}, fields []string) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		return v, nil
+	}
+	filtered := map[string]json.RawMessage{}
+	var warnings []string
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if value, ok := object[field]; ok {
+			filtered[field] = value
+		} else if field != "" {
+			warnings = append(warnings, fmt.Sprintf("Unknown field %#v", field))
+		}
+	}
+	if len(warnings) > 0 {
+		encodedWarnings, err := json.Marshal(warnings)
+		if err != nil {
+			return nil, err
+		}
+		filtered["warnings"] = encodedWarnings
+	}
+	return filtered, nil
+}
+
+// shouldShowRawMessages returns whether the client requested that
+// Protobuf messages are displayed in their entirety, in addition to
+// the fields that are rendered explicitly. This is useful for
+// inspecting fields that the templates don't display.
+func shouldShowRawMessages(req *http.Request) bool {
+	return req.URL.Query().Get("raw") == "1"
+}
+
+// acceptsJSON returns whether the client requested that responses are
+// returned as JSON, either through the Accept header or by providing
+// "format=json" as a query parameter.
+func acceptsJSON(req *http.Request) bool {
+	if req.URL.Query().Get("format") == "json" {
+		return true
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accept); err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonError is the body of error responses returned to clients that
+// accept JSON.
+type jsonError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	HTTPStatus int    `json:"httpStatus"`
+}
+
+func (s *BrowserService) renderError(w http.ResponseWriter, req *http.Request, err error) {
 	st := status.Convert(err)
-	w.WriteHeader(bb_http.StatusCodeFromGRPCCode(st.Code()))
+	httpStatus := bb_http.StatusCodeFromGRPCCode(st.Code())
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	if err := s.templates.ExecuteTemplate(w, "error.html", st); err != nil {
+	if acceptsJSON(req) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(httpStatus)
+		if err := json.NewEncoder(w).Encode(jsonError{
+			Code:       st.Code().String(),
+			Message:    st.Message(),
+			HTTPStatus: httpStatus,
+		}); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(httpStatus)
+	if err := s.templates.ExecuteTemplate(w, "page_error.html", struct {
+		Status         *status.Status
+		HTTPStatus     int
+		HTTPStatusText string
+		RoutePrefix    string
+	}{
+		Status:         st,
+		HTTPStatus:     httpStatus,
+		HTTPStatusText: http.StatusText(httpStatus),
+		RoutePrefix:    s.getRoutePrefix(req),
+	}); err != nil {
 		log.Print(err)
 	}
 }
 
+// handleNotFound is invoked for requests whose URL does not match any
+// of the routes, so that they are answered with the same error page as
+// other errors, as opposed to the plain text response of the router.
+func (s *BrowserService) handleNotFound(w http.ResponseWriter, req *http.Request) {
+	s.renderError(w, req, status.Errorf(codes.NotFound, "No page exists at path %#v", req.URL.Path))
+}
+
 // getBBClientdBlobPath returns a relative path of the shape
 // "${instanceName}/blobs/${digestFunction}/${blobType}/${hash}-${sizeBytes}".
 // This corresponds to the pathname scheme that can be used to access
@@ -177,24 +615,283 @@ func formatBBClientdPath(p *path.Trace) string {
 	return "~/bb_clientd/cas/" + shellquote.Join(p.String())
 }
 
+// getRoutePrefix returns the absolute path under which the web service
+// is exposed to the client. When bb_browser is placed behind a reverse
+// proxy that strips a path prefix, the stripped prefix is either
+// configured as the base path, or announced by the proxy through the
+// X-Forwarded-Prefix header.
+//
+// Links between pages should preferably remain relative, so that they
+// work regardless of the prefix. This function should only be used in
+// places where absolute paths are needed.
+func (s *BrowserService) getRoutePrefix(req *http.Request) string {
+	basePath := s.basePath
+	if forwardedPrefix := strings.Trim(req.Header.Get("X-Forwarded-Prefix"), "/"); forwardedPrefix != "" {
+		basePath = "/" + forwardedPrefix
+	}
+	return basePath + s.routePrefix
+}
+
+// getPermalink returns the fully qualified URL of a page displaying an
+// object. As opposed to the URL of the current request, it always
+// contains the instance name and digest in canonical form.
+//
+// The suffix is appended to the URL, and can be used to refer to
+// files and subdirectories of trees.
+func (s *BrowserService) getPermalink(req *http.Request, pageType string, blobDigest digest.Digest, suffix string) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if forwardedProto := req.Header.Get("X-Forwarded-Proto"); forwardedProto != "" {
+		scheme = forwardedProto
+	}
+	host := req.Host
+	if forwardedHost := req.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+
+	var sb strings.Builder
+	sb.WriteString(scheme)
+	sb.WriteString("://")
+	sb.WriteString(host)
+	sb.WriteString(s.getRoutePrefix(req))
+	if instanceName := blobDigest.GetInstanceName().String(); instanceName != "" {
+		sb.WriteString(instanceName)
+		sb.WriteByte('/')
+	}
+	fmt.Fprintf(
+		&sb,
+		"blobs/%s/%s/%s-%d/",
+		strings.ToLower(blobDigest.GetDigestFunction().GetEnumValue().String()),
+		pageType,
+		blobDigest.GetHashString(),
+		blobDigest.GetSizeBytes())
+	if suffix != "" {
+		components := strings.Split(suffix, "/")
+		for i, component := range components {
+			components[i] = url.PathEscape(component)
+		}
+		sb.WriteString(strings.Join(components, "/"))
+	}
+	return sb.String()
+}
+
+// permalinkPageTypes contains the types of pages for which permalinks
+// may be requested, and whether they accept a suffix.
+var permalinkPageTypes = map[string]bool{
+	"action":                      false,
+	"command":                     false,
+	"directory":                   false,
+	"file":                        true,
+	"historical_execute_response": false,
+	"log":                         false,
+	"tree":                        true,
+}
+
+// handlePermalink returns the permalink of a page as plain text, or as
+// a JSON object if requested. This permits tooling to generate
+// canonical links to objects, using the same rules for selecting the
+// instance name as the pages themselves.
+func (s *BrowserService) handlePermalink(w http.ResponseWriter, req *http.Request) {
+	blobDigest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	vars := mux.Vars(req)
+	pageType, suffix := vars["pageType"], vars["suffix"]
+	acceptsSuffix, ok := permalinkPageTypes[pageType]
+	if !ok {
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Unknown page type %#v", pageType))
+		return
+	}
+	if suffix != "" && !acceptsSuffix {
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Pages of type %#v do not accept a suffix", pageType))
+		return
+	}
+	if pageType == "file" && suffix == "" {
+		s.renderError(w, req, status.Error(codes.InvalidArgument, "Pages of type \"file\" require a filename"))
+		return
+	}
+
+	permalink := s.getPermalink(req, pageType, blobDigest, suffix)
+	if acceptsJSON(req) {
+		writeJSON(w, req, struct {
+			URL string `json:"url"`
+		}{
+			URL: permalink,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, permalink+"\n")
+}
+
+// getWelcomeInstanceName returns the instance name that is used to
+// construct the URLs shown on the welcome page. This is the instance
+// name that was used most recently, or the default instance name if
+// none is known.
+func (s *BrowserService) getWelcomeInstanceName(req *http.Request) digest.InstanceName {
+	if s.rememberLastInstanceName {
+		if cookie, err := req.Cookie(instanceNameCookie); err == nil {
+			if instanceName, err := digest.NewInstanceName(cookie.Value); err == nil {
+				return instanceName
+			}
+		}
+	}
+	return s.defaultInstanceName
+}
+
 func (s *BrowserService) handleWelcome(w http.ResponseWriter, req *http.Request) {
-	if err := s.templates.ExecuteTemplate(w, "page_welcome.html", nil); err != nil {
+	// Only display a placeholder for the instance name in URLs if
+	// no instance name is known.
+	instanceNamePrefix := "${instance_name}/"
+	if instanceName := s.getWelcomeInstanceName(req); instanceName != digest.EmptyInstanceName {
+		instanceNamePrefix = instanceName.String() + "/"
+	}
+	if err := s.templates.ExecuteTemplate(w, "page_welcome.html", struct {
+		RoutePrefix        string
+		InstanceNamePrefix string
+	}{
+		RoutePrefix:        s.getRoutePrefix(req),
+		InstanceNamePrefix: instanceNamePrefix,
+	}); err != nil {
 		log.Print(err)
 	}
 }
 
 type commandInfo struct {
-	Digest        digest.Digest
-	Command       *remoteexecution.Command
-	BBClientdPath string
+	Digest         digest.Digest
+	Command        *remoteexecution.Command
+	ContainerImage *containerImageInfo
+	BBClientdPath  string
+	Permalink      string
+	ShowRawMessage bool
+}
+
+// containerImagePlatformPropertyNames contains the names of platform
+// properties that are commonly used to specify the container image in
+// which an action needs to run.
+var containerImagePlatformPropertyNames = []string{
+	"container-image",
+	"container_image",
+	"docker-image",
+	"image",
+}
+
+// containerImageInfo contains a reference to a container image that is
+// specified in the platform properties of an action or command,
+// decomposed into its components.
+type containerImageInfo struct {
+	Reference  string
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// getContainerImage returns the container image in which an action
+// needs to run, if specified in its platform properties. Knowing the
+// container image is essential for reproducing an action locally.
+func getContainerImage(platform *remoteexecution.Platform) *containerImageInfo {
+	for _, name := range containerImagePlatformPropertyNames {
+		for _, property := range platform.GetProperties() {
+			if property.Name == name && property.Value != "" {
+				return parseContainerImageReference(property.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// parseContainerImageReference decomposes a container image reference
+// of the form [docker://][registry/]repository[:tag][@digest].
+func parseContainerImageReference(reference string) *containerImageInfo {
+	image := containerImageInfo{Reference: reference}
+	remainder := strings.TrimPrefix(reference, "docker://")
+	remainder, image.Digest, _ = strings.Cut(remainder, "@")
+	// Colons may also be part of the registry's port number.
+	if colon := strings.LastIndexByte(remainder, ':'); colon > strings.LastIndexByte(remainder, '/') {
+		remainder, image.Tag = remainder[:colon], remainder[colon+1:]
+	}
+	// The first component is only a registry if it looks like a
+	// hostname, as Docker Hub images may omit the registry.
+	if registry, repository, ok := strings.Cut(remainder, "/"); ok && (strings.ContainsAny(registry, ".:") || registry == "localhost") {
+		image.Registry, image.Repository = registry, repository
+	} else {
+		image.Repository = remainder
+	}
+	return &image
 }
 
 type directoryInfo struct {
 	Digest                           digest.Digest
+	InstanceName                     digest.InstanceName
 	Directory                        *remoteexecution.Directory
 	BBClientdPath                    string
+	Permalink                        string
 	FileSystemAccessProfileReference *query.FileSystemAccessProfileReference
 	BloomFilter                      *access.BloomFilterReader
+	// Statistics on the full contents of the directory, which
+	// are only computed if requested explicitly.
+	RecursiveStats *treeStats
+	// For every file, the number of other files in the same
+	// directory having the same contents and executable bit.
+	FileDuplicatesCounts map[string]int
+	ShowRawMessage       bool
+}
+
+// GetEntriesCount returns the total number of files, directories and
+// symbolic links contained in the directory.
+func (di *directoryInfo) GetEntriesCount() int {
+	return len(di.Directory.Directories) + len(di.Directory.Symlinks) + len(di.Directory.Files)
+}
+
+// GetFilesSizeBytes returns the total size of the files contained in
+// the directory. Files in child directories are not included.
+func (di *directoryInfo) GetFilesSizeBytes() int64 {
+	var sizeBytes int64
+	for _, fileNode := range di.Directory.Files {
+		sizeBytes += fileNode.Digest.GetSizeBytes()
+	}
+	return sizeBytes
+}
+
+// GetFileDuplicatesCount returns the number of other files in the
+// directory that are identical to the file with the provided name.
+func (di *directoryInfo) GetFileDuplicatesCount(name string) int {
+	return di.FileDuplicatesCounts[name]
+}
+
+// computeFileDuplicatesCounts computes, for every file in a directory,
+// how many other files in the same directory refer to the same blob
+// and have the same executable bit. Files for which no duplicates exist
+// are omitted.
+func computeFileDuplicatesCounts(directory *remoteexecution.Directory) map[string]int {
+	type fileKey struct {
+		hash         string
+		sizeBytes    int64
+		isExecutable bool
+	}
+	namesByKey := map[fileKey][]string{}
+	for _, fileNode := range directory.Files {
+		key := fileKey{
+			hash:         fileNode.Digest.GetHash(),
+			sizeBytes:    fileNode.Digest.GetSizeBytes(),
+			isExecutable: fileNode.IsExecutable,
+		}
+		namesByKey[key] = append(namesByKey[key], fileNode.Name)
+	}
+	counts := map[string]int{}
+	for _, names := range namesByKey {
+		if len(names) > 1 {
+			for _, name := range names {
+				counts[name] = len(names) - 1
+			}
+		}
+	}
+	return counts
 }
 
 // GetChildPathHashes returns path hashes for a file or directory
@@ -228,51 +925,218 @@ type logInfo struct {
 	Digest   digest.Digest
 	TooLarge bool
 	NotFound bool
-	HTML     template.HTML
+	// Binary log files, such as core dumps attached by workers, are
+	// not rendered inline, but offered as a download.
+	NotHumanReadable bool
+	LineCount        int
+	Collapsed        bool
+	HTML             template.HTML
+}
+
+// newRenderedLogInfo converts the contents of a log file containing
+// ANSI escape sequences to HTML. Logs that consist of many lines are
+// marked as collapsed, so that they don't make the page excessively
+// long.
+func (s *BrowserService) newRenderedLogInfo(name string, digest digest.Digest, data []byte) *logInfo {
+	lineCount := countLines(data)
+	return &logInfo{
+		Name:      name,
+		Digest:    digest,
+		LineCount: lineCount,
+		Collapsed: s.collapsedLogMinimumLines > 0 && lineCount > s.collapsedLogMinimumLines,
+		HTML:      template.HTML(terminal.Render(data)),
+	}
 }
 
 func (s *BrowserService) handleAction(w http.ResponseWriter, req *http.Request) {
-	digest, err := getDigestFromRequest(req)
+	digest, err := s.getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
 	ctx := extractContextFromRequest(req)
 	var actionResult *remoteexecution.ActionResult
-	if m, err := s.actionCache.Get(ctx, digest).ToProto(
+	m, err := s.actionCache.Get(ctx, digest).ToProto(
 		&remoteexecution.ActionResult{},
-		s.maximumMessageSizeBytes); err == nil {
+		s.maximumMessageSizeBytes)
+	observeLookup("action_result", err)
+	if err == nil {
 		actionResult = m.(*remoteexecution.ActionResult)
 	} else if status.Code(err) != codes.NotFound {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
 	s.handleActionCommon(w, req, digest, &remoteexecution.ExecuteResponse{
 		Result: actionResult,
-	}, false)
+	}, false, s.getPermalink(req, "action", digest, ""))
 }
 
 func (s *BrowserService) handleHistoricalExecuteResponse(w http.ResponseWriter, req *http.Request) {
-	digest, err := getDigestFromRequest(req)
+	digest, err := s.getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 	ctx := extractContextFromRequest(req)
 	m, err := s.contentAddressableStorage.Get(ctx, digest).ToProto(&cas_proto.HistoricalExecuteResponse{}, s.maximumMessageSizeBytes)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 	historicalExecuteResponse := m.(*cas_proto.HistoricalExecuteResponse)
 	actionDigest, err := digest.GetDigestFunction().NewDigestFromProto(historicalExecuteResponse.ActionDigest)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
+		return
+	}
+	s.handleActionCommon(w, req, actionDigest, historicalExecuteResponse.ExecuteResponse, true, s.getPermalink(req, "historical_execute_response", digest, ""))
+}
+
+// outputDigestDiffInfo contains the digests of a single output path in
+// two action results that are being compared.
+type outputDigestDiffInfo struct {
+	Path    string
+	DigestA *remoteexecution.Digest
+	DigestB *remoteexecution.Digest
+	Differs bool
+}
+
+// diffOutputDigests computes the union of the output paths of two
+// action results, and reports for each of them whether their digests
+// differ.
+func diffOutputDigests(digestsA, digestsB map[string]*remoteexecution.Digest) []outputDigestDiffInfo {
+	paths := make([]string, 0, len(digestsA)+len(digestsB))
+	for outputPath := range digestsA {
+		paths = append(paths, outputPath)
+	}
+	for outputPath := range digestsB {
+		if _, ok := digestsA[outputPath]; !ok {
+			paths = append(paths, outputPath)
+		}
+	}
+	sort.Strings(paths)
+
+	diffs := make([]outputDigestDiffInfo, 0, len(paths))
+	for _, outputPath := range paths {
+		digestA, digestB := digestsA[outputPath], digestsB[outputPath]
+		diffs = append(diffs, outputDigestDiffInfo{
+			Path:    outputPath,
+			DigestA: digestA,
+			DigestB: digestB,
+			Differs: digestA == nil || digestB == nil || digestA.Hash != digestB.Hash || digestA.SizeBytes != digestB.SizeBytes,
+		})
+	}
+	return diffs
+}
+
+// executionStageDiffInfo contains the duration of a single stage of
+// execution in two action results that are being compared. Durations
+// are nil if the worker did not report timestamps for the stage.
+type executionStageDiffInfo struct {
+	Name      string
+	DurationA *time.Duration
+	DurationB *time.Duration
+}
+
+// executionStages lists the stages of execution, delimited by
+// timestamps stored in ExecutedActionMetadata.
+var executionStages = []struct {
+	name       string
+	start, end func(*remoteexecution.ExecutedActionMetadata) *timestamppb.Timestamp
+}{
+	{"Queued", (*remoteexecution.ExecutedActionMetadata).GetQueuedTimestamp, (*remoteexecution.ExecutedActionMetadata).GetWorkerStartTimestamp},
+	{"Fetching inputs", (*remoteexecution.ExecutedActionMetadata).GetInputFetchStartTimestamp, (*remoteexecution.ExecutedActionMetadata).GetInputFetchCompletedTimestamp},
+	{"Executing", (*remoteexecution.ExecutedActionMetadata).GetExecutionStartTimestamp, (*remoteexecution.ExecutedActionMetadata).GetExecutionCompletedTimestamp},
+	{"Uploading outputs", (*remoteexecution.ExecutedActionMetadata).GetOutputUploadStartTimestamp, (*remoteexecution.ExecutedActionMetadata).GetOutputUploadCompletedTimestamp},
+	{"Worker total", (*remoteexecution.ExecutedActionMetadata).GetWorkerStartTimestamp, (*remoteexecution.ExecutedActionMetadata).GetWorkerCompletedTimestamp},
+}
+
+func getExecutionStageDuration(start, end *timestamppb.Timestamp) *time.Duration {
+	if start.CheckValid() != nil || end.CheckValid() != nil {
+		return nil
+	}
+	d := end.AsTime().Sub(start.AsTime())
+	return &d
+}
+
+// handleActionDiff compares the action results of two actions stored
+// in the Action Cache. This can be used to investigate
+// nondeterministic build actions.
+func (s *BrowserService) handleActionDiff(w http.ResponseWriter, req *http.Request) {
+	actionDigestA, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	vars := mux.Vars(req)
+	otherSizeBytes, err := strconv.ParseInt(vars["otherSizeBytes"], 10, 64)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid blob size %#v", vars["otherSizeBytes"]))
+		return
+	}
+	actionDigestB, err := actionDigestA.GetDigestFunction().NewDigest(vars["otherHash"], otherSizeBytes)
+	if err != nil {
+		s.renderError(w, req, err)
 		return
 	}
-	s.handleActionCommon(w, req, actionDigest, historicalExecuteResponse.ExecuteResponse, true)
+
+	ctx := extractContextFromRequest(req)
+	var actionResults [2]*remoteexecution.ActionResult
+	for i, actionDigest := range []digest.Digest{actionDigestA, actionDigestB} {
+		m, err := s.actionCache.Get(ctx, actionDigest).ToProto(
+			&remoteexecution.ActionResult{},
+			s.maximumMessageSizeBytes)
+		observeLookup("action_result", err)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapf(err, "Failed to obtain action result of action %#v", actionDigest.String()))
+			return
+		}
+		actionResults[i] = m.(*remoteexecution.ActionResult)
+	}
+
+	var outputFiles, outputDirectories [2]map[string]*remoteexecution.Digest
+	for i, actionResult := range actionResults {
+		outputFiles[i] = map[string]*remoteexecution.Digest{}
+		for _, outputFile := range actionResult.OutputFiles {
+			outputFiles[i][outputFile.Path] = outputFile.Digest
+		}
+		outputDirectories[i] = map[string]*remoteexecution.Digest{}
+		for _, outputDirectory := range actionResult.OutputDirectories {
+			outputDirectories[i][outputDirectory.Path] = outputDirectory.TreeDigest
+		}
+	}
+
+	executionStageDiffs := make([]executionStageDiffInfo, 0, len(executionStages))
+	metadataA, metadataB := actionResults[0].ExecutionMetadata, actionResults[1].ExecutionMetadata
+	for _, stage := range executionStages {
+		executionStageDiffs = append(executionStageDiffs, executionStageDiffInfo{
+			Name:      stage.name,
+			DurationA: getExecutionStageDuration(stage.start(metadataA), stage.end(metadataA)),
+			DurationB: getExecutionStageDuration(stage.start(metadataB), stage.end(metadataB)),
+		})
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "page_action_diff.html", struct {
+		ActionDigestA     digest.Digest
+		ActionDigestB     digest.Digest
+		ActionResultA     *remoteexecution.ActionResult
+		ActionResultB     *remoteexecution.ActionResult
+		OutputFiles       []outputDigestDiffInfo
+		OutputDirectories []outputDigestDiffInfo
+		ExecutionStages   []executionStageDiffInfo
+	}{
+		ActionDigestA:     actionDigestA,
+		ActionDigestB:     actionDigestB,
+		ActionResultA:     actionResults[0],
+		ActionResultB:     actionResults[1],
+		OutputFiles:       diffOutputDigests(outputFiles[0], outputFiles[1]),
+		OutputDirectories: diffOutputDigests(outputDirectories[0], outputDirectories[1]),
+		ExecutionStages:   executionStageDiffs,
+	}); err != nil {
+		log.Print(err)
+	}
 }
 
 func (s *BrowserService) getLogInfoFromActionResult(ctx context.Context, name string, digestFunction digest.Function, logDigest *remoteexecution.Digest, rawLogBody []byte) (*logInfo, error) {
@@ -287,11 +1151,7 @@ func (s *BrowserService) getLogInfoFromActionResult(ctx context.Context, name st
 
 	if len(rawLogBody) > 0 {
 		// Log body is small enough to be provided inline.
-		return &logInfo{
-			Name:   name,
-			Digest: blobDigest,
-			HTML:   template.HTML(terminal.Render(rawLogBody)),
-		}, nil
+		return s.newRenderedLogInfo(name, blobDigest, rawLogBody), nil
 	} else if logDigest != nil {
 		// Load the log from the Content Addressable Storage.
 		return s.getLogInfoForDigest(ctx, name, blobDigest)
@@ -300,7 +1160,14 @@ func (s *BrowserService) getLogInfoFromActionResult(ctx context.Context, name st
 }
 
 func (s *BrowserService) getLogInfoForDigest(ctx context.Context, name string, digest digest.Digest) (*logInfo, error) {
-	maximumLogSizeBytes := 100000
+	return s.loadLogInfo(ctx, name, digest, false)
+}
+
+// loadLogInfo loads the contents of a log file or another file that is
+// displayed in the same way as logs from the Content Addressable
+// Storage. If requireText is set, files that don't contain UTF-8
+// encoded text are not rendered.
+func (s *BrowserService) loadLogInfo(ctx context.Context, name string, digest digest.Digest, requireText bool) (*logInfo, error) {
 	if size := digest.GetSizeBytes(); size == 0 {
 		// No log file present.
 		return nil, nil
@@ -314,463 +1181,3426 @@ func (s *BrowserService) getLogInfoForDigest(ctx context.Context, name string, d
 	}
 
 	data, err := s.contentAddressableStorage.Get(ctx, digest).ToByteSlice(maximumLogSizeBytes)
-	if err == nil {
-		// Log found. Convert ANSI escape sequences to HTML.
-		return &logInfo{
-			Name:   name,
-			Digest: digest,
-			HTML:   template.HTML(terminal.Render(data)),
-		}, nil
-	} else if status.Code(err) == codes.NotFound {
-		// Not found.
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			// Not found.
+			return &logInfo{
+				Name:     name,
+				Digest:   digest,
+				NotFound: true,
+			}, nil
+		}
+		return nil, err
+	}
+	if requireText && !utf8.Valid(data) {
 		return &logInfo{
-			Name:     name,
-			Digest:   digest,
-			NotFound: true,
+			Name:             name,
+			Digest:           digest,
+			NotHumanReadable: true,
 		}, nil
 	}
-	return nil, err
+	// Log found. Convert ANSI escape sequences to HTML.
+	return s.newRenderedLogInfo(name, digest, data), nil
 }
 
-func (s *BrowserService) handleActionCommon(w http.ResponseWriter, req *http.Request, actionDigest digest.Digest, executeResponse *remoteexecution.ExecuteResponse, isHistoricalExecuteResponse bool) {
-	actionInfo := struct {
-		IsHistoricalExecuteResponse bool
-		ActionDigest                digest.Digest
-		Action                      *remoteexecution.Action
-
-		Command *commandInfo
+// isPreviewedOutputFile returns true if the filename of an output file
+// matches one of the patterns of output files that should be displayed
+// inline on action pages.
+func (s *BrowserService) isPreviewedOutputFile(outputPath string) bool {
+	filename := outputPath[strings.LastIndexByte(outputPath, '/')+1:]
+	for _, pattern := range s.previewedOutputFilePatterns {
+		if matched, _ := filepath.Match(pattern, filename); matched {
+			return true
+		}
+	}
+	return false
+}
 
-		ExecuteResponse *remoteexecution.ExecuteResponse
-		StdoutInfo      *logInfo
-		StderrInfo      *logInfo
+// getFilePreview loads the contents of a file, so that it can be
+// displayed inline on an action page in the same way as logs. Files
+// that are too large or don't contain text are not rendered.
+func (s *BrowserService) getFilePreview(ctx context.Context, name string, digest digest.Digest) (*logInfo, error) {
+	return s.loadLogInfo(ctx, name, digest, true)
+}
 
-		InputRoot *directoryInfo
+// getStandardInputInfo loads the file in the input root of an action
+// that is configured to contain its standard input. Nil is returned if
+// the input root contains no such file, as Remote Execution API itself
+// provides no way to pass data to an action's standard input.
+func (s *BrowserService) getStandardInputInfo(ctx context.Context, digestFunction digest.Function, inputRoot *remoteexecution.Directory) (*logInfo, error) {
+	directory := inputRoot
+	components := strings.Split(s.standardInputPath, "/")
+	for _, component := range components[:len(components)-1] {
+		childNode := func() *remoteexecution.DirectoryNode {
+			for _, directoryNode := range directory.Directories {
+				if component == directoryNode.Name {
+					return directoryNode
+				}
+			}
+			return nil
+		}()
+		if childNode == nil {
+			return nil, nil
+		}
+		childDigest, err := digestFunction.NewDigestFromProto(childNode.Digest)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid digest for directory %#v", component)
+		}
+		childMessage, err := s.contentAddressableStorage.Get(ctx, childDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		directory = childMessage.(*remoteexecution.Directory)
+	}
+
+	filename := components[len(components)-1]
+	for _, fileNode := range directory.Files {
+		if fileNode.Name == filename {
+			fileDigest, err := digestFunction.NewDigestFromProto(fileNode.Digest)
+			if err != nil {
+				return nil, util.StatusWrapf(err, "Invalid digest for standard input file %#v", s.standardInputPath)
+			}
+			return s.getFilePreview(ctx, "Standard input", fileDigest)
+		}
+	}
+	return nil, nil
+}
+
+// writeLogToBundle writes a single log of an action result to a log
+// bundle, preceded by a header containing its name.
+func (s *BrowserService) writeLogToBundle(ctx context.Context, w io.Writer, name string, digestFunction digest.Function, logDigest *remoteexecution.Digest, rawLogBody []byte, plain bool) error {
+	if _, err := fmt.Fprintf(w, "==== %s ====\n", name); err != nil {
+		return err
+	}
+
+	var data []byte
+	if len(rawLogBody) > 0 {
+		// Log body is small enough to be provided inline.
+		data = rawLogBody
+	} else if logDigest != nil && logDigest.SizeBytes > 0 {
+		blobDigest, err := digestFunction.NewDigestFromProto(logDigest)
+		if err != nil {
+			return err
+		}
+		if blobDigest.GetSizeBytes() > maximumLogSizeBytes {
+			_, err := fmt.Fprintf(w, "Log file %s is too large to be included (%d bytes)\n\n", blobDigest, blobDigest.GetSizeBytes())
+			return err
+		}
+		data, err = s.contentAddressableStorage.Get(ctx, blobDigest).ToByteSlice(maximumLogSizeBytes)
+		if status.Code(err) == codes.NotFound {
+			_, err := fmt.Fprintf(w, "Log file %s could not be found\n\n", blobDigest)
+			return err
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if plain {
+		data = ansiEscapeSequence.ReplaceAll(data, nil)
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// handleLogBundle serves the standard output and standard error of an
+// action result as a single text file, so that it can be attached to
+// bug reports.
+func (s *BrowserService) handleLogBundle(w http.ResponseWriter, req *http.Request, actionDigest digest.Digest, actionResult *remoteexecution.ActionResult) {
+	if actionResult == nil {
+		s.renderError(w, req, status.Error(codes.NotFound, "Could not find an action result"))
+		return
+	}
+
+	// Generate the bundle in memory first, so that errors can still
+	// be reported properly.
+	ctx := extractContextFromRequest(req)
+	digestFunction := actionDigest.GetDigestFunction()
+	plain := req.URL.Query().Get("plain") == "1"
+	var bundle bytes.Buffer
+	if err := s.writeLogToBundle(ctx, &bundle, "Standard output", digestFunction, actionResult.StdoutDigest, actionResult.StdoutRaw, plain); err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	if err := s.writeLogToBundle(ctx, &bundle, "Standard error", digestFunction, actionResult.StderrDigest, actionResult.StderrRaw, plain); err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-logs.txt\"", actionDigest.GetHashString()))
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(bundle.Len()), 10))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	bundle.WriteTo(w)
+}
+
+// outputsTarballEntry is an output of an action, together with the
+// path at which it is stored in the tarball returned by
+// handleOutputsTarball(). Exactly one of the outputs is set.
+type outputsTarballEntry struct {
+	tarballPath *path.Trace
+	file        *remoteexecution.OutputFile
+	symlink     *remoteexecution.OutputSymlink
+	directory   *remoteexecution.OutputDirectory
+}
+
+// parseRelativePath splits a relative pathname into its components,
+// rejecting pathnames containing "." or ".." components.
+func parseRelativePath(p string) ([]path.Component, bool) {
+	var components []path.Component
+	for _, componentStr := range strings.Split(p, "/") {
+		if componentStr == "" {
+			continue
+		}
+		component, ok := path.NewComponent(componentStr)
+		if !ok {
+			return nil, false
+		}
+		components = append(components, component)
+	}
+	return components, true
+}
+
+// resolveOutputPath returns the components of the path of an output
+// relative to the input root, given the working directory of the
+// command. Unlike path.Join(), this function does not clean the
+// resulting path, as removing "." and ".." components could cause it
+// to refer to another output. Instead, non-normalized paths are
+// rejected.
+func resolveOutputPath(workingDirectory, outputPath string) ([]path.Component, bool) {
+	workingDirectoryComponents, ok := parseNormalizedRelativePath(workingDirectory)
+	if !ok {
+		return nil, false
+	}
+	outputPathComponents, ok := parseNormalizedRelativePath(outputPath)
+	if !ok || len(outputPathComponents) == 0 {
+		return nil, false
+	}
+	return append(workingDirectoryComponents, outputPathComponents...), true
+}
+
+// parseNormalizedRelativePath is identical to parseRelativePath(),
+// except that it also rejects pathnames containing leading, trailing
+// or redundant slashes.
+func parseNormalizedRelativePath(p string) ([]path.Component, bool) {
+	components, ok := parseRelativePath(p)
+	if !ok || formatRelativePath(components) != p {
+		return nil, false
+	}
+	return components, true
+}
+
+// formatRelativePath joins the components of a relative pathname.
+func formatRelativePath(components []path.Component) string {
+	var sb strings.Builder
+	for i, component := range components {
+		if i > 0 {
+			sb.WriteByte('/')
+		}
+		sb.WriteString(component.String())
+	}
+	return sb.String()
+}
+
+// getOutputPathInInputRoot is called by templates to display the path
+// of an output relative to the input root. It returns the empty string
+// if the working directory or output path is not normalized.
+func getOutputPathInInputRoot(workingDirectory, outputPath string) string {
+	components, ok := resolveOutputPath(workingDirectory, outputPath)
+	if !ok {
+		return ""
+	}
+	return formatRelativePath(components)
+}
+
+// handleActionSummary returns a minimal JSON summary of the result of
+// an action, intended to be polled by dashboards. As opposed to the
+// action page, it does not load the Command and input root of the
+// action from storage.
+func (s *BrowserService) handleActionSummary(w http.ResponseWriter, req *http.Request, executeResponse *remoteexecution.ExecuteResponse, isHistoricalExecuteResponse bool) {
+	actionResult := executeResponse.GetResult()
+	if actionResult == nil {
+		s.renderError(w, req, status.Error(codes.NotFound, "Could not find an action result"))
+		return
+	}
+
+	// Results on the action page are obtained from the Action
+	// Cache, while historical execute responses record whether the
+	// result was served from the cache.
+	summary := struct {
+		ExitCode                 int32    `json:"exitCode"`
+		Cached                   bool     `json:"cached"`
+		ExecutionDurationSeconds *float64 `json:"executionDurationSeconds"`
+		OutputsCount             int      `json:"outputsCount"`
+	}{
+		ExitCode:     actionResult.ExitCode,
+		Cached:       !isHistoricalExecuteResponse || executeResponse.CachedResult,
+		OutputsCount: len(actionResult.OutputFiles) + len(actionResult.OutputDirectories),
+	}
+	// REv2.1 uses 'output_symlinks', while REv2.0 uses
+	// 'output_{directory,file}_symlinks'.
+	if outputSymlinksCount := len(actionResult.OutputSymlinks); outputSymlinksCount > 0 {
+		summary.OutputsCount += outputSymlinksCount
+	} else {
+		summary.OutputsCount += len(actionResult.OutputDirectorySymlinks) + len(actionResult.OutputFileSymlinks)
+	}
+	if metadata := actionResult.ExecutionMetadata; metadata.GetExecutionStartTimestamp() != nil && metadata.GetExecutionCompletedTimestamp() != nil {
+		seconds := metadata.ExecutionCompletedTimestamp.AsTime().Sub(metadata.ExecutionStartTimestamp.AsTime()).Seconds()
+		summary.ExecutionDurationSeconds = &seconds
+	}
+	writeJSON(w, req, &summary)
+}
+
+// provenanceResource describes a single input or output of an action
+// in provenance documents, using the same shape as ResourceDescriptor
+// messages in in-toto attestations. Digests are keyed by the lowercase
+// name of the digest function (e.g., "sha256").
+type provenanceResource struct {
+	Name      string            `json:"name"`
+	Digest    map[string]string `json:"digest"`
+	SizeBytes int64             `json:"sizeBytes"`
+}
+
+func newProvenanceResource(name string, blobDigest digest.Digest) provenanceResource {
+	return provenanceResource{
+		Name: name,
+		Digest: map[string]string{
+			strings.ToLower(blobDigest.GetDigestFunction().GetEnumValue().String()): blobDigest.GetHashString(),
+		},
+		SizeBytes: blobDigest.GetSizeBytes(),
+	}
+}
+
+// handleActionProvenance returns a JSON document describing the inputs
+// and outputs of an action, resembling the materials and products of
+// in-toto and SLSA provenance. It has the following fields:
+//
+//   - "action": the digest of the Action.
+//   - "command": the Command, encoded as Protobuf JSON.
+//   - "platform": the platform properties of the Action, encoded as
+//     Protobuf JSON.
+//   - "materials": the files contained in the input root, named by
+//     their path relative to the input root.
+//   - "materialsTruncated": whether the list of materials is
+//     incomplete, due to the input root being too large.
+//   - "products": the output files and output directories, named by
+//     their path relative to the input root, so that they can be
+//     compared against materials of other actions. The digest of an
+//     output directory is the one of its Tree message.
+//
+// Symbolic links are omitted, as they don't have a digest.
+func (s *BrowserService) handleActionProvenance(w http.ResponseWriter, req *http.Request, actionDigest digest.Digest, actionResult *remoteexecution.ActionResult) {
+	if actionResult == nil {
+		s.renderError(w, req, status.Error(codes.NotFound, "Could not find an action result"))
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	digestFunction := actionDigest.GetDigestFunction()
+	actionMessage, err := s.contentAddressableStorage.Get(ctx, actionDigest).ToProto(&remoteexecution.Action{}, s.maximumMessageSizeBytes)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrap(err, "Failed to obtain action"))
+		return
+	}
+	action := actionMessage.(*remoteexecution.Action)
+	commandDigest, err := digestFunction.NewDigestFromProto(action.CommandDigest)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrap(err, "Invalid command digest"))
+		return
+	}
+	commandMessage, err := s.contentAddressableStorage.Get(ctx, commandDigest).ToProto(&remoteexecution.Command{}, s.maximumMessageSizeBytes)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrap(err, "Failed to obtain command"))
+		return
+	}
+	command := commandMessage.(*remoteexecution.Command)
+	commandJSON, err := protojson.Marshal(command)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	platformJSON, err := protojson.Marshal(action.GetPlatform())
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	inputRootDigest, err := digestFunction.NewDigestFromProto(action.InputRootDigest)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrap(err, "Invalid input root digest"))
+		return
+	}
+	manifest, err := s.getInputManifest(ctx, digestFunction, inputRootDigest)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	provenance := struct {
+		Action             provenanceResource   `json:"action"`
+		Command            json.RawMessage      `json:"command"`
+		Platform           json.RawMessage      `json:"platform"`
+		Materials          []provenanceResource `json:"materials"`
+		MaterialsTruncated bool                 `json:"materialsTruncated"`
+		Products           []provenanceResource `json:"products"`
+	}{
+		Action:             newProvenanceResource("action", actionDigest),
+		Command:            commandJSON,
+		Platform:           platformJSON,
+		Materials:          make([]provenanceResource, 0, len(manifest.Files)),
+		MaterialsTruncated: manifest.Truncated,
+		Products:           []provenanceResource{},
+	}
+	for _, file := range manifest.Files {
+		fileDigest, err := digestFunction.NewDigest(file.Hash, file.SizeBytes)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapf(err, "Invalid digest for input file %#v", file.Path))
+			return
+		}
+		provenance.Materials = append(provenance.Materials, newProvenanceResource(file.Path, fileDigest))
+	}
+	// Paths of outputs are relative to the working directory,
+	// while paths of materials are relative to the input root.
+	addProduct := func(outputPath string, outputDigest digest.Digest) error {
+		components, ok := resolveOutputPath(command.WorkingDirectory, outputPath)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Output %#v has an invalid path", outputPath)
+		}
+		provenance.Products = append(provenance.Products, newProvenanceResource(formatRelativePath(components), outputDigest))
+		return nil
+	}
+	for _, outputFile := range actionResult.OutputFiles {
+		outputFileDigest, err := digestFunction.NewDigestFromProto(outputFile.Digest)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapf(err, "Invalid digest for output file %#v", outputFile.Path))
+			return
+		}
+		if err := addProduct(outputFile.Path, outputFileDigest); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		outputDirectoryDigest, err := digestFunction.NewDigestFromProto(outputDirectory.TreeDigest)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapf(err, "Invalid digest for output directory %#v", outputDirectory.Path))
+			return
+		}
+		if err := addProduct(outputDirectory.Path, outputDirectoryDigest); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+	sort.Slice(provenance.Products, func(i, j int) bool {
+		return provenance.Products[i].Name < provenance.Products[j].Name
+	})
+	writeJSON(w, req, &provenance)
+}
+
+// getWorkingDirectory returns the working directory of the Command
+// associated with an Action. An empty string is returned if the Action
+// or Command can't be found, in which case outputs can only be
+// presented relative to the working directory.
+func (s *BrowserService) getWorkingDirectory(ctx context.Context, actionDigest digest.Digest) (string, error) {
+	actionMessage, err := s.contentAddressableStorage.Get(ctx, actionDigest).ToProto(&remoteexecution.Action{}, s.maximumMessageSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	commandDigest, err := actionDigest.GetDigestFunction().NewDigestFromProto(actionMessage.(*remoteexecution.Action).CommandDigest)
+	if err != nil {
+		return "", util.StatusWrap(err, "Invalid command digest")
+	}
+	commandMessage, err := s.contentAddressableStorage.Get(ctx, commandDigest).ToProto(&remoteexecution.Command{}, s.maximumMessageSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return commandMessage.(*remoteexecution.Command).WorkingDirectory, nil
+}
+
+// handleOutputsTarball returns a tarball containing all outputs of an
+// action. Outputs are stored at the paths at which they were declared
+// by the Command, resolved against its working directory, so that
+// extracting the tarball yields the same layout as on the worker. The
+// "prefix" query parameter can be used to place all outputs under a
+// common directory, while "flatten=1" causes outputs to be stored at
+// the top level of the tarball. As the latter may cause outputs to
+// collide, the request fails in that case.
+func (s *BrowserService) handleOutputsTarball(w http.ResponseWriter, req *http.Request, actionDigest digest.Digest, actionResult *remoteexecution.ActionResult) {
+	if actionResult == nil {
+		s.renderError(w, req, status.Error(codes.NotFound, "Could not find an action result"))
+		return
+	}
+
+	query := req.URL.Query()
+	prefixStr := query.Get("prefix")
+	prefixComponents, ok := parseRelativePath(prefixStr)
+	if !ok || strings.HasPrefix(prefixStr, "/") {
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Invalid prefix %#v", prefixStr))
+		return
+	}
+	var prefix *path.Trace
+	for _, component := range prefixComponents {
+		prefix = prefix.Append(component)
+	}
+	flatten := query.Get("flatten") == "1"
+	workingDirectory, err := s.getWorkingDirectory(extractContextFromRequest(req), actionDigest)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	// Compute the paths of all outputs in the tarball up front, so
+	// that collisions can be reported before the response is
+	// written.
+	var entries []outputsTarballEntry
+	outputPathsByTarballPath := map[string]string{}
+	addEntry := func(outputPath string, entry outputsTarballEntry) error {
+		components, ok := resolveOutputPath(workingDirectory, outputPath)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Output %#v has an invalid path", outputPath)
+		}
+		if flatten {
+			components = components[len(components)-1:]
+		}
+		trace := prefix
+		for _, component := range components {
+			trace = trace.Append(component)
+		}
+		tarballPath := trace.String()
+		if otherOutputPath, ok := outputPathsByTarballPath[tarballPath]; ok {
+			return status.Errorf(codes.InvalidArgument, "Outputs %#v and %#v would both be stored at %#v", otherOutputPath, outputPath, tarballPath)
+		}
+		outputPathsByTarballPath[tarballPath] = outputPath
+		entry.tarballPath = trace
+		entries = append(entries, entry)
+		return nil
+	}
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		if err := addEntry(outputDirectory.Path, outputsTarballEntry{directory: outputDirectory}); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+	outputSymlinks := actionResult.OutputSymlinks
+	if len(outputSymlinks) == 0 {
+		outputSymlinks = append(append([]*remoteexecution.OutputSymlink(nil), actionResult.OutputDirectorySymlinks...), actionResult.OutputFileSymlinks...)
+	}
+	for _, outputSymlink := range outputSymlinks {
+		if err := addEntry(outputSymlink.Path, outputsTarballEntry{symlink: outputSymlink}); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+	for _, outputFile := range actionResult.OutputFiles {
+		if err := addEntry(outputFile.Path, outputsTarballEntry{file: outputFile}); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+
+	if !s.acquireTarballGenerationSlot(w, req) {
+		return
+	}
+	defer s.releaseTarballGenerationSlot()
+
+	// Track the generation of the tarball, so that administrators
+	// may cancel it.
+	ctx, cancel := context.WithCancel(extractContextFromRequest(req))
+	defer cancel()
+	id := s.registerTarballGeneration(actionDigest.GetInstanceName(), actionDigest.String(), req.RemoteAddr, cancel)
+	defer s.unregisterTarballGeneration(id)
+
+	compress := shouldCompressTarball(req)
+	setTarballHeaders(w.Header(), actionDigest.GetHashString()+"-outputs", compress)
+	statusTrailerDeclared := declareTarballStatusTrailer(w, req)
+	finishTarball(w, s.writeOutputsTarball(ctx, w, actionDigest.GetDigestFunction(), entries, compress), statusTrailerDeclared)
+}
+
+func (s *BrowserService) writeOutputsTarball(ctx context.Context, w io.Writer, digestFunction digest.Function, entries []outputsTarballEntry, compress bool) error {
+	tarWriter, closeTarball := newTarballWriter(w, compress)
+	filesSeen := map[string]string{}
+	for _, entry := range entries {
+		tarballPath := entry.tarballPath.String()
+		switch {
+		case entry.directory != nil:
+			treeDigest, err := digestFunction.NewDigestFromProto(entry.directory.TreeDigest)
+			if err != nil {
+				return util.StatusWrapf(err, "Invalid tree digest for output directory %#v", entry.directory.Path)
+			}
+			treeMessage, err := s.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, s.maximumMessageSizeBytes)
+			if err != nil {
+				return util.StatusWrapf(err, "Failed to obtain tree of output directory %#v", entry.directory.Path)
+			}
+			tree := treeMessage.(*remoteexecution.Tree)
+			children, err := getTreeChildren(digestFunction, tree)
+			if err != nil {
+				return err
+			}
+			root := tree.Root
+			getDirectory := func(ctx context.Context, directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
+				childDirectory, ok := children[directoryDigest.GetKey(digest.KeyWithoutInstance)]
+				if !ok {
+					return nil, errors.New("Failed to find child node in tree")
+				}
+				return childDirectory, nil
+			}
+			header := &tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     tarballPath,
+				Mode:     0o777,
+			}
+			applyNodePropertiesToTarHeader(root.GetNodeProperties(), header)
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+			if err := s.generateTarballDirectory(ctx, tarWriter, digestFunction, root, entry.tarballPath, getDirectory, filesSeen); err != nil {
+				return err
+			}
+		case entry.symlink != nil:
+			if err := tarWriter.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeSymlink,
+				Name:     tarballPath,
+				Linkname: entry.symlink.Target,
+				Mode:     0o777,
+			}); err != nil {
+				return err
+			}
+		case entry.file != nil:
+			fileDigest, err := digestFunction.NewDigestFromProto(entry.file.Digest)
+			if err != nil {
+				return util.StatusWrapf(err, "Invalid digest for output file %#v", entry.file.Path)
+			}
+			var mode int64 = 0o666
+			if entry.file.IsExecutable {
+				mode = 0o777
+			}
+			if err := tarWriter.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     tarballPath,
+				Size:     fileDigest.GetSizeBytes(),
+				Mode:     mode,
+			}); err != nil {
+				return err
+			}
+			if err := s.contentAddressableStorage.Get(ctx, fileDigest).IntoWriter(tarWriter); err != nil {
+				return util.StatusWrapf(err, "Failed to read output file %#v", entry.file.Path)
+			}
+		}
+	}
+	return closeTarball()
+}
+
+const (
+	// Limits on the number of input directories that are loaded
+	// when the input root of an action is expanded.
+	maximumExpandedDirectoryDepth   = 16
+	maximumExpandedDirectoriesCount = 1000
+)
+
+// expandedDirectoryInfo contains the information that we display for
+// a directory whose children are expanded recursively, as opposed to
+// only showing a single level.
+type expandedDirectoryInfo struct {
+	Directory   *remoteexecution.Directory
+	Directories []*expandedDirectoryNodeInfo
+}
+
+// expandedDirectoryNodeInfo contains the information that we display
+// for a child directory of an expanded directory. The contents of the
+// child directory are only provided if they could be loaded without
+// exceeding the limits on depth and the number of directories.
+type expandedDirectoryNodeInfo struct {
+	Node      *remoteexecution.DirectoryNode
+	Directory *expandedDirectoryInfo
+	NotFound  bool
+}
+
+// expandDirectory loads the child directories of a directory
+// recursively. To prevent pages from becoming too large, the depth and
+// the total number of directories loaded are bounded. Directories
+// that are their own ancestors are not expanded.
+func (s *BrowserService) expandDirectory(ctx context.Context, digestFunction digest.Function, directory *remoteexecution.Directory, depth int, ancestors map[string]struct{}, remainingDirectories *int) (*expandedDirectoryInfo, error) {
+	expandedDirectory := &expandedDirectoryInfo{
+		Directory:   directory,
+		Directories: make([]*expandedDirectoryNodeInfo, 0, len(directory.Directories)),
+	}
+	for _, directoryNode := range directory.Directories {
+		nodeInfo := &expandedDirectoryNodeInfo{
+			Node: directoryNode,
+		}
+		expandedDirectory.Directories = append(expandedDirectory.Directories, nodeInfo)
+
+		childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+		if err != nil {
+			return nil, err
+		}
+		childKey := childDigest.GetKey(digest.KeyWithoutInstance)
+		if _, ok := ancestors[childKey]; ok || depth >= maximumExpandedDirectoryDepth || *remainingDirectories <= 0 {
+			continue
+		}
+		*remainingDirectories--
+
+		childMessage, err := s.contentAddressableStorage.Get(ctx, childDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				nodeInfo.NotFound = true
+				continue
+			}
+			return nil, err
+		}
+		ancestors[childKey] = struct{}{}
+		nodeInfo.Directory, err = s.expandDirectory(ctx, digestFunction, childMessage.(*remoteexecution.Directory), depth+1, ancestors, remainingDirectories)
+		delete(ancestors, childKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expandedDirectory, nil
+}
+
+// outputDirectoryInfo contains the information that we display for
+// output directories of an action result. Statistics on the contents
+// of the output directory are only provided if requested explicitly,
+// as computing them requires loading the Tree message.
+type outputDirectoryInfo struct {
+	*remoteexecution.OutputDirectory
+	TreeStats    *treeStats
+	TreeNotFound bool
+}
+
+// getDigestWarning returns a message that is displayed on the action
+// page when a digest contained in an Action is inconsistent with the
+// digest function of the Action itself.
+func getDigestWarning(messageName string, digestFunction digest.Function, err error) string {
+	return fmt.Sprintf(
+		"The %s digest of this action is not valid for digest function %s: %s",
+		messageName,
+		digestFunction.GetEnumValue().String(),
+		status.Convert(err).Message())
+}
+
+// outputSymlinkInfo contains the information that we display for
+// output symbolic links of an action result. If the symbolic link
+// points to another output file or directory, a link to its page is
+// provided.
+type outputSymlinkInfo struct {
+	*remoteexecution.OutputSymlink
+	TargetURL string
+}
+
+// resolveOutputSymlinkTarget returns the path of the target of an
+// output symbolic link, relative to the working directory of the
+// action. False is returned if the target is absolute or points to a
+// location outside the working directory.
+func resolveOutputSymlinkTarget(symlinkPath, target string) (string, bool) {
+	if strings.HasPrefix(target, "/") {
+		return "", false
+	}
+	components := strings.Split(symlinkPath, "/")
+	components = components[:len(components)-1]
+	for _, component := range strings.Split(target, "/") {
+		switch component {
+		case "", ".":
+		case "..":
+			if len(components) == 0 {
+				return "", false
+			}
+			components = components[:len(components)-1]
+		default:
+			components = append(components, component)
+		}
+	}
+	return strings.Join(components, "/"), true
+}
+
+// treeStats contains aggregate statistics on the contents of a
+// directory hierarchy.
+type treeStats struct {
+	DirectoriesCount int64
+	FilesCount       int64
+	FilesSizeBytes   int64
+}
+
+// getTreeChildren returns all child directories contained in a Tree
+// message, keyed by their digest.
+func getTreeChildren(digestFunction digest.Function, tree *remoteexecution.Tree) (map[string]*remoteexecution.Directory, error) {
+	children := map[string]*remoteexecution.Directory{}
+	for _, child := range tree.Children {
+		data, err := proto.Marshal(child)
+		if err != nil {
+			return nil, err
+		}
+		digestGenerator := digestFunction.NewGenerator(int64(len(data)))
+		if _, err := digestGenerator.Write(data); err != nil {
+			return nil, err
+		}
+		children[digestGenerator.Sum().GetKey(digest.KeyWithoutInstance)] = child
+	}
+	return children, nil
+}
+
+// validateTree checks whether the directories contained in a Tree are
+// consistent with each other. It returns descriptions of
+// DirectoryNodes referring to directories that are not contained in
+// the Tree, and of child directories that cannot be reached from the
+// root directory.
+func validateTree(digestFunction digest.Function, tree *remoteexecution.Tree, children map[string]*remoteexecution.Directory) []string {
+	var problems []string
+	reached := map[string]struct{}{}
+	queue := []*remoteexecution.Directory{tree.Root}
+	for len(queue) > 0 {
+		directory := queue[0]
+		queue = queue[1:]
+		for _, directoryNode := range directory.GetDirectories() {
+			childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("Directory %#v has an invalid digest: %s", directoryNode.Name, status.Convert(err).Message()))
+				continue
+			}
+			childKey := childDigest.GetKey(digest.KeyWithoutInstance)
+			child, ok := children[childKey]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("Directory %#v refers to directory %s, which is not contained in the tree", directoryNode.Name, childDigest.String()))
+				continue
+			}
+			if _, ok := reached[childKey]; !ok {
+				reached[childKey] = struct{}{}
+				queue = append(queue, child)
+			}
+		}
+	}
+	if orphanedCount := len(children) - len(reached); orphanedCount > 0 {
+		problems = append(problems, fmt.Sprintf("The tree contains %d child directories that cannot be reached from the root directory", orphanedCount))
+	}
+	return problems
+}
+
+// computeDirectoryStats computes aggregate statistics on the contents
+// of a directory, whose child directories are all contained in a Tree.
+// Statistics of child directories are cached, so that directories that
+// are present in the tree many times are only traversed once.
+func computeDirectoryStats(digestFunction digest.Function, directory *remoteexecution.Directory, children map[string]*remoteexecution.Directory, statsCache map[string]*treeStats) (*treeStats, error) {
+	stats := treeStats{
+		FilesCount: int64(len(directory.Files)),
+	}
+	for _, fileNode := range directory.Files {
+		stats.FilesSizeBytes += fileNode.Digest.GetSizeBytes()
+	}
+	for _, directoryNode := range directory.Directories {
+		childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+		if err != nil {
+			return nil, err
+		}
+		childKey := childDigest.GetKey(digest.KeyWithoutInstance)
+		childStats, ok := statsCache[childKey]
+		if !ok {
+			childDirectory, ok := children[childKey]
+			if !ok {
+				return nil, status.Error(codes.InvalidArgument, "Failed to find child node in tree")
+			}
+			childStats, err = computeDirectoryStats(digestFunction, childDirectory, children, statsCache)
+			if err != nil {
+				return nil, err
+			}
+			statsCache[childKey] = childStats
+		}
+		stats.DirectoriesCount += 1 + childStats.DirectoriesCount
+		stats.FilesCount += childStats.FilesCount
+		stats.FilesSizeBytes += childStats.FilesSizeBytes
+	}
+	return &stats, nil
+}
+
+// getTreeStats loads a Tree message from the Content Addressable
+// Storage and computes aggregate statistics on its contents.
+func (s *BrowserService) getTreeStats(ctx context.Context, treeDigest digest.Digest) (*treeStats, error) {
+	treeMessage, err := s.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, s.maximumMessageSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	tree := treeMessage.(*remoteexecution.Tree)
+	digestFunction := treeDigest.GetDigestFunction()
+	children, err := getTreeChildren(digestFunction, tree)
+	if err != nil {
+		return nil, err
+	}
+	return computeDirectoryStats(digestFunction, tree.Root, children, map[string]*treeStats{})
+}
+
+// maximumDirectoryStatsCacheSize is the maximum number of directories
+// for which recursive statistics are cached. When exceeded, the
+// statistics of the least recently used directory are evicted.
+const maximumDirectoryStatsCacheSize = 10000
+
+// getDirectoryStats computes aggregate statistics on the contents of
+// a directory stored in the Content Addressable Storage, including all
+// of its child directories. As directories are immutable, results are
+// cached. The instance name and digest function are part of the key,
+// as the child directories need not be present under every instance
+// name.
+func (s *BrowserService) getDirectoryStats(ctx context.Context, directoryDigest digest.Digest, directory *remoteexecution.Directory) (*treeStats, error) {
+	key := directoryDigest.GetKey(digest.KeyWithInstance)
+	s.directoryStatsCacheLock.Lock()
+	stats, ok := s.directoryStatsCache[key]
+	if ok {
+		s.directoryStatsCacheEvictionSet.Touch(key)
+	}
+	s.directoryStatsCacheLock.Unlock()
+	if ok {
+		return stats, nil
+	}
+
+	children := map[string]*remoteexecution.Directory{}
+	var missingDirectoryDigest *digest.Digest
+	truncated, err := s.walkDirectoryClosure(
+		ctx,
+		directoryDigest,
+		func(childDigest digest.Digest, childDirectory *remoteexecution.Directory) {
+			children[childDigest.GetKey(digest.KeyWithoutInstance)] = childDirectory
+		},
+		func(childDigest digest.Digest) {
+			if missingDirectoryDigest == nil {
+				missingDirectoryDigest = &childDigest
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		return nil, status.Error(codes.ResourceExhausted, "Directory contains too many child directories to compute statistics")
+	}
+	if missingDirectoryDigest != nil {
+		return nil, status.Errorf(codes.NotFound, "Directory %#v is not present in the Content Addressable Storage", missingDirectoryDigest.String())
+	}
+	stats, err = computeDirectoryStats(directoryDigest.GetDigestFunction(), directory, children, map[string]*treeStats{})
+	if err != nil {
+		return nil, err
+	}
+
+	s.directoryStatsCacheLock.Lock()
+	if _, ok := s.directoryStatsCache[key]; !ok {
+		// Statistics may have been computed concurrently, in
+		// which case they are already present.
+		for len(s.directoryStatsCache) >= maximumDirectoryStatsCacheSize {
+			delete(s.directoryStatsCache, s.directoryStatsCacheEvictionSet.Peek())
+			s.directoryStatsCacheEvictionSet.Remove()
+		}
+		s.directoryStatsCache[key] = stats
+		s.directoryStatsCacheEvictionSet.Insert(key)
+	}
+	s.directoryStatsCacheLock.Unlock()
+	return stats, nil
+}
+
+func (s *BrowserService) handleActionCommon(w http.ResponseWriter, req *http.Request, actionDigest digest.Digest, executeResponse *remoteexecution.ExecuteResponse, isHistoricalExecuteResponse bool, permalink string) {
+	switch req.URL.Query().Get("format") {
+	case "logs":
+		s.handleLogBundle(w, req, actionDigest, executeResponse.GetResult())
+		return
+	case "provenance":
+		s.handleActionProvenance(w, req, actionDigest, executeResponse.GetResult())
+		return
+	case "summary":
+		s.handleActionSummary(w, req, executeResponse, isHistoricalExecuteResponse)
+		return
+	case "tar":
+		s.handleOutputsTarball(w, req, actionDigest, executeResponse.GetResult())
+		return
+	}
+	if acceptsJSON(req) {
+		// Return the action result, so that the outputs of the
+		// action can be listed without rendering the page.
+		actionResult := executeResponse.GetResult()
+		if actionResult == nil {
+			s.renderError(w, req, status.Error(codes.NotFound, "Could not find an action result"))
+			return
+		}
+		writeProtoJSON(w, req, actionResult)
+		return
+	}
+
+	actionInfo := struct {
+		IsHistoricalExecuteResponse bool
+		ActionDigest                digest.Digest
+		Action                      *remoteexecution.Action
+		// Salt of the action, encoded as hexadecimal, as it
+		// generally consists of arbitrary bytes.
+		Salt           string
+		ContainerImage *containerImageInfo
+		Permalink      string
+		// Problems with digests contained in the Action that
+		// prevented messages from being loaded.
+		DigestWarnings []string
+
+		Command *commandInfo
+		// Error that prevented the command from being loaded,
+		// other than it not being found.
+		CommandError *status.Status
+		// Working directory of the command, against which the
+		// paths of outputs are resolved.
+		WorkingDirectory string
+
+		ExecuteResponse *remoteexecution.ExecuteResponse
+		ExecutionStatus *status.Status
+		StdinInfo       *logInfo
+		StdoutInfo      *logInfo
+		StderrInfo      *logInfo
+		ServerLogs      []*logInfo
+
+		InputRoot         *directoryInfo
+		ExpandedInputRoot *expandedDirectoryInfo
+		// Error that prevented the input root from being loaded
+		// or expanded, other than it not being found.
+		InputRootError *status.Status
+
+		OutputDirectories []*outputDirectoryInfo
+		OutputSymlinks    []*outputSymlinkInfo
+		OutputFiles       []*remoteexecution.OutputFile
+		MissingPaths      []string
+
+		// Contents of output files that are displayed inline.
+		OutputFilePreviews []*logInfo
+
+		PreviousExecutionStats *previousExecutionStatsInfo
+
+		// Time zone in which timestamps are displayed.
+		TimeLocation      *time.Location
+		TimeLocationError string
+
+		ShowRawMessage bool
+	}{
+		IsHistoricalExecuteResponse: isHistoricalExecuteResponse,
+		ActionDigest:                actionDigest,
+		Permalink:                   permalink,
+		ExecuteResponse:             executeResponse,
+		ShowRawMessage:              shouldShowRawMessages(req),
+	}
+
+	timeLocation, err := getTimeLocationFromRequest(req)
+	actionInfo.TimeLocation = timeLocation
+	if err != nil {
+		actionInfo.TimeLocationError = status.Convert(err).Message()
+	}
+
+	ctx := extractContextFromRequest(req)
+	actionResult := executeResponse.GetResult()
+	digestFunction := actionDigest.GetDigestFunction()
+	if actionResult != nil {
+		showOutputDirectoryStats := req.URL.Query().Get("output_directory_stats") == "1"
+		for _, outputDirectory := range actionResult.OutputDirectories {
+			outputDirectoryInfo := &outputDirectoryInfo{
+				OutputDirectory: outputDirectory,
+			}
+			if showOutputDirectoryStats && outputDirectory.TreeDigest != nil {
+				treeDigest, err := digestFunction.NewDigestFromProto(outputDirectory.TreeDigest)
+				if err != nil {
+					s.renderError(w, req, err)
+					return
+				}
+				treeStats, err := s.getTreeStats(ctx, treeDigest)
+				if err == nil {
+					outputDirectoryInfo.TreeStats = treeStats
+				} else if status.Code(err) == codes.NotFound {
+					outputDirectoryInfo.TreeNotFound = true
+				} else {
+					s.renderError(w, req, err)
+					return
+				}
+			}
+			actionInfo.OutputDirectories = append(actionInfo.OutputDirectories, outputDirectoryInfo)
+		}
+		actionInfo.OutputFiles = actionResult.OutputFiles
+
+		// Output symbolic links that point to other outputs of
+		// the action are displayed as links to their pages.
+		outputURLs := map[string]string{}
+		for _, outputDirectory := range actionResult.OutputDirectories {
+			if d := outputDirectory.TreeDigest; d != nil {
+				outputURLs[outputDirectory.Path] = fmt.Sprintf("../../tree/%s-%d/", d.Hash, d.SizeBytes)
+			}
+		}
+		for _, outputFile := range actionResult.OutputFiles {
+			if d := outputFile.Digest; d != nil {
+				outputURLs[outputFile.Path] = fmt.Sprintf("../../file/%s-%d/%s", d.Hash, d.SizeBytes, url.PathEscape(outputFile.Path[strings.LastIndexByte(outputFile.Path, '/')+1:]))
+			}
+		}
+		// REv2.1 uses 'output_symlinks'.
+		outputSymlinks := actionResult.OutputSymlinks
+		if len(outputSymlinks) == 0 {
+			// REv2.0 uses 'output_{directory,file}_symlinks'.
+			outputSymlinks = append(append([]*remoteexecution.OutputSymlink(nil), actionResult.OutputDirectorySymlinks...), actionResult.OutputFileSymlinks...)
+		}
+		for _, outputSymlink := range outputSymlinks {
+			info := &outputSymlinkInfo{OutputSymlink: outputSymlink}
+			if targetPath, ok := resolveOutputSymlinkTarget(outputSymlink.Path, outputSymlink.Target); ok {
+				info.TargetURL = outputURLs[targetPath]
+			}
+			actionInfo.OutputSymlinks = append(actionInfo.OutputSymlinks, info)
+		}
+
+		var err error
+		actionInfo.StdoutInfo, err = s.getLogInfoFromActionResult(ctx, "Standard output", digestFunction, actionResult.StdoutDigest, actionResult.StdoutRaw)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		actionInfo.StderrInfo, err = s.getLogInfoFromActionResult(ctx, "Standard error", digestFunction, actionResult.StderrDigest, actionResult.StderrRaw)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+
+		for _, outputFile := range actionResult.OutputFiles {
+			if !s.isPreviewedOutputFile(outputFile.Path) {
+				continue
+			}
+			outputFileDigest, err := digestFunction.NewDigestFromProto(outputFile.Digest)
+			if err != nil {
+				s.renderError(w, req, util.StatusWrapf(err, "Invalid digest for output file %#v", outputFile.Path))
+				return
+			}
+			preview, err := s.getFilePreview(ctx, outputFile.Path, outputFileDigest)
+			if err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+			if preview != nil {
+				actionInfo.OutputFilePreviews = append(actionInfo.OutputFilePreviews, preview)
+			}
+		}
+	}
+
+	// Execution may have failed before an action result could be
+	// produced, e.g. due to a worker crashing. Display the error and
+	// any logs that the server attached for post-mortem analysis.
+	if st := executeResponse.GetStatus(); st.GetCode() != int32(codes.OK) {
+		actionInfo.ExecutionStatus = status.FromProto(st)
+	}
+	serverLogNames := make([]string, 0, len(executeResponse.GetServerLogs()))
+	for name := range executeResponse.GetServerLogs() {
+		serverLogNames = append(serverLogNames, name)
+	}
+	sort.Strings(serverLogNames)
+	for _, name := range serverLogNames {
+		serverLog := executeResponse.ServerLogs[name]
+		serverLogDigest, err := digestFunction.NewDigestFromProto(serverLog.Digest)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapf(err, "Invalid digest for server log %#v", name))
+			return
+		}
+		if !serverLog.HumanReadable {
+			actionInfo.ServerLogs = append(actionInfo.ServerLogs, &logInfo{
+				Name:             name,
+				Digest:           serverLogDigest,
+				NotHumanReadable: true,
+			})
+			continue
+		}
+		serverLogInfo, err := s.getLogInfoForDigest(ctx, name, serverLogDigest)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		if serverLogInfo != nil {
+			actionInfo.ServerLogs = append(actionInfo.ServerLogs, serverLogInfo)
+		}
+	}
+
+	actionMessage, err := s.contentAddressableStorage.Get(ctx, actionDigest).ToProto(&remoteexecution.Action{}, s.maximumMessageSizeBytes)
+	if err == nil {
+		action := actionMessage.(*remoteexecution.Action)
+		actionInfo.Action = action
+		actionInfo.Salt = hex.EncodeToString(action.Salt)
+		actionInfo.ContainerImage = getContainerImage(action.Platform)
+
+		// Digests contained in the Action are expected to use
+		// the same digest function as the Action itself. Instead
+		// of failing, display a warning if this is not the case,
+		// as it indicates the Action is corrupted or constructed
+		// incorrectly.
+		commandDigest, err := digestFunction.NewDigestFromProto(action.CommandDigest)
+		if err != nil {
+			actionInfo.DigestWarnings = append(actionInfo.DigestWarnings, getDigestWarning("command", digestFunction, err))
+		} else if commandMessage, err := s.contentAddressableStorage.Get(ctx, commandDigest).ToProto(&remoteexecution.Command{}, s.maximumMessageSizeBytes); err == nil {
+			command := commandMessage.(*remoteexecution.Command)
+			actionInfo.Command = &commandInfo{
+				Digest:         commandDigest,
+				Command:        command,
+				ContainerImage: getContainerImage(command.Platform),
+				BBClientdPath:  formatBBClientdPath(s.getBBClientdBlobPath(commandDigest, commandDirectoryComponent)),
+				Permalink:      s.getPermalink(req, "command", commandDigest, ""),
+			}
+			actionInfo.WorkingDirectory = command.WorkingDirectory
+
+			foundPaths := map[string]struct{}{}
+			for _, outputDirectory := range actionInfo.OutputDirectories {
+				foundPaths[outputDirectory.Path] = struct{}{}
+			}
+			for _, outputSymlinks := range actionInfo.OutputSymlinks {
+				foundPaths[outputSymlinks.Path] = struct{}{}
+			}
+			for _, outputFiles := range actionInfo.OutputFiles {
+				foundPaths[outputFiles.Path] = struct{}{}
+			}
+			if len(command.OutputPaths) > 0 {
+				// REv2.1 uses output_paths.
+				for _, outputPath := range command.OutputPaths {
+					if _, ok := foundPaths[outputPath]; !ok {
+						actionInfo.MissingPaths = append(actionInfo.MissingPaths, outputPath)
+					}
+				}
+			} else {
+				// REv2.0 uses output_{directories,files}.
+				for _, outputDirectory := range command.OutputDirectories {
+					if _, ok := foundPaths[outputDirectory]; !ok {
+						actionInfo.MissingPaths = append(actionInfo.MissingPaths, outputDirectory)
+					}
+				}
+				for _, outputFile := range command.OutputFiles {
+					if _, ok := foundPaths[outputFile]; !ok {
+						actionInfo.MissingPaths = append(actionInfo.MissingPaths, outputFile)
+					}
+				}
+			}
+		} else if status.Code(err) != codes.NotFound {
+			actionInfo.CommandError = status.Convert(util.StatusWrap(err, "Failed to load command"))
+		}
+
+		reducedActionDigest, err := blobstore.GetReducedActionDigest(actionDigest.GetDigestFunction(), action)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		inputRootDigest, err := digestFunction.NewDigestFromProto(action.InputRootDigest)
+		if err != nil {
+			actionInfo.DigestWarnings = append(actionInfo.DigestWarnings, getDigestWarning("input root", digestFunction, err))
+		} else if directoryMessage, err := s.contentAddressableStorage.Get(ctx, inputRootDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes); err == nil {
+			// Check whether a file system access profile exists for
+			// the current action. If so, download it, so that we
+			// can display which files in the root directory are
+			// being accessed.
+			var fileSystemAccessProfileReference *query.FileSystemAccessProfileReference
+			var bloomFilter *access.BloomFilterReader
+			if profileMessage, err := s.fileSystemAccessCache.Get(ctx, reducedActionDigest).ToProto(&fsac.FileSystemAccessProfile{}, s.maximumMessageSizeBytes); err == nil {
+				profile := profileMessage.(*fsac.FileSystemAccessProfile)
+				if bloomFilterReader, err := access.NewBloomFilterReader(profile.BloomFilter, profile.BloomFilterHashFunctions); err == nil {
+					fileSystemAccessProfileReference = &query.FileSystemAccessProfileReference{
+						Digest:             reducedActionDigest.GetProto(),
+						PathHashesBaseHash: access.RootPathHashes.GetBaseHash(),
+					}
+					bloomFilter = bloomFilterReader
+				} else {
+					log.Printf("Cannot read Bloom filter for %s: %s", reducedActionDigest.String(), err)
+				}
+			} else if status.Code(err) != codes.NotFound {
+				// The file system access profile is only
+				// used to annotate the input root. Don't
+				// let failures prevent the page from being
+				// displayed.
+				log.Printf("Cannot load file system access profile for %s: %s", reducedActionDigest.String(), err)
+			}
+
+			inputRoot := directoryMessage.(*remoteexecution.Directory)
+			actionInfo.InputRoot = &directoryInfo{
+				Digest:                           inputRootDigest,
+				InstanceName:                     inputRootDigest.GetInstanceName(),
+				Directory:                        inputRoot,
+				BBClientdPath:                    formatBBClientdPath(s.getBBClientdBlobPath(inputRootDigest, directoryDirectoryComponent)),
+				Permalink:                        s.getPermalink(req, "directory", inputRootDigest, ""),
+				FileSystemAccessProfileReference: fileSystemAccessProfileReference,
+				BloomFilter:                      bloomFilter,
+			}
+
+			if req.URL.Query().Get("expand_inputs") == "1" {
+				remainingDirectories := maximumExpandedDirectoriesCount
+				expandedInputRoot, err := s.expandDirectory(
+					ctx,
+					digestFunction,
+					inputRoot,
+					0,
+					map[string]struct{}{
+						inputRootDigest.GetKey(digest.KeyWithoutInstance): {},
+					},
+					&remainingDirectories)
+				if err == nil {
+					actionInfo.ExpandedInputRoot = expandedInputRoot
+				} else {
+					actionInfo.InputRootError = status.Convert(util.StatusWrap(err, "Failed to expand input root"))
+				}
+			}
+
+			if s.standardInputPath != "" {
+				actionInfo.StdinInfo, err = s.getStandardInputInfo(ctx, digestFunction, inputRoot)
+				if err != nil {
+					s.renderError(w, req, err)
+					return
+				}
+			}
+		} else if status.Code(err) != codes.NotFound {
+			actionInfo.InputRootError = status.Convert(util.StatusWrap(err, "Failed to load input root"))
+		}
+		previousExecutionStatsInfo, err := s.getPreviousExecutionStatsInfo(ctx, reducedActionDigest)
+		if err == nil {
+			actionInfo.PreviousExecutionStats = previousExecutionStatsInfo
+		} else if status.Code(err) != codes.NotFound {
+			log.Printf("Cannot load previous execution stats for %s: %s", reducedActionDigest.String(), err)
+		}
+	} else if status.Code(err) != codes.NotFound {
+		s.renderError(w, req, err)
+		return
+	}
+
+	if actionMessage == nil && actionResult == nil && actionInfo.ExecutionStatus == nil {
+		s.renderError(w, req, status.Error(codes.NotFound, "Could not find an action or action result"))
+		return
+	}
+
+	if req.URL.Query().Get("format") == "report" {
+		// Standalone report that can be saved and shared. The
+		// size of the logs it contains is bounded by
+		// maximumLogSizeBytes, as larger logs are omitted.
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"action-%s.html\"", actionDigest.GetHashString()))
+		if err := s.templates.ExecuteTemplate(w, "page_action_report.html", actionInfo); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+
+	// Announce the pages that the user is most likely to visit next,
+	// so that browsers and CDNs may fetch them in parallel.
+	for _, logFile := range []*logInfo{actionInfo.StdoutInfo, actionInfo.StderrInfo} {
+		if logFile != nil && logFile.Digest != digest.BadDigest && !logFile.NotFound && !logFile.TooLarge {
+			addPreloadLink(w.Header(), fmt.Sprintf("../../file/%s-%d/log.txt", logFile.Digest.GetHashString(), logFile.Digest.GetSizeBytes()))
+		}
+	}
+	if inputRoot := actionInfo.InputRoot; inputRoot != nil {
+		addPreloadLink(w.Header(), fmt.Sprintf("../../directory/%s-%d/", inputRoot.Digest.GetHashString(), inputRoot.Digest.GetSizeBytes()))
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "page_action.html", actionInfo); err != nil {
+		log.Print(err)
+	}
+}
+
+const (
+	// Limits on the number of directories that are loaded when
+	// traversing the closure of an input root.
+	maximumClosureDirectoryDepth   = 64
+	maximumClosureDirectoriesCount = 100000
+)
+
+// walkDirectoryClosure loads all directories that are reachable from a
+// root directory, calling a visitor function for each of them.
+// Directories that are referenced multiple times are only visited
+// once. Directories that are not present in the Content Addressable
+// Storage are reported separately.
+//
+// The traversal is bounded in depth and in the total number of
+// directories loaded. The return value indicates whether these limits
+// were reached, meaning the results are incomplete.
+func (s *BrowserService) walkDirectoryClosure(ctx context.Context, rootDigest digest.Digest, visit func(directoryDigest digest.Digest, directory *remoteexecution.Directory), notFound func(directoryDigest digest.Digest)) (bool, error) {
+	digestFunction := rootDigest.GetDigestFunction()
+	seen := map[string]struct{}{
+		rootDigest.GetKey(digest.KeyWithoutInstance): {},
+	}
+	currentLevel := []digest.Digest{rootDigest}
+	remainingDirectories := maximumClosureDirectoriesCount
+	for depth := 0; len(currentLevel) > 0; depth++ {
+		if depth >= maximumClosureDirectoryDepth {
+			return true, nil
+		}
+		var nextLevel []digest.Digest
+		for _, directoryDigest := range currentLevel {
+			if remainingDirectories <= 0 {
+				return true, nil
+			}
+			remainingDirectories--
+
+			directoryMessage, err := s.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
+			if err != nil {
+				if status.Code(err) == codes.NotFound {
+					notFound(directoryDigest)
+					continue
+				}
+				return false, err
+			}
+			directory := directoryMessage.(*remoteexecution.Directory)
+			visit(directoryDigest, directory)
+
+			for _, directoryNode := range directory.Directories {
+				childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+				if err != nil {
+					return false, err
+				}
+				childKey := childDigest.GetKey(digest.KeyWithoutInstance)
+				if _, ok := seen[childKey]; !ok {
+					seen[childKey] = struct{}{}
+					nextLevel = append(nextLevel, childDigest)
+				}
+			}
+		}
+		currentLevel = nextLevel
+	}
+	return false, nil
+}
+
+// jsonDigest is the representation of a digest in JSON responses. It
+// uses the same field names as the JSON representation of REv2's
+// Digest message.
+type jsonDigest struct {
+	Hash      string `json:"hash"`
+	SizeBytes int64  `json:"sizeBytes"`
+}
+
+func newJSONDigest(d digest.Digest) jsonDigest {
+	return jsonDigest{
+		Hash:      d.GetHashString(),
+		SizeBytes: d.GetSizeBytes(),
+	}
+}
+
+// findMissingBlobs returns the subset of a set of digests that is
+// absent from the Content Addressable Storage. FindMissing() is called
+// in batches, so that requests don't exceed the maximum message size of
+// the storage backend.
+func (s *BrowserService) findMissingBlobs(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missingDigests := digest.NewSetBuilder()
+	candidates := digests.Items()
+	for len(candidates) > 0 {
+		batchSize := len(candidates)
+		if batchSize > blobstore.RecommendedFindMissingDigestsCount {
+			batchSize = blobstore.RecommendedFindMissingDigestsCount
+		}
+		batch := digest.NewSetBuilder()
+		for _, candidate := range candidates[:batchSize] {
+			batch.Add(candidate)
+		}
+		candidates = candidates[batchSize:]
+
+		missing, err := s.contentAddressableStorage.FindMissing(ctx, batch.Build())
+		if err != nil {
+			return digest.EmptySet, err
+		}
+		for _, missingDigest := range missing.Items() {
+			missingDigests.Add(missingDigest)
+		}
+	}
+	return missingDigests.Build(), nil
+}
+
+// handleMissingBlobs reports which of the blobs that are needed to
+// execute an action are absent from the Content Addressable Storage.
+// This includes the Action and Command messages, and all directories
+// and files contained in the input root.
+func (s *BrowserService) handleMissingBlobs(w http.ResponseWriter, req *http.Request) {
+	actionDigest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	digestFunction := actionDigest.GetDigestFunction()
+	missingDigests := digest.NewSetBuilder()
+	candidateDigests := digest.NewSetBuilder()
+	truncated := false
+	actionMessage, err := s.contentAddressableStorage.Get(ctx, actionDigest).ToProto(&remoteexecution.Action{}, s.maximumMessageSizeBytes)
+	if err == nil {
+		action := actionMessage.(*remoteexecution.Action)
+		commandDigest, err := digestFunction.NewDigestFromProto(action.CommandDigest)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		candidateDigests.Add(commandDigest)
+
+		inputRootDigest, err := digestFunction.NewDigestFromProto(action.InputRootDigest)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		var visitErr error
+		truncated, err = s.walkDirectoryClosure(
+			ctx,
+			inputRootDigest,
+			func(directoryDigest digest.Digest, directory *remoteexecution.Directory) {
+				for _, fileNode := range directory.Files {
+					fileDigest, err := digestFunction.NewDigestFromProto(fileNode.Digest)
+					if err != nil {
+						visitErr = err
+						return
+					}
+					candidateDigests.Add(fileDigest)
+				}
+			},
+			func(directoryDigest digest.Digest) {
+				missingDigests.Add(directoryDigest)
+			})
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		if visitErr != nil {
+			s.renderError(w, req, visitErr)
+			return
+		}
+	} else if status.Code(err) == codes.NotFound {
+		missingDigests.Add(actionDigest)
+	} else {
+		s.renderError(w, req, err)
+		return
+	}
+
+	missing, err := s.findMissingBlobs(ctx, candidateDigests.Build())
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	for _, missingDigest := range missing.Items() {
+		missingDigests.Add(missingDigest)
+	}
+
+	response := struct {
+		MissingDigests []jsonDigest `json:"missingDigests"`
+		Truncated      bool         `json:"truncated"`
+	}{
+		MissingDigests: []jsonDigest{},
+		Truncated:      truncated,
+	}
+	for _, missingDigest := range missingDigests.Build().Items() {
+		response.MissingDigests = append(response.MissingDigests, newJSONDigest(missingDigest))
+	}
+	writeJSON(w, req, &response)
+}
+
+// maximumInputManifestEntriesCount is the maximum number of
+// directories, files and symbolic links that are visited while
+// generating an input manifest. Directories that are referenced at many
+// paths in an input root may cause the manifest to be exponentially
+// larger than the number of directories loaded. Directories are
+// counted as well, so that input roots that consist of many paths to
+// empty directories are also bounded.
+const maximumInputManifestEntriesCount = 1000000
+
+type inputManifestFile struct {
+	Path         string `json:"path"`
+	Hash         string `json:"hash"`
+	SizeBytes    int64  `json:"sizeBytes"`
+	IsExecutable bool   `json:"isExecutable"`
+}
+
+type inputManifestSymlink struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+}
+
+// inputManifest contains all files and symbolic links contained in the
+// input root of an action.
+type inputManifest struct {
+	Files     []inputManifestFile    `json:"files"`
+	Symlinks  []inputManifestSymlink `json:"symlinks"`
+	Truncated bool                   `json:"truncated"`
+}
+
+// getInputManifest returns a list of all files and symbolic links
+// contained in an input root, sorted by path. The traversal is bounded,
+// meaning that the manifest may be truncated for large input roots.
+func (s *BrowserService) getInputManifest(ctx context.Context, digestFunction digest.Function, inputRootDigest digest.Digest) (*inputManifest, error) {
+	// Load all directories in the input root. Directories that are
+	// referenced at multiple paths are only loaded once.
+	directories := map[string]*remoteexecution.Directory{}
+	var missingDirectoryDigest *digest.Digest
+	truncated, err := s.walkDirectoryClosure(
+		ctx,
+		inputRootDigest,
+		func(directoryDigest digest.Digest, directory *remoteexecution.Directory) {
+			directories[directoryDigest.GetKey(digest.KeyWithoutInstance)] = directory
+		},
+		func(directoryDigest digest.Digest) {
+			if missingDirectoryDigest == nil {
+				missingDirectoryDigest = &directoryDigest
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+	if missingDirectoryDigest != nil {
+		return nil, status.Errorf(codes.NotFound, "Directory %#v is not present in the Content Addressable Storage", missingDirectoryDigest.String())
+	}
+
+	// Expand the directories into a list of paths. Directories that
+	// were not loaded due to the traversal being bounded are
+	// omitted.
+	manifest := &inputManifest{
+		Files:    []inputManifestFile{},
+		Symlinks: []inputManifestSymlink{},
+	}
+	remainingEntries := maximumInputManifestEntriesCount
+	var expandDirectory func(directoryDigest digest.Digest, directoryPath string) error
+	expandDirectory = func(directoryDigest digest.Digest, directoryPath string) error {
+		directory, ok := directories[directoryDigest.GetKey(digest.KeyWithoutInstance)]
+		if !ok {
+			truncated = true
+			return nil
+		}
+		entriesCount := len(directory.Directories) + len(directory.Files) + len(directory.Symlinks)
+		if entriesCount > remainingEntries {
+			truncated = true
+			return nil
+		}
+		remainingEntries -= entriesCount
+		for _, fileNode := range directory.Files {
+			fileDigest, err := digestFunction.NewDigestFromProto(fileNode.Digest)
+			if err != nil {
+				return util.StatusWrapf(err, "Failed to extract digest for file %#v", directoryPath+fileNode.Name)
+			}
+			manifest.Files = append(manifest.Files, inputManifestFile{
+				Path:         directoryPath + fileNode.Name,
+				Hash:         fileDigest.GetHashString(),
+				SizeBytes:    fileDigest.GetSizeBytes(),
+				IsExecutable: fileNode.IsExecutable,
+			})
+		}
+		for _, symlinkNode := range directory.Symlinks {
+			manifest.Symlinks = append(manifest.Symlinks, inputManifestSymlink{
+				Path:   directoryPath + symlinkNode.Name,
+				Target: symlinkNode.Target,
+			})
+		}
+		for _, directoryNode := range directory.Directories {
+			childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+			if err != nil {
+				return util.StatusWrapf(err, "Failed to extract digest for directory %#v", directoryPath+directoryNode.Name)
+			}
+			if err := expandDirectory(childDigest, directoryPath+directoryNode.Name+"/"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := expandDirectory(inputRootDigest, ""); err != nil {
+		return nil, err
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	sort.Slice(manifest.Symlinks, func(i, j int) bool {
+		return manifest.Symlinks[i].Path < manifest.Symlinks[j].Path
+	})
+	manifest.Truncated = truncated
+	return manifest, nil
+}
+
+// handleInputManifest returns a list of all files and symbolic links
+// contained in the input root of an action, sorted by path. This
+// permits tooling to determine which inputs were provided to an action
+// without downloading their contents.
+//
+// The manifest is returned as JSON by default. With format=text, a
+// line-based representation is returned instead.
+func (s *BrowserService) handleInputManifest(w http.ResponseWriter, req *http.Request) {
+	actionDigest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	digestFunction := actionDigest.GetDigestFunction()
+	actionMessage, err := s.contentAddressableStorage.Get(ctx, actionDigest).ToProto(&remoteexecution.Action{}, s.maximumMessageSizeBytes)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	inputRootDigest, err := digestFunction.NewDigestFromProto(actionMessage.(*remoteexecution.Action).InputRootDigest)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	response, err := s.getInputManifest(ctx, digestFunction, inputRootDigest)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	if req.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, file := range response.Files {
+			fileType := "file"
+			if file.IsExecutable {
+				fileType = "executable"
+			}
+			fmt.Fprintf(bw, "%s %s-%d %s\n", fileType, file.Hash, file.SizeBytes, file.Path)
+		}
+		for _, symlink := range response.Symlinks {
+			fmt.Fprintf(bw, "symlink %s -> %s\n", symlink.Path, symlink.Target)
+		}
+		if response.Truncated {
+			fmt.Fprintf(bw, "# Manifest is truncated\n")
+		}
+		if err := bw.Flush(); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	writeJSON(w, req, response)
+}
+
+// blobTypes contains the types of messages that may be stored in the
+// Content Addressable Storage, and the pages on which they can be
+// displayed.
+var blobTypes = []struct {
+	Name       string
+	Message    proto.Message
+	PageSuffix string
+}{
+	{"Action", &remoteexecution.Action{}, "action"},
+	{"Command", &remoteexecution.Command{}, "command"},
+	{"Directory", &remoteexecution.Directory{}, "directory"},
+	{"Tree", &remoteexecution.Tree{}, "tree"},
+	{"Historical execute response", &cas_proto.HistoricalExecuteResponse{}, "historical_execute_response"},
+}
+
+// hasUnknownFields returns whether a message, or any of the messages
+// contained within, has fields that are not part of its schema.
+func hasUnknownFields(m protoreflect.Message) bool {
+	if len(m.GetUnknown()) > 0 {
+		return true
+	}
+	found := false
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				if hasUnknownFields(l.Get(i).Message()) {
+					found = true
+					return false
+				}
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				found = hasUnknownFields(v.Message())
+				return !found
+			})
+		case fd.Message() != nil && !fd.IsMap():
+			found = hasUnknownFields(v.Message())
+		}
+		return !found
+	})
+	return found
+}
+
+// isBlobOfType returns whether the contents of a blob are the exact
+// encoding of a message of a given type. Because Protobuf messages
+// don't carry any type information, blobs may successfully be
+// unmarshaled as messages of an incorrect type. False positives are
+// reduced by requiring that the message contains no unknown fields,
+// and that marshaling it again yields the original contents.
+func isBlobOfType(data []byte, messageType proto.Message) bool {
+	m := messageType.ProtoReflect().New().Interface()
+	if err := proto.Unmarshal(data, m); err != nil || hasUnknownFields(m.ProtoReflect()) {
+		return false
+	}
+	remarshaled, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	return err == nil && bytes.Equal(data, remarshaled)
+}
+
+// handleBlob displays a blob stored in the Content Addressable Storage
+// whose type is not known. It attempts to determine the type of the
+// blob, and redirects to the page that is capable of displaying it. If
+// the type is ambiguous, the user is asked to pick one.
+func (s *BrowserService) handleBlob(w http.ResponseWriter, req *http.Request) {
+	blobDigest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	digestPath := fmt.Sprintf("%s-%d/", blobDigest.GetHashString(), blobDigest.GetSizeBytes())
+	if blobDigest.GetSizeBytes() == 0 {
+		// The empty blob is a valid encoding of every message
+		// type, meaning it's always ambiguous. Display it as an
+		// empty file, which requires no access to storage.
+		http.Redirect(w, req, "../../file/"+digestPath+"blob", http.StatusFound)
+		return
+	}
+	if blobDigest.GetSizeBytes() > int64(s.maximumMessageSizeBytes) {
+		// Too large to be a message. Display it as a plain file.
+		http.Redirect(w, req, "../../file/"+digestPath+"blob", http.StatusFound)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	data, err := s.contentAddressableStorage.Get(ctx, blobDigest).ToByteSlice(s.maximumMessageSizeBytes)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	blobInfo := struct {
+		Digest    digest.Digest
+		BlobTypes []string
+		PageURLs  []string
+	}{
+		Digest: blobDigest,
+	}
+	for _, blobType := range blobTypes {
+		if isBlobOfType(data, blobType.Message) {
+			blobInfo.BlobTypes = append(blobInfo.BlobTypes, blobType.Name)
+			blobInfo.PageURLs = append(blobInfo.PageURLs, "../../"+blobType.PageSuffix+"/"+digestPath)
+		}
+	}
+
+	switch len(blobInfo.PageURLs) {
+	case 0:
+		// Not a known message type. Display it as a plain file.
+		http.Redirect(w, req, "../../file/"+digestPath+"blob", http.StatusFound)
+	case 1:
+		http.Redirect(w, req, blobInfo.PageURLs[0], http.StatusFound)
+	default:
+		if err := s.templates.ExecuteTemplate(w, "page_blob.html", &blobInfo); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// handleCommandOfAction redirects to the page of the Command message
+// of an action. Unlike the action page, this does not require any of
+// the other messages referenced by the action to be present.
+func (s *BrowserService) handleCommandOfAction(w http.ResponseWriter, req *http.Request) {
+	actionDigest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	actionMessage, err := s.contentAddressableStorage.Get(ctx, actionDigest).ToProto(&remoteexecution.Action{}, s.maximumMessageSizeBytes)
+	observeLookup("action", err)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	commandDigest, err := actionDigest.GetDigestFunction().NewDigestFromProto(actionMessage.(*remoteexecution.Action).CommandDigest)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrap(err, "Invalid command digest"))
+		return
+	}
+	http.Redirect(w, req, fmt.Sprintf("../../command/%s-%d/", commandDigest.GetHashString(), commandDigest.GetSizeBytes()), http.StatusFound)
+}
+
+func (s *BrowserService) handleCommand(w http.ResponseWriter, req *http.Request) {
+	digest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	commandMessage, err := s.contentAddressableStorage.Get(ctx, digest).ToProto(&remoteexecution.Command{}, s.maximumMessageSizeBytes)
+	observeLookup("command", err)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	command := commandMessage.(*remoteexecution.Command)
+
+	if acceptsJSON(req) {
+		writeProtoJSON(w, req, command)
+		return
+	}
+	if format := req.URL.Query().Get("format"); format == "sh" || format == "script" {
+		// Generate a script that creates the parent directories
+		// of outputs, switches to the working directory, sets
+		// environment variables and runs the command. Arguments
+		// and values of environment variables are quoted.
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.sh\"", digest.GetHashString()))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		if err := builder.ConvertCommandToShellScript(command, bw); err != nil {
+			log.Print(err)
+			panic(http.ErrAbortHandler)
+		}
+		if err := bw.Flush(); err != nil {
+			log.Print(err)
+			panic(http.ErrAbortHandler)
+		}
+	} else {
+		if err := s.templates.ExecuteTemplate(w, "page_command.html", commandInfo{
+			Digest:         digest,
+			Command:        command,
+			ContainerImage: getContainerImage(command.Platform),
+			BBClientdPath:  formatBBClientdPath(s.getBBClientdBlobPath(digest, commandDirectoryComponent)),
+			Permalink:      s.getPermalink(req, "command", digest, ""),
+			ShowRawMessage: shouldShowRawMessages(req),
+		}); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// applyNodePropertiesToTarHeader copies the permissions and
+// modification time stored in the node properties of a file or
+// directory into a tar header, overriding the defaults.
+func applyNodePropertiesToTarHeader(nodeProperties *remoteexecution.NodeProperties, header *tar.Header) {
+	if unixMode := nodeProperties.GetUnixMode(); unixMode != nil {
+		header.Mode = int64(unixMode.Value & 0o7777)
+	}
+	if mtime := nodeProperties.GetMtime(); mtime.CheckValid() == nil {
+		header.ModTime = mtime.AsTime()
+	}
+}
+
+func (s *BrowserService) generateTarballDirectory(ctx context.Context, w *tar.Writer, digestFunction digest.Function, directory *remoteexecution.Directory, directoryPath *path.Trace, getDirectory func(context.Context, digest.Digest) (*remoteexecution.Directory, error), filesSeen map[string]string) error {
+	// Emit child directories.
+	for _, directoryNode := range directory.Directories {
+		childName, ok := path.NewComponent(directoryNode.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Directory %#v in directory %#v has an invalid name", directoryNode.Name, directoryPath.String())
+		}
+		childPath := directoryPath.Append(childName)
+
+		// Fetch the child directory prior to emitting its header,
+		// as the directory's own node properties are stored in
+		// the Directory message, as opposed to the DirectoryNode.
+		childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+		if err != nil {
+			return err
+		}
+		childDirectory, err := getDirectory(ctx, childDigest)
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     childPath.String(),
+			Mode:     0o777,
+		}
+		applyNodePropertiesToTarHeader(childDirectory.NodeProperties, header)
+		if err := w.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := s.generateTarballDirectory(ctx, w, digestFunction, childDirectory, childPath, getDirectory, filesSeen); err != nil {
+			return err
+		}
+	}
+
+	// Emit symlinks.
+	for _, symlinkNode := range directory.Symlinks {
+		childName, ok := path.NewComponent(symlinkNode.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Symbolic link %#v in directory %#v has an invalid name", symlinkNode.Name, directoryPath.String())
+		}
+		childPath := directoryPath.Append(childName)
+
+		if err := w.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeSymlink,
+			Name:     childPath.String(),
+			Linkname: symlinkNode.Target,
+			Mode:     0o777,
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Emit regular files.
+	for _, fileNode := range directory.Files {
+		childName, ok := path.NewComponent(fileNode.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "File %#v in directory %#v has an invalid name", fileNode.Name, directoryPath.String())
+		}
+		childPath := directoryPath.Append(childName)
+		childPathString := childPath.String()
+
+		childDigest, err := digestFunction.NewDigestFromProto(fileNode.Digest)
+		if err != nil {
+			return err
+		}
+
+		childKey := childDigest.GetKey(digest.KeyWithoutInstance)
+		if fileNode.IsExecutable {
+			childKey += "+x"
+		} else {
+			childKey += "-x"
+		}
+
+		if linkPath, ok := filesSeen[childKey]; ok {
+			// This file was already returned previously.
+			// Emit a hardlink pointing to the first
+			// occurrence.
+			//
+			// Not only does this reduce the size of the
+			// tarball, it also makes the directory more
+			// representative of what it looks like when
+			// executed through bb_worker.
+			if err := w.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeLink,
+				Name:     childPathString,
+				Linkname: linkPath,
+			}); err != nil {
+				return err
+			}
+		} else {
+			// This is the first time we're returning this
+			// file. Actually add it to the archive.
+			mode := int64(0o666)
+			if fileNode.IsExecutable {
+				mode = 0o777
+			}
+			if err := w.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     childPathString,
+				Size:     fileNode.Digest.SizeBytes,
+				Mode:     mode,
+			}); err != nil {
+				return err
+			}
 
-		OutputDirectories []*remoteexecution.OutputDirectory
-		OutputSymlinks    []*remoteexecution.OutputSymlink
-		OutputFiles       []*remoteexecution.OutputFile
-		MissingPaths      []string
+			if err := s.contentAddressableStorage.Get(ctx, childDigest).IntoWriter(w); err != nil {
+				return err
+			}
 
-		PreviousExecutionStats *previousExecutionStatsInfo
+			filesSeen[childKey] = childPathString
+		}
+	}
+	return nil
+}
+
+// skippingWriter is a decorator for io.Writer that discards the first
+// bytes written to it. It also counts the total number of bytes
+// written, including the ones that are discarded.
+type skippingWriter struct {
+	w       io.Writer
+	skip    int64
+	written int64
+}
+
+func (sw *skippingWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	sw.written += int64(n)
+	if sw.skip >= int64(n) {
+		sw.skip -= int64(n)
+		return n, nil
+	}
+	if _, err := sw.w.Write(p[sw.skip:]); err != nil {
+		return 0, err
+	}
+	sw.skip = 0
+	return n, nil
+}
+
+// getRangeStart extracts the offset at which an open-ended byte range
+// of the form "bytes=${start}-" starts, which is what clients send to
+// resume an interrupted download. Other kinds of ranges are not
+// supported, in which case the full response should be returned.
+func getRangeStart(req *http.Request) (int64, bool) {
+	if req.Header.Get("If-Range") != "" {
+		// Tarballs have no validators that can be compared.
+		return 0, false
+	}
+	spec, ok := strings.CutPrefix(req.Header.Get("Range"), "bytes=")
+	if !ok {
+		return 0, false
+	}
+	startStr, ok := strings.CutSuffix(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, false
+	}
+	return start, true
+}
+
+// shouldCompressTarball returns whether a tarball returned to a client
+// should be gzip compressed. Clients that extract tarballs directly
+// (e.g., "curl | tar x") may request an uncompressed tarball by
+// providing "compression=none", or by sending an Accept-Encoding header
+// that only accepts the identity content coding.
+//
+// As gzip compression is part of the tarball's file format, as opposed
+// to being a content coding, the content codings that the client
+// accepts are otherwise irrelevant.
+func shouldCompressTarball(req *http.Request) bool {
+	if req.URL.Query().Get("compression") == "none" {
+		return false
+	}
+	acceptsIdentity := false
+	for coding, quality := range parseAcceptEncoding(req.Header.Get("Accept-Encoding")) {
+		if quality > 0 {
+			if coding != "identity" {
+				return true
+			}
+			acceptsIdentity = true
+		}
+	}
+	return !acceptsIdentity
+}
+
+// setTarballHeaders sets the response headers of a tarball download,
+// with a filename extension that corresponds to whether the tarball is
+// compressed.
+func setTarballHeaders(h http.Header, basename string, compress bool) {
+	if compress {
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.tar.gz\"", basename))
+		h.Set("Content-Type", "application/gzip")
+	} else {
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.tar\"", basename))
+		h.Set("Content-Type", "application/x-tar")
+	}
+}
+
+// newTarballWriter creates a tar.Writer that writes a tarball,
+// optionally gzip compressed. The function that is returned must be
+// called to finalize the tarball.
+func newTarballWriter(w io.Writer, compress bool) (*tar.Writer, func() error) {
+	if !compress {
+		tarWriter := tar.NewWriter(w)
+		return tarWriter, tarWriter.Close
+	}
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	return tarWriter, func() error {
+		if err := tarWriter.Close(); err != nil {
+			return err
+		}
+		return gzipWriter.Close()
+	}
+}
+
+func (s *BrowserService) writeTarball(ctx context.Context, w io.Writer, digestFunction digest.Function, directory *remoteexecution.Directory, getDirectory func(context.Context, digest.Digest) (*remoteexecution.Directory, error), compress bool) error {
+	tarWriter, closeTarball := newTarballWriter(w, compress)
+
+	// Always emit an entry for the root directory, so that
+	// extracting the tarball of an empty directory still yields a
+	// directory.
+	header := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     "./",
+		Mode:     0o777,
+	}
+	applyNodePropertiesToTarHeader(directory.NodeProperties, header)
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+
+	filesSeen := map[string]string{}
+	if err := s.generateTarballDirectory(ctx, tarWriter, digestFunction, directory, nil, getDirectory, filesSeen); err != nil {
+		return err
+	}
+	return closeTarball()
+}
+
+// activeTarballGeneration contains the state of a tarball that is
+// currently being generated, which may be listed and cancelled by
+// administrators.
+type activeTarballGeneration struct {
+	ID           uint64 `json:"id"`
+	InstanceName string `json:"instanceName"`
+	// The digest of the directory or action whose contents are
+	// placed in the tarball. For batch downloads, this contains
+	// the number of blobs that are requested instead.
+	Digest    string    `json:"digest"`
+	Client    string    `json:"client"`
+	StartTime time.Time `json:"startTime"`
+
+	instanceName digest.InstanceName
+	cancel       context.CancelFunc
+}
+
+func (s *BrowserService) registerTarballGeneration(instanceName digest.InstanceName, digest, client string, cancel context.CancelFunc) uint64 {
+	s.tarballGenerationsLock.Lock()
+	defer s.tarballGenerationsLock.Unlock()
+
+	s.nextTarballGenerationID++
+	id := s.nextTarballGenerationID
+	s.activeTarballGenerations[id] = &activeTarballGeneration{
+		ID:           id,
+		InstanceName: instanceName.String(),
+		Digest:       digest,
+		Client:       client,
+		StartTime:    s.clock.Now(),
+
+		instanceName: instanceName,
+		cancel:       cancel,
+	}
+	return id
+}
+
+func (s *BrowserService) unregisterTarballGeneration(id uint64) {
+	s.tarballGenerationsLock.Lock()
+	delete(s.activeTarballGenerations, id)
+	s.tarballGenerationsLock.Unlock()
+}
+
+// The value of the Retry-After header that is returned when a request
+// for a tarball is rejected, due to too many tarballs being generated
+// concurrently.
+const tarballGenerationRetryAfterSeconds = 10
+
+// acquireTarballGenerationSlot waits until fewer tarballs are being
+// generated concurrently than the configured limit. If the limit is
+// still reached after the maximum queueing duration, an error page is
+// written and false is returned. Otherwise,
+// releaseTarballGenerationSlot() must be called after the tarball has
+// been generated.
+func (s *BrowserService) acquireTarballGenerationSlot(w http.ResponseWriter, req *http.Request) bool {
+	if s.tarballGenerationSlots == nil {
+		return true
+	}
+	select {
+	case s.tarballGenerationSlots <- struct{}{}:
+		return true
+	default:
+	}
+	if s.maximumTarballGenerationQueueingDuration > 0 {
+		timer := time.NewTimer(s.maximumTarballGenerationQueueingDuration)
+		defer timer.Stop()
+		select {
+		case s.tarballGenerationSlots <- struct{}{}:
+			return true
+		case <-timer.C:
+		case <-req.Context().Done():
+			s.renderError(w, req, util.StatusFromContext(req.Context()))
+			return false
+		}
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(tarballGenerationRetryAfterSeconds))
+	s.renderError(w, req, status.Error(codes.Unavailable, "Too many tarballs are being generated concurrently"))
+	return false
+}
+
+func (s *BrowserService) releaseTarballGenerationSlot() {
+	if s.tarballGenerationSlots != nil {
+		<-s.tarballGenerationSlots
+	}
+}
+
+// handleListTarballGenerations returns a JSON list of all tarballs that
+// are currently being generated. Only generations belonging to
+// instance names for which the client is authorized are listed.
+func (s *BrowserService) handleListTarballGenerations(w http.ResponseWriter, req *http.Request) {
+	s.tarballGenerationsLock.Lock()
+	allGenerations := make([]activeTarballGeneration, 0, len(s.activeTarballGenerations))
+	for _, generation := range s.activeTarballGenerations {
+		allGenerations = append(allGenerations, *generation)
+	}
+	s.tarballGenerationsLock.Unlock()
+
+	instanceNames := make([]digest.InstanceName, 0, len(allGenerations))
+	for _, generation := range allGenerations {
+		instanceNames = append(instanceNames, generation.instanceName)
+	}
+	generations := make([]activeTarballGeneration, 0, len(allGenerations))
+	for i, err := range s.listTarballGenerationsAuthorizer.Authorize(extractContextFromRequest(req), instanceNames) {
+		if err == nil {
+			generations = append(generations, allGenerations[i])
+		}
+	}
+
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].ID < generations[j].ID
+	})
+	writeJSON(w, req, generations)
+}
+
+// handleCancelTarballGeneration cancels the generation of a tarball.
+// This can be used to stop downloads that are saturating storage.
+func (s *BrowserService) handleCancelTarballGeneration(w http.ResponseWriter, req *http.Request) {
+	idStr := mux.Vars(req)["id"]
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid tarball generation ID %#v", idStr))
+		return
+	}
+
+	s.tarballGenerationsLock.Lock()
+	generation, ok := s.activeTarballGenerations[id]
+	s.tarballGenerationsLock.Unlock()
+
+	// Authorize the request before reporting that the generation
+	// does not exist, so that clients can't determine which IDs are
+	// in use. As the instance name of an unknown generation is not
+	// known, the empty instance name is used instead.
+	instanceName := digest.EmptyInstanceName
+	if ok {
+		instanceName = generation.instanceName
+	}
+	if err := auth.AuthorizeSingleInstanceName(extractContextFromRequest(req), s.cancelTarballGenerationAuthorizer, instanceName); err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	if !ok {
+		s.renderError(w, req, status.Errorf(codes.NotFound, "Tarball generation %d not found", id))
+		return
+	}
+	generation.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *BrowserService) generateTarball(ctx context.Context, w http.ResponseWriter, req *http.Request, digest digest.Digest, directory *remoteexecution.Directory, getDirectory func(context.Context, digest.Digest) (*remoteexecution.Directory, error)) {
+	if !s.acquireTarballGenerationSlot(w, req) {
+		return
+	}
+	defer s.releaseTarballGenerationSlot()
+
+	// Track the generation of the tarball, so that administrators
+	// may cancel it. Cancellation causes any reads against storage
+	// to be interrupted.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	id := s.registerTarballGeneration(digest.GetInstanceName(), digest.String(), req.RemoteAddr, cancel)
+	defer s.unregisterTarballGeneration(id)
+
+	compress := shouldCompressTarball(req)
+	setTarballHeaders(w.Header(), digest.GetHashString(), compress)
+
+	var out io.Writer = w
+	ranged := false
+	if req.URL.Query().Get("reproducible") == "1" {
+		// Tarballs contain no timestamps and list entries in the
+		// order in which they appear in the Directory messages,
+		// meaning that the archive can be regenerated in an
+		// identical way. This permits resuming downloads.
+		w.Header().Set("Accept-Ranges", "bytes")
+		if start, ok := getRangeStart(req); ok {
+			// Tarballs are generated on the fly, and gzip
+			// streams are not seekable. Resuming a download
+			// requires regenerating the archive from the
+			// start, discarding everything the client already
+			// received. As Content-Range needs to contain the
+			// total size, the archive is generated twice.
+			// This is expensive, but still cheaper than
+			// letting the client restart a large download.
+			counter := &skippingWriter{w: io.Discard}
+			if err := s.writeTarball(ctx, counter, digest.GetDigestFunction(), directory, getDirectory, compress); err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+			total := counter.written
+			if start >= total {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, total-1, total))
+			w.Header().Set("Content-Length", strconv.FormatInt(total-start, 10))
+			w.WriteHeader(http.StatusPartialContent)
+			out = &skippingWriter{w: w, skip: start}
+			ranged = true
+		}
+	}
+
+	// Partial responses have a Content-Length, meaning they are not
+	// sent using chunked encoding and can't carry trailers.
+	statusTrailerDeclared := !ranged && declareTarballStatusTrailer(w, req)
+	finishTarball(w, s.writeTarball(ctx, out, digest.GetDigestFunction(), directory, getDirectory, compress), statusTrailerDeclared)
+}
+
+// tarballStatusTrailer is the name of the HTTP trailer through which
+// the completion status of a tarball is reported.
+const tarballStatusTrailer = "X-Tarball-Status"
+
+// declareTarballStatusTrailer announces that the completion status of
+// a tarball is reported through an HTTP trailer. As tarballs are
+// streamed, the status code of the response is sent before it is known
+// whether generation succeeds. This is only done if the client
+// indicated that it accepts trailers by sending "TE: trailers".
+func declareTarballStatusTrailer(w http.ResponseWriter, req *http.Request) bool {
+	for _, te := range strings.Split(req.Header.Get("TE"), ",") {
+		if coding, _, _ := strings.Cut(te, ";"); strings.EqualFold(strings.TrimSpace(coding), "trailers") {
+			w.Header().Set("Trailer", tarballStatusTrailer)
+			return true
+		}
+	}
+	return false
+}
+
+// finishTarball reports the outcome of writing a tarball to the
+// client. If the client accepts trailers, the outcome is stored in a
+// trailer, so that the response can be terminated properly. Otherwise
+// the response is aborted on failure, so that the client can observe
+// that the tarball is incomplete.
+func finishTarball(w http.ResponseWriter, err error, statusTrailerDeclared bool) {
+	if err != nil {
+		log.Print(err)
+		if !statusTrailerDeclared {
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set(tarballStatusTrailer, "incomplete")
+		return
+	}
+	if statusTrailerDeclared {
+		w.Header().Set(tarballStatusTrailer, "complete")
+	}
+}
+
+const (
+	// Limits on the requests that may be sent to the batch download
+	// endpoint.
+	maximumBatchDownloadRequestSizeBytes = 1 << 20
+	maximumBatchDownloadBlobsCount       = 1000
+)
+
+// handleBatchDownload returns a tarball containing multiple blobs
+// stored in the Content Addressable Storage. The digests of the blobs
+// are provided as a JSON object in the request body. Every blob is
+// stored in the tarball under the name "${hash}-${size_bytes}". The
+// tarball also contains a file named "manifest.json", listing which of
+// the requested blobs are included, and which are missing.
+func (s *BrowserService) handleBatchDownload(w http.ResponseWriter, req *http.Request) {
+	digestFunction, err := s.getDigestFunctionFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	var request struct {
+		Digests []jsonDigest `json:"digests"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maximumBatchDownloadRequestSizeBytes)).Decode(&request); err != nil {
+		s.renderError(w, req, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to parse request"))
+		return
+	}
+	if len(request.Digests) > maximumBatchDownloadBlobsCount {
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Request contains %d digests, while at most %d digests may be downloaded at once", len(request.Digests), maximumBatchDownloadBlobsCount))
+		return
+	}
+	requestedDigests := digest.NewSetBuilder()
+	for _, requestedDigest := range request.Digests {
+		blobDigest, err := digestFunction.NewDigest(requestedDigest.Hash, requestedDigest.SizeBytes)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapf(err, "Invalid digest %#v", fmt.Sprintf("%s-%d", requestedDigest.Hash, requestedDigest.SizeBytes)))
+			return
+		}
+		requestedDigests.Add(blobDigest)
+	}
+
+	ctx := extractContextFromRequest(req)
+	missingDigests, err := s.findMissingBlobs(ctx, requestedDigests.Build())
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	presentDigests, _, _ := digest.GetDifferenceAndIntersection(requestedDigests.Build(), missingDigests)
+
+	manifest := struct {
+		Blobs        []jsonDigest `json:"blobs"`
+		MissingBlobs []jsonDigest `json:"missingBlobs"`
 	}{
-		IsHistoricalExecuteResponse: isHistoricalExecuteResponse,
-		ActionDigest:                actionDigest,
-		ExecuteResponse:             executeResponse,
+		Blobs:        []jsonDigest{},
+		MissingBlobs: []jsonDigest{},
+	}
+	for _, presentDigest := range presentDigests.Items() {
+		manifest.Blobs = append(manifest.Blobs, newJSONDigest(presentDigest))
+	}
+	for _, missingDigest := range missingDigests.Items() {
+		manifest.MissingBlobs = append(manifest.MissingBlobs, newJSONDigest(missingDigest))
+	}
+	manifestJSON, err := json.Marshal(&manifest)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	if !s.acquireTarballGenerationSlot(w, req) {
+		return
+	}
+	defer s.releaseTarballGenerationSlot()
+
+	// Track the generation of the tarball, so that administrators
+	// may cancel it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	id := s.registerTarballGeneration(digestFunction.GetInstanceName(), fmt.Sprintf("%d blobs", presentDigests.Length()), req.RemoteAddr, cancel)
+	defer s.unregisterTarballGeneration(id)
+
+	compress := shouldCompressTarball(req)
+	setTarballHeaders(w.Header(), "blobs", compress)
+	// Blobs may have been removed from storage after FindMissing()
+	// was called. As the response has already been partially
+	// written, this can only be reported by aborting or through a
+	// trailer.
+	statusTrailerDeclared := declareTarballStatusTrailer(w, req)
+	finishTarball(w, s.writeBatchDownloadTarball(ctx, w, manifestJSON, presentDigests, compress), statusTrailerDeclared)
+}
+
+func (s *BrowserService) writeBatchDownloadTarball(ctx context.Context, w io.Writer, manifestJSON []byte, digests digest.Set, compress bool) error {
+	tarWriter, closeTarball := newTarballWriter(w, compress)
+
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "manifest.json",
+		Size:     int64(len(manifestJSON)),
+		Mode:     0o666,
+	}); err != nil {
+		return err
+	}
+	if _, err := tarWriter.Write(manifestJSON); err != nil {
+		return err
+	}
+
+	for _, blobDigest := range digests.Items() {
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     fmt.Sprintf("%s-%d", blobDigest.GetHashString(), blobDigest.GetSizeBytes()),
+			Size:     blobDigest.GetSizeBytes(),
+			Mode:     0o666,
+		}); err != nil {
+			return err
+		}
+		if err := s.contentAddressableStorage.Get(ctx, blobDigest).IntoWriter(tarWriter); err != nil {
+			return util.StatusWrapf(err, "Failed to read blob %#v", blobDigest.String())
+		}
+	}
+	return closeTarball()
+}
+
+func (s *BrowserService) handleDirectory(w http.ResponseWriter, req *http.Request) {
+	directoryDigest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	directory := &remoteexecution.Directory{}
+	if directoryDigest.GetSizeBytes() > 0 {
+		// Empty directories don't need to be loaded from
+		// storage, as they serialize to an empty blob.
+		directoryMessage, err := s.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
+		observeLookup("directory", err)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		directory = directoryMessage.(*remoteexecution.Directory)
+	}
+
+	if acceptsJSON(req) {
+		writeProtoJSON(w, req, directory)
+	} else if req.URL.Query().Get("format") == "tar" {
+		s.generateTarball(ctx, w, req, directoryDigest, directory, func(ctx context.Context, digest digest.Digest) (*remoteexecution.Directory, error) {
+			directoryMessage, err := s.contentAddressableStorage.Get(ctx, digest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
+			if err != nil {
+				return nil, err
+			}
+			return directoryMessage.(*remoteexecution.Directory), nil
+		})
+	} else {
+		var fileSystemAccessProfileReference *query.FileSystemAccessProfileReference
+		var bloomFilter *access.BloomFilterReader
+		if profileReferenceJSON := req.URL.Query().Get("file_system_access_profile"); profileReferenceJSON != "" {
+			// A file system access profile reference is provided as
+			// part of the URL. Obtain the Bloom filter from the
+			// File System Access Cache (FSAC), so that we can
+			// display file usage for the current directory.
+			var profileReference query.FileSystemAccessProfileReference
+			if err := protojson.Unmarshal([]byte(profileReferenceJSON), &profileReference); err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+			profileDigest, err := directoryDigest.GetDigestFunction().NewDigestFromProto(profileReference.Digest)
+			if err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+			profileMessage, err := s.fileSystemAccessCache.Get(ctx, profileDigest).ToProto(&fsac.FileSystemAccessProfile{}, s.maximumMessageSizeBytes)
+			if err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+			profile := profileMessage.(*fsac.FileSystemAccessProfile)
+			bloomFilterReader, err := access.NewBloomFilterReader(profile.BloomFilter, profile.BloomFilterHashFunctions)
+			if err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+			fileSystemAccessProfileReference = &profileReference
+			bloomFilter = bloomFilterReader
+		}
+
+		info := &directoryInfo{
+			Digest:                           directoryDigest,
+			InstanceName:                     directoryDigest.GetInstanceName(),
+			Directory:                        directory,
+			BBClientdPath:                    formatBBClientdPath(s.getBBClientdBlobPath(directoryDigest, directoryDirectoryComponent)),
+			Permalink:                        s.getPermalink(req, "directory", directoryDigest, ""),
+			FileSystemAccessProfileReference: fileSystemAccessProfileReference,
+			BloomFilter:                      bloomFilter,
+			FileDuplicatesCounts:             computeFileDuplicatesCounts(directory),
+			ShowRawMessage:                   shouldShowRawMessages(req),
+		}
+		if req.URL.Query().Get("recursive_size") == "1" {
+			info.RecursiveStats, err = s.getDirectoryStats(ctx, directoryDigest, directory)
+			if err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+		}
+		if info.GetEntriesCount() >= streamedDirectoryMinimumEntriesCount {
+			s.streamDirectory(w, info)
+		} else if err := s.templates.ExecuteTemplate(w, "page_directory.html", info); err != nil {
+			log.Print(err)
+		}
 	}
+}
 
-	ctx := extractContextFromRequest(req)
-	actionResult := executeResponse.GetResult()
-	digestFunction := actionDigest.GetDigestFunction()
-	if actionResult != nil {
-		actionInfo.OutputDirectories = actionResult.OutputDirectories
-		if len(actionResult.OutputSymlinks) > 0 {
-			// REv2.1 uses 'output_symlinks'.
-			actionInfo.OutputSymlinks = actionResult.OutputSymlinks
-		} else {
-			// REv2.0 uses 'output_{directory,file}_symlinks'.
-			actionInfo.OutputSymlinks = append(append([]*remoteexecution.OutputSymlink(nil), actionResult.OutputDirectorySymlinks...), actionResult.OutputFileSymlinks...)
+const (
+	// Directories containing at least this number of entries are
+	// rendered incrementally, flushing the response periodically.
+	streamedDirectoryMinimumEntriesCount = 10000
+	// The number of entries of a directory that is rendered
+	// incrementally after which the response is flushed.
+	streamedDirectoryFlushInterval = 1000
+)
+
+// directoryEntryInfo is provided to the templates that render a single
+// entry of a directory that is rendered incrementally.
+type directoryEntryInfo struct {
+	Directory *directoryInfo
+	Node      interface{}
+}
+
+// streamDirectory renders a directory listing by executing a separate
+// template for every entry, as opposed to executing a single template
+// for the directory as a whole. This allows the response to be
+// flushed periodically, so that browsers can display large
+// directories before they have been rendered completely.
+func (s *BrowserService) streamDirectory(w http.ResponseWriter, di *directoryInfo) {
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
 		}
-		actionInfo.OutputFiles = actionResult.OutputFiles
+	}
 
-		var err error
-		actionInfo.StdoutInfo, err = s.getLogInfoFromActionResult(ctx, "Standard output", digestFunction, actionResult.StdoutDigest, actionResult.StdoutRaw)
-		if err != nil {
-			s.renderError(w, err)
+	// Emit the header, including the link for downloading the
+	// directory as a tarball, prior to rendering any entries.
+	if err := s.templates.ExecuteTemplate(w, "directory_streamed_header", di); err != nil {
+		log.Print(err)
+		return
+	}
+	flush()
+
+	entriesWritten := 0
+	writeEntry := func(templateName string, node interface{}) bool {
+		if err := s.templates.ExecuteTemplate(w, templateName, directoryEntryInfo{
+			Directory: di,
+			Node:      node,
+		}); err != nil {
+			log.Print(err)
+			return false
+		}
+		entriesWritten++
+		if entriesWritten%streamedDirectoryFlushInterval == 0 {
+			flush()
+		}
+		return true
+	}
+	for _, directoryNode := range di.Directory.Directories {
+		if !writeEntry("directory_streamed_directory", directoryNode) {
 			return
 		}
-		actionInfo.StderrInfo, err = s.getLogInfoFromActionResult(ctx, "Standard error", digestFunction, actionResult.StderrDigest, actionResult.StderrRaw)
-		if err != nil {
-			s.renderError(w, err)
+	}
+	for _, symlinkNode := range di.Directory.Symlinks {
+		if !writeEntry("directory_streamed_symlink", symlinkNode) {
+			return
+		}
+	}
+	for _, fileNode := range di.Directory.Files {
+		if !writeEntry("directory_streamed_file", fileNode) {
 			return
 		}
 	}
 
-	actionMessage, err := s.contentAddressableStorage.Get(ctx, actionDigest).ToProto(&remoteexecution.Action{}, s.maximumMessageSizeBytes)
-	if err == nil {
-		action := actionMessage.(*remoteexecution.Action)
-		actionInfo.Action = action
+	if err := s.templates.ExecuteTemplate(w, "directory_streamed_footer", nil); err != nil {
+		log.Print(err)
+	}
+}
 
-		commandDigest, err := digestFunction.NewDigestFromProto(action.CommandDigest)
-		if err != nil {
-			s.renderError(w, err)
-			return
+// contentTypeOverrideAllowlist contains the content types that may be
+// provided through the "content_type" query parameter. Types that
+// browsers may execute scripts from (e.g., "text/html", "text/xml",
+// "application/xml" and "image/svg+xml") are deliberately absent, as
+// serving user provided blobs under those types would permit
+// cross-site scripting.
+var contentTypeOverrideAllowlist = map[string]bool{
+	"application/json":         true,
+	"application/octet-stream": true,
+	"image/avif":               true,
+	"image/bmp":                true,
+	"image/gif":                true,
+	"image/jpeg":               true,
+	"image/png":                true,
+	"image/webp":               true,
+	"text/plain":               true,
+}
+
+// getContentTypeOverride returns the content type that the client
+// requested to be used for serving a file through the "content_type"
+// query parameter. Only content types that cannot be abused for
+// cross-site scripting are permitted. Parameters other than the
+// character set are stripped.
+func getContentTypeOverride(req *http.Request) (string, error) {
+	contentType := req.URL.Query().Get("content_type")
+	if contentType == "" {
+		return "", nil
+	}
+	mediaType, parameters, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Invalid content type %#v", contentType)
+	}
+	if contentTypeOverrideAllowlist[mediaType] {
+		var sanitizedParameters map[string]string
+		if charset, ok := parameters["charset"]; ok {
+			sanitizedParameters = map[string]string{"charset": charset}
 		}
-		commandMessage, err := s.contentAddressableStorage.Get(ctx, commandDigest).ToProto(&remoteexecution.Command{}, s.maximumMessageSizeBytes)
-		if err == nil {
-			command := commandMessage.(*remoteexecution.Command)
-			actionInfo.Command = &commandInfo{
-				Digest:        commandDigest,
-				Command:       command,
-				BBClientdPath: formatBBClientdPath(s.getBBClientdBlobPath(commandDigest, commandDirectoryComponent)),
-			}
+		if formatted := mime.FormatMediaType(mediaType, sanitizedParameters); formatted != "" {
+			return formatted, nil
+		}
+	}
+	return "", status.Errorf(codes.InvalidArgument, "Content type %#v is not permitted", contentType)
+}
 
-			foundPaths := map[string]struct{}{}
-			for _, outputDirectory := range actionInfo.OutputDirectories {
-				foundPaths[outputDirectory.Path] = struct{}{}
-			}
-			for _, outputSymlinks := range actionInfo.OutputSymlinks {
-				foundPaths[outputSymlinks.Path] = struct{}{}
-			}
-			for _, outputFiles := range actionInfo.OutputFiles {
-				foundPaths[outputFiles.Path] = struct{}{}
-			}
-			if len(command.OutputPaths) > 0 {
-				// REv2.1 uses output_paths.
-				for _, outputPath := range command.OutputPaths {
-					if _, ok := foundPaths[outputPath]; !ok {
-						actionInfo.MissingPaths = append(actionInfo.MissingPaths, outputPath)
-					}
-				}
-			} else {
-				// REv2.0 uses output_{directories,files}.
-				for _, outputDirectory := range command.OutputDirectories {
-					if _, ok := foundPaths[outputDirectory]; !ok {
-						actionInfo.MissingPaths = append(actionInfo.MissingPaths, outputDirectory)
-					}
-				}
-				for _, outputFile := range command.OutputFiles {
-					if _, ok := foundPaths[outputFile]; !ok {
-						actionInfo.MissingPaths = append(actionInfo.MissingPaths, outputFile)
-					}
-				}
-			}
-		} else if status.Code(err) != codes.NotFound {
-			s.renderError(w, err)
+func (s *BrowserService) handleFile(w http.ResponseWriter, req *http.Request) {
+	digest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	if actionStr := req.URL.Query().Get("action"); actionStr != "" {
+		s.handleOutputFileNavigation(w, req, digest, mux.Vars(req)["name"], actionStr)
+		return
+	}
+
+	// Display text files with a range of lines highlighted, if
+	// requested.
+	name := mux.Vars(req)["name"]
+	if query := req.URL.Query(); query.Get("lines") != "" && query.Get("raw") != "1" {
+		s.serveTextFile(w, req, digest, name)
+		return
+	}
+
+	// Render markdown files, unless the raw contents are requested
+	// explicitly. This is only done for this page, so that output
+	// files accessed through the action remain downloadable as is.
+	if contentTypeOverride, err := getContentTypeOverride(req); err == nil && contentTypeOverride == "" &&
+		req.URL.Query().Get("raw") != "1" && digest.GetSizeBytes() > 0 && isMarkdownFile(name, digest) {
+		if s.serveMarkdownFile(w, req, digest, name) {
 			return
 		}
+	}
+	s.serveFile(w, req, digest, name)
+}
+
+// outputFileNavigationLink is a link to another output file of the same
+// action, displayed on the output file navigation page.
+type outputFileNavigationLink struct {
+	Path string
+	URL  string
+}
+
+// outputFileNavigationInfo contains the data that is passed to the
+// template of the output file navigation page.
+type outputFileNavigationInfo struct {
+	Path string
+	// The position of the file among the sorted output files of the
+	// action, starting at one.
+	Index        int
+	Count        int
+	ActionDigest digest.Digest
+	Previous     *outputFileNavigationLink
+	Next         *outputFileNavigationLink
+	Preview      *logInfo
+}
+
+// handleOutputFileNavigation displays an output file of an action,
+// together with links to the previous and next output files of the
+// action in sorted order. The action is provided through the "action"
+// query parameter, having the form "${hash}-${size_bytes}". This makes
+// it possible to step through the outputs of an action without
+// returning to the action page.
+func (s *BrowserService) handleOutputFileNavigation(w http.ResponseWriter, req *http.Request, fileDigest digest.Digest, name, actionStr string) {
+	separator := strings.LastIndexByte(actionStr, '-')
+	if separator < 0 {
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Action %#v is not of the form ${hash}-${size_bytes}", actionStr))
+		return
+	}
+	actionSizeBytes, err := strconv.ParseInt(actionStr[separator+1:], 10, 64)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid action size %#v", actionStr[separator+1:]))
+		return
+	}
+	digestFunction := fileDigest.GetDigestFunction()
+	actionDigest, err := digestFunction.NewDigest(actionStr[:separator], actionSizeBytes)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrap(err, "Invalid action digest"))
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	actionResultMessage, err := s.actionCache.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, s.maximumMessageSizeBytes)
+	if err != nil {
+		s.renderError(w, req, util.StatusWrap(err, "Failed to obtain action result"))
+		return
+	}
+	outputFiles := append([]*remoteexecution.OutputFile(nil), actionResultMessage.(*remoteexecution.ActionResult).OutputFiles...)
+	sort.Slice(outputFiles, func(i, j int) bool {
+		return outputFiles[i].Path < outputFiles[j].Path
+	})
+
+	// Locate the current file among the outputs. As multiple
+	// outputs may have the same contents, both the digest and the
+	// filename need to match.
+	index := -1
+	for i, outputFile := range outputFiles {
+		if outputFile.Digest.GetHash() == fileDigest.GetHashString() &&
+			outputFile.Digest.GetSizeBytes() == fileDigest.GetSizeBytes() &&
+			outputFile.Path[strings.LastIndexByte(outputFile.Path, '/')+1:] == name {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		s.renderError(w, req, status.Errorf(codes.NotFound, "File %#v is not an output file of action %#v", name, actionDigest.String()))
+		return
+	}
 
-		inputRootDigest, err := digestFunction.NewDigestFromProto(action.InputRootDigest)
+	// Links cycle through the output files, so that the last output
+	// file links back to the first one.
+	getLink := func(outputFile *remoteexecution.OutputFile) *outputFileNavigationLink {
+		d := outputFile.Digest
+		return &outputFileNavigationLink{
+			Path: outputFile.Path,
+			URL:  fmt.Sprintf("../../file/%s-%d/%s?action=%s", d.GetHash(), d.GetSizeBytes(), url.PathEscape(outputFile.Path[strings.LastIndexByte(outputFile.Path, '/')+1:]), url.QueryEscape(actionStr)),
+		}
+	}
+	var previous, next *outputFileNavigationLink
+	if len(outputFiles) > 1 {
+		previous = getLink(outputFiles[(index+len(outputFiles)-1)%len(outputFiles)])
+		next = getLink(outputFiles[(index+1)%len(outputFiles)])
+	}
+
+	preview, err := s.getFilePreview(ctx, outputFiles[index].Path, fileDigest)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	if err := s.templates.ExecuteTemplate(w, "page_output_file.html", outputFileNavigationInfo{
+		Path:         outputFiles[index].Path,
+		Index:        index + 1,
+		Count:        len(outputFiles),
+		ActionDigest: actionDigest,
+		Previous:     previous,
+		Next:         next,
+		Preview:      preview,
+	}); err != nil {
+		log.Print(err)
+	}
+}
+
+// resolveOutputFile returns the digest of an output file of an action,
+// given the path at which the command declared it. Files contained in
+// output directories are resolved by traversing the directory's tree.
+func (s *BrowserService) resolveOutputFile(ctx context.Context, digestFunction digest.Function, actionResult *remoteexecution.ActionResult, outputPath string) (digest.Digest, error) {
+	for _, outputFile := range actionResult.OutputFiles {
+		if outputFile.Path == outputPath {
+			return digestFunction.NewDigestFromProto(outputFile.Digest)
+		}
+	}
+
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		prefix := outputDirectory.Path
+		if prefix != "" {
+			prefix += "/"
+		}
+		relativePath, ok := strings.CutPrefix(outputPath, prefix)
+		if !ok {
+			continue
+		}
+
+		treeDigest, err := digestFunction.NewDigestFromProto(outputDirectory.TreeDigest)
 		if err != nil {
-			s.renderError(w, err)
-			return
+			return digest.BadDigest, err
 		}
-		reducedActionDigest, err := blobstore.GetReducedActionDigest(actionDigest.GetDigestFunction(), action)
+		treeMessage, err := s.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, s.maximumMessageSizeBytes)
 		if err != nil {
-			s.renderError(w, err)
-			return
+			return digest.BadDigest, util.StatusWrapf(err, "Failed to obtain tree of output directory %#v", outputDirectory.Path)
 		}
-		directoryMessage, err := s.contentAddressableStorage.Get(ctx, inputRootDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
-		if err == nil {
-			// Check whether a file system access profile exists for
-			// the current action. If so, download it, so that we
-			// can display which files in the root directory are
-			// being accessed.
-			var fileSystemAccessProfileReference *query.FileSystemAccessProfileReference
-			var bloomFilter *access.BloomFilterReader
-			if profileMessage, err := s.fileSystemAccessCache.Get(ctx, reducedActionDigest).ToProto(&fsac.FileSystemAccessProfile{}, s.maximumMessageSizeBytes); err == nil {
-				profile := profileMessage.(*fsac.FileSystemAccessProfile)
-				if bloomFilterReader, err := access.NewBloomFilterReader(profile.BloomFilter, profile.BloomFilterHashFunctions); err == nil {
-					fileSystemAccessProfileReference = &query.FileSystemAccessProfileReference{
-						Digest:             reducedActionDigest.GetProto(),
-						PathHashesBaseHash: access.RootPathHashes.GetBaseHash(),
+		tree := treeMessage.(*remoteexecution.Tree)
+		children, err := getTreeChildren(digestFunction, tree)
+		if err != nil {
+			return digest.BadDigest, err
+		}
+
+		// Traverse into the directory containing the file.
+		directory := tree.Root
+		components := strings.Split(relativePath, "/")
+		for _, component := range components[:len(components)-1] {
+			childNode := func() *remoteexecution.DirectoryNode {
+				for _, directoryNode := range directory.Directories {
+					if component == directoryNode.Name {
+						return directoryNode
 					}
-					bloomFilter = bloomFilterReader
-				} else {
-					log.Printf("Cannot read Bloom filter for %s: %s", reducedActionDigest.String(), err)
 				}
-			} else if status.Code(err) != codes.NotFound {
-				s.renderError(w, err)
-				return
+				return nil
+			}()
+			if childNode == nil {
+				return digest.BadDigest, status.Errorf(codes.NotFound, "Path %#v is not an output of this action", outputPath)
+			}
+			childDigest, err := digestFunction.NewDigestFromProto(childNode.Digest)
+			if err != nil {
+				return digest.BadDigest, err
 			}
+			childDirectory, ok := children[childDigest.GetKey(digest.KeyWithoutInstance)]
+			if !ok {
+				return digest.BadDigest, status.Error(codes.InvalidArgument, "Failed to find child node in tree")
+			}
+			directory = childDirectory
+		}
 
-			actionInfo.InputRoot = &directoryInfo{
-				Digest:                           inputRootDigest,
-				Directory:                        directoryMessage.(*remoteexecution.Directory),
-				BBClientdPath:                    formatBBClientdPath(s.getBBClientdBlobPath(inputRootDigest, directoryDirectoryComponent)),
-				FileSystemAccessProfileReference: fileSystemAccessProfileReference,
-				BloomFilter:                      bloomFilter,
+		filename := components[len(components)-1]
+		for _, fileNode := range directory.Files {
+			if fileNode.Name == filename {
+				return digestFunction.NewDigestFromProto(fileNode.Digest)
 			}
-		} else if status.Code(err) != codes.NotFound {
-			s.renderError(w, err)
-			return
 		}
-		previousExecutionStatsInfo, err := s.getPreviousExecutionStatsInfo(ctx, reducedActionDigest)
-		if err == nil {
-			actionInfo.PreviousExecutionStats = previousExecutionStatsInfo
-		} else if status.Code(err) != codes.NotFound {
-			s.renderError(w, err)
-			return
+	}
+	return digest.BadDigest, status.Errorf(codes.NotFound, "Path %#v is not an output of this action", outputPath)
+}
+
+// handleActionOutputFile serves a single output file of an action,
+// identified by its path, as opposed to its digest. This makes it
+// easier to write scripts that retrieve build artifacts.
+func (s *BrowserService) handleActionOutputFile(w http.ResponseWriter, req *http.Request) {
+	actionDigest, err := s.getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	m, err := s.actionCache.Get(ctx, actionDigest).ToProto(
+		&remoteexecution.ActionResult{},
+		s.maximumMessageSizeBytes)
+	observeLookup("action_result", err)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	fileDigest, err := s.resolveOutputFile(ctx, actionDigest.GetDigestFunction(), m.(*remoteexecution.ActionResult), mux.Vars(req)["outputPath"])
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	outputPath := mux.Vars(req)["outputPath"]
+	s.serveFile(w, req, fileDigest, outputPath[strings.LastIndexByte(outputPath, '/')+1:])
+}
+
+// setFileContentType sets the Content-Type header of a response
+// containing a file, based on the first bytes of the file's contents.
+// If the file is larger than the prefix, the prefix may end with a
+// character that is cut off, which is ignored.
+func setFileContentType(h http.Header, contentTypeOverride string, prefix []byte, truncated bool) {
+	if truncated {
+		for i := 1; i < utf8.UTFMax && i <= len(prefix); i++ {
+			if utf8.RuneStart(prefix[len(prefix)-i]) {
+				if !utf8.FullRune(prefix[len(prefix)-i:]) {
+					prefix = prefix[:len(prefix)-i]
+				}
+				break
+			}
 		}
-	} else if status.Code(err) != codes.NotFound {
-		s.renderError(w, err)
+	}
+	if contentTypeOverride != "" {
+		// Even though only harmless content types may be
+		// provided, prevent the browser from executing any
+		// scripts contained in the file, in case it interprets
+		// the content type differently.
+		h.Set("Content-Type", contentTypeOverride)
+		h.Set("Content-Security-Policy", "sandbox")
+		h.Set("X-Content-Type-Options", "nosniff")
+	} else if utf8.Valid(prefix) {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+}
+
+// serveTextFile displays a text file as a page, in which the range of
+// lines provided through the "lines" query parameter is highlighted.
+func (s *BrowserService) serveTextFile(w http.ResponseWriter, req *http.Request, digest digest.Digest, name string) {
+	if digest.GetSizeBytes() > maximumLogSizeBytes {
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Lines can only be highlighted in files that are at most %d bytes in size", maximumLogSizeBytes))
+		return
+	}
+	ctx := extractContextFromRequest(req)
+	data, err := s.contentAddressableStorage.Get(ctx, digest).ToByteSlice(maximumLogSizeBytes)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	if !utf8.Valid(data) {
+		s.renderError(w, req, status.Error(codes.InvalidArgument, "Lines can only be highlighted in files containing UTF-8 encoded text"))
+		return
+	}
+	lines, err := newLineRangeInfo(req, data, renderPlainText)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	if err := s.templates.ExecuteTemplate(w, "page_file.html", struct {
+		Name  string
+		Lines *lineRangeInfo
+	}{
+		Name:  name,
+		Lines: lines,
+	}); err != nil {
+		log.Print(err)
+	}
+}
+
+// serveFile writes the contents of a file stored in the Content
+// Addressable Storage to the HTTP response.
+func (s *BrowserService) serveFile(w http.ResponseWriter, req *http.Request, digest digest.Digest, name string) {
+	contentTypeOverride, err := getContentTypeOverride(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	if query := req.URL.Query(); query.Get("decompress") == "1" && query.Get("raw") != "1" && (strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz")) {
+		s.serveDecompressedFile(w, req, digest, name, contentTypeOverride)
+		return
+	}
+	if digest.GetSizeBytes() == 0 {
+		// Empty files don't need to be loaded from storage.
+		w.Header().Set("Content-Length", "0")
+		setFileContentType(w.Header(), contentTypeOverride, nil, false)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	r := s.contentAddressableStorage.Get(ctx, digest).ToReader()
+	defer r.Close()
+
+	// Attempt to read the first chunk of data to see whether we can
+	// trigger an error. Only when no error occurs, we start setting
+	// response headers.
+	first := make([]byte, s.contentSniffingPrefixSizeBytes)
+	n, err := io.ReadFull(r, first)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		s.renderError(w, req, err)
 		return
 	}
-
-	if actionMessage == nil && actionResult == nil {
-		s.renderError(w, status.Error(codes.NotFound, "Could not find an action or action result"))
-		return
+
+	w.Header().Set("Content-Length", strconv.FormatInt(digest.GetSizeBytes(), 10))
+	setFileContentType(w.Header(), contentTypeOverride, first[:n], int64(n) < digest.GetSizeBytes())
+	w.Write(first[:n])
+	if _, err := copyWithContext(ctx, w, r); err != nil {
+		// The client may have disconnected. Abort, so that the
+		// reader is closed and storage stops sending data.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// copyWithContext is identical to io.Copy(), except that it stops
+// copying as soon as the context is cancelled. This ensures that no
+// further data is read from storage when a client abandons a download,
+// even if writes to the client don't fail immediately.
+func copyWithContext(ctx context.Context, w io.Writer, r io.Reader) (int64, error) {
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			nWritten, err := w.Write(buf[:n])
+			written += int64(nWritten)
+			if err != nil {
+				return written, err
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		} else if readErr != nil {
+			return written, readErr
+		}
+	}
+}
+
+// The maximum size of a markdown file that is rendered as HTML. Larger
+// files are served as plain text.
+const maximumMarkdownSizeBytes = 1024 * 1024
+
+// isMarkdownFile returns true if a file should be rendered as markdown,
+// based on its name and size.
+func isMarkdownFile(name string, digest digest.Digest) bool {
+	return (strings.HasSuffix(name, ".md") || strings.HasSuffix(name, ".markdown")) &&
+		digest.GetSizeBytes() <= maximumMarkdownSizeBytes
+}
+
+// serveMarkdownFile renders a markdown file stored in the CAS as HTML.
+// Embedded HTML is omitted and links using unsafe URL schemes are
+// dropped by the renderer, so that files can't be used to inject
+// scripts into pages. This function returns false if the file does
+// not contain text, in which case it should be served as is.
+func (s *BrowserService) serveMarkdownFile(w http.ResponseWriter, req *http.Request, digest digest.Digest, name string) bool {
+	ctx := extractContextFromRequest(req)
+	data, err := s.contentAddressableStorage.Get(ctx, digest).ToByteSlice(maximumMarkdownSizeBytes)
+	if err != nil {
+		s.renderError(w, req, err)
+		return true
+	}
+	if !utf8.Valid(data) {
+		return false
 	}
 
-	if err := s.templates.ExecuteTemplate(w, "page_action.html", actionInfo); err != nil {
+	var rendered bytes.Buffer
+	if err := goldmark.Convert(data, &rendered); err != nil {
+		s.renderError(w, req, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to render markdown"))
+		return true
+	}
+	if err := s.templates.ExecuteTemplate(w, "page_markdown.html", struct {
+		Name     string
+		Rendered template.HTML
+	}{
+		Name:     name,
+		Rendered: template.HTML(rendered.String()),
+	}); err != nil {
 		log.Print(err)
 	}
+	return true
 }
 
-func (s *BrowserService) handleCommand(w http.ResponseWriter, req *http.Request) {
-	digest, err := getDigestFromRequest(req)
-	if err != nil {
-		s.renderError(w, err)
+const (
+	// The maximum number of bytes returned when decompressing a
+	// file, protecting against decompression bombs.
+	maximumDecompressedFileSizeBytes = 100 * 1024 * 1024
+	// The maximum number of entries of a tarball that are listed.
+	maximumArchiveEntriesCount = 10000
+)
+
+// errDecompressedFileTooLarge is returned by decompressedSizeLimiter
+// when the decompressed contents of a file exceed
+// maximumDecompressedFileSizeBytes.
+var errDecompressedFileTooLarge = status.Errorf(codes.FailedPrecondition, "Decompressed file exceeds the maximum size of %d bytes", maximumDecompressedFileSizeBytes)
+
+// decompressedSizeLimiter is similar to io.LimitedReader, except that
+// it returns an error instead of io.EOF once the limit is exceeded.
+// This prevents truncated output from being mistaken for the full
+// contents of a file.
+type decompressedSizeLimiter struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *decompressedSizeLimiter) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		return n, errDecompressedFileTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// serveDecompressedFile serves the contents of a gzip compressed file.
+// If the file is a tarball, a listing of its contents is displayed
+// instead.
+func (s *BrowserService) serveDecompressedFile(w http.ResponseWriter, req *http.Request, digest digest.Digest, name, contentTypeOverride string) {
+	if sizeBytes := digest.GetSizeBytes(); sizeBytes > s.maximumCompressedFileSizeBytes {
+		s.renderError(w, req, status.Errorf(codes.FailedPrecondition, "Compressed file is %d bytes in size, which exceeds the maximum size of %d bytes that may be decompressed", sizeBytes, s.maximumCompressedFileSizeBytes))
 		return
 	}
 
 	ctx := extractContextFromRequest(req)
-	commandMessage, err := s.contentAddressableStorage.Get(ctx, digest).ToProto(&remoteexecution.Command{}, s.maximumMessageSizeBytes)
+	r := s.contentAddressableStorage.Get(ctx, digest).ToReader()
+	defer r.Close()
+
+	// Errors returned by storage are reported as is, while errors
+	// caused by the file being malformed are reported as invalid
+	// arguments.
+	decompressionError := func(err error, msg string) error {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return util.StatusWrapWithCode(err, codes.InvalidArgument, msg)
+	}
+
+	gzipReader, err := gzip.NewReader(r)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, decompressionError(err, "Failed to decompress file"))
 		return
 	}
-	command := commandMessage.(*remoteexecution.Command)
+	limitedReader := &decompressedSizeLimiter{
+		r:         gzipReader,
+		remaining: maximumDecompressedFileSizeBytes,
+	}
 
-	if req.URL.Query().Get("format") == "sh" {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		bw := bufio.NewWriter(w)
-		if err := builder.ConvertCommandToShellScript(command, bw); err != nil {
-			log.Print(err)
-			panic(http.ErrAbortHandler)
-		}
-		if err := bw.Flush(); err != nil {
-			log.Print(err)
-			panic(http.ErrAbortHandler)
+	if strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") {
+		// Listings of tarballs whose entries or decompressed
+		// contents exceed the limits are truncated. The page
+		// explicitly states this.
+		tarReader := tar.NewReader(limitedReader)
+		var entries []*tar.Header
+		truncated := false
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			} else if err == errDecompressedFileTooLarge {
+				truncated = true
+				break
+			} else if err != nil {
+				s.renderError(w, req, decompressionError(err, "Failed to read tarball"))
+				return
+			}
+			if len(entries) >= maximumArchiveEntriesCount {
+				truncated = true
+				break
+			}
+			entries = append(entries, header)
 		}
-	} else {
-		if err := s.templates.ExecuteTemplate(w, "page_command.html", commandInfo{
-			Digest:        digest,
-			Command:       command,
-			BBClientdPath: formatBBClientdPath(s.getBBClientdBlobPath(digest, commandDirectoryComponent)),
+		if err := s.templates.ExecuteTemplate(w, "page_archive.html", struct {
+			Name      string
+			Entries   []*tar.Header
+			Truncated bool
+		}{
+			Name:      name,
+			Entries:   entries,
+			Truncated: truncated,
 		}); err != nil {
 			log.Print(err)
 		}
+		return
 	}
-}
-
-func (s *BrowserService) generateTarballDirectory(ctx context.Context, w *tar.Writer, digestFunction digest.Function, directory *remoteexecution.Directory, directoryPath *path.Trace, getDirectory func(context.Context, digest.Digest) (*remoteexecution.Directory, error), filesSeen map[string]string) error {
-	// Emit child directories.
-	for _, directoryNode := range directory.Directories {
-		childName, ok := path.NewComponent(directoryNode.Name)
-		if !ok {
-			return status.Errorf(codes.InvalidArgument, "Directory %#v in directory %#v has an invalid name", directoryNode.Name, directoryPath.String())
-		}
-		childPath := directoryPath.Append(childName)
 
-		if err := w.WriteHeader(&tar.Header{
-			Typeflag: tar.TypeDir,
-			Name:     childPath.String(),
-			Mode:     0o777,
-		}); err != nil {
-			return err
-		}
-		childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
-		if err != nil {
-			return err
-		}
-		childDirectory, err := getDirectory(ctx, childDigest)
-		if err != nil {
-			return err
-		}
-		if err := s.generateTarballDirectory(ctx, w, digestFunction, childDirectory, childPath, getDirectory, filesSeen); err != nil {
-			return err
-		}
+	// Attempt to decompress the first chunk of data, so that its
+	// content type can be detected, and errors can still be
+	// reported properly.
+	first := make([]byte, s.contentSniffingPrefixSizeBytes)
+	n, err := io.ReadFull(limitedReader, first)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		s.renderError(w, req, decompressionError(err, "Failed to decompress file"))
+		return
 	}
 
-	// Emit symlinks.
-	for _, symlinkNode := range directory.Symlinks {
-		childName, ok := path.NewComponent(symlinkNode.Name)
-		if !ok {
-			return status.Errorf(codes.InvalidArgument, "Symbolic link %#v in directory %#v has an invalid name", symlinkNode.Name, directoryPath.String())
-		}
-		childPath := directoryPath.Append(childName)
-
-		if err := w.WriteHeader(&tar.Header{
-			Typeflag: tar.TypeSymlink,
-			Name:     childPath.String(),
-			Linkname: symlinkNode.Target,
-			Mode:     0o777,
-		}); err != nil {
-			return err
-		}
+	setFileContentType(w.Header(), contentTypeOverride, first[:n], n == len(first))
+	w.Write(first[:n])
+	if _, err := copyWithContext(ctx, w, limitedReader); err != nil {
+		// The response has already been partially written, meaning
+		// the error can't be reported properly. Abort the
+		// response, so that clients don't mistake the truncated
+		// output for the full contents of the file.
+		log.Print(err)
+		panic(http.ErrAbortHandler)
 	}
+}
 
-	// Emit regular files.
-	for _, fileNode := range directory.Files {
-		childName, ok := path.NewComponent(fileNode.Name)
-		if !ok {
-			return status.Errorf(codes.InvalidArgument, "File %#v in directory %#v has an invalid name", fileNode.Name, directoryPath.String())
-		}
-		childPath := directoryPath.Append(childName)
-		childPathString := childPath.String()
-
-		childDigest, err := digestFunction.NewDigestFromProto(fileNode.Digest)
-		if err != nil {
-			return err
-		}
-
-		childKey := childDigest.GetKey(digest.KeyWithoutInstance)
-		if fileNode.IsExecutable {
-			childKey += "+x"
-		} else {
-			childKey += "-x"
+// parseLineRange parses an inclusive range of line numbers of the form
+// "${first}-${last}", or a single line number. Line numbers start at
+// one and may be prefixed with "L", so that ranges may also be copied
+// from URL fragments of the form "#L${first}-L${last}". The range must
+// start within text consisting of linesCount lines. Its end is capped
+// to the last line.
+func parseLineRange(lineRange string, linesCount int) (int, int, error) {
+	firstStr, lastStr, isRange := strings.Cut(lineRange, "-")
+	first, err := strconv.Atoi(strings.TrimPrefix(firstStr, "L"))
+	if err != nil || first < 1 {
+		return 0, 0, status.Errorf(codes.InvalidArgument, "Invalid line range %#v", lineRange)
+	}
+	last := first
+	if isRange {
+		last, err = strconv.Atoi(strings.TrimPrefix(lastStr, "L"))
+		if err != nil || last < first {
+			return 0, 0, status.Errorf(codes.InvalidArgument, "Invalid line range %#v", lineRange)
 		}
+	}
+	if first > linesCount {
+		return 0, 0, status.Errorf(codes.OutOfRange, "Line range %#v starts beyond the last line, which is line %d", lineRange, linesCount)
+	}
+	if last > linesCount {
+		last = linesCount
+	}
+	return first, last, nil
+}
 
-		if linkPath, ok := filesSeen[childKey]; ok {
-			// This file was already returned previously.
-			// Emit a hardlink pointing to the first
-			// occurrence.
-			//
-			// Not only does this reduce the size of the
-			// tarball, it also makes the directory more
-			// representative of what it looks like when
-			// executed through bb_worker.
-			if err := w.WriteHeader(&tar.Header{
-				Typeflag: tar.TypeLink,
-				Name:     childPathString,
-				Linkname: linkPath,
-			}); err != nil {
-				return err
-			}
-		} else {
-			// This is the first time we're returning this
-			// file. Actually add it to the archive.
-			mode := int64(0o666)
-			if fileNode.IsExecutable {
-				mode = 0o777
-			}
-			if err := w.WriteHeader(&tar.Header{
-				Typeflag: tar.TypeReg,
-				Name:     childPathString,
-				Size:     fileNode.Digest.SizeBytes,
-				Mode:     mode,
-			}); err != nil {
-				return err
-			}
+// countLines returns the number of lines in text. A trailing newline
+// does not start a new line.
+func countLines(data []byte) int {
+	n := bytes.Count(data, []byte{'\n'})
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		n++
+	}
+	return n
+}
 
-			if err := s.contentAddressableStorage.Get(ctx, childDigest).IntoWriter(w); err != nil {
-				return err
+// splitLineRange splits text into the parts before, inside and after
+// an inclusive range of lines. Newlines separating the parts are
+// omitted, as the parts are rendered as separate blocks.
+func splitLineRange(data []byte, first, last int) ([]byte, []byte, []byte) {
+	getLineOffset := func(line int) int {
+		offset := 0
+		for i := 1; i < line; i++ {
+			n := bytes.IndexByte(data[offset:], '\n')
+			if n < 0 {
+				return len(data)
 			}
-
-			filesSeen[childKey] = childPathString
+			offset += n + 1
 		}
+		return offset
 	}
-	return nil
+	start, end := getLineOffset(first), getLineOffset(last+1)
+	return bytes.TrimSuffix(data[:start], []byte{'\n'}), bytes.TrimSuffix(data[start:end], []byte{'\n'}), data[end:]
 }
 
-func (s *BrowserService) generateTarball(ctx context.Context, w http.ResponseWriter, digest digest.Digest, directory *remoteexecution.Directory, getDirectory func(context.Context, digest.Digest) (*remoteexecution.Directory, error)) {
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.tar.gz\"", digest.GetHashString()))
-	w.Header().Set("Content-Type", "application/gzip")
-	gzipWriter := gzip.NewWriter(w)
-	tarWriter := tar.NewWriter(gzipWriter)
-	filesSeen := map[string]string{}
-	if err := s.generateTarballDirectory(ctx, tarWriter, digest.GetDigestFunction(), directory, nil, getDirectory, filesSeen); err != nil {
-		// TODO(edsch): Any way to propagate this to the client?
-		log.Print(err)
-		panic(http.ErrAbortHandler)
-	}
-	if err := tarWriter.Close(); err != nil {
-		log.Print(err)
-		panic(http.ErrAbortHandler)
+// lineRangeInfo contains the rendered contents of a log or text file,
+// in which a range of lines may be highlighted.
+type lineRangeInfo struct {
+	// If a range of lines is highlighted, HTML contains the lines
+	// preceding the range.
+	HTML                 template.HTML
+	FirstHighlightedLine int
+	HighlightedHTML      template.HTML
+	TrailingHTML         template.HTML
+}
+
+// newLineRangeInfo renders text, highlighting the range of lines
+// provided through the "lines" query parameter, if any.
+func newLineRangeInfo(req *http.Request, data []byte, render func([]byte) []byte) (*lineRangeInfo, error) {
+	lineRange := req.URL.Query().Get("lines")
+	if lineRange == "" {
+		return &lineRangeInfo{HTML: template.HTML(render(data))}, nil
 	}
-	if err := gzipWriter.Close(); err != nil {
-		log.Print(err)
-		panic(http.ErrAbortHandler)
+	first, last, err := parseLineRange(lineRange, countLines(data))
+	if err != nil {
+		return nil, err
 	}
+	before, highlighted, after := splitLineRange(data, first, last)
+	return &lineRangeInfo{
+		HTML:                 template.HTML(render(before)),
+		FirstHighlightedLine: first,
+		HighlightedHTML:      template.HTML(render(highlighted)),
+		TrailingHTML:         template.HTML(render(after)),
+	}, nil
 }
 
-func (s *BrowserService) handleDirectory(w http.ResponseWriter, req *http.Request) {
-	directoryDigest, err := getDigestFromRequest(req)
+// renderPlainText renders text that contains no formatting, so that it
+// can be displayed in the same way as logs.
+func renderPlainText(data []byte) []byte {
+	var b bytes.Buffer
+	template.HTMLEscape(&b, data)
+	return b.Bytes()
+}
+
+// handleLog displays a log file containing ANSI escape sequences,
+// split up in pages. This makes it possible to view logs that are too
+// large to be displayed on the action page in their entirety. Pages
+// are rendered independently, meaning that formatting that spans page
+// boundaries is not preserved.
+func (s *BrowserService) handleLog(w http.ResponseWriter, req *http.Request) {
+	logDigest, err := s.getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
-
-	ctx := extractContextFromRequest(req)
-	directoryMessage, err := s.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
-	if err != nil {
-		s.renderError(w, err)
+	pageIndex := int64(0)
+	if pageStr := req.URL.Query().Get("page"); pageStr != "" {
+		pageIndex, err = strconv.ParseInt(pageStr, 10, 64)
+		if err != nil || pageIndex < 0 {
+			s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Invalid page number %#v", pageStr))
+			return
+		}
+	}
+	sizeBytes := logDigest.GetSizeBytes()
+	pagesCount := (sizeBytes + maximumLogSizeBytes - 1) / maximumLogSizeBytes
+	if pagesCount == 0 {
+		pagesCount = 1
+	}
+	if pageIndex >= pagesCount {
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Page number %d exceeds the number of pages of this log, which is %d", pageIndex, pagesCount))
 		return
 	}
-	directory := directoryMessage.(*remoteexecution.Directory)
 
-	if req.URL.Query().Get("format") == "tar" {
-		s.generateTarball(ctx, w, directoryDigest, directory, func(ctx context.Context, digest digest.Digest) (*remoteexecution.Directory, error) {
-			directoryMessage, err := s.contentAddressableStorage.Get(ctx, digest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
-			if err != nil {
-				return nil, err
-			}
-			return directoryMessage.(*remoteexecution.Directory), nil
-		})
-	} else {
-		var fileSystemAccessProfileReference *query.FileSystemAccessProfileReference
-		var bloomFilter *access.BloomFilterReader
-		if profileReferenceJSON := req.URL.Query().Get("file_system_access_profile"); profileReferenceJSON != "" {
-			// A file system access profile reference is provided as
-			// part of the URL. Obtain the Bloom filter from the
-			// File System Access Cache (FSAC), so that we can
-			// display file usage for the current directory.
-			var profileReference query.FileSystemAccessProfileReference
-			if err := protojson.Unmarshal([]byte(profileReferenceJSON), &profileReference); err != nil {
-				s.renderError(w, err)
-				return
-			}
-			profileDigest, err := directoryDigest.GetDigestFunction().NewDigestFromProto(profileReference.Digest)
-			if err != nil {
-				s.renderError(w, err)
-				return
-			}
-			profileMessage, err := s.fileSystemAccessCache.Get(ctx, profileDigest).ToProto(&fsac.FileSystemAccessProfile{}, s.maximumMessageSizeBytes)
-			if err != nil {
-				s.renderError(w, err)
-				return
-			}
-			profile := profileMessage.(*fsac.FileSystemAccessProfile)
-			bloomFilterReader, err := access.NewBloomFilterReader(profile.BloomFilter, profile.BloomFilterHashFunctions)
-			if err != nil {
-				s.renderError(w, err)
-				return
-			}
-			fileSystemAccessProfileReference = &profileReference
-			bloomFilter = bloomFilterReader
+	// Only read the part of the log that corresponds to the
+	// requested page.
+	offset := pageIndex * maximumLogSizeBytes
+	pageSizeBytes := sizeBytes - offset
+	if pageSizeBytes > maximumLogSizeBytes {
+		pageSizeBytes = maximumLogSizeBytes
+	}
+	data := make([]byte, pageSizeBytes)
+	if len(data) > 0 {
+		ctx := extractContextFromRequest(req)
+		if n, err := s.contentAddressableStorage.Get(ctx, logDigest).ReadAt(data, offset); err != nil && (err != io.EOF || n != len(data)) {
+			s.renderError(w, req, err)
+			return
 		}
+	}
 
-		if err := s.templates.ExecuteTemplate(w, "page_directory.html", &directoryInfo{
-			Digest:                           directoryDigest,
-			Directory:                        directory,
-			BBClientdPath:                    formatBBClientdPath(s.getBBClientdBlobPath(directoryDigest, directoryDirectoryComponent)),
-			FileSystemAccessProfileReference: fileSystemAccessProfileReference,
-			BloomFilter:                      bloomFilter,
-		}); err != nil {
-			log.Print(err)
+	if shouldShowRawMessages(req) {
+		// Serve the page without rendering ANSI escape
+		// sequences, so that its original contents can be
+		// inspected.
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if utf8.Valid(data) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
 		}
+		w.Write(data)
+		return
 	}
-}
 
-func (s *BrowserService) handleFile(w http.ResponseWriter, req *http.Request) {
-	digest, err := getDigestFromRequest(req)
+	// Line numbers of highlighted lines are relative to the start
+	// of the page.
+	lines, err := newLineRangeInfo(req, data, terminal.Render)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
-
-	ctx := extractContextFromRequest(req)
-	r := s.contentAddressableStorage.Get(ctx, digest).ToReader()
-	defer r.Close()
-
-	// Attempt to read the first chunk of data to see whether we can
-	// trigger an error. Only when no error occurs, we start setting
-	// response headers.
-	var first [4096]byte
-	n, err := r.Read(first[:])
-	if err != nil && err != io.EOF {
-		s.renderError(w, err)
-		return
+	logPageInfo := struct {
+		Digest     digest.Digest
+		PageIndex  int64
+		PagesCount int64
+		Permalink  string
+		Lines      *lineRangeInfo
+	}{
+		Digest:     logDigest,
+		PageIndex:  pageIndex,
+		PagesCount: pagesCount,
+		Permalink:  s.getPermalink(req, "log", logDigest, ""),
+		Lines:      lines,
 	}
-
-	w.Header().Set("Content-Length", strconv.FormatInt(digest.GetSizeBytes(), 10))
-	if utf8.ValidString(string(first[:])) {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	} else {
-		w.Header().Set("Content-Type", "application/octet-stream")
+	if err := s.templates.ExecuteTemplate(w, "page_log.html", &logPageInfo); err != nil {
+		log.Print(err)
 	}
-	w.Write(first[:n])
-	io.Copy(w, r)
 }
 
 // previousExecutionStatsInfo contains the information that we display
@@ -901,16 +4731,16 @@ func (l sizeClassList) Swap(i, j int) {
 }
 
 func (s *BrowserService) handlePreviousExecutionStats(w http.ResponseWriter, req *http.Request) {
-	digest, err := getDigestFromRequest(req)
+	digest, err := s.getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
 	ctx := extractContextFromRequest(req)
 	statsInfo, err := s.getPreviousExecutionStatsInfo(ctx, digest)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
@@ -920,44 +4750,47 @@ func (s *BrowserService) handlePreviousExecutionStats(w http.ResponseWriter, req
 }
 
 func (s *BrowserService) handleTree(w http.ResponseWriter, req *http.Request) {
-	treeDigest, err := getDigestFromRequest(req)
+	treeDigest, err := s.getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
 	ctx := extractContextFromRequest(req)
 	treeMessage, err := s.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, s.maximumMessageSizeBytes)
+	observeLookup("tree", err)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 	tree := treeMessage.(*remoteexecution.Tree)
 	treeInfo := struct {
+		InstanceName       digest.InstanceName
 		Directory          *remoteexecution.Directory
 		HasParentDirectory bool
-		BBClientdPath      string
-		RootDirectory      string
+		// Relative URL of the parent directory, which is only
+		// set if HasParentDirectory is true.
+		ParentDirectory string
+		BBClientdPath   string
+		Permalink       string
+		RootDirectory   string
+		// Inconsistencies between the directories contained in
+		// the Tree, indicating that it is corrupted.
+		ValidationProblems []string
 	}{
-		Directory: tree.Root,
+		InstanceName: treeDigest.GetInstanceName(),
+		Directory:    tree.Root,
+		Permalink:    s.getPermalink(req, "tree", treeDigest, mux.Vars(req)["subdirectory"]),
 	}
 
 	// Construct map of all child directories.
 	digestFunction := treeDigest.GetDigestFunction()
-	children := map[string]*remoteexecution.Directory{}
-	for _, child := range tree.Children {
-		data, err := proto.Marshal(child)
-		if err != nil {
-			s.renderError(w, err)
-			return
-		}
-		digestGenerator := digestFunction.NewGenerator(int64(len(data)))
-		if _, err := digestGenerator.Write(data); err != nil {
-			s.renderError(w, err)
-			return
-		}
-		children[digestGenerator.Sum().GetKey(digest.KeyWithoutInstance)] = child
+	children, err := getTreeChildren(digestFunction, tree)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
 	}
+	treeInfo.ValidationProblems = validateTree(digestFunction, tree, children)
 
 	// In case additional directory components are provided, we need
 	// to traverse the directories stored within. While there,
@@ -968,12 +4801,13 @@ func (s *BrowserService) handleTree(w http.ResponseWriter, req *http.Request) {
 	directoryDigest := treeDigest
 	rootDirectory, scopeWalker := path.EmptyBuilder.Join(path.VoidScopeWalker)
 	rootDirectoryWalker, _ := scopeWalker.OnScope(false)
-	for _, component := range strings.FieldsFunc(
+	components := strings.FieldsFunc(
 		mux.Vars(req)["subdirectory"],
-		func(r rune) bool { return r == '/' }) {
+		func(r rune) bool { return r == '/' })
+	for _, component := range components {
 		pathComponent, ok := path.NewComponent(component)
 		if !ok {
-			s.renderError(w, status.Errorf(codes.InvalidArgument, "Path contains invalid component %#v"))
+			s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Path contains invalid component %#v", component))
 			return
 		}
 		bbClientdPath = bbClientdPath.Append(pathComponent)
@@ -989,19 +4823,19 @@ func (s *BrowserService) handleTree(w http.ResponseWriter, req *http.Request) {
 			return nil
 		}()
 		if childNode == nil {
-			s.renderError(w, status.Error(codes.NotFound, "Subdirectory in tree not found"))
+			s.renderError(w, req, status.Error(codes.NotFound, "Subdirectory in tree not found"))
 			return
 		}
 
 		// Find corresponding child directory message.
 		directoryDigest, err = digestFunction.NewDigestFromProto(childNode.Digest)
 		if err != nil {
-			s.renderError(w, err)
+			s.renderError(w, req, err)
 			return
 		}
 		childDirectory, ok := children[directoryDigest.GetKey(digest.KeyWithoutInstance)]
 		if !ok {
-			s.renderError(w, status.Error(codes.InvalidArgument, "Failed to find child node in tree"))
+			s.renderError(w, req, status.Error(codes.InvalidArgument, "Failed to find child node in tree"))
 			return
 		}
 		treeInfo.HasParentDirectory = true
@@ -1009,10 +4843,23 @@ func (s *BrowserService) handleTree(w http.ResponseWriter, req *http.Request) {
 	}
 	treeInfo.BBClientdPath = formatBBClientdPath(bbClientdPath)
 	treeInfo.RootDirectory = rootDirectory.String()
+	if treeInfo.HasParentDirectory {
+		// Link to the parent directory relative to the root of
+		// the tree, as opposed to using "..", so that the link
+		// remains valid regardless of trailing slashes.
+		var parentDirectory strings.Builder
+		parentDirectory.WriteString(treeInfo.RootDirectory)
+		parentDirectory.WriteByte('/')
+		for _, component := range components[:len(components)-1] {
+			parentDirectory.WriteString(url.PathEscape(component))
+			parentDirectory.WriteByte('/')
+		}
+		treeInfo.ParentDirectory = parentDirectory.String()
+	}
 
 	if req.URL.Query().Get("format") == "tar" {
 		s.generateTarball(
-			ctx, w, directoryDigest, treeInfo.Directory,
+			ctx, w, req, directoryDigest, treeInfo.Directory,
 			func(ctx context.Context, directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
 				childDirectory, ok := children[directoryDigest.GetKey(digest.KeyWithoutInstance)]
 				if !ok {
@@ -0,0 +1,7263 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	cas_proto "github.com/buildbarn/bb-remote-execution/pkg/proto/cas"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var testDigestFunction = digest.MustNewFunction("", remoteexecution.DigestFunction_SHA256)
+
+// fakeBlobAccess is a BlobAccess that serves blobs that are stored in
+// memory.
+type fakeBlobAccess struct {
+	blobstore.BlobAccess
+	blobs    map[digest.Digest][]byte
+	errors   map[digest.Digest]error
+	getCalls int
+}
+
+func newFakeBlobAccess() *fakeBlobAccess {
+	return &fakeBlobAccess{
+		blobs:  map[digest.Digest][]byte{},
+		errors: map[digest.Digest]error{},
+	}
+}
+
+func (ba *fakeBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	ba.getCalls++
+	if err, ok := ba.errors[blobDigest]; ok {
+		return buffer.NewBufferFromError(err)
+	}
+	data, ok := ba.blobs[blobDigest]
+	if !ok {
+		return buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))
+	}
+	return buffer.NewValidatedBufferFromByteSlice(data)
+}
+
+func (ba *fakeBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if _, ok := ba.blobs[blobDigest]; !ok {
+			missing.Add(blobDigest)
+		}
+	}
+	return missing.Build(), nil
+}
+
+// putBytes stores a blob in the Content Addressable Storage, returning
+// its digest.
+func (ba *fakeBlobAccess) putBytes(data []byte) digest.Digest {
+	digestGenerator := testDigestFunction.NewGenerator(int64(len(data)))
+	digestGenerator.Write(data)
+	blobDigest := digestGenerator.Sum()
+	ba.blobs[blobDigest] = data
+	return blobDigest
+}
+
+// putMessage stores a Protobuf message in the Content Addressable
+// Storage, returning its digest.
+func (ba *fakeBlobAccess) putMessage(t *testing.T, m proto.Message) digest.Digest {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ba.putBytes(data)
+}
+
+// putBytesWithInstanceName stores a blob in the Content Addressable
+// Storage under a given instance name, returning its digest.
+func (ba *fakeBlobAccess) putBytesWithInstanceName(instanceName string, data []byte) digest.Digest {
+	digestGenerator := digest.MustNewFunction(instanceName, remoteexecution.DigestFunction_SHA256).NewGenerator(int64(len(data)))
+	digestGenerator.Write(data)
+	blobDigest := digestGenerator.Sum()
+	ba.blobs[blobDigest] = data
+	return blobDigest
+}
+
+// recordingTemplateExecutor is a TemplateExecutor that renders the
+// HTML templates of bb_browser, while recording the name of the
+// template and the data that was provided, so that tests can inspect
+// them.
+type recordingTemplateExecutor struct {
+	templates *template.Template
+	name      string
+	data      interface{}
+}
+
+func newRecordingTemplateExecutor() *recordingTemplateExecutor {
+	return &recordingTemplateExecutor{
+		templates: template.Must(template.New("templates").Funcs(newTemplateFuncMap()).ParseFS(templatesFS, "templates/*.html")),
+	}
+}
+
+func (te *recordingTemplateExecutor) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	te.name = name
+	te.data = data
+	return te.templates.ExecuteTemplate(w, name, data)
+}
+
+// testBrowserService holds a BrowserService whose storage is replaced
+// by fakes, and whose rendered templates are recorded.
+type testBrowserService struct {
+	*BrowserService
+	router                    *mux.Router
+	contentAddressableStorage *fakeBlobAccess
+	actionCache               *fakeBlobAccess
+	templates                 *recordingTemplateExecutor
+
+	// The number of Actions stored through putActionMessage(),
+	// which is used to give each of them a distinct salt.
+	actionsCount int
+}
+
+// newTestBrowserService creates a BrowserService for testing. Options
+// that are left unset obtain the same defaults as provided by main().
+func newTestBrowserService(options BrowserServiceOptions) *testBrowserService {
+	if options.RoutePrefix == "" {
+		options.RoutePrefix = "/"
+	}
+	if options.ContentSniffingPrefixSizeBytes == 0 {
+		options.ContentSniffingPrefixSizeBytes = 4096
+	}
+	if options.MaximumCompressedFileSizeBytes == 0 {
+		options.MaximumCompressedFileSizeBytes = 10 * 1024 * 1024
+	}
+	allowAuthorizer := auth.NewStaticAuthorizer(func(digest.InstanceName) bool { return true })
+	denyAuthorizer := auth.NewStaticAuthorizer(func(digest.InstanceName) bool { return false })
+	if options.BrowseAuthorizer == nil {
+		options.BrowseAuthorizer = allowAuthorizer
+	}
+	if options.DownloadAuthorizer == nil {
+		options.DownloadAuthorizer = options.BrowseAuthorizer
+	}
+	if options.ListTarballGenerationsAuthorizer == nil {
+		options.ListTarballGenerationsAuthorizer = denyAuthorizer
+	}
+	if options.CancelTarballGenerationAuthorizer == nil {
+		options.CancelTarballGenerationAuthorizer = denyAuthorizer
+	}
+	if options.Clock == nil {
+		options.Clock = clock.SystemClock
+	}
+
+	ts := &testBrowserService{
+		router:                    mux.NewRouter(),
+		contentAddressableStorage: newFakeBlobAccess(),
+		actionCache:               newFakeBlobAccess(),
+		templates:                 newRecordingTemplateExecutor(),
+	}
+	// Mount the service under the route prefix in the same way as
+	// main() does.
+	router := ts.router
+	if options.RoutePrefix != "/" {
+		router = ts.router.PathPrefix(options.RoutePrefix).Subrouter()
+	}
+	ts.BrowserService = NewBrowserService(
+		ts.contentAddressableStorage,
+		ts.actionCache,
+		blobstore.NewErrorBlobAccess(status.Error(codes.NotFound, "No Initial Size Class Cache configured")),
+		blobstore.NewErrorBlobAccess(status.Error(codes.NotFound, "No File System Access Cache configured")),
+		16*1024*1024,
+		ts.templates,
+		digest.NewInstanceNamePatcher(digest.EmptyInstanceName, digest.EmptyInstanceName),
+		&options,
+		router)
+	return ts
+}
+
+// serve processes an HTTP request against the BrowserService.
+func (ts *testBrowserService) serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+// getURL returns the URL of a page of an object with a given digest.
+func getURL(pageType string, blobDigest digest.Digest, suffix string) string {
+	return fmt.Sprintf("/blobs/sha256/%s/%s-%d/%s", pageType, blobDigest.GetHashString(), blobDigest.GetSizeBytes(), suffix)
+}
+
+func TestGetContentTypeOverride(t *testing.T) {
+	for name, tc := range map[string]struct {
+		contentType string
+		expected    string
+		code        codes.Code
+	}{
+		"None":               {contentType: "", expected: ""},
+		"PlainText":          {contentType: "text/plain", expected: "text/plain"},
+		"PlainTextCharset":   {contentType: "text/plain; charset=latin1", expected: "text/plain; charset=latin1"},
+		"StripParameters":    {contentType: "application/json; foo=bar", expected: "application/json"},
+		"OctetStream":        {contentType: "application/octet-stream", expected: "application/octet-stream"},
+		"PNG":                {contentType: "image/png", expected: "image/png"},
+		"Uppercase":          {contentType: "Image/JPEG", expected: "image/jpeg"},
+		"HTML":               {contentType: "text/html", code: codes.InvalidArgument},
+		"SVG":                {contentType: "image/svg+xml", code: codes.InvalidArgument},
+		"ApplicationXML":     {contentType: "application/xml", code: codes.InvalidArgument},
+		"TextXML":            {contentType: "text/xml", code: codes.InvalidArgument},
+		"XSL":                {contentType: "text/xsl", code: codes.InvalidArgument},
+		"XHTML":              {contentType: "application/xhtml+xml", code: codes.InvalidArgument},
+		"JavaScript":         {contentType: "text/javascript", code: codes.InvalidArgument},
+		"HeaderInjection":    {contentType: "text/plain\r\nSet-Cookie: a=b", code: codes.InvalidArgument},
+		"InvalidMediaType":   {contentType: "text/", code: codes.InvalidArgument},
+		"UnknownApplication": {contentType: "application/x-sh", code: codes.InvalidArgument},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.RawQuery = (url.Values{"content_type": {tc.contentType}}).Encode()
+			contentType, err := getContentTypeOverride(req)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("Expected code %s, got %v", tc.code, err)
+			}
+			if contentType != tc.expected {
+				t.Errorf("Expected content type %#v, got %#v", tc.expected, contentType)
+			}
+		})
+	}
+}
+
+func TestHandleFileContentTypeOverride(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	fileDigest := ts.contentAddressableStorage.putBytes([]byte(`<x:script xmlns:x="http://www.w3.org/1999/xhtml">alert(1)</x:script>`))
+
+	for name, tc := range map[string]struct {
+		query       string
+		code        int
+		contentType string
+		sandboxed   bool
+	}{
+		"None":           {query: "", code: http.StatusOK, contentType: "text/plain; charset=utf-8"},
+		"Valid":          {query: "?content_type=application/json", code: http.StatusOK, contentType: "application/json", sandboxed: true},
+		"ApplicationXML": {query: "?content_type=application/xml", code: http.StatusBadRequest},
+		"TextXML":        {query: "?content_type=text/xml", code: http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", fileDigest, "file.xml")+tc.query, nil))
+			if w.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != tc.contentType {
+				t.Errorf("Expected content type %#v, got %#v", tc.contentType, contentType)
+			}
+			if sandboxed := w.Header().Get("Content-Security-Policy") == "sandbox"; sandboxed != tc.sandboxed {
+				t.Errorf("Expected response to be sandboxed: %v, got %v", tc.sandboxed, sandboxed)
+			}
+		})
+	}
+}
+
+func TestGetInputManifest(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	fileDigest := cas.putBytes([]byte("Hello"))
+	childDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "script.sh", Digest: fileDigest.GetProto(), IsExecutable: true},
+		},
+		Symlinks: []*remoteexecution.SymlinkNode{
+			{Name: "link", Target: "../hello.txt"},
+		},
+	})
+	rootDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: fileDigest.GetProto()},
+		},
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "bin", Digest: childDigest.GetProto()},
+		},
+	})
+
+	manifest, err := ts.getInputManifest(context.Background(), testDigestFunction, rootDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &inputManifest{
+		Files: []inputManifestFile{
+			{Path: "bin/script.sh", Hash: fileDigest.GetHashString(), SizeBytes: 5, IsExecutable: true},
+			{Path: "hello.txt", Hash: fileDigest.GetHashString(), SizeBytes: 5},
+		},
+		Symlinks: []inputManifestSymlink{
+			{Path: "bin/link", Target: "../hello.txt"},
+		},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("Expected manifest %#v, got %#v", expected, manifest)
+	}
+}
+
+func TestGetInputManifestExponentialPaths(t *testing.T) {
+	// Create a chain of directories, where every directory
+	// references the next one twice. Even though only a small
+	// number of directories need to be loaded, the number of paths
+	// is exponential in the length of the chain. Generating the
+	// manifest should terminate quickly, reporting that it is
+	// truncated.
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	directoryDigest := ts.contentAddressableStorage.putMessage(t, &remoteexecution.Directory{})
+	for i := 0; i < 48; i++ {
+		directoryDigest = ts.contentAddressableStorage.putMessage(t, &remoteexecution.Directory{
+			Directories: []*remoteexecution.DirectoryNode{
+				{Name: "a", Digest: directoryDigest.GetProto()},
+				{Name: "b", Digest: directoryDigest.GetProto()},
+			},
+		})
+	}
+
+	manifest, err := ts.getInputManifest(context.Background(), testDigestFunction, directoryDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Truncated {
+		t.Error("Expected manifest to be truncated")
+	}
+}
+
+func TestParseLineRange(t *testing.T) {
+	for name, tc := range map[string]struct {
+		lineRange string
+		first     int
+		last      int
+		code      codes.Code
+	}{
+		"SingleLine":       {lineRange: "3", first: 3, last: 3},
+		"Range":            {lineRange: "2-4", first: 2, last: 4},
+		"Fragment":         {lineRange: "L2-L4", first: 2, last: 4},
+		"FragmentSingle":   {lineRange: "L5", first: 5, last: 5},
+		"CappedToLastLine": {lineRange: "8-20", first: 8, last: 10},
+		"LastLine":         {lineRange: "10", first: 10, last: 10},
+		"Reversed":         {lineRange: "4-2", code: codes.InvalidArgument},
+		"Zero":             {lineRange: "0-2", code: codes.InvalidArgument},
+		"Negative":         {lineRange: "-2", code: codes.InvalidArgument},
+		"NonNumeric":       {lineRange: "abc", code: codes.InvalidArgument},
+		"NonNumericLast":   {lineRange: "2-abc", code: codes.InvalidArgument},
+		"MissingLast":      {lineRange: "2-", code: codes.InvalidArgument},
+		"DoubleRange":      {lineRange: "2-3-4", code: codes.InvalidArgument},
+		"BeyondLastLine":   {lineRange: "11-12", code: codes.OutOfRange},
+	} {
+		t.Run(name, func(t *testing.T) {
+			first, last, err := parseLineRange(tc.lineRange, 10)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("Expected code %s, got %v", tc.code, err)
+			}
+			if first != tc.first || last != tc.last {
+				t.Errorf("Expected range %d-%d, got %d-%d", tc.first, tc.last, first, last)
+			}
+		})
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data     string
+		expected int
+	}{
+		"Empty":              {data: "", expected: 0},
+		"SingleLine":         {data: "a", expected: 1},
+		"TrailingNewline":    {data: "a\n", expected: 1},
+		"MultipleLines":      {data: "a\nb\nc", expected: 3},
+		"EmptyLines":         {data: "\n\n", expected: 2},
+		"MultipleTrailingNL": {data: "a\nb\n", expected: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if n := countLines([]byte(tc.data)); n != tc.expected {
+				t.Errorf("Expected %d lines, got %d", tc.expected, n)
+			}
+		})
+	}
+}
+
+func TestSplitLineRange(t *testing.T) {
+	data := []byte("one\ntwo\nthree\nfour\n")
+	for name, tc := range map[string]struct {
+		first       int
+		last        int
+		before      string
+		highlighted string
+		after       string
+	}{
+		"FirstLine":  {first: 1, last: 1, before: "", highlighted: "one", after: "two\nthree\nfour\n"},
+		"Middle":     {first: 2, last: 3, before: "one", highlighted: "two\nthree", after: "four\n"},
+		"LastLine":   {first: 4, last: 4, before: "one\ntwo\nthree", highlighted: "four", after: ""},
+		"EntireFile": {first: 1, last: 4, before: "", highlighted: "one\ntwo\nthree\nfour", after: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			before, highlighted, after := splitLineRange(data, tc.first, tc.last)
+			if string(before) != tc.before || string(highlighted) != tc.highlighted || string(after) != tc.after {
+				t.Errorf("Expected %#v, %#v, %#v, got %#v, %#v, %#v", tc.before, tc.highlighted, tc.after, string(before), string(highlighted), string(after))
+			}
+		})
+	}
+}
+
+func TestHandleLogLineRange(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	// Create a log spanning two pages, where the second page
+	// contains three lines.
+	firstPage := strings.Repeat("x", maximumLogSizeBytes-1) + "\n"
+	logDigest := ts.contentAddressableStorage.putBytes([]byte(firstPage + "one\ntwo\nthree\n"))
+
+	for name, tc := range map[string]struct {
+		query       string
+		code        int
+		highlighted template.HTML
+	}{
+		"None":             {query: "?page=1", code: http.StatusOK},
+		"SecondPage":       {query: "?page=1&lines=2-3", code: http.StatusOK, highlighted: "two\nthree"},
+		"Capped":           {query: "?page=1&lines=3-100", code: http.StatusOK, highlighted: "three"},
+		"OutsidePage":      {query: "?page=1&lines=4-5", code: http.StatusBadRequest},
+		"OutsideFirstPage": {query: "?lines=2", code: http.StatusBadRequest},
+		"Reversed":         {query: "?page=1&lines=3-2", code: http.StatusBadRequest},
+		"NonNumeric":       {query: "?page=1&lines=two", code: http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts.templates.data = nil
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("log", logDigest, "")+tc.query, nil))
+			if w.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			lines := reflect.ValueOf(ts.templates.data).Elem().FieldByName("Lines").Interface().(*lineRangeInfo)
+			if lines.HighlightedHTML != tc.highlighted {
+				t.Errorf("Expected highlighted HTML %#v, got %#v", tc.highlighted, lines.HighlightedHTML)
+			}
+		})
+	}
+}
+
+func TestHandleFileLineRange(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	textDigest := ts.contentAddressableStorage.putBytes([]byte("<b>one</b>\ntwo\nthree\n"))
+	binaryDigest := ts.contentAddressableStorage.putBytes([]byte{0xff, 0xfe, '\n'})
+
+	t.Run("Highlighted", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", textDigest, "hello.txt")+"?lines=L1-L2", nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_file.html" {
+			t.Fatalf("Expected page_file.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		lines := reflect.ValueOf(ts.templates.data).FieldByName("Lines").Interface().(*lineRangeInfo)
+		expected := &lineRangeInfo{
+			FirstHighlightedLine: 1,
+			HighlightedHTML:      "&lt;b&gt;one&lt;/b&gt;\ntwo",
+			TrailingHTML:         "three\n",
+		}
+		if !reflect.DeepEqual(lines, expected) {
+			t.Errorf("Expected %#v, got %#v", expected, lines)
+		}
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", textDigest, "hello.txt")+"?lines=1&raw=1", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "<b>one</b>\ntwo\nthree\n" {
+			t.Fatalf("Expected raw file contents, got status %d and body %#v", w.Code, w.Body.String())
+		}
+	})
+
+	for name, tc := range map[string]struct {
+		blobDigest digest.Digest
+		query      string
+	}{
+		"OutsideFile": {blobDigest: textDigest, query: "?lines=4"},
+		"Reversed":    {blobDigest: textDigest, query: "?lines=2-1"},
+		"NonNumeric":  {blobDigest: textDigest, query: "?lines=L1-Lx"},
+		"Binary":      {blobDigest: binaryDigest, query: "?lines=1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", tc.blobDigest, "hello.txt")+tc.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+// serveProtoJSON requests the JSON representation of a page, parsing it
+// as a Protobuf message if the request succeeds.
+func (ts *testBrowserService) serveProtoJSON(t *testing.T, url string, m proto.Message) int {
+	return ts.serveProtoJSONRequest(t, httptest.NewRequest(http.MethodGet, url, nil), m)
+}
+
+// serveProtoJSONRequest is identical to serveProtoJSON, except that it
+// permits providing a custom request.
+func (ts *testBrowserService) serveProtoJSONRequest(t *testing.T, req *http.Request, m proto.Message) int {
+	req.Header.Set("Accept", "application/json")
+	w := ts.serve(req)
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected content type \"application/json\", got %#v", contentType)
+	}
+	if w.Code == http.StatusOK {
+		if err := protojson.Unmarshal(w.Body.Bytes(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+// putAction stores an Action in the Content Addressable Storage,
+// returning its digest. Every call yields a distinct Action.
+func (ts *testBrowserService) putAction(t *testing.T, commandDigest, inputRootDigest digest.Digest) digest.Digest {
+	return ts.putActionMessage(t, &remoteexecution.Action{
+		CommandDigest:   commandDigest.GetProto(),
+		InputRootDigest: inputRootDigest.GetProto(),
+	})
+}
+
+// putActionMessage stores an Action in the Content Addressable Storage,
+// returning its digest. The salt of the Action is overwritten, so that
+// every call yields a distinct Action.
+func (ts *testBrowserService) putActionMessage(t *testing.T, action *remoteexecution.Action) digest.Digest {
+	ts.actionsCount++
+	action = proto.Clone(action).(*remoteexecution.Action)
+	action.Salt = []byte(strconv.Itoa(ts.actionsCount))
+	return ts.contentAddressableStorage.putMessage(t, action)
+}
+
+// putActionResult stores an action result in the Action Cache.
+func (ts *testBrowserService) putActionResult(t *testing.T, actionDigest digest.Digest, actionResult *remoteexecution.ActionResult) {
+	data, err := proto.Marshal(actionResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.actionCache.blobs[actionDigest] = data
+}
+
+// serveJSONError processes an HTTP request that is expected to fail,
+// returning the status code and the error contained in the JSON
+// response.
+func (ts *testBrowserService) serveJSONError(t *testing.T, req *http.Request) (int, jsonError) {
+	req.Header.Set("Accept", "application/json")
+	w := ts.serve(req)
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected content type \"application/json\", got %#v", contentType)
+	}
+	var response jsonError
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, response
+}
+
+// expectErrorPage checks that a request causes page_error.html to be
+// rendered with a given HTTP status code and message.
+func (ts *testBrowserService) expectErrorPage(t *testing.T, req *http.Request, code int, message string) {
+	t.Helper()
+	ts.templates.name = ""
+	w := ts.serve(req)
+	if w.Code != code || ts.templates.name != "page_error.html" || !strings.Contains(w.Body.String(), template.HTMLEscapeString(message)) {
+		t.Errorf("Expected page_error.html with status %d containing %#v, got status %d and template %#v", code, message, w.Code, ts.templates.name)
+	}
+}
+
+// expectRequestError checks that requests fail with a given error, both
+// when rendered as page_error.html and when returned as JSON. Requests
+// are created by calling newRequest, as both responses require a
+// request of their own. The message of the JSON error only needs to
+// start with the provided message.
+func (ts *testBrowserService) expectRequestError(t *testing.T, newRequest func() *http.Request, code int, statusCode, message string) {
+	t.Helper()
+	ts.expectErrorPage(t, newRequest(), code, message)
+	if gotCode, response := ts.serveJSONError(t, newRequest()); gotCode != code || response.Code != statusCode || !strings.HasPrefix(response.Message, message) {
+		t.Errorf("Expected %s error with status %d and message %#v, got status %d and %#v", statusCode, code, message, gotCode, response)
+	}
+}
+
+// expectError is identical to expectRequestError, except that it
+// performs GET requests against a given URL.
+func (ts *testBrowserService) expectError(t *testing.T, url string, code int, statusCode, message string) {
+	t.Helper()
+	ts.expectRequestError(t, func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, url, nil)
+	}, code, statusCode, message)
+}
+
+func TestHandleCommandJSON(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	command := &remoteexecution.Command{
+		Arguments: []string{"/bin/sh", "-c", "echo $GREETING > out.txt"},
+		EnvironmentVariables: []*remoteexecution.Command_EnvironmentVariable{
+			{Name: "GREETING", Value: "Hello"},
+			{Name: "PATH", Value: "/bin"},
+		},
+		OutputPaths: []string{"out.txt"},
+	}
+	commandDigest := ts.contentAddressableStorage.putMessage(t, command)
+
+	var got remoteexecution.Command
+	if code := ts.serveProtoJSON(t, getURL("command", commandDigest, ""), &got); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if !proto.Equal(&got, command) {
+		t.Errorf("Expected command %v, got %v", command, &got)
+	}
+
+	// Missing commands yield errors that are encoded as JSON.
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "0000000000000000000000000000000000000000000000000000000000000000", 5)
+	if code := ts.serveProtoJSON(t, getURL("command", missingDigest, ""), &got); code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestHandleDirectoryJSON(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	fileDigest := cas.putBytes([]byte("Hello"))
+	childDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	directory := &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: fileDigest.GetProto(), IsExecutable: true},
+		},
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "bin", Digest: childDigest.GetProto()},
+		},
+		Symlinks: []*remoteexecution.SymlinkNode{
+			{Name: "link", Target: "hello.txt"},
+		},
+	}
+	directoryDigest := cas.putMessage(t, directory)
+
+	for name, url := range map[string]string{
+		"AcceptHeader": getURL("directory", directoryDigest, ""),
+		"FormatQuery":  getURL("directory", directoryDigest, "?format=json"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var got remoteexecution.Directory
+			if code := ts.serveProtoJSON(t, url, &got); code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+			}
+			if !proto.Equal(&got, directory) {
+				t.Errorf("Expected directory %v, got %v", directory, &got)
+			}
+		})
+	}
+}
+
+func TestHandleActionJSON(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	fileDigest := cas.putBytes([]byte("Hello"))
+	treeDigest := cas.putMessage(t, &remoteexecution.Tree{Root: &remoteexecution.Directory{}})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}}).GetProto(),
+		InputRootDigest: cas.putMessage(t, &remoteexecution.Directory{}).GetProto(),
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		var got remoteexecution.ActionResult
+		if code := ts.serveProtoJSON(t, getURL("action", actionDigest, ""), &got); code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, code)
+		}
+	})
+
+	t.Run("Found", func(t *testing.T) {
+		actionResult := &remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{Path: "out/hello.txt", Digest: fileDigest.GetProto()},
+			},
+			OutputDirectories: []*remoteexecution.OutputDirectory{
+				{Path: "out/dir", TreeDigest: treeDigest.GetProto()},
+			},
+			OutputSymlinks: []*remoteexecution.OutputSymlink{
+				{Path: "out/link", Target: "hello.txt"},
+			},
+			ExitCode: 1,
+		}
+		data, err := proto.Marshal(actionResult)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.actionCache.blobs[actionDigest] = data
+
+		var got remoteexecution.ActionResult
+		if code := ts.serveProtoJSON(t, getURL("action", actionDigest, ""), &got); code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+		if !proto.Equal(&got, actionResult) {
+			t.Errorf("Expected action result %v, got %v", actionResult, &got)
+		}
+	})
+}
+
+func TestNewRenderedLogInfoCollapsed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		collapsedLogMinimumLines int
+		data                     string
+		lineCount                int
+		collapsed                bool
+	}{
+		"Empty":                  {collapsedLogMinimumLines: 2, data: "", lineCount: 0},
+		"BelowThreshold":         {collapsedLogMinimumLines: 2, data: "a\n", lineCount: 1},
+		"AtThreshold":            {collapsedLogMinimumLines: 2, data: "a\nb\n", lineCount: 2},
+		"AboveThreshold":         {collapsedLogMinimumLines: 2, data: "a\nb\nc\n", lineCount: 3, collapsed: true},
+		"NoTrailingNewline":      {collapsedLogMinimumLines: 2, data: "a\nb\nc", lineCount: 3, collapsed: true},
+		"CollapsingDisabled":     {collapsedLogMinimumLines: 0, data: "a\nb\nc\n", lineCount: 3},
+		"TrailingNewlineOnlyOne": {collapsedLogMinimumLines: 1, data: "a\n", lineCount: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestBrowserService(BrowserServiceOptions{
+				CollapsedLogMinimumLines: tc.collapsedLogMinimumLines,
+			})
+			logDigest := ts.contentAddressableStorage.putBytes([]byte(tc.data))
+			logInfo := ts.newRenderedLogInfo("Standard output", logDigest, []byte(tc.data))
+			if logInfo.LineCount != tc.lineCount {
+				t.Errorf("Expected %d lines, got %d", tc.lineCount, logInfo.LineCount)
+			}
+			if logInfo.Collapsed != tc.collapsed {
+				t.Errorf("Expected log to be collapsed: %v, got %v", tc.collapsed, logInfo.Collapsed)
+			}
+		})
+	}
+}
+
+func TestObserveLookup(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	commandDigest := ts.contentAddressableStorage.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "0000000000000000000000000000000000000000000000000000000000000000", 5)
+
+	found := browserServiceLookupsTotal.WithLabelValues("command", "found")
+	notFound := browserServiceLookupsTotal.WithLabelValues("command", "not_found")
+	for name, tc := range map[string]struct {
+		blobDigest    digest.Digest
+		code          int
+		foundDelta    float64
+		notFoundDelta float64
+	}{
+		"Found":    {blobDigest: commandDigest, code: http.StatusOK, foundDelta: 1},
+		"NotFound": {blobDigest: missingDigest, code: http.StatusNotFound, notFoundDelta: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			foundBefore, notFoundBefore := testutil.ToFloat64(found), testutil.ToFloat64(notFound)
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("command", tc.blobDigest, ""), nil))
+			if w.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+			}
+			if delta := testutil.ToFloat64(found) - foundBefore; delta != tc.foundDelta {
+				t.Errorf("Expected found counter to increase by %v, got %v", tc.foundDelta, delta)
+			}
+			if delta := testutil.ToFloat64(notFound) - notFoundBefore; delta != tc.notFoundDelta {
+				t.Errorf("Expected not found counter to increase by %v, got %v", tc.notFoundDelta, delta)
+			}
+		})
+	}
+}
+
+func TestHandleBlob(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	fileDigest := cas.putBytes(make([]byte, 200))
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{
+		Arguments: []string{"echo", "hello"},
+	})
+	// The size of the file is encoded as a varint that is not valid
+	// UTF-8, meaning this can't be parsed as a Command.
+	directoryDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: fileDigest.GetProto()},
+		},
+	})
+	// A FileNode containing only a name can also be interpreted as
+	// an argument of a Command.
+	ambiguousDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "a"},
+		},
+	})
+	unknownDigest := cas.putBytes([]byte{0xff, 0xff, 0xff})
+	emptyDigest := cas.putBytes(nil)
+
+	for name, tc := range map[string]struct {
+		blobDigest digest.Digest
+		location   string
+	}{
+		"Command":   {blobDigest: commandDigest, location: getURL("command", commandDigest, "")},
+		"Directory": {blobDigest: directoryDigest, location: getURL("directory", directoryDigest, "")},
+		"Unknown":   {blobDigest: unknownDigest, location: getURL("file", unknownDigest, "blob")},
+		"Empty":     {blobDigest: emptyDigest, location: getURL("file", emptyDigest, "blob")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("blob", tc.blobDigest, ""), nil))
+			if w.Code != http.StatusFound {
+				t.Fatalf("Expected status %d, got %d", http.StatusFound, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tc.location {
+				t.Errorf("Expected redirect to %#v, got %#v", tc.location, location)
+			}
+		})
+	}
+
+	t.Run("Ambiguous", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("blob", ambiguousDigest, ""), nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_blob.html" {
+			t.Fatalf("Expected page_blob.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		blobTypes := reflect.ValueOf(ts.templates.data).Elem().FieldByName("BlobTypes").Interface().([]string)
+		for _, expected := range []string{"Command", "Directory"} {
+			found := false
+			for _, blobType := range blobTypes {
+				found = found || blobType == expected
+			}
+			if !found {
+				t.Errorf("Expected blob types %v to contain %#v", blobTypes, expected)
+			}
+		}
+	})
+}
+
+func TestSkippingWriter(t *testing.T) {
+	chunks := []string{"abc", "defg", "hijkl"}
+	for name, tc := range map[string]struct {
+		skip     int64
+		expected string
+	}{
+		"NoSkip":         {skip: 0, expected: "abcdefghijkl"},
+		"WithinChunk":    {skip: 2, expected: "cdefghijkl"},
+		"ChunkBoundary":  {skip: 3, expected: "defghijkl"},
+		"LaterChunk":     {skip: 8, expected: "ijkl"},
+		"EverythingLeft": {skip: 12, expected: ""},
+		"BeyondEnd":      {skip: 20, expected: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var out strings.Builder
+			sw := &skippingWriter{w: &out, skip: tc.skip}
+			for _, chunk := range chunks {
+				if n, err := sw.Write([]byte(chunk)); n != len(chunk) || err != nil {
+					t.Fatalf("Expected write of %d bytes to succeed, got %d, %v", len(chunk), n, err)
+				}
+			}
+			if out.String() != tc.expected {
+				t.Errorf("Expected output %#v, got %#v", tc.expected, out.String())
+			}
+			// Discarded bytes are counted as well.
+			if sw.written != 12 {
+				t.Errorf("Expected 12 bytes to be written, got %d", sw.written)
+			}
+		})
+	}
+}
+
+func TestGetRangeStart(t *testing.T) {
+	for name, tc := range map[string]struct {
+		rangeHeader   string
+		ifRangeHeader string
+		start         int64
+		ok            bool
+	}{
+		"None":       {rangeHeader: "", ok: false},
+		"OpenEnded":  {rangeHeader: "bytes=100-", start: 100, ok: true},
+		"Zero":       {rangeHeader: "bytes=0-", start: 0, ok: true},
+		"Bounded":    {rangeHeader: "bytes=100-200", ok: false},
+		"Suffix":     {rangeHeader: "bytes=-100", ok: false},
+		"MultiRange": {rangeHeader: "bytes=0-10,20-", ok: false},
+		"OtherUnit":  {rangeHeader: "items=100-", ok: false},
+		"NonNumeric": {rangeHeader: "bytes=abc-", ok: false},
+		"IfRange":    {rangeHeader: "bytes=100-", ifRangeHeader: "\"etag\"", ok: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			if tc.ifRangeHeader != "" {
+				req.Header.Set("If-Range", tc.ifRangeHeader)
+			}
+			if start, ok := getRangeStart(req); start != tc.start || ok != tc.ok {
+				t.Errorf("Expected %d, %v, got %d, %v", tc.start, tc.ok, start, ok)
+			}
+		})
+	}
+}
+
+func TestHandleDirectoryTarballRange(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	fileDigest := cas.putBytes([]byte("Hello, world!\n"))
+	directoryDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: fileDigest.GetProto()},
+		},
+	})
+	tarballURL := getURL("directory", directoryDigest, "?format=tar&reproducible=1")
+	getTarball := func(url, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		return ts.serve(req)
+	}
+
+	full := getTarball(tarballURL, "")
+	if full.Code != http.StatusOK || full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("Expected full tarball supporting ranges, got status %d and Accept-Ranges %#v", full.Code, full.Header().Get("Accept-Ranges"))
+	}
+	total := full.Body.Len()
+	if total <= 100 {
+		t.Fatalf("Tarball is only %d bytes in size", total)
+	}
+
+	t.Run("Resume", func(t *testing.T) {
+		w := getTarball(tarballURL, "bytes=100-")
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("Expected status %d, got %d", http.StatusPartialContent, w.Code)
+		}
+		if contentRange, expected := w.Header().Get("Content-Range"), fmt.Sprintf("bytes 100-%d/%d", total-1, total); contentRange != expected {
+			t.Errorf("Expected Content-Range %#v, got %#v", expected, contentRange)
+		}
+		if !bytes.Equal(w.Body.Bytes(), full.Body.Bytes()[100:]) {
+			t.Error("Resumed tarball does not match the end of the full tarball")
+		}
+	})
+
+	t.Run("Unsatisfiable", func(t *testing.T) {
+		w := getTarball(tarballURL, fmt.Sprintf("bytes=%d-", total))
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Fatalf("Expected status %d, got %d", http.StatusRequestedRangeNotSatisfiable, w.Code)
+		}
+		if contentRange, expected := w.Header().Get("Content-Range"), fmt.Sprintf("bytes */%d", total); contentRange != expected {
+			t.Errorf("Expected Content-Range %#v, got %#v", expected, contentRange)
+		}
+	})
+
+	t.Run("NotReproducible", func(t *testing.T) {
+		// Tarballs containing timestamps can't be resumed, so
+		// the full tarball is returned.
+		w := getTarball(getURL("directory", directoryDigest, "?format=tar"), "bytes=100-")
+		if w.Code != http.StatusOK || w.Header().Get("Accept-Ranges") != "" {
+			t.Fatalf("Expected full tarball without support for ranges, got status %d and Accept-Ranges %#v", w.Code, w.Header().Get("Accept-Ranges"))
+		}
+	})
+}
+
+// gzipBytes compresses data using gzip.
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// tarGzipBytes creates a gzip compressed tarball containing empty
+// files with the provided names.
+func tarGzipBytes(t *testing.T, names []string) []byte {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return gzipBytes(t, b.Bytes())
+}
+
+// countingResponseWriter is an http.ResponseWriter that only counts
+// the number of bytes in the response body, so that large responses
+// don't need to be kept in memory.
+type countingResponseWriter struct {
+	header http.Header
+	code   int
+	count  int64
+}
+
+func (w *countingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.count += int64(len(p))
+	return len(p), nil
+}
+
+func (w *countingResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func TestHandleFileDecompress(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	compressed := gzipBytes(t, []byte("Hello, world!\n"))
+	textDigest := cas.putBytes(compressed)
+
+	t.Run("Text", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", textDigest, "hello.txt.gz?decompress=1"), nil))
+		if w.Code != http.StatusOK || w.Body.String() != "Hello, world!\n" {
+			t.Fatalf("Expected decompressed contents, got status %d and body %#v", w.Code, w.Body.String())
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+			t.Errorf("Expected content type of decompressed contents, got %#v", contentType)
+		}
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", textDigest, "hello.txt.gz?decompress=1&raw=1"), nil))
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), compressed) {
+			t.Fatalf("Expected compressed contents, got status %d", w.Code)
+		}
+	})
+
+	t.Run("NotGzip", func(t *testing.T) {
+		notGzipDigest := cas.putBytes([]byte("Hello, world!\n"))
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", notGzipDigest, "hello.txt.gz?decompress=1"), nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("MaximumDecompressedSize", func(t *testing.T) {
+		// Decompression bombs are aborted, as opposed to being
+		// truncated silently.
+		bombDigest := cas.putBytes(gzipBytes(t, make([]byte, maximumDecompressedFileSizeBytes+1024)))
+		w := &countingResponseWriter{header: http.Header{}}
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Fatalf("Expected the response to be aborted, got %#v", r)
+			}
+			if w.count != maximumDecompressedFileSizeBytes {
+				t.Errorf("Expected %d bytes to be written before aborting, got %d", maximumDecompressedFileSizeBytes, w.count)
+			}
+		}()
+		ts.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getURL("file", bombDigest, "bomb.gz?decompress=1"), nil))
+	})
+
+	t.Run("MaximumCompressedSize", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{
+			MaximumCompressedFileSizeBytes: int64(len(compressed)) - 1,
+		})
+		textDigest := ts.contentAddressableStorage.putBytes(compressed)
+		for name, filename := range map[string]string{
+			"File":    "hello.txt.gz",
+			"Tarball": "hello.tar.gz",
+		} {
+			t.Run(name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, getURL("file", textDigest, filename+"?decompress=1"), nil)
+				req.Header.Set("Accept", "application/json")
+				w := ts.serve(req)
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+				}
+				var response jsonError
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				if expected := fmt.Sprintf("Compressed file is %d bytes in size, which exceeds the maximum size of %d bytes that may be decompressed", len(compressed), len(compressed)-1); response.Code != "FailedPrecondition" || response.Message != expected {
+					t.Errorf("Expected FailedPrecondition error %#v, got %#v", expected, response)
+				}
+				if ts.contentAddressableStorage.getCalls != 0 {
+					t.Errorf("Expected the file not to be loaded, got %d calls", ts.contentAddressableStorage.getCalls)
+				}
+			})
+		}
+	})
+
+	t.Run("TruncatedListingDecompressedSize", func(t *testing.T) {
+		var b bytes.Buffer
+		tw := tar.NewWriter(&b)
+		for _, file := range []struct {
+			name string
+			size int64
+		}{
+			{name: "small.txt", size: 1},
+			{name: "large.bin", size: maximumDecompressedFileSizeBytes},
+			{name: "hidden.txt", size: 1},
+		} {
+			if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: file.size}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(make([]byte, file.size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ts := newTestBrowserService(BrowserServiceOptions{
+			MaximumCompressedFileSizeBytes: 1024 * 1024 * 1024,
+		})
+		tarballDigest := ts.contentAddressableStorage.putBytes(gzipBytes(t, b.Bytes()))
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", tarballDigest, "archive.tar.gz?decompress=1"), nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_archive.html" {
+			t.Fatalf("Expected page_archive.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		archiveInfo := reflect.ValueOf(ts.templates.data)
+		var names []string
+		for _, entry := range archiveInfo.FieldByName("Entries").Interface().([]*tar.Header) {
+			names = append(names, entry.Name)
+		}
+		if expected := []string{"small.txt", "large.bin"}; !reflect.DeepEqual(names, expected) {
+			t.Errorf("Expected entries %#v, got %#v", expected, names)
+		}
+		if !archiveInfo.FieldByName("Truncated").Bool() {
+			t.Error("Expected listing to be truncated")
+		}
+	})
+
+	for name, tc := range map[string]struct {
+		filename      string
+		entriesCount  int
+		expectedCount int
+		truncated     bool
+	}{
+		"Tarball":          {filename: "archive.tar.gz", entriesCount: 3, expectedCount: 3},
+		"TgzExtension":     {filename: "archive.tgz", entriesCount: 3, expectedCount: 3},
+		"MaximumEntries":   {filename: "archive.tar.gz", entriesCount: maximumArchiveEntriesCount, expectedCount: maximumArchiveEntriesCount},
+		"TruncatedListing": {filename: "archive.tar.gz", entriesCount: maximumArchiveEntriesCount + 1, expectedCount: maximumArchiveEntriesCount, truncated: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			names := make([]string, 0, tc.entriesCount)
+			for i := 0; i < tc.entriesCount; i++ {
+				names = append(names, fmt.Sprintf("file%d.txt", i))
+			}
+			tarballDigest := cas.putBytes(tarGzipBytes(t, names))
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", tarballDigest, tc.filename+"?decompress=1"), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_archive.html" {
+				t.Fatalf("Expected page_archive.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			archiveInfo := reflect.ValueOf(ts.templates.data)
+			entries := archiveInfo.FieldByName("Entries").Interface().([]*tar.Header)
+			if len(entries) != tc.expectedCount || entries[0].Name != "file0.txt" {
+				t.Errorf("Expected %d entries starting with \"file0.txt\", got %d", tc.expectedCount, len(entries))
+			}
+			if truncated := archiveInfo.FieldByName("Truncated").Bool(); truncated != tc.truncated {
+				t.Errorf("Expected listing to be truncated: %v, got %v", tc.truncated, truncated)
+			}
+		})
+	}
+}
+
+func TestHandleFileContentSniffingPrefix(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{
+		ContentSniffingPrefixSizeBytes: 16,
+	})
+	for name, sizeBytes := range map[string]int{
+		"Empty":          0,
+		"SmallerPrefix":  15,
+		"EqualToPrefix":  16,
+		"LargerPrefix":   17,
+		"MultiplePrefix": 100,
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := make([]byte, sizeBytes)
+			for i := range data {
+				data[i] = 'a' + byte(i%26)
+			}
+			fileDigest := ts.contentAddressableStorage.putBytes(data)
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", fileDigest, "file.txt?raw=1"), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if !bytes.Equal(w.Body.Bytes(), data) {
+				t.Errorf("Expected body %#v, got %#v", string(data), w.Body.String())
+			}
+			if contentLength := w.Header().Get("Content-Length"); contentLength != fmt.Sprint(sizeBytes) {
+				t.Errorf("Expected Content-Length %d, got %#v", sizeBytes, contentLength)
+			}
+
+			// Decompressed files are sniffed in the same way.
+			compressedDigest := ts.contentAddressableStorage.putBytes(gzipBytes(t, data))
+			w = ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", compressedDigest, "file.txt.gz?decompress=1"), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if !bytes.Equal(w.Body.Bytes(), data) {
+				t.Errorf("Expected decompressed body %#v, got %#v", string(data), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestFilterJSONFields(t *testing.T) {
+	value := struct {
+		ExitCode     int      `json:"exitCode"`
+		Cached       bool     `json:"cached"`
+		OutputFiles  []string `json:"outputFiles"`
+		OutputsCount int      `json:"outputsCount"`
+	}{
+		ExitCode:     1,
+		Cached:       true,
+		OutputFiles:  []string{"a.txt"},
+		OutputsCount: 1,
+	}
+	for name, tc := range map[string]struct {
+		value    interface{}
+		fields   []string
+		expected string
+	}{
+		"SingleField":    {value: value, fields: []string{"exitCode"}, expected: `{"exitCode":1}`},
+		"MultipleFields": {value: value, fields: []string{"exitCode", "outputFiles"}, expected: `{"exitCode":1,"outputFiles":["a.txt"]}`},
+		"Whitespace":     {value: value, fields: []string{" exitCode ", "cached"}, expected: `{"cached":true,"exitCode":1}`},
+		"EmptyField":     {value: value, fields: []string{"exitCode", ""}, expected: `{"exitCode":1}`},
+		"UnknownField":   {value: value, fields: []string{"exitCode", "stdout"}, expected: `{"exitCode":1,"warnings":["Unknown field \"stdout\""]}`},
+		"OnlyUnknown":    {value: value, fields: []string{"stdout"}, expected: `{"warnings":["Unknown field \"stdout\""]}`},
+		"NotAnObject":    {value: []int{1, 2}, fields: []string{"exitCode"}, expected: `[1,2]`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			filtered, err := filterJSONFields(tc.value, tc.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(filtered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, data)
+			}
+		})
+	}
+}
+
+func TestHandleActionSummaryFields(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	actionDigest := ts.contentAddressableStorage.putMessage(t, &remoteexecution.Action{})
+	data, err := proto.Marshal(&remoteexecution.ActionResult{ExitCode: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.actionCache.blobs[actionDigest] = data
+
+	for name, tc := range map[string]struct {
+		query    string
+		expected string
+	}{
+		"Unfiltered": {query: "?format=summary", expected: `{"exitCode":3,"cached":true,"executionDurationSeconds":null,"outputsCount":0}`},
+		"Filtered":   {query: "?format=summary&fields=exitCode,cached", expected: `{"cached":true,"exitCode":3}`},
+		"Unknown":    {query: "?format=summary&fields=exitCode,stdout", expected: `{"exitCode":3,"warnings":["Unknown field \"stdout\""]}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, tc.query), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if body := strings.TrimSpace(w.Body.String()); body != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, body)
+			}
+		})
+	}
+}
+
+func TestGetDigestFromRequestEmptyBlob(t *testing.T) {
+	// The Content Addressable Storage is empty, meaning that any
+	// attempt to load the empty blob from storage fails.
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	emptyDigest := testDigestFunction.NewGenerator(0).Sum()
+	inconsistentDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 0)
+
+	t.Run("EmptyFile", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", emptyDigest, "empty.txt"), nil))
+		if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "0" {
+			t.Fatalf("Expected empty file, got status %d and %d bytes", w.Code, w.Body.Len())
+		}
+	})
+
+	t.Run("EmptyDirectory", func(t *testing.T) {
+		var directory remoteexecution.Directory
+		if code := ts.serveProtoJSON(t, getURL("directory", emptyDigest, ""), &directory); code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+		if !proto.Equal(&directory, &remoteexecution.Directory{}) {
+			t.Errorf("Expected empty directory, got %v", &directory)
+		}
+	})
+
+	for name, url := range map[string]string{
+		"InconsistentCommand":   getURL("command", inconsistentDigest, ""),
+		"InconsistentDirectory": getURL("directory", inconsistentDigest, ""),
+		"InconsistentFile":      getURL("file", inconsistentDigest, "empty.txt"),
+		"InconsistentLog":       getURL("log", inconsistentDigest, ""),
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+func TestGenerateTarballConcurrencyLimit(t *testing.T) {
+	// Requesting a tarball of the empty directory requires no
+	// access to storage.
+	tarballURL := getURL("directory", testDigestFunction.NewGenerator(0).Sum(), "?format=tar")
+	newRequest := func(ctx context.Context) *http.Request {
+		return httptest.NewRequest(http.MethodGet, tarballURL, nil).WithContext(ctx)
+	}
+
+	t.Run("Unlimited", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{})
+		if w := ts.serve(newRequest(context.Background())); w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{
+			MaximumConcurrentTarballGenerations: 1,
+		})
+		ts.tarballGenerationSlots <- struct{}{}
+		w := ts.serve(newRequest(context.Background()))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != fmt.Sprint(tarballGenerationRetryAfterSeconds) {
+			t.Fatalf("Expected status %d with Retry-After, got status %d and Retry-After %#v", http.StatusServiceUnavailable, w.Code, w.Header().Get("Retry-After"))
+		}
+
+		// Once the slot is released, tarballs can be generated
+		// again.
+		ts.releaseTarballGenerationSlot()
+		if w := ts.serve(newRequest(context.Background())); w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if len(ts.tarballGenerationSlots) != 0 {
+			t.Errorf("Expected all slots to be released, got %d in use", len(ts.tarballGenerationSlots))
+		}
+	})
+
+	t.Run("Queued", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{
+			MaximumConcurrentTarballGenerations:      1,
+			MaximumTarballGenerationQueueingDuration: time.Minute,
+		})
+		ts.tarballGenerationSlots <- struct{}{}
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			done <- ts.serve(newRequest(context.Background()))
+		}()
+		select {
+		case w := <-done:
+			t.Fatalf("Request completed with status %d while no slot was available", w.Code)
+		case <-time.After(50 * time.Millisecond):
+		}
+		ts.releaseTarballGenerationSlot()
+		if w := <-done; w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("QueueingTimeout", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{
+			MaximumConcurrentTarballGenerations:      1,
+			MaximumTarballGenerationQueueingDuration: 10 * time.Millisecond,
+		})
+		ts.tarballGenerationSlots <- struct{}{}
+		w := ts.serve(newRequest(context.Background()))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("Expected status %d with Retry-After, got status %d and Retry-After %#v", http.StatusServiceUnavailable, w.Code, w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("RequestCanceled", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{
+			MaximumConcurrentTarballGenerations:      1,
+			MaximumTarballGenerationQueueingDuration: time.Hour,
+		})
+		ts.tarballGenerationSlots <- struct{}{}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		w := ts.serve(newRequest(ctx))
+		if w.Code != 499 || w.Header().Get("Retry-After") != "" {
+			t.Fatalf("Expected status 499 without Retry-After, got status %d and Retry-After %#v", w.Code, w.Header().Get("Retry-After"))
+		}
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	// Authorizer that denies access to instance name "secret".
+	denySecretAuthorizer := auth.NewStaticAuthorizer(func(instanceName digest.InstanceName) bool {
+		return instanceName.String() != "secret"
+	})
+	// putBytesWithInstanceName stores a blob under a given instance
+	// name, returning the URL of one of its pages.
+	putBytesWithInstanceName := func(ts *testBrowserService, instanceName, pageType string, data []byte, suffix string) string {
+		digestGenerator := digest.MustNewFunction(instanceName, remoteexecution.DigestFunction_SHA256).NewGenerator(int64(len(data)))
+		digestGenerator.Write(data)
+		blobDigest := digestGenerator.Sum()
+		ts.contentAddressableStorage.blobs[blobDigest] = data
+		return "/" + instanceName + getURL(pageType, blobDigest, suffix)
+	}
+	commandData, err := proto.Marshal(&remoteexecution.Command{Arguments: []string{"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Browse", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{
+			BrowseAuthorizer: denySecretAuthorizer,
+		})
+		for name, tc := range map[string]struct {
+			url  string
+			code int
+		}{
+			"PublicCommand": {url: putBytesWithInstanceName(ts, "public", "command", commandData, ""), code: http.StatusOK},
+			"SecretCommand": {url: putBytesWithInstanceName(ts, "secret", "command", commandData, ""), code: http.StatusForbidden},
+			"PublicFile":    {url: putBytesWithInstanceName(ts, "public", "file", []byte("Hello"), "hello.txt"), code: http.StatusOK},
+			"SecretFile":    {url: putBytesWithInstanceName(ts, "secret", "file", []byte("Hello"), "hello.txt"), code: http.StatusForbidden},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.contentAddressableStorage.getCalls = 0
+				w := ts.serve(httptest.NewRequest(http.MethodGet, tc.url, nil))
+				if w.Code != tc.code {
+					t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+				}
+				// Access must be denied before storage is
+				// accessed.
+				if tc.code == http.StatusForbidden && ts.contentAddressableStorage.getCalls != 0 {
+					t.Errorf("Expected storage not to be accessed, got %d calls", ts.contentAddressableStorage.getCalls)
+				}
+			})
+		}
+	})
+
+	t.Run("Download", func(t *testing.T) {
+		// Browsing is permitted, while downloading objects
+		// belonging to instance name "secret" is not.
+		ts := newTestBrowserService(BrowserServiceOptions{
+			DownloadAuthorizer: denySecretAuthorizer,
+		})
+		for name, tc := range map[string]struct {
+			url  string
+			code int
+		}{
+			"SecretCommand": {url: putBytesWithInstanceName(ts, "secret", "command", commandData, ""), code: http.StatusOK},
+			"SecretFile":    {url: putBytesWithInstanceName(ts, "secret", "file", []byte("Hello"), "hello.txt"), code: http.StatusForbidden},
+			"SecretTarball": {url: putBytesWithInstanceName(ts, "secret", "directory", nil, "?format=tar"), code: http.StatusForbidden},
+			"PublicFile":    {url: putBytesWithInstanceName(ts, "public", "file", []byte("Hello"), "hello.txt"), code: http.StatusOK},
+		} {
+			t.Run(name, func(t *testing.T) {
+				if w := ts.serve(httptest.NewRequest(http.MethodGet, tc.url, nil)); w.Code != tc.code {
+					t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+				}
+			})
+		}
+	})
+
+	t.Run("ListTarballGenerations", func(t *testing.T) {
+		// Only generations belonging to instance names for
+		// which listing is authorized are returned. Listing
+		// is denied by default.
+		for name, tc := range map[string]struct {
+			authorizer    auth.Authorizer
+			instanceNames []string
+		}{
+			"Default":    {},
+			"DenySecret": {authorizer: denySecretAuthorizer, instanceNames: []string{"public"}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts := newTestBrowserService(BrowserServiceOptions{
+					ListTarballGenerationsAuthorizer: tc.authorizer,
+				})
+				for _, instanceName := range []string{"public", "secret"} {
+					id := ts.registerTarballGeneration(digest.MustNewInstanceName(instanceName), "digest", "client", func() {})
+					defer ts.unregisterTarballGeneration(id)
+				}
+
+				w := ts.serve(httptest.NewRequest(http.MethodGet, "/admin/tarballs/", nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+				}
+				var generations []activeTarballGeneration
+				if err := json.Unmarshal(w.Body.Bytes(), &generations); err != nil {
+					t.Fatal(err)
+				}
+				var instanceNames []string
+				for _, generation := range generations {
+					instanceNames = append(instanceNames, generation.InstanceName)
+				}
+				if !reflect.DeepEqual(instanceNames, tc.instanceNames) {
+					t.Errorf("Expected generations of instance names %#v, got %#v", tc.instanceNames, instanceNames)
+				}
+			})
+		}
+	})
+
+	t.Run("CancelTarballGeneration", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			authorizer   auth.Authorizer
+			instanceName string
+			unknown      bool
+			code         int
+		}{
+			"Public":        {authorizer: denySecretAuthorizer, instanceName: "public", code: http.StatusNoContent},
+			"Secret":        {authorizer: denySecretAuthorizer, instanceName: "secret", code: http.StatusForbidden},
+			"Unknown":       {authorizer: denySecretAuthorizer, unknown: true, code: http.StatusNotFound},
+			"DeniedSecret":  {instanceName: "secret", code: http.StatusForbidden},
+			"DeniedUnknown": {unknown: true, code: http.StatusForbidden},
+		} {
+			t.Run(name, func(t *testing.T) {
+				// Unauthorized clients can't distinguish
+				// unknown generations from ones belonging
+				// to other instance names.
+				ts := newTestBrowserService(BrowserServiceOptions{
+					CancelTarballGenerationAuthorizer: tc.authorizer,
+				})
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				id := ts.registerTarballGeneration(digest.MustNewInstanceName(tc.instanceName), "digest", "client", cancel)
+				defer ts.unregisterTarballGeneration(id)
+				if tc.unknown {
+					id++
+				}
+
+				w := ts.serve(httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/tarballs/%d/cancel", id), nil))
+				if w.Code != tc.code {
+					t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+				}
+				if canceled := ctx.Err() != nil; canceled != (tc.code == http.StatusNoContent) {
+					t.Errorf("Expected tarball generation to be canceled: %v, got %v", tc.code == http.StatusNoContent, canceled)
+				}
+			})
+		}
+	})
+}
+
+func TestGetInstanceNameFromRequest(t *testing.T) {
+	// URLs that don't contain an instance name refer to the empty
+	// instance name. Neither the default instance name, nor the one
+	// stored in a cookie may be used instead.
+	ts := newTestBrowserService(BrowserServiceOptions{
+		DefaultInstanceName:      digest.MustNewInstanceName("default"),
+		RememberLastInstanceName: true,
+	})
+	emptyCommand := &remoteexecution.Command{Arguments: []string{"empty"}}
+	emptyData, err := proto.Marshal(emptyCommand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyDigest := ts.contentAddressableStorage.putBytesWithInstanceName("", emptyData)
+	defaultCommand := &remoteexecution.Command{Arguments: []string{"default"}}
+	defaultData, err := proto.Marshal(defaultCommand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultDigest := ts.contentAddressableStorage.putBytesWithInstanceName("default", defaultData)
+
+	for name, tc := range map[string]struct {
+		url     string
+		cookie  string
+		code    int
+		command *remoteexecution.Command
+	}{
+		"Empty":               {url: getURL("command", emptyDigest, ""), code: http.StatusOK, command: emptyCommand},
+		"EmptyWithCookie":     {url: getURL("command", emptyDigest, ""), cookie: "default", code: http.StatusOK, command: emptyCommand},
+		"EmptyNotDefault":     {url: getURL("command", defaultDigest, ""), code: http.StatusNotFound},
+		"EmptyNotCookie":      {url: getURL("command", defaultDigest, ""), cookie: "default", code: http.StatusNotFound},
+		"Explicit":            {url: "/default" + getURL("command", defaultDigest, ""), code: http.StatusOK, command: defaultCommand},
+		"ExplicitWithCookie":  {url: "/default" + getURL("command", defaultDigest, ""), cookie: "other", code: http.StatusOK, command: defaultCommand},
+		"ExplicitNotEmpty":    {url: "/default" + getURL("command", emptyDigest, ""), code: http.StatusNotFound},
+		"InvalidInstanceName": {url: "/operations" + getURL("command", emptyDigest, ""), code: http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: instanceNameCookie, Value: tc.cookie})
+			}
+			var got remoteexecution.Command
+			if code := ts.serveProtoJSONRequest(t, req, &got); code != tc.code {
+				t.Fatalf("Expected status %d, got %d", tc.code, code)
+			}
+			if tc.command != nil && !proto.Equal(&got, tc.command) {
+				t.Errorf("Expected command %v, got %v", tc.command, &got)
+			}
+		})
+	}
+}
+
+func TestHandleWelcomeInstanceName(t *testing.T) {
+	for name, tc := range map[string]struct {
+		options  BrowserServiceOptions
+		cookie   string
+		expected string
+	}{
+		"Placeholder":          {expected: "${instance_name}/"},
+		"Default":              {options: BrowserServiceOptions{DefaultInstanceName: digest.MustNewInstanceName("default")}, expected: "default/"},
+		"CookieIgnored":        {options: BrowserServiceOptions{DefaultInstanceName: digest.MustNewInstanceName("default")}, cookie: "last", expected: "default/"},
+		"Cookie":               {options: BrowserServiceOptions{DefaultInstanceName: digest.MustNewInstanceName("default"), RememberLastInstanceName: true}, cookie: "last", expected: "last/"},
+		"CookieInvalid":        {options: BrowserServiceOptions{DefaultInstanceName: digest.MustNewInstanceName("default"), RememberLastInstanceName: true}, cookie: "a/../b", expected: "default/"},
+		"CookieWithoutDefault": {options: BrowserServiceOptions{RememberLastInstanceName: true}, cookie: "last", expected: "last/"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestBrowserService(tc.options)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: instanceNameCookie, Value: tc.cookie})
+			}
+			if w := ts.serve(req); w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if ts.templates.name != "page_welcome.html" {
+				t.Fatalf("Expected template \"page_welcome.html\", got %#v", ts.templates.name)
+			}
+			if got := reflect.ValueOf(ts.templates.data).FieldByName("InstanceNamePrefix").String(); got != tc.expected {
+				t.Errorf("Expected instance name prefix %#v, got %#v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestHandleActionPreloadLinks(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}}).GetProto(),
+		InputRootDigest: inputRootDigest.GetProto(),
+	})
+	stdoutDigest := cas.putBytes([]byte("Hello\n"))
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "0000000000000000000000000000000000000000000000000000000000000000", 5)
+	inputRootLink := fmt.Sprintf("<../../directory/%s-%d/>; rel=preload; as=fetch", inputRootDigest.GetHashString(), inputRootDigest.GetSizeBytes())
+
+	for name, tc := range map[string]struct {
+		actionDigest digest.Digest
+		actionResult *remoteexecution.ActionResult
+		code         int
+		links        []string
+	}{
+		"NoActionResult": {
+			actionDigest: actionDigest,
+			code:         http.StatusOK,
+			links:        []string{inputRootLink},
+		},
+		"Stdout": {
+			actionDigest: actionDigest,
+			actionResult: &remoteexecution.ActionResult{StdoutDigest: stdoutDigest.GetProto()},
+			code:         http.StatusOK,
+			links: []string{
+				fmt.Sprintf("<../../file/%s-%d/log.txt>; rel=preload; as=fetch", stdoutDigest.GetHashString(), stdoutDigest.GetSizeBytes()),
+				inputRootLink,
+			},
+		},
+		"StdoutMissing": {
+			actionDigest: actionDigest,
+			actionResult: &remoteexecution.ActionResult{StdoutDigest: missingDigest.GetProto()},
+			code:         http.StatusOK,
+			links:        []string{inputRootLink},
+		},
+		"ActionMissing": {
+			actionDigest: missingDigest,
+			code:         http.StatusNotFound,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			delete(ts.actionCache.blobs, tc.actionDigest)
+			if tc.actionResult != nil {
+				data, err := proto.Marshal(tc.actionResult)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ts.actionCache.blobs[tc.actionDigest] = data
+			}
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", tc.actionDigest, ""), nil))
+			if w.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+			}
+			if links := w.Header().Values("Link"); !reflect.DeepEqual(links, tc.links) {
+				t.Errorf("Expected links %#v, got %#v", tc.links, links)
+			}
+			if tc.code == http.StatusOK && ts.templates.name != "page_action.html" {
+				t.Errorf("Expected template \"page_action.html\", got %#v", ts.templates.name)
+			}
+		})
+	}
+}
+
+func TestShouldCompressTarball(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	directoryDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+		},
+	})
+
+	for name, tc := range map[string]struct {
+		query          string
+		acceptEncoding string
+		compress       bool
+	}{
+		"NoAcceptEncoding":       {compress: true},
+		"Gzip":                   {acceptEncoding: "gzip", compress: true},
+		"Brotli":                 {acceptEncoding: "br", compress: true},
+		"Deflate":                {acceptEncoding: "deflate", compress: true},
+		"Wildcard":               {acceptEncoding: "*", compress: true},
+		"Identity":               {acceptEncoding: "identity", compress: false},
+		"IdentityUppercase":      {acceptEncoding: "IDENTITY", compress: false},
+		"IdentityOthersExcluded": {acceptEncoding: "identity, gzip;q=0", compress: false},
+		"IdentityExcluded":       {acceptEncoding: "identity;q=0", compress: true},
+		"IdentityAndDeflate":     {acceptEncoding: "identity, deflate", compress: true},
+		"CompressionNone":        {query: "&compression=none", acceptEncoding: "gzip", compress: false},
+		"CompressionNoneOnly":    {query: "&compression=none", compress: false},
+		"CompressionOther":       {query: "&compression=foo", compress: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, getURL("directory", directoryDigest, "?format=tar"+tc.query), nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			if compress := shouldCompressTarball(req); compress != tc.compress {
+				t.Fatalf("Expected compression %v, got %v", tc.compress, compress)
+			}
+
+			w := ts.serve(req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			body := w.Body.Bytes()
+			contentType, filename := "application/x-tar", fmt.Sprintf("%s.tar", directoryDigest.GetHashString())
+			if tc.compress {
+				contentType, filename = "application/gzip", filename+".gz"
+				gzipReader, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(gzipReader); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := w.Header().Get("Content-Type"); got != contentType {
+				t.Errorf("Expected content type %#v, got %#v", contentType, got)
+			}
+			if got, expected := w.Header().Get("Content-Disposition"), fmt.Sprintf("attachment; filename=\"%s\"", filename); got != expected {
+				t.Errorf("Expected content disposition %#v, got %#v", expected, got)
+			}
+			header, err := tar.NewReader(bytes.NewReader(body)).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Name != "./" {
+				t.Errorf("Expected first entry \"./\", got %#v", header.Name)
+			}
+		})
+	}
+}
+
+func TestHandleActionSectionErrors(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	childDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+		},
+	})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "child", Digest: childDigest.GetProto()},
+		},
+	})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   commandDigest.GetProto(),
+		InputRootDigest: inputRootDigest.GetProto(),
+	})
+
+	for name, tc := range map[string]struct {
+		query          string
+		errors         map[digest.Digest]error
+		command        bool
+		commandError   codes.Code
+		inputRoot      bool
+		expanded       bool
+		inputRootError codes.Code
+	}{
+		"Success":          {command: true, inputRoot: true},
+		"Expanded":         {query: "?expand_inputs=1", command: true, inputRoot: true, expanded: true},
+		"CommandNotFound":  {errors: map[digest.Digest]error{commandDigest: status.Error(codes.NotFound, "Object not found")}, inputRoot: true},
+		"CommandInternal":  {errors: map[digest.Digest]error{commandDigest: status.Error(codes.Internal, "Disk on fire")}, commandError: codes.Internal, inputRoot: true},
+		"InputRootEvicted": {errors: map[digest.Digest]error{inputRootDigest: status.Error(codes.NotFound, "Object not found")}, command: true},
+		"InputRootUnavailable": {
+			errors:         map[digest.Digest]error{inputRootDigest: status.Error(codes.Unavailable, "Server is shutting down")},
+			command:        true,
+			inputRootError: codes.Unavailable,
+		},
+		"ExpansionFailure": {
+			query:          "?expand_inputs=1",
+			errors:         map[digest.Digest]error{childDigest: status.Error(codes.Internal, "Disk on fire")},
+			command:        true,
+			inputRoot:      true,
+			inputRootError: codes.Internal,
+		},
+		"BothFailing": {
+			errors: map[digest.Digest]error{
+				commandDigest:   status.Error(codes.PermissionDenied, "Not allowed"),
+				inputRootDigest: status.Error(codes.DeadlineExceeded, "Took too long"),
+			},
+			commandError:   codes.PermissionDenied,
+			inputRootError: codes.DeadlineExceeded,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cas.errors = tc.errors
+			defer func() { cas.errors = map[digest.Digest]error{} }()
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, tc.query), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected template \"page_action.html\", got %#v", ts.templates.name)
+			}
+			data := reflect.ValueOf(ts.templates.data)
+			if command := !data.FieldByName("Command").IsNil(); command != tc.command {
+				t.Errorf("Expected command to be present: %v, got %v", tc.command, command)
+			}
+			if inputRoot := !data.FieldByName("InputRoot").IsNil(); inputRoot != tc.inputRoot {
+				t.Errorf("Expected input root to be present: %v, got %v", tc.inputRoot, inputRoot)
+			}
+			if expanded := !data.FieldByName("ExpandedInputRoot").IsNil(); expanded != tc.expanded {
+				t.Errorf("Expected expanded input root to be present: %v, got %v", tc.expanded, expanded)
+			}
+			if code := data.FieldByName("CommandError").Interface().(*status.Status).Code(); code != tc.commandError {
+				t.Errorf("Expected command error code %s, got %s", tc.commandError, code)
+			}
+			if code := data.FieldByName("InputRootError").Interface().(*status.Status).Code(); code != tc.inputRootError {
+				t.Errorf("Expected input root error code %s, got %s", tc.inputRootError, code)
+			}
+		})
+	}
+
+	// Requests for JSON are unaffected, as they only return the
+	// action result.
+	cas.errors = map[digest.Digest]error{commandDigest: status.Error(codes.Internal, "Disk on fire")}
+	var got remoteexecution.ActionResult
+	if code := ts.serveProtoJSON(t, getURL("action", actionDigest, ""), &got); code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestHandleTreeParentDirectory(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	leaf := &remoteexecution.Directory{}
+	leafDigest := cas.putMessage(t, leaf)
+	middle := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "e#f", Digest: leafDigest.GetProto()},
+		},
+	}
+	middleDigest := cas.putMessage(t, middle)
+	top := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "c?d", Digest: middleDigest.GetProto()},
+		},
+	}
+	topDigest := cas.putMessage(t, top)
+	treeDigest := cas.putMessage(t, &remoteexecution.Tree{
+		Root: &remoteexecution.Directory{
+			Directories: []*remoteexecution.DirectoryNode{
+				{Name: "a b", Digest: topDigest.GetProto()},
+			},
+		},
+		Children: []*remoteexecution.Directory{top, middle, leaf},
+	})
+
+	for name, tc := range map[string]struct {
+		subdirectory       string
+		code               int
+		hasParentDirectory bool
+		parentDirectory    string
+	}{
+		"Root":     {subdirectory: "", code: http.StatusOK},
+		"OneLevel": {subdirectory: "a%20b/", code: http.StatusOK, hasParentDirectory: true, parentDirectory: "../"},
+		"TwoLevels": {
+			subdirectory:       "a%20b/c%3Fd/",
+			code:               http.StatusOK,
+			hasParentDirectory: true,
+			parentDirectory:    "../../a%20b/",
+		},
+		"ThreeLevels": {
+			subdirectory:       "a%20b/c%3Fd/e%23f/",
+			code:               http.StatusOK,
+			hasParentDirectory: true,
+			parentDirectory:    "../../../a%20b/c%3Fd/",
+		},
+		"NotFound": {subdirectory: "a%20b/nonexistent/", code: http.StatusNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("tree", treeDigest, tc.subdirectory), nil))
+			if w.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			if ts.templates.name != "page_tree.html" {
+				t.Fatalf("Expected template \"page_tree.html\", got %#v", ts.templates.name)
+			}
+			data := reflect.ValueOf(ts.templates.data).Elem()
+			if hasParentDirectory := data.FieldByName("HasParentDirectory").Bool(); hasParentDirectory != tc.hasParentDirectory {
+				t.Errorf("Expected parent directory to be present: %v, got %v", tc.hasParentDirectory, hasParentDirectory)
+			}
+			if parentDirectory := data.FieldByName("ParentDirectory").String(); parentDirectory != tc.parentDirectory {
+				t.Errorf("Expected parent directory %#v, got %#v", tc.parentDirectory, parentDirectory)
+			}
+		})
+	}
+}
+
+func TestResolveOutputPath(t *testing.T) {
+	for name, tc := range map[string]struct {
+		workingDirectory string
+		outputPath       string
+		resolved         string
+	}{
+		"NoWorkingDirectory":         {outputPath: "a/b", resolved: "a/b"},
+		"WorkingDirectory":           {workingDirectory: "src", outputPath: "a/b", resolved: "src/a/b"},
+		"DotDot":                     {workingDirectory: "src", outputPath: "a/../b"},
+		"LeadingDotDot":              {workingDirectory: "src", outputPath: "../b"},
+		"Dot":                        {outputPath: "./b"},
+		"Absolute":                   {outputPath: "/b"},
+		"TrailingSlash":              {outputPath: "b/"},
+		"RedundantSlash":             {outputPath: "a//b"},
+		"Empty":                      {workingDirectory: "src"},
+		"WorkingDirectoryDotDot":     {workingDirectory: "src/..", outputPath: "b"},
+		"WorkingDirectoryTrailing":   {workingDirectory: "src/", outputPath: "b"},
+		"WorkingDirectoryIsAbsolute": {workingDirectory: "/src", outputPath: "b"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if resolved := getOutputPathInInputRoot(tc.workingDirectory, tc.outputPath); resolved != tc.resolved {
+				t.Errorf("Expected %#v, got %#v", tc.resolved, resolved)
+			}
+		})
+	}
+}
+
+func TestHandleOutputsTarballPaths(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	fileDigest := cas.putBytes([]byte("Hello"))
+
+	for name, tc := range map[string]struct {
+		outputPath string
+		code       int
+		entry      string
+	}{
+		"Valid":  {outputPath: "a/b", code: http.StatusOK, entry: "src/a/b"},
+		"DotDot": {outputPath: "a/../b", code: http.StatusBadRequest},
+		"Dot":    {outputPath: "./b", code: http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			commandDigest := cas.putMessage(t, &remoteexecution.Command{
+				Arguments:        []string{"true"},
+				WorkingDirectory: "src",
+			})
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest:   commandDigest.GetProto(),
+				InputRootDigest: cas.putMessage(t, &remoteexecution.Directory{}).GetProto(),
+			})
+			actionResult, err := proto.Marshal(&remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: tc.outputPath, Digest: fileDigest.GetProto()},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts.actionCache.blobs[actionDigest] = actionResult
+
+			req := httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, "?format=tar&compression=none"), nil)
+			req.Header.Set("Accept", "application/json")
+			w := ts.serve(req)
+			if w.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d: %s", tc.code, w.Code, w.Body.String())
+			}
+			if tc.code != http.StatusOK {
+				var response jsonError
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				if expected := fmt.Sprintf("Output %#v has an invalid path", tc.outputPath); response.Code != "InvalidArgument" || response.Message != expected {
+					t.Errorf("Expected InvalidArgument error %#v, got %#v", expected, response)
+				}
+				return
+			}
+			var names []string
+			tarReader := tar.NewReader(w.Body)
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, header.Name)
+			}
+			if len(names) == 0 || names[len(names)-1] != tc.entry {
+				t.Errorf("Expected last entry %#v, got %#v", tc.entry, names)
+			}
+		})
+	}
+}
+
+func TestRoutePrefixAndBasePath(t *testing.T) {
+	for name, tc := range map[string]struct {
+		routePrefix     string
+		basePath        string
+		forwardedPrefix string
+		expectedPrefix  string
+	}{
+		"Root":                      {expectedPrefix: "/"},
+		"RoutePrefix":               {routePrefix: "/browser/", expectedPrefix: "/browser/"},
+		"BasePath":                  {basePath: "/proxy", expectedPrefix: "/proxy/"},
+		"BasePathAndRoutePrefix":    {routePrefix: "/browser/", basePath: "/proxy", expectedPrefix: "/proxy/browser/"},
+		"ForwardedPrefix":           {routePrefix: "/browser/", forwardedPrefix: "/forwarded/", expectedPrefix: "/forwarded/browser/"},
+		"ForwardedPrefixPrecedence": {routePrefix: "/browser/", basePath: "/proxy", forwardedPrefix: "forwarded", expectedPrefix: "/forwarded/browser/"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestBrowserService(BrowserServiceOptions{
+				RoutePrefix:              tc.routePrefix,
+				BasePath:                 tc.basePath,
+				RememberLastInstanceName: true,
+			})
+			cas := ts.contentAddressableStorage
+			commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+			routePrefix := tc.routePrefix
+			if routePrefix == "" {
+				routePrefix = "/"
+			}
+			newRequest := func(url, accept string) *http.Request {
+				req := httptest.NewRequest(http.MethodGet, url, nil)
+				if accept != "" {
+					req.Header.Set("Accept", accept)
+				}
+				if tc.forwardedPrefix != "" {
+					req.Header.Set("X-Forwarded-Prefix", tc.forwardedPrefix)
+				}
+				return req
+			}
+
+			t.Run("Welcome", func(t *testing.T) {
+				w := ts.serve(newRequest(routePrefix, ""))
+				if w.Code != http.StatusOK || ts.templates.name != "page_welcome.html" {
+					t.Fatalf("Expected page_welcome.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+				}
+				if got := reflect.ValueOf(ts.templates.data).FieldByName("RoutePrefix").String(); got != tc.expectedPrefix {
+					t.Errorf("Expected route prefix %#v, got %#v", tc.expectedPrefix, got)
+				}
+			})
+
+			t.Run("ErrorPage", func(t *testing.T) {
+				// The error page links back to the welcome page.
+				w := ts.serve(newRequest(routePrefix+"nonexistent", ""))
+				if w.Code != http.StatusNotFound || ts.templates.name != "page_error.html" {
+					t.Fatalf("Expected page_error.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+				}
+				if got := reflect.ValueOf(ts.templates.data).FieldByName("RoutePrefix").String(); got != tc.expectedPrefix {
+					t.Errorf("Expected route prefix %#v, got %#v", tc.expectedPrefix, got)
+				}
+			})
+
+			t.Run("Permalink", func(t *testing.T) {
+				w := ts.serve(newRequest(routePrefix+"permalink/hello"+getURL("command", commandDigest, ""), "application/json"))
+				if w.Code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+				}
+				var response struct {
+					URL string `json:"url"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				if expected := "http://example.com" + tc.expectedPrefix + "hello" + getURL("command", commandDigest, ""); response.URL != expected {
+					t.Errorf("Expected permalink %#v, got %#v", expected, response.URL)
+				}
+			})
+
+			t.Run("Cookie", func(t *testing.T) {
+				data, err := proto.Marshal(&remoteexecution.Command{Arguments: []string{"true"}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				helloCommandDigest := cas.putBytesWithInstanceName("hello", data)
+				w := ts.serve(newRequest(routePrefix+"hello"+getURL("command", helloCommandDigest, ""), ""))
+				if w.Code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+				}
+				cookies := w.Result().Cookies()
+				if len(cookies) != 1 || cookies[0].Path != tc.expectedPrefix {
+					t.Errorf("Expected a single cookie with path %#v, got %#v", tc.expectedPrefix, cookies)
+				}
+			})
+
+			t.Run("Redirect", func(t *testing.T) {
+				// Redirects are relative, so that they work
+				// regardless of the prefix.
+				url := routePrefix + strings.TrimSuffix(getURL("command", commandDigest, "")[1:], "/") + "?format=json"
+				w := ts.serve(newRequest(url, ""))
+				if w.Code != http.StatusMovedPermanently {
+					t.Fatalf("Expected status %d, got %d", http.StatusMovedPermanently, w.Code)
+				}
+				if expected := fmt.Sprintf("%s-%d/?format=json", commandDigest.GetHashString(), commandDigest.GetSizeBytes()); w.Header().Get("Location") != expected {
+					t.Errorf("Expected location %#v, got %#v", expected, w.Header().Get("Location"))
+				}
+			})
+
+			if routePrefix != "/" {
+				t.Run("OutsideRoutePrefix", func(t *testing.T) {
+					if w := ts.serve(newRequest(getURL("command", commandDigest, ""), "")); w.Code != http.StatusNotFound {
+						t.Fatalf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestGetDirectoryStatsCache(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	marshal := func(m proto.Message) []byte {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	// Store the same directory under two instance names, while
+	// only storing its child directory under the first one.
+	fileDigest := cas.putBytesWithInstanceName("a", []byte("Hello"))
+	cas.putBytesWithInstanceName("b", []byte("Hello"))
+	child := marshal(&remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: fileDigest.GetProto()},
+		},
+	})
+	childDigest := cas.putBytesWithInstanceName("a", child)
+	parent := marshal(&remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "child", Digest: childDigest.GetProto()},
+		},
+	})
+	parentDigestA := cas.putBytesWithInstanceName("a", parent)
+	parentDigestB := cas.putBytesWithInstanceName("b", parent)
+
+	for _, tc := range []struct {
+		name            string
+		instanceName    string
+		directoryDigest digest.Digest
+		code            int
+		stats           *treeStats
+	}{
+		{name: "Complete", instanceName: "a", directoryDigest: parentDigestA, code: http.StatusOK, stats: &treeStats{DirectoriesCount: 1, FilesCount: 1, FilesSizeBytes: 5}},
+		{name: "Cached", instanceName: "a", directoryDigest: parentDigestA, code: http.StatusOK, stats: &treeStats{DirectoriesCount: 1, FilesCount: 1, FilesSizeBytes: 5}},
+		{name: "OtherInstanceName", instanceName: "b", directoryDigest: parentDigestB, code: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts.templates.data = nil
+			w := ts.serve(httptest.NewRequest(http.MethodGet, "/"+tc.instanceName+getURL("directory", tc.directoryDigest, "?recursive_size=1"), nil))
+			if w.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+			}
+			if tc.stats != nil {
+				if stats := reflect.ValueOf(ts.templates.data).Elem().FieldByName("RecursiveStats").Interface().(*treeStats); !reflect.DeepEqual(stats, tc.stats) {
+					t.Errorf("Expected statistics %#v, got %#v", tc.stats, stats)
+				}
+			}
+		})
+	}
+
+	t.Run("LeastRecentlyUsedEviction", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{})
+		cas := ts.contentAddressableStorage
+		directory := &remoteexecution.Directory{}
+		directoryDigest := cas.putMessage(t, directory)
+		otherDirectory := &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{Name: "hello.txt", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+			},
+		}
+		otherDirectoryDigest := cas.putMessage(t, otherDirectory)
+		ctx := context.Background()
+		if _, err := ts.getDirectoryStats(ctx, directoryDigest, directory); err != nil {
+			t.Fatal(err)
+		}
+
+		// Fill up the cache. Accessing the directory again should
+		// cause the oldest entry to be evicted instead.
+		for i := 1; i < maximumDirectoryStatsCacheSize; i++ {
+			key := fmt.Sprintf("filler-%d", i)
+			ts.directoryStatsCache[key] = &treeStats{}
+			ts.directoryStatsCacheEvictionSet.Insert(key)
+		}
+		if _, err := ts.getDirectoryStats(ctx, directoryDigest, directory); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ts.getDirectoryStats(ctx, otherDirectoryDigest, otherDirectory); err != nil {
+			t.Fatal(err)
+		}
+		if len(ts.directoryStatsCache) != maximumDirectoryStatsCacheSize {
+			t.Errorf("Expected %d cached entries, got %d", maximumDirectoryStatsCacheSize, len(ts.directoryStatsCache))
+		}
+		for key, expected := range map[string]bool{
+			directoryDigest.GetKey(digest.KeyWithInstance):      true,
+			otherDirectoryDigest.GetKey(digest.KeyWithInstance): true,
+			"filler-1": false,
+			"filler-2": true,
+		} {
+			if _, ok := ts.directoryStatsCache[key]; ok != expected {
+				t.Errorf("Expected %#v to be cached: %v, got %v", key, expected, ok)
+			}
+		}
+	})
+}
+
+func TestLoadLogInfo(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	textDigest := cas.putBytes([]byte("Hello\n"))
+	binaryDigest := cas.putBytes([]byte{0xff, 0xfe, 0x00})
+	tooLargeDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", maximumLogSizeBytes+1)
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	failingDigest := cas.putBytes([]byte("Failing"))
+	cas.errors[failingDigest] = status.Error(codes.Internal, "Disk on fire")
+
+	for name, tc := range map[string]struct {
+		digest      digest.Digest
+		requireText bool
+		expected    *logInfo
+		code        codes.Code
+	}{
+		"Empty":           {digest: testDigestFunction.NewGenerator(0).Sum()},
+		"TooLarge":        {digest: tooLargeDigest, expected: &logInfo{Name: "log", Digest: tooLargeDigest, TooLarge: true}},
+		"NotFound":        {digest: missingDigest, expected: &logInfo{Name: "log", Digest: missingDigest, NotFound: true}},
+		"StorageFailure":  {digest: failingDigest, code: codes.Internal},
+		"Text":            {digest: textDigest, requireText: true, expected: &logInfo{Name: "log", Digest: textDigest, LineCount: 1, HTML: "Hello\n"}},
+		"BinaryAsLog":     {digest: binaryDigest, expected: &logInfo{Name: "log", Digest: binaryDigest, LineCount: 1, HTML: "\xff\xfe\x00"}},
+		"BinaryAsPreview": {digest: binaryDigest, requireText: true, expected: &logInfo{Name: "log", Digest: binaryDigest, NotHumanReadable: true}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			info, err := ts.loadLogInfo(context.Background(), "log", tc.digest, tc.requireText)
+			if status.Code(err) != tc.code {
+				t.Fatalf("Expected error code %s, got %v", tc.code, err)
+			}
+			if !reflect.DeepEqual(info, tc.expected) {
+				t.Errorf("Expected %#v, got %#v", tc.expected, info)
+			}
+		})
+	}
+}
+
+func TestHandleLogBundle(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	stderrDigest := cas.putBytes([]byte("\x1b[31mFailure\x1b[0m"))
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	tooLargeDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", maximumLogSizeBytes+1)
+	failingDigest := cas.putBytes([]byte("Failing"))
+	cas.errors[failingDigest] = status.Error(codes.Internal, "Disk on fire")
+
+	for name, tc := range map[string]struct {
+		actionResult *remoteexecution.ActionResult
+		query        string
+		code         int
+		expected     string
+	}{
+		"Combined": {
+			actionResult: &remoteexecution.ActionResult{StdoutRaw: []byte("\x1b[1mHello\x1b[0m\n"), StderrDigest: stderrDigest.GetProto()},
+			code:         http.StatusOK,
+			expected:     "==== Standard output ====\n\x1b[1mHello\x1b[0m\n\n==== Standard error ====\n\x1b[31mFailure\x1b[0m\n\n",
+		},
+		"Plain": {
+			actionResult: &remoteexecution.ActionResult{StdoutRaw: []byte("\x1b[1mHello\x1b[0m\n"), StderrDigest: stderrDigest.GetProto()},
+			query:        "&plain=1",
+			code:         http.StatusOK,
+			expected:     "==== Standard output ====\nHello\n\n==== Standard error ====\nFailure\n\n",
+		},
+		"Empty": {
+			actionResult: &remoteexecution.ActionResult{},
+			code:         http.StatusOK,
+			expected:     "==== Standard output ====\n\n==== Standard error ====\n\n",
+		},
+		"Missing": {
+			actionResult: &remoteexecution.ActionResult{StdoutDigest: missingDigest.GetProto()},
+			code:         http.StatusOK,
+			expected:     fmt.Sprintf("==== Standard output ====\nLog file %s could not be found\n\n==== Standard error ====\n\n", missingDigest),
+		},
+		"TooLarge": {
+			actionResult: &remoteexecution.ActionResult{StderrDigest: tooLargeDigest.GetProto()},
+			code:         http.StatusOK,
+			expected:     fmt.Sprintf("==== Standard output ====\n\n==== Standard error ====\nLog file %s is too large to be included (%d bytes)\n\n", tooLargeDigest, maximumLogSizeBytes+1),
+		},
+		"StorageFailure": {
+			actionResult: &remoteexecution.ActionResult{StdoutDigest: failingDigest.GetProto()},
+			code:         http.StatusInternalServerError,
+			expected:     "Disk on fire",
+		},
+		"NoActionResult": {
+			code:     http.StatusNotFound,
+			expected: "Could not find an action result",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest: cas.putMessage(t, &remoteexecution.Command{Arguments: []string{name}}).GetProto(),
+			})
+			if tc.actionResult != nil {
+				ts.putActionResult(t, actionDigest, tc.actionResult)
+			}
+			req := httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, "?format=logs"+tc.query), nil)
+			if tc.code != http.StatusOK {
+				code, response := ts.serveJSONError(t, req)
+				if code != tc.code || response.Message != tc.expected {
+					t.Fatalf("Expected status %d and message %#v, got status %d and message %#v", tc.code, tc.expected, code, response.Message)
+				}
+				return
+			}
+
+			w := ts.serve(req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if body := w.Body.String(); body != tc.expected {
+				t.Errorf("Expected body %#v, got %#v", tc.expected, body)
+			}
+			for header, expected := range map[string]string{
+				"Content-Disposition": fmt.Sprintf("attachment; filename=\"%s-logs.txt\"", actionDigest.GetHashString()),
+				"Content-Length":      strconv.Itoa(len(tc.expected)),
+				"Content-Type":        "text/plain; charset=utf-8",
+			} {
+				if got := w.Header().Get(header); got != expected {
+					t.Errorf("Expected header %s to be %#v, got %#v", header, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestShowRawMessages(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"<script>alert(1)</script>"}})
+	directoryDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "<script>alert(1)</script>", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+		},
+	})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   commandDigest.GetProto(),
+		InputRootDigest: directoryDigest.GetProto(),
+		Platform: &remoteexecution.Platform{
+			Properties: []*remoteexecution.Platform_Property{
+				{Name: "OSFamily", Value: "<script>alert(1)</script>"},
+			},
+		},
+	})
+	ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{ExitCode: 1})
+
+	// The output of prototext is deliberately unstable with respect
+	// to whitespace.
+	for name, tc := range map[string]struct {
+		url      string
+		template string
+		heading  string
+		escaped  *regexp.Regexp
+	}{
+		"Action": {
+			url:      getURL("action", actionDigest, ""),
+			template: "page_action.html",
+			heading:  `<h2 class="my-4">Raw messages</h2>`,
+			escaped:  regexp.MustCompile(`value:\s+&#34;&lt;script&gt;alert\(1\)&lt;/script&gt;&#34;`),
+		},
+		"Command": {
+			url:      getURL("command", commandDigest, ""),
+			template: "page_command.html",
+			heading:  `<h2 class="my-4">Raw message</h2>`,
+			escaped:  regexp.MustCompile(`arguments:\s+&#34;&lt;script&gt;alert\(1\)&lt;/script&gt;&#34;`),
+		},
+		"Directory": {
+			url:      getURL("directory", directoryDigest, ""),
+			template: "page_directory.html",
+			heading:  `<h2 class="my-4">Raw message</h2>`,
+			escaped:  regexp.MustCompile(`name:\s+&#34;&lt;script&gt;alert\(1\)&lt;/script&gt;&#34;`),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for query, shown := range map[string]bool{
+				"":       false,
+				"?raw=0": false,
+				"?raw=1": true,
+			} {
+				w := ts.serve(httptest.NewRequest(http.MethodGet, tc.url+query, nil))
+				if w.Code != http.StatusOK || ts.templates.name != tc.template {
+					t.Fatalf("Expected %s to be rendered for %#v, got status %d and template %#v", tc.template, query, w.Code, ts.templates.name)
+				}
+				body := w.Body.String()
+				if strings.Contains(body, tc.heading) != shown || tc.escaped.MatchString(body) != shown {
+					t.Errorf("Expected raw message to be shown for %#v: %v", query, shown)
+				}
+				if strings.Contains(body, "<script>alert(1)</script>") {
+					t.Errorf("Page for %#v contains unescaped data", query)
+				}
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 5)
+		code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("command", missingDigest, "?raw=1"), nil))
+		if code != http.StatusNotFound || response.Code != "NotFound" {
+			t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+		}
+	})
+}
+
+func TestHandleActionOutputDirectoryStats(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	subdirectory := &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "c.txt", Digest: cas.putBytes([]byte("!")).GetProto()},
+		},
+	}
+	subdirectoryDigest := cas.putMessage(t, subdirectory)
+	treeDigest := cas.putMessage(t, &remoteexecution.Tree{
+		Root: &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{Name: "a.txt", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+				{Name: "b.txt", Digest: cas.putBytes([]byte("World")).GetProto()},
+			},
+			Directories: []*remoteexecution.DirectoryNode{
+				{Name: "sub", Digest: subdirectoryDigest.GetProto()},
+			},
+		},
+		Children: []*remoteexecution.Directory{subdirectory},
+	})
+	incompleteTreeDigest := cas.putMessage(t, &remoteexecution.Tree{
+		Root: &remoteexecution.Directory{
+			Directories: []*remoteexecution.DirectoryNode{
+				{Name: "sub", Digest: subdirectoryDigest.GetProto()},
+			},
+		},
+	})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	missingTreeDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+
+	for name, tc := range map[string]struct {
+		treeDigest *remoteexecution.Digest
+		query      string
+		stats      *treeStats
+		notFound   bool
+		body       string
+	}{
+		"Disabled": {
+			treeDigest: treeDigest.GetProto(),
+		},
+		"Enabled": {
+			treeDigest: treeDigest.GetProto(),
+			query:      "?output_directory_stats=1",
+			stats:      &treeStats{DirectoriesCount: 1, FilesCount: 3, FilesSizeBytes: 11},
+			body:       "(3 files in 1 directories, having a total size of ",
+		},
+		"TreeNotFound": {
+			treeDigest: missingTreeDigest.GetProto(),
+			query:      "?output_directory_stats=1",
+			notFound:   true,
+			body:       "(tree could not be found)",
+		},
+		"TreeNotFoundDisabled": {
+			treeDigest: missingTreeDigest.GetProto(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest:   cas.putMessage(t, &remoteexecution.Command{Arguments: []string{name}}).GetProto(),
+				InputRootDigest: inputRootDigest.GetProto(),
+			})
+			ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{
+				OutputDirectories: []*remoteexecution.OutputDirectory{
+					{Path: "out", TreeDigest: tc.treeDigest},
+				},
+			})
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, tc.query), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			outputDirectories := reflect.ValueOf(ts.templates.data).FieldByName("OutputDirectories").Interface().([]*outputDirectoryInfo)
+			if len(outputDirectories) != 1 {
+				t.Fatalf("Expected 1 output directory, got %d", len(outputDirectories))
+			}
+			if stats := outputDirectories[0].TreeStats; !reflect.DeepEqual(stats, tc.stats) {
+				t.Errorf("Expected tree statistics %#v, got %#v", tc.stats, stats)
+			}
+			if notFound := outputDirectories[0].TreeNotFound; notFound != tc.notFound {
+				t.Errorf("Expected tree not found: %v, got %v", tc.notFound, notFound)
+			}
+			body := w.Body.String()
+			if tc.body != "" && !strings.Contains(body, tc.body) {
+				t.Errorf("Expected page to contain %#v", tc.body)
+			}
+			if tc.stats == nil && strings.Contains(body, "files in") {
+				t.Error("Expected page not to contain tree statistics")
+			}
+			if !tc.notFound && strings.Contains(body, "(tree could not be found)") {
+				t.Error("Expected page not to report a missing tree")
+			}
+
+			// The JSON representation only contains the
+			// action result, without any statistics.
+			var got remoteexecution.ActionResult
+			if code := ts.serveProtoJSON(t, getURL("action", actionDigest, tc.query), &got); code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+			}
+			if len(got.OutputDirectories) != 1 || got.OutputDirectories[0].Path != "out" {
+				t.Errorf("Unexpected output directories %v", got.OutputDirectories)
+			}
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		treeDigest *remoteexecution.Digest
+		errors     map[digest.Digest]error
+		code       int
+		message    string
+	}{
+		"InvalidTreeDigest": {
+			treeDigest: &remoteexecution.Digest{Hash: strings.Repeat("0", 64), SizeBytes: -1},
+			code:       http.StatusBadRequest,
+			message:    "Invalid digest size",
+		},
+		"IncompleteTree": {
+			treeDigest: incompleteTreeDigest.GetProto(),
+			code:       http.StatusBadRequest,
+			message:    "Failed to find child node in tree",
+		},
+		"StorageFailure": {
+			treeDigest: treeDigest.GetProto(),
+			errors:     map[digest.Digest]error{treeDigest: status.Error(codes.Internal, "Disk on fire")},
+			code:       http.StatusInternalServerError,
+			message:    "Disk on fire",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cas.errors = tc.errors
+			defer func() { cas.errors = map[digest.Digest]error{} }()
+
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest:   cas.putMessage(t, &remoteexecution.Command{Arguments: []string{name}}).GetProto(),
+				InputRootDigest: inputRootDigest.GetProto(),
+			})
+			ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{
+				OutputDirectories: []*remoteexecution.OutputDirectory{
+					{Path: "out", TreeDigest: tc.treeDigest},
+				},
+			})
+
+			ts.expectErrorPage(t, httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, "?output_directory_stats=1"), nil), tc.code, tc.message)
+		})
+	}
+}
+
+func TestHandleActionExpandInputs(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	innerDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "inner.txt", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+		},
+	})
+	outerDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "outer.txt", Digest: cas.putBytes([]byte("World")).GetProto()},
+		},
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "inner", Digest: innerDigest.GetProto()},
+		},
+	})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "missing", Digest: missingDigest.GetProto()},
+			{Name: "outer", Digest: outerDigest.GetProto()},
+		},
+	})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   commandDigest.GetProto(),
+		InputRootDigest: inputRootDigest.GetProto(),
+	})
+	ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{})
+
+	for name, tc := range map[string]struct {
+		query     string
+		errors    map[digest.Digest]error
+		expanded  bool
+		contains  []string
+		omits     []string
+		errorCode codes.Code
+	}{
+		"Disabled": {
+			contains: []string{">outer</a>/"},
+			omits:    []string{"outer.txt", "inner.txt", "(directory could not be found)"},
+		},
+		"Expanded": {
+			query:    "?expand_inputs=1",
+			expanded: true,
+			contains: []string{
+				"<summary><a href=\"../../directory/" + outerDigest.GetHashString() + "-" + strconv.FormatInt(outerDigest.GetSizeBytes(), 10) + "/\">outer</a>/</summary>",
+				"<summary><a href=\"../../directory/" + innerDigest.GetHashString() + "-" + strconv.FormatInt(innerDigest.GetSizeBytes(), 10) + "/\">inner</a>/</summary>",
+				">outer.txt</a>",
+				">inner.txt</a>",
+				">missing</a>/",
+				"(directory could not be found)",
+			},
+		},
+		"ExpansionFailure": {
+			query:     "?expand_inputs=1",
+			errors:    map[digest.Digest]error{innerDigest: status.Error(codes.Internal, "Disk on fire")},
+			contains:  []string{"Failed to expand input root: Disk on fire", ">outer</a>/"},
+			omits:     []string{"inner.txt"},
+			errorCode: codes.Internal,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cas.errors = tc.errors
+			defer func() { cas.errors = map[digest.Digest]error{} }()
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, tc.query), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			data := reflect.ValueOf(ts.templates.data)
+			expandedInputRoot := data.FieldByName("ExpandedInputRoot").Interface().(*expandedDirectoryInfo)
+			if expanded := expandedInputRoot != nil; expanded != tc.expanded {
+				t.Fatalf("Expected expanded input root to be present: %v, got %v", tc.expanded, expanded)
+			}
+			if expandedInputRoot != nil {
+				if len(expandedInputRoot.Directories) != 2 {
+					t.Fatalf("Expected 2 child directories, got %d", len(expandedInputRoot.Directories))
+				}
+				if missing := expandedInputRoot.Directories[0]; !missing.NotFound || missing.Directory != nil {
+					t.Errorf("Expected directory %#v to be reported as missing", missing.Node.Name)
+				}
+				outer := expandedInputRoot.Directories[1].Directory
+				if outer == nil || len(outer.Directories) != 1 || outer.Directories[0].Directory == nil {
+					t.Fatal("Expected both levels of the input root to be expanded")
+				}
+				if files := outer.Directories[0].Directory.Directory.Files; len(files) != 1 || files[0].Name != "inner.txt" {
+					t.Errorf("Unexpected files in nested directory: %v", files)
+				}
+			}
+			if code := data.FieldByName("InputRootError").Interface().(*status.Status).Code(); code != tc.errorCode {
+				t.Errorf("Expected input root error code %s, got %s", tc.errorCode, code)
+			}
+			body := w.Body.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+			for _, s := range tc.omits {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected page not to contain %#v", s)
+				}
+			}
+
+			// The JSON representation only contains the
+			// action result, regardless of expansion.
+			var got remoteexecution.ActionResult
+			if code := ts.serveProtoJSON(t, getURL("action", actionDigest, tc.query), &got); code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+			}
+		})
+	}
+
+	t.Run("DepthLimit", func(t *testing.T) {
+		// Create a chain of directories that is deeper than
+		// the maximum expansion depth.
+		chainDigest := cas.putMessage(t, &remoteexecution.Directory{})
+		for i := maximumExpandedDirectoryDepth + 1; i > 0; i-- {
+			chainDigest = cas.putMessage(t, &remoteexecution.Directory{
+				Directories: []*remoteexecution.DirectoryNode{
+					{Name: fmt.Sprintf("level%d", i), Digest: chainDigest.GetProto()},
+				},
+			})
+		}
+		chainActionDigest := cas.putMessage(t, &remoteexecution.Action{
+			CommandDigest:   commandDigest.GetProto(),
+			InputRootDigest: chainDigest.GetProto(),
+		})
+		ts.putActionResult(t, chainActionDigest, &remoteexecution.ActionResult{})
+
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", chainActionDigest, "?expand_inputs=1"), nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+			t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		levels := 0
+		for directory := reflect.ValueOf(ts.templates.data).FieldByName("ExpandedInputRoot").Interface().(*expandedDirectoryInfo); len(directory.Directories) > 0 && directory.Directories[0].Directory != nil; directory = directory.Directories[0].Directory {
+			levels++
+		}
+		if levels != maximumExpandedDirectoryDepth {
+			t.Errorf("Expected %d levels to be expanded, got %d", maximumExpandedDirectoryDepth, levels)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, fmt.Sprintf(">level%d</a>/", maximumExpandedDirectoryDepth+1)) {
+			t.Error("Expected the deepest directory that is not expanded to be linked")
+		}
+		if strings.Contains(body, "(directory could not be found)") {
+			t.Error("Expected directories beyond the maximum depth not to be reported as missing")
+		}
+	})
+}
+
+func TestHandleMissingBlobs(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	presentFileDigest := cas.putBytes([]byte("Hello"))
+	evictedFileDigest := cas.putBytes([]byte("World"))
+	nestedFileDigest := cas.putBytes([]byte("!"))
+	evictedDirectoryDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "nested.txt", Digest: nestedFileDigest.GetProto()},
+		},
+	})
+	presentDirectoryDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "present.txt", Digest: presentFileDigest.GetProto()},
+			{Name: "evicted.txt", Digest: evictedFileDigest.GetProto()},
+		},
+	})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "evicted", Digest: evictedDirectoryDigest.GetProto()},
+			{Name: "present", Digest: presentDirectoryDigest.GetProto()},
+		},
+	})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   commandDigest.GetProto(),
+		InputRootDigest: inputRootDigest.GetProto(),
+	})
+	missingActionDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+
+	for name, tc := range map[string]struct {
+		actionDigest digest.Digest
+		evicted      []digest.Digest
+		missing      []digest.Digest
+	}{
+		"Complete": {
+			actionDigest: actionDigest,
+		},
+		"PartiallyEvicted": {
+			actionDigest: actionDigest,
+			// Contents of directories that are absent cannot
+			// be reported, as they are unknown.
+			evicted: []digest.Digest{commandDigest, evictedFileDigest, evictedDirectoryDigest, nestedFileDigest},
+			missing: []digest.Digest{commandDigest, evictedFileDigest, evictedDirectoryDigest},
+		},
+		"InputRootEvicted": {
+			actionDigest: actionDigest,
+			evicted:      []digest.Digest{inputRootDigest},
+			missing:      []digest.Digest{inputRootDigest},
+		},
+		"ActionNotFound": {
+			actionDigest: missingActionDigest,
+			missing:      []digest.Digest{missingActionDigest},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, evictedDigest := range tc.evicted {
+				data := cas.blobs[evictedDigest]
+				delete(cas.blobs, evictedDigest)
+				defer func(evictedDigest digest.Digest) { cas.blobs[evictedDigest] = data }(evictedDigest)
+			}
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("missing_blobs", tc.actionDigest, ""), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Fatalf("Expected content type \"application/json\", got %#v", contentType)
+			}
+			var response struct {
+				MissingDigests []jsonDigest `json:"missingDigests"`
+				Truncated      bool         `json:"truncated"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			expected := []jsonDigest{}
+			missing := digest.NewSetBuilder()
+			for _, missingDigest := range tc.missing {
+				missing.Add(missingDigest)
+			}
+			for _, missingDigest := range missing.Build().Items() {
+				expected = append(expected, newJSONDigest(missingDigest))
+			}
+			if !reflect.DeepEqual(response.MissingDigests, expected) || response.Truncated {
+				t.Errorf("Expected missing digests %v, got %v (truncated: %v)", expected, response.MissingDigests, response.Truncated)
+			}
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		url     string
+		errors  map[digest.Digest]error
+		code    int
+		message string
+	}{
+		"InvalidDigest": {
+			url:     "/blobs/sha256/missing_blobs/" + strings.Repeat("g", 64) + "-123/",
+			code:    http.StatusBadRequest,
+			message: "Non-hexadecimal character in digest hash",
+		},
+		"StorageFailure": {
+			url:     getURL("missing_blobs", actionDigest, ""),
+			errors:  map[digest.Digest]error{presentDirectoryDigest: status.Error(codes.Internal, "Disk on fire")},
+			code:    http.StatusInternalServerError,
+			message: "Disk on fire",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cas.errors = tc.errors
+			defer func() { cas.errors = map[digest.Digest]error{} }()
+
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if code != tc.code || response.HTTPStatus != tc.code || !strings.Contains(response.Message, tc.message) {
+				t.Errorf("Expected status %d with message %#v, got status %d and %#v", tc.code, tc.message, code, response)
+			}
+		})
+	}
+
+	t.Run("Documented", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_welcome.html" {
+			t.Fatalf("Expected page_welcome.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		if !strings.Contains(w.Body.String(), "blobs/${digest_function}/missing_blobs/${hash}-${size_bytes}/") {
+			t.Error("Expected the welcome page to document the missing blobs route")
+		}
+	})
+}
+
+func TestHandleLogPages(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	// Create a log spanning three pages, where each page consists of
+	// lines of a different character.
+	pages := []string{
+		strings.Repeat(strings.Repeat("a", 99)+"\n", maximumLogSizeBytes/100),
+		strings.Repeat(strings.Repeat("b", 99)+"\n", maximumLogSizeBytes/100),
+		"ccc\n",
+	}
+	logDigest := cas.putBytes([]byte(pages[0] + pages[1] + pages[2]))
+
+	for name, tc := range map[string]struct {
+		query     string
+		pageIndex int64
+		contains  []string
+		omits     []string
+	}{
+		"Default": {
+			pageIndex: 0,
+			contains:  []string{"Page 1 of 3", `href="?page=1"`, "aaa"},
+			omits:     []string{"Previous page", "bbb"},
+		},
+		"Middle": {
+			query:     "?page=1",
+			pageIndex: 1,
+			contains:  []string{"Page 2 of 3", `href="?page=0"`, `href="?page=2"`, "bbb", `href="?page=1&amp;raw=1"`},
+			omits:     []string{"aaa", "ccc"},
+		},
+		"Last": {
+			query:     "?page=2",
+			pageIndex: 2,
+			contains:  []string{"Page 3 of 3", `href="?page=1"`, "ccc"},
+			omits:     []string{"Next page", "bbb"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("log", logDigest, tc.query), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_log.html" {
+				t.Fatalf("Expected page_log.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			data := reflect.ValueOf(ts.templates.data).Elem()
+			if pageIndex := data.FieldByName("PageIndex").Int(); pageIndex != tc.pageIndex {
+				t.Errorf("Expected page index %d, got %d", tc.pageIndex, pageIndex)
+			}
+			if pagesCount := data.FieldByName("PagesCount").Int(); pagesCount != 3 {
+				t.Errorf("Expected 3 pages, got %d", pagesCount)
+			}
+			body := w.Body.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+			for _, s := range tc.omits {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected page not to contain %#v", s)
+				}
+			}
+
+			// Raw pages contain the original contents,
+			// including escape sequences.
+			w = ts.serve(httptest.NewRequest(http.MethodGet, getURL("log", logDigest, fmt.Sprintf("?page=%d&raw=1", tc.pageIndex)), nil))
+			if w.Code != http.StatusOK || w.Body.String() != pages[tc.pageIndex] {
+				t.Errorf("Expected raw page to be returned, got status %d", w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+				t.Errorf("Expected content type \"text/plain; charset=utf-8\", got %#v", contentType)
+			}
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		query   string
+		errors  map[digest.Digest]error
+		code    int
+		message string
+	}{
+		"Negative": {
+			query:   "?page=-1",
+			code:    http.StatusBadRequest,
+			message: "Invalid page number \"-1\"",
+		},
+		"NonNumeric": {
+			query:   "?page=two",
+			code:    http.StatusBadRequest,
+			message: "Invalid page number \"two\"",
+		},
+		"OutOfRange": {
+			query:   "?page=3",
+			code:    http.StatusBadRequest,
+			message: "Page number 3 exceeds the number of pages of this log, which is 3",
+		},
+		"StorageFailure": {
+			query:   "?page=1",
+			errors:  map[digest.Digest]error{logDigest: status.Error(codes.Internal, "Disk on fire")},
+			code:    http.StatusInternalServerError,
+			message: "Disk on fire",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cas.errors = tc.errors
+			defer func() { cas.errors = map[digest.Digest]error{} }()
+
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("log", logDigest, tc.query), nil))
+			if code != tc.code || response.Message != tc.message {
+				t.Errorf("Expected status %d with message %#v, got status %d and %#v", tc.code, tc.message, code, response)
+			}
+		})
+	}
+
+	t.Run("LinkedFromAction", func(t *testing.T) {
+		actionDigest := cas.putMessage(t, &remoteexecution.Action{
+			CommandDigest:   cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}}).GetProto(),
+			InputRootDigest: cas.putMessage(t, &remoteexecution.Directory{}).GetProto(),
+		})
+		ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{StdoutDigest: logDigest.GetProto()})
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+			t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		if link := fmt.Sprintf(`<a href="../../log/%s-%d/">viewed in pages</a>`, logDigest.GetHashString(), logDigest.GetSizeBytes()); !strings.Contains(w.Body.String(), link) {
+			t.Errorf("Expected action page to contain %#v", link)
+		}
+	})
+}
+
+func TestHandleActionExecutionStatus(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}}).GetProto(),
+		InputRootDigest: cas.putMessage(t, &remoteexecution.Directory{}).GetProto(),
+	})
+	missingActionDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	workerLogDigest := cas.putBytes([]byte("Segmentation fault\n"))
+	coreDumpDigest := cas.putBytes([]byte{0x7f, 'E', 'L', 'F'})
+
+	for name, tc := range map[string]struct {
+		actionDigest    digest.Digest
+		executeResponse *remoteexecution.ExecuteResponse
+		executionStatus codes.Code
+		serverLogs      []string
+		contains        []string
+		omits           []string
+		jsonCode        int
+	}{
+		"FailedWithServerLogs": {
+			actionDigest: actionDigest,
+			executeResponse: &remoteexecution.ExecuteResponse{
+				Status: status.New(codes.Unavailable, "Worker crashed").Proto(),
+				ServerLogs: map[string]*remoteexecution.LogFile{
+					"worker.log": {Digest: workerLogDigest.GetProto(), HumanReadable: true},
+					"core":       {Digest: coreDumpDigest.GetProto()},
+				},
+			},
+			executionStatus: codes.Unavailable,
+			serverLogs:      []string{"core", "worker.log"},
+			contains: []string{
+				"<b>Execution failed: Unavailable</b><br/>\n\tWorker crashed",
+				"Execution of this action failed before an action result was produced.",
+				`<h2 class="my-4">Server logs</h2>`,
+				"Segmentation fault",
+				"This log file is not human readable.",
+			},
+			omits:    []string{"The action result of this action could not be found."},
+			jsonCode: http.StatusNotFound,
+		},
+		"FailedWithoutResult": {
+			actionDigest: actionDigest,
+			executeResponse: &remoteexecution.ExecuteResponse{
+				Status: status.New(codes.DeadlineExceeded, "Execution timed out").Proto(),
+			},
+			executionStatus: codes.DeadlineExceeded,
+			contains: []string{
+				"<b>Execution failed: DeadlineExceeded</b>",
+				"Execution of this action failed before an action result was produced.",
+			},
+			omits:    []string{"Server logs"},
+			jsonCode: http.StatusNotFound,
+		},
+		"FailedActionNotFound": {
+			actionDigest: missingActionDigest,
+			executeResponse: &remoteexecution.ExecuteResponse{
+				Status: status.New(codes.Internal, "Worker crashed").Proto(),
+			},
+			executionStatus: codes.Internal,
+			contains:        []string{"<b>Execution failed: Internal</b>"},
+			jsonCode:        http.StatusNotFound,
+		},
+		"Succeeded": {
+			actionDigest: actionDigest,
+			executeResponse: &remoteexecution.ExecuteResponse{
+				Result: &remoteexecution.ActionResult{ExitCode: 0},
+				Status: status.New(codes.OK, "").Proto(),
+			},
+			executionStatus: codes.OK,
+			omits:           []string{"Execution failed", "Server logs"},
+			jsonCode:        http.StatusOK,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			historicalExecuteResponseDigest := cas.putMessage(t, &cas_proto.HistoricalExecuteResponse{
+				ActionDigest:    tc.actionDigest.GetProto(),
+				ExecuteResponse: tc.executeResponse,
+			})
+			url := getURL("historical_execute_response", historicalExecuteResponseDigest, "")
+			w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			data := reflect.ValueOf(ts.templates.data)
+			if code := data.FieldByName("ExecutionStatus").Interface().(*status.Status).Code(); code != tc.executionStatus {
+				t.Errorf("Expected execution status %s, got %s", tc.executionStatus, code)
+			}
+			var serverLogs []string
+			for _, serverLog := range data.FieldByName("ServerLogs").Interface().([]*logInfo) {
+				serverLogs = append(serverLogs, serverLog.Name)
+			}
+			if !reflect.DeepEqual(serverLogs, tc.serverLogs) {
+				t.Errorf("Expected server logs %v, got %v", tc.serverLogs, serverLogs)
+			}
+			body := w.Body.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+			for _, s := range tc.omits {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected page not to contain %#v", s)
+				}
+			}
+
+			// The JSON representation only contains the
+			// action result, which is absent if execution
+			// failed.
+			var got remoteexecution.ActionResult
+			if code := ts.serveProtoJSON(t, url, &got); code != tc.jsonCode {
+				t.Errorf("Expected status %d, got %d", tc.jsonCode, code)
+			}
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		actionDigest    digest.Digest
+		executeResponse *remoteexecution.ExecuteResponse
+		code            int
+		message         string
+	}{
+		"ActionNotFound": {
+			actionDigest:    missingActionDigest,
+			executeResponse: &remoteexecution.ExecuteResponse{},
+			code:            http.StatusNotFound,
+			message:         "Could not find an action or action result",
+		},
+		"InvalidServerLogDigest": {
+			actionDigest: actionDigest,
+			executeResponse: &remoteexecution.ExecuteResponse{
+				Status: status.New(codes.Unavailable, "Worker crashed").Proto(),
+				ServerLogs: map[string]*remoteexecution.LogFile{
+					"worker.log": {Digest: &remoteexecution.Digest{Hash: strings.Repeat("0", 64), SizeBytes: -1}, HumanReadable: true},
+				},
+			},
+			code:    http.StatusBadRequest,
+			message: "Invalid digest for server log \"worker.log\": ",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			historicalExecuteResponseDigest := cas.putMessage(t, &cas_proto.HistoricalExecuteResponse{
+				ActionDigest:    tc.actionDigest.GetProto(),
+				ExecuteResponse: tc.executeResponse,
+			})
+			ts.expectErrorPage(t, httptest.NewRequest(http.MethodGet, getURL("historical_execute_response", historicalExecuteResponseDigest, ""), nil), tc.code, tc.message)
+		})
+	}
+}
+
+func TestHandleActionServerLogs(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}}).GetProto(),
+		InputRootDigest: cas.putMessage(t, &remoteexecution.Directory{}).GetProto(),
+	})
+	helloDigest := cas.putBytes([]byte("Hello"))
+	worldDigest := cas.putBytes([]byte("World"))
+	coreDumpDigest := cas.putBytes([]byte{0x7f, 'E', 'L', 'F'})
+	largeDigest := cas.putBytes(bytes.Repeat([]byte("x"), maximumLogSizeBytes+1))
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+
+	for name, tc := range map[string]struct {
+		serverLogs map[string]*remoteexecution.LogFile
+		expected   []*logInfo
+		contains   []string
+	}{
+		"HumanReadable": {
+			serverLogs: map[string]*remoteexecution.LogFile{
+				"worker.log": {Digest: worldDigest.GetProto(), HumanReadable: true},
+				"runner.log": {Digest: helloDigest.GetProto(), HumanReadable: true},
+			},
+			expected: []*logInfo{
+				{Name: "runner.log", Digest: helloDigest, LineCount: 1, HTML: "Hello"},
+				{Name: "worker.log", Digest: worldDigest, LineCount: 1, HTML: "World"},
+			},
+			contains: []string{
+				`<th style="width: 25%">runner.log<sup>`,
+				`<div class="term-container">Hello</div>`,
+				`<th style="width: 25%">worker.log<sup>`,
+				`<div class="term-container">World</div>`,
+			},
+		},
+		"NotHumanReadable": {
+			serverLogs: map[string]*remoteexecution.LogFile{
+				"dumps/core": {Digest: coreDumpDigest.GetProto()},
+				"worker.log": {Digest: worldDigest.GetProto(), HumanReadable: true},
+			},
+			expected: []*logInfo{
+				{Name: "dumps/core", Digest: coreDumpDigest, NotHumanReadable: true},
+				{Name: "worker.log", Digest: worldDigest, LineCount: 1, HTML: "World"},
+			},
+			contains: []string{
+				fmt.Sprintf(`This log file is not human readable. It can be <a href="../../file/%s-4/core">downloaded</a> instead (4 bytes).`, coreDumpDigest.GetHashString()),
+				`<div class="term-container">World</div>`,
+			},
+		},
+		"MissingAndTooLarge": {
+			serverLogs: map[string]*remoteexecution.LogFile{
+				"large.log":   {Digest: largeDigest.GetProto(), HumanReadable: true},
+				"missing.log": {Digest: missingDigest.GetProto(), HumanReadable: true},
+			},
+			expected: []*logInfo{
+				{Name: "large.log", Digest: largeDigest, TooLarge: true},
+				{Name: "missing.log", Digest: missingDigest, NotFound: true},
+			},
+			contains: []string{
+				fmt.Sprintf(`It can be <a href="../../log/%s-%d/">viewed in pages</a> instead.`, largeDigest.GetHashString(), largeDigest.GetSizeBytes()),
+				"The log file for this action could not be found.",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			historicalExecuteResponseDigest := cas.putMessage(t, &cas_proto.HistoricalExecuteResponse{
+				ActionDigest: actionDigest.GetProto(),
+				ExecuteResponse: &remoteexecution.ExecuteResponse{
+					Result:     &remoteexecution.ActionResult{ExitCode: 1},
+					ServerLogs: tc.serverLogs,
+				},
+			})
+			url := getURL("historical_execute_response", historicalExecuteResponseDigest, "")
+			w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			if serverLogs := reflect.ValueOf(ts.templates.data).FieldByName("ServerLogs").Interface().([]*logInfo); !reflect.DeepEqual(serverLogs, tc.expected) {
+				t.Errorf("Expected server logs %v, got %v", tc.expected, serverLogs)
+			}
+			body := w.Body.String()
+			for _, s := range append([]string{`<h2 class="my-4">Server logs</h2>`}, tc.contains...) {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+
+			// Server logs are not part of the JSON
+			// representation, which only contains the action
+			// result.
+			var got remoteexecution.ActionResult
+			if code := ts.serveProtoJSON(t, url, &got); code != http.StatusOK || got.ExitCode != 1 {
+				t.Errorf("Expected action result to be returned, got status %d and %v", code, &got)
+			}
+		})
+	}
+
+	t.Run("StorageFailure", func(t *testing.T) {
+		cas.errors = map[digest.Digest]error{worldDigest: status.Error(codes.Internal, "Disk on fire")}
+		defer func() { cas.errors = map[digest.Digest]error{} }()
+
+		historicalExecuteResponseDigest := cas.putMessage(t, &cas_proto.HistoricalExecuteResponse{
+			ActionDigest: actionDigest.GetProto(),
+			ExecuteResponse: &remoteexecution.ExecuteResponse{
+				ServerLogs: map[string]*remoteexecution.LogFile{
+					"worker.log": {Digest: worldDigest.GetProto(), HumanReadable: true},
+				},
+			},
+		})
+		ts.expectErrorPage(t, httptest.NewRequest(http.MethodGet, getURL("historical_execute_response", historicalExecuteResponseDigest, ""), nil), http.StatusInternalServerError, "Disk on fire")
+	})
+}
+
+// equalOutputDigestDiffs returns whether two lists of output digest
+// differences are equal, comparing digests by value.
+func equalOutputDigestDiffs(a, b []outputDigestDiffInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].Differs != b[i].Differs || !proto.Equal(a[i].DigestA, b[i].DigestA) || !proto.Equal(a[i].DigestB, b[i].DigestB) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHandleActionDiff(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	actionDigestA := cas.putBytes([]byte("Action A"))
+	actionDigestB := cas.putBytes([]byte("Action B"))
+	actionDigestC := cas.putBytes([]byte("Action C"))
+	missingActionDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	commonDigest := &remoteexecution.Digest{Hash: strings.Repeat("1", 64), SizeBytes: 1}
+	fileDigestA := &remoteexecution.Digest{Hash: strings.Repeat("2", 64), SizeBytes: 2}
+	fileDigestB := &remoteexecution.Digest{Hash: strings.Repeat("3", 64), SizeBytes: 3}
+	treeDigest := &remoteexecution.Digest{Hash: strings.Repeat("4", 64), SizeBytes: 4}
+	start := time.Unix(1000, 0)
+	ts.putActionResult(t, actionDigestA, &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "common.txt", Digest: commonDigest},
+			{Path: "differs.txt", Digest: fileDigestA},
+		},
+		OutputDirectories: []*remoteexecution.OutputDirectory{
+			{Path: "dir", TreeDigest: treeDigest},
+		},
+		ExitCode: 0,
+		ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+			ExecutionStartTimestamp:     timestamppb.New(start),
+			ExecutionCompletedTimestamp: timestamppb.New(start.Add(3 * time.Second)),
+		},
+	})
+	ts.putActionResult(t, actionDigestB, &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "common.txt", Digest: commonDigest},
+			{Path: "differs.txt", Digest: fileDigestB},
+		},
+		ExitCode: 1,
+	})
+	ts.putActionResult(t, actionDigestC, &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "common.txt", Digest: commonDigest},
+			{Path: "differs.txt", Digest: fileDigestA},
+		},
+		OutputDirectories: []*remoteexecution.OutputDirectory{
+			{Path: "dir", TreeDigest: treeDigest},
+		},
+	})
+	executingDuration := 3 * time.Second
+
+	for name, tc := range map[string]struct {
+		actionDigestB     digest.Digest
+		outputFiles       []outputDigestDiffInfo
+		outputDirectories []outputDigestDiffInfo
+		executing         executionStageDiffInfo
+		contains          []string
+		omits             []string
+	}{
+		"Different": {
+			actionDigestB: actionDigestB,
+			outputFiles: []outputDigestDiffInfo{
+				{Path: "common.txt", DigestA: commonDigest, DigestB: commonDigest},
+				{Path: "differs.txt", DigestA: fileDigestA, DigestB: fileDigestB, Differs: true},
+			},
+			outputDirectories: []outputDigestDiffInfo{
+				{Path: "dir", DigestA: treeDigest, Differs: true},
+			},
+			executing: executionStageDiffInfo{Name: "Executing", DurationA: &executingDuration},
+			contains: []string{
+				"<tr class=\"table-warning\">\n\t\t<th>Exit code:</th>\n\t\t<td>0</td>\n\t\t<td>1</td>",
+				"<tr class=\"table-warning\">\n\t\t<td class=\"font-monospace\" style=\"word-break: break-all\">differs.txt</td>",
+				"<tr>\n\t\t<td class=\"font-monospace\" style=\"word-break: break-all\">common.txt</td>",
+				"<td class=\"font-monospace\" style=\"word-break: break-all\"><i>absent</i></td>",
+				"<td>3s</td>",
+			},
+		},
+		"Identical": {
+			actionDigestB: actionDigestC,
+			outputFiles: []outputDigestDiffInfo{
+				{Path: "common.txt", DigestA: commonDigest, DigestB: commonDigest},
+				{Path: "differs.txt", DigestA: fileDigestA, DigestB: fileDigestA},
+			},
+			outputDirectories: []outputDigestDiffInfo{
+				{Path: "dir", DigestA: treeDigest, DigestB: treeDigest},
+			},
+			executing: executionStageDiffInfo{Name: "Executing", DurationA: &executingDuration},
+			contains:  []string{"<tr>\n\t\t<th>Exit code:</th>"},
+			omits:     []string{"table-warning", "<i>absent</i>"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			url := getURL("diff_action", actionDigestA, fmt.Sprintf("%s-%d/", tc.actionDigestB.GetHashString(), tc.actionDigestB.GetSizeBytes()))
+			w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action_diff.html" {
+				t.Fatalf("Expected page_action_diff.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			data := reflect.ValueOf(ts.templates.data)
+			if outputFiles := data.FieldByName("OutputFiles").Interface().([]outputDigestDiffInfo); !equalOutputDigestDiffs(outputFiles, tc.outputFiles) {
+				t.Errorf("Expected output files %v, got %v", tc.outputFiles, outputFiles)
+			}
+			if outputDirectories := data.FieldByName("OutputDirectories").Interface().([]outputDigestDiffInfo); !equalOutputDigestDiffs(outputDirectories, tc.outputDirectories) {
+				t.Errorf("Expected output directories %v, got %v", tc.outputDirectories, outputDirectories)
+			}
+			if executing := data.FieldByName("ExecutionStages").Interface().([]executionStageDiffInfo)[2]; !reflect.DeepEqual(executing, tc.executing) {
+				t.Errorf("Expected execution stage %v, got %v", tc.executing, executing)
+			}
+			body := w.Body.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+			for _, s := range tc.omits {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected page not to contain %#v", s)
+				}
+			}
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		suffix  string
+		code    int
+		message string
+	}{
+		"InvalidSize": {
+			suffix:  actionDigestB.GetHashString() + "-abc/",
+			code:    http.StatusBadRequest,
+			message: "Invalid blob size \"abc\"",
+		},
+		"InvalidHash": {
+			suffix:  strings.Repeat("g", 64) + "-123/",
+			code:    http.StatusBadRequest,
+			message: "Non-hexadecimal character in digest hash",
+		},
+		"NotFound": {
+			suffix:  fmt.Sprintf("%s-%d/", missingActionDigest.GetHashString(), missingActionDigest.GetSizeBytes()),
+			code:    http.StatusNotFound,
+			message: fmt.Sprintf("Failed to obtain action result of action %#v", missingActionDigest.String()),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("diff_action", actionDigestA, tc.suffix), nil))
+			if code != tc.code || !strings.HasPrefix(response.Message, tc.message) {
+				t.Errorf("Expected status %d with message %#v, got status %d and %#v", tc.code, tc.message, code, response)
+			}
+		})
+	}
+}
+
+func TestDirectoryNodeProperties(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	mtime := time.Date(2023, 10, 1, 12, 30, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		nodeProperties *remoteexecution.NodeProperties
+		mode           int64
+		modTime        time.Time
+		contains       []string
+		omits          []string
+	}{
+		"ModeAndMtime": {
+			nodeProperties: &remoteexecution.NodeProperties{
+				UnixMode: wrapperspb.UInt32(0o40750),
+				Mtime:    timestamppb.New(mtime),
+			},
+			mode:    0o750,
+			modTime: mtime,
+			contains: []string{
+				"<th style=\"width: 25%\">Mode:</th>\n\t\t\t\t<td class=\"font-monospace\" style=\"width: 75%\">040750</td>",
+				"<th style=\"width: 25%\">Modification time:</th>\n\t\t\t\t<td style=\"width: 75%\">2023-10-01T12:30:00Z</td>",
+			},
+		},
+		"ModeOnly": {
+			nodeProperties: &remoteexecution.NodeProperties{
+				UnixMode: wrapperspb.UInt32(0o1755),
+			},
+			mode:     0o1755,
+			modTime:  time.Unix(0, 0),
+			contains: []string{">01755</td>"},
+			omits:    []string{"Modification time:"},
+		},
+		"None": {
+			mode:    0o777,
+			modTime: time.Unix(0, 0),
+			omits:   []string{"Mode:", "Modification time:"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			childDigest := cas.putMessage(t, &remoteexecution.Directory{
+				Files: []*remoteexecution.FileNode{
+					{Name: name + ".txt", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+				},
+				NodeProperties: tc.nodeProperties,
+			})
+			rootDigest := cas.putMessage(t, &remoteexecution.Directory{
+				Directories: []*remoteexecution.DirectoryNode{
+					{Name: "child", Digest: childDigest.GetProto()},
+				},
+			})
+
+			// The node properties of the child directory
+			// should be reflected in its tar header.
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("directory", rootDigest, "?format=tar&compression=none"), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			tarReader := tar.NewReader(w.Body)
+			var header *tar.Header
+			for {
+				var err error
+				header, err = tarReader.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Name == "child" {
+					break
+				}
+			}
+			if header.Typeflag != tar.TypeDir {
+				t.Fatalf("Expected a directory, got type %c", header.Typeflag)
+			}
+			if header.Mode != tc.mode {
+				t.Errorf("Expected mode %#o, got %#o", tc.mode, header.Mode)
+			}
+			if !header.ModTime.Equal(tc.modTime) {
+				t.Errorf("Expected modification time %s, got %s", tc.modTime, header.ModTime)
+			}
+
+			// They should also be displayed on the page of
+			// the child directory.
+			w = ts.serve(httptest.NewRequest(http.MethodGet, getURL("directory", childDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_directory.html" {
+				t.Fatalf("Expected page_directory.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			body := w.Body.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+			for _, s := range tc.omits {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected page not to contain %#v", s)
+				}
+			}
+
+			// The JSON representation contains the node
+			// properties as part of the Directory message.
+			var got remoteexecution.Directory
+			if code := ts.serveProtoJSON(t, getURL("directory", childDigest, ""), &got); code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+			}
+			if !proto.Equal(got.NodeProperties, tc.nodeProperties) {
+				t.Errorf("Expected node properties %v, got %v", tc.nodeProperties, got.NodeProperties)
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		for _, query := range []string{"", "?format=tar&compression=none"} {
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("directory", missingDigest, query), nil))
+			if code != http.StatusNotFound || response.Code != "NotFound" {
+				t.Errorf("Expected NotFound error for %#v, got status %d and %#v", query, code, response)
+			}
+		}
+	})
+}
+
+func TestHandleActionDoNotCacheAndSalt(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+
+	for name, tc := range map[string]struct {
+		doNotCache bool
+		salt       []byte
+		hexSalt    string
+		contains   []string
+		omits      []string
+	}{
+		"Both": {
+			doNotCache: true,
+			salt:       []byte{0xde, 0xad, 0xbe, 0xef},
+			hexSalt:    "deadbeef",
+			contains: []string{
+				"yes<br/>\n\t\t\t\t<small class=\"text-muted\">Results of this action are never written to the Action Cache.</small>",
+				"<th style=\"width: 25%\">Salt:</th>\n\t\t\t<td class=\"font-monospace\" style=\"width: 75%; word-break: break-all\">deadbeef</td>",
+			},
+		},
+		"Neither": {
+			contains: []string{"<th style=\"width: 25%\">Do not cache:</th>"},
+			omits:    []string{"yes<br/>", "Salt:", "never written to the Action Cache"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest:   commandDigest.GetProto(),
+				InputRootDigest: inputRootDigest.GetProto(),
+				DoNotCache:      tc.doNotCache,
+				Salt:            tc.salt,
+			})
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			if salt := reflect.ValueOf(ts.templates.data).FieldByName("Salt").String(); salt != tc.hexSalt {
+				t.Errorf("Expected salt %#v, got %#v", tc.hexSalt, salt)
+			}
+			body := w.Body.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+			for _, s := range tc.omits {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected page not to contain %#v", s)
+				}
+			}
+
+			// Actions that are not cached have no action
+			// result that can be returned as JSON.
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil))
+			if code != http.StatusNotFound || response.Message != "Could not find an action result" {
+				t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+			}
+		})
+	}
+}
+
+func TestHandleActionOutputFile(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	helloDigest := cas.putBytes([]byte("Hello"))
+	binaryDigest := cas.putBytes([]byte{0xff, 0xfe, 0xfd})
+	compressedDigest := cas.putBytes(gzipBytes(t, []byte("Decompressed")))
+	subdirectory := &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "data.bin", Digest: binaryDigest.GetProto()},
+			{Name: "log.gz", Digest: compressedDigest.GetProto()},
+		},
+	}
+	subdirectoryDigest := cas.putMessage(t, subdirectory)
+	treeDigest := cas.putMessage(t, &remoteexecution.Tree{
+		Root: &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{Name: "top.txt", Digest: helloDigest.GetProto()},
+			},
+			Directories: []*remoteexecution.DirectoryNode{
+				{Name: "sub", Digest: subdirectoryDigest.GetProto()},
+			},
+		},
+		Children: []*remoteexecution.Directory{subdirectory},
+	})
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	actionDigest := cas.putBytes([]byte("Action"))
+	ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "bin/hello.txt", Digest: helloDigest.GetProto()},
+		},
+		OutputDirectories: []*remoteexecution.OutputDirectory{
+			{Path: "out", TreeDigest: treeDigest.GetProto()},
+			{Path: "evicted", TreeDigest: missingDigest.GetProto()},
+		},
+	})
+
+	for name, tc := range map[string]struct {
+		suffix      string
+		contentType string
+		body        string
+	}{
+		"OutputFile": {
+			suffix:      "output/bin/hello.txt",
+			contentType: "text/plain; charset=utf-8",
+			body:        "Hello",
+		},
+		"TopLevelInOutputDirectory": {
+			suffix:      "output/out/top.txt",
+			contentType: "text/plain; charset=utf-8",
+			body:        "Hello",
+		},
+		"NestedInOutputDirectory": {
+			suffix:      "output/out/sub/data.bin",
+			contentType: "application/octet-stream",
+			body:        "\xff\xfe\xfd",
+		},
+		"ContentTypeOverride": {
+			suffix:      "output/out/sub/data.bin?content_type=text/plain",
+			contentType: "text/plain",
+			body:        "\xff\xfe\xfd",
+		},
+		"Decompressed": {
+			// The filename of the output path determines
+			// whether the file may be decompressed.
+			suffix:      "output/out/sub/log.gz?decompress=1",
+			contentType: "text/plain; charset=utf-8",
+			body:        "Decompressed",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, tc.suffix), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != tc.contentType {
+				t.Errorf("Expected content type %#v, got %#v", tc.contentType, contentType)
+			}
+			if body := w.Body.String(); body != tc.body {
+				t.Errorf("Expected body %#v, got %#v", tc.body, body)
+			}
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		actionDigest digest.Digest
+		suffix       string
+		code         int
+		message      string
+	}{
+		"NotAnOutput": {
+			actionDigest: actionDigest,
+			suffix:       "output/bin/other.txt",
+			code:         http.StatusNotFound,
+			message:      "Path \"bin/other.txt\" is not an output of this action",
+		},
+		"DirectoryNotInTree": {
+			actionDigest: actionDigest,
+			suffix:       "output/out/nonexistent/data.bin",
+			code:         http.StatusNotFound,
+			message:      "Path \"out/nonexistent/data.bin\" is not an output of this action",
+		},
+		"FileNotInTree": {
+			actionDigest: actionDigest,
+			suffix:       "output/out/sub/nonexistent.bin",
+			code:         http.StatusNotFound,
+			message:      "Path \"out/sub/nonexistent.bin\" is not an output of this action",
+		},
+		"TreeNotFound": {
+			actionDigest: actionDigest,
+			suffix:       "output/evicted/file.txt",
+			code:         http.StatusNotFound,
+			message:      "Failed to obtain tree of output directory \"evicted\": ",
+		},
+		"ActionResultNotFound": {
+			actionDigest: missingDigest,
+			suffix:       "output/bin/hello.txt",
+			code:         http.StatusNotFound,
+			message:      "Object not found",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("action", tc.actionDigest, tc.suffix), nil))
+			if code != tc.code || !strings.HasPrefix(response.Message, tc.message) {
+				t.Errorf("Expected status %d with message %#v, got status %d and %#v", tc.code, tc.message, code, response)
+			}
+		})
+	}
+
+	t.Run("Documented", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_welcome.html" {
+			t.Fatalf("Expected page_welcome.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		if !strings.Contains(w.Body.String(), "blobs/${digest_function}/action/${hash}-${size_bytes}/output/${path}") {
+			t.Error("Expected the welcome page to document the output file route")
+		}
+	})
+}
+
+func TestReloadingTemplateExecutor(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	templatesDirectory := t.TempDir()
+	writeTemplate := func(contents string) {
+		if err := os.WriteFile(filepath.Join(templatesDirectory, "page_error.html"), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.BrowserService.templates = NewReloadingTemplateExecutor(os.DirFS(templatesDirectory), newTemplateFuncMap())
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	url := getURL("command", missingDigest, "")
+
+	// Changes to templates on disk should be reflected by the next
+	// request, without restarting.
+	for name, tc := range map[string]struct {
+		contents string
+		body     string
+	}{
+		"Initial":  {contents: "Version 1: {{.Status.Message}}", body: "Version 1: Object not found"},
+		"Modified": {contents: "Version 2: {{.HTTPStatus}}", body: "Version 2: 404"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeTemplate(tc.contents)
+			w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusNotFound || w.Body.String() != tc.body {
+				t.Errorf("Expected status %d with body %#v, got status %d and %#v", http.StatusNotFound, tc.body, w.Code, w.Body.String())
+			}
+
+			// JSON responses don't make use of templates.
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, url, nil))
+			if code != http.StatusNotFound || response.Message != "Object not found" {
+				t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+			}
+		})
+	}
+
+	t.Run("SyntaxError", func(t *testing.T) {
+		writeTemplate("{{if}}")
+		var b bytes.Buffer
+		err := ts.BrowserService.templates.ExecuteTemplate(&b, "page_error.html", nil)
+		if err == nil || !strings.HasPrefix(status.Convert(err).Message(), "Failed to parse HTML templates: ") {
+			t.Errorf("Expected a parse error, got %v", err)
+		}
+		if b.Len() != 0 {
+			t.Errorf("Expected no output, got %#v", b.String())
+		}
+	})
+
+	t.Run("Embedded", func(t *testing.T) {
+		// The templates that are embedded into the binary should
+		// render identically when reloaded.
+		templatesFSDirectory, err := fs.Sub(templatesFS, "templates")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.BrowserService.templates = NewReloadingTemplateExecutor(templatesFSDirectory, newTemplateFuncMap())
+		reloaded := ts.serve(httptest.NewRequest(http.MethodGet, "/", nil))
+		ts.BrowserService.templates = ts.templates
+		embedded := ts.serve(httptest.NewRequest(http.MethodGet, "/", nil))
+		if reloaded.Code != http.StatusOK || reloaded.Body.String() != embedded.Body.String() {
+			t.Errorf("Expected the welcome page to be rendered identically, got status %d", reloaded.Code)
+		}
+	})
+}
+
+func TestHandleActionReport(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{
+		Arguments:            []string{"cc", "-o", "hello", "hello.c"},
+		EnvironmentVariables: []*remoteexecution.Command_EnvironmentVariable{{Name: "PATH", Value: "/bin"}},
+	})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.c", Digest: cas.putBytes([]byte("int main() {}\n")).GetProto()},
+		},
+	})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   commandDigest.GetProto(),
+		InputRootDigest: inputRootDigest.GetProto(),
+		Platform: &remoteexecution.Platform{
+			Properties: []*remoteexecution.Platform_Property{{Name: "OSFamily", Value: "linux"}},
+		},
+	})
+	stdoutDigest := cas.putBytes([]byte("Compilation succeeded\n"))
+	stderrDigest := cas.putBytes(bytes.Repeat([]byte("x"), maximumLogSizeBytes+1))
+	start := time.Unix(1000, 0)
+	ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "hello", Digest: cas.putBytes([]byte("ELF")).GetProto()},
+		},
+		ExitCode:     0,
+		StdoutDigest: stdoutDigest.GetProto(),
+		StderrDigest: stderrDigest.GetProto(),
+		ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+			Worker:                      "worker-1",
+			QueuedTimestamp:             timestamppb.New(start),
+			WorkerCompletedTimestamp:    timestamppb.New(start.Add(time.Second)),
+			ExecutionStartTimestamp:     timestamppb.New(start),
+			ExecutionCompletedTimestamp: timestamppb.New(start.Add(time.Second)),
+		},
+	})
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	urlPattern := regexp.MustCompile(`(?:src|href|action)=["']?([^"' >]*)`)
+
+	for name, tc := range map[string]struct {
+		query    string
+		contains []string
+	}{
+		"Default": {
+			query: "?format=report",
+			contains: []string{
+				`<h1 class="my-4">Action report</h1>`,
+				"OSFamily=&#34;linux&#34;",
+				"hello.c",
+				"<b>PATH</b>=/bin",
+				`<div class="term-container">Compilation succeeded`,
+				fmt.Sprintf("This log file is too large to be included in this report (%d bytes).", maximumLogSizeBytes+1),
+				"worker-1",
+				"<td style=\"word-break: break-all\">hello</td>",
+			},
+		},
+		"TimeZone": {
+			query:    "?format=report&tz=UTC",
+			contains: []string{"<b>1970-01-01T00:16:40"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, tc.query), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action_report.html" {
+				t.Fatalf("Expected page_action_report.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			if disposition, expected := w.Header().Get("Content-Disposition"), fmt.Sprintf("attachment; filename=\"action-%s.html\"", actionDigest.GetHashString()); disposition != expected {
+				t.Errorf("Expected Content-Disposition %#v, got %#v", expected, disposition)
+			}
+			body := w.Body.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected report to contain %#v", s)
+				}
+			}
+
+			// The report must be self-contained, meaning
+			// that it may only refer to inline resources.
+			for _, match := range urlPattern.FindAllStringSubmatch(body, -1) {
+				if !strings.HasPrefix(match[1], "data:") {
+					t.Errorf("Report refers to external resource %#v", match[1])
+				}
+			}
+			if strings.Contains(body, "<link rel=\"stylesheet\"") || strings.Contains(body, "<script") {
+				t.Error("Report loads external stylesheets or scripts")
+			}
+		})
+	}
+
+	t.Run("LinkedFromAction", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+			t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		if !strings.Contains(w.Body.String(), `<a class="btn btn-primary" href="?format=report" role="button">Download report</a>`) {
+			t.Error("Expected the action page to link to the report")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", missingDigest, "?format=report"), nil))
+		if w.Code != http.StatusNotFound || ts.templates.name != "page_error.html" {
+			t.Fatalf("Expected page_error.html to be rendered with status %d, got status %d and template %#v", http.StatusNotFound, w.Code, ts.templates.name)
+		}
+		if w.Header().Get("Content-Disposition") != "" {
+			t.Error("Errors should not be offered as a download")
+		}
+		code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("action", missingDigest, "?format=report"), nil))
+		if code != http.StatusNotFound || response.Code != "NotFound" {
+			t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+		}
+	})
+}
+
+func TestHandleDirectoryTarballEmptyDirectories(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	emptyDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	nestedDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "b", Digest: emptyDigest.GetProto()},
+		},
+	})
+
+	type tarEntry struct {
+		name     string
+		typeflag byte
+		mode     int64
+	}
+	for name, tc := range map[string]struct {
+		directory *remoteexecution.Directory
+		entries   []tarEntry
+	}{
+		"Empty": {
+			directory: &remoteexecution.Directory{},
+			entries:   []tarEntry{{"./", tar.TypeDir, 0o777}},
+		},
+		"EmptyWithNodeProperties": {
+			directory: &remoteexecution.Directory{
+				NodeProperties: &remoteexecution.NodeProperties{UnixMode: wrapperspb.UInt32(0o700)},
+			},
+			entries: []tarEntry{{"./", tar.TypeDir, 0o700}},
+		},
+		"OnlyEmptySubdirectories": {
+			directory: &remoteexecution.Directory{
+				Directories: []*remoteexecution.DirectoryNode{
+					{Name: "a", Digest: nestedDigest.GetProto()},
+					{Name: "c", Digest: emptyDigest.GetProto()},
+				},
+			},
+			entries: []tarEntry{
+				{"./", tar.TypeDir, 0o777},
+				{"a", tar.TypeDir, 0o777},
+				{"a/b", tar.TypeDir, 0o777},
+				{"c", tar.TypeDir, 0o777},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			directoryDigest := cas.putMessage(t, tc.directory)
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("directory", directoryDigest, "?format=tar&compression=none"), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			var entries []tarEntry
+			tarReader := tar.NewReader(w.Body)
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				entries = append(entries, tarEntry{header.Name, header.Typeflag, header.Mode})
+			}
+			if !reflect.DeepEqual(entries, tc.entries) {
+				t.Errorf("Expected entries %v, got %v", tc.entries, entries)
+			}
+
+			// The directory page should still offer the
+			// tarball for download.
+			w = ts.serve(httptest.NewRequest(http.MethodGet, getURL("directory", directoryDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_directory.html" {
+				t.Fatalf("Expected page_directory.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			if link := fmt.Sprintf(`href="../../directory/%s-%d/?format=tar"`, directoryDigest.GetHashString(), directoryDigest.GetSizeBytes()); !strings.Contains(w.Body.String(), link) {
+				t.Error("Expected the directory page to link to the tarball")
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		// Nothing may be written if the root directory itself
+		// cannot be loaded.
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("directory", missingDigest, "?format=tar&compression=none"), nil))
+		if code != http.StatusNotFound || response.Code != "NotFound" {
+			t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+		}
+	})
+}
+
+func TestRenderErrorJSON(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	notFoundURL := getURL("command", missingDigest, "")
+	invalidArgumentURL := "/blobs/sha256/command/" + strings.Repeat("0", 64) + "-abc/"
+
+	for name, tc := range map[string]struct {
+		url      string
+		accept   string
+		json     bool
+		expected jsonError
+	}{
+		"NotFoundAcceptHeader": {
+			url:      notFoundURL,
+			accept:   "application/json",
+			json:     true,
+			expected: jsonError{Code: "NotFound", Message: "Object not found", HTTPStatus: http.StatusNotFound},
+		},
+		"NotFoundQueryParameter": {
+			url:      notFoundURL + "?format=json",
+			json:     true,
+			expected: jsonError{Code: "NotFound", Message: "Object not found", HTTPStatus: http.StatusNotFound},
+		},
+		"NotFoundHTML": {
+			url:      notFoundURL,
+			accept:   "text/html",
+			expected: jsonError{Code: "NotFound", Message: "Object not found", HTTPStatus: http.StatusNotFound},
+		},
+		"InvalidArgumentMultipleMediaTypes": {
+			url:      invalidArgumentURL,
+			accept:   "text/html;q=0.9, application/json; charset=utf-8",
+			json:     true,
+			expected: jsonError{Code: "InvalidArgument", Message: "Invalid blob size \"abc\": strconv.ParseInt: parsing \"abc\": invalid syntax", HTTPStatus: http.StatusBadRequest},
+		},
+		"InvalidArgumentHTML": {
+			url:      invalidArgumentURL,
+			expected: jsonError{Code: "InvalidArgument", Message: "Invalid blob size \"abc\": strconv.ParseInt: parsing \"abc\": invalid syntax", HTTPStatus: http.StatusBadRequest},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts.templates.name = ""
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			if json := acceptsJSON(req); json != tc.json {
+				t.Fatalf("Expected JSON to be accepted: %v, got %v", tc.json, json)
+			}
+			w := ts.serve(req)
+			if w.Code != tc.expected.HTTPStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expected.HTTPStatus, w.Code)
+			}
+			if nosniff := w.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+				t.Errorf("Expected X-Content-Type-Options \"nosniff\", got %#v", nosniff)
+			}
+			if tc.json {
+				if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+					t.Fatalf("Expected content type \"application/json\", got %#v", contentType)
+				}
+				if ts.templates.name != "" {
+					t.Errorf("Expected no template to be rendered, got %#v", ts.templates.name)
+				}
+				var response jsonError
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				if response != tc.expected {
+					t.Errorf("Expected error %#v, got %#v", tc.expected, response)
+				}
+				return
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+				t.Fatalf("Expected content type \"text/html; charset=utf-8\", got %#v", contentType)
+			}
+			if ts.templates.name != "page_error.html" {
+				t.Fatalf("Expected template \"page_error.html\", got %#v", ts.templates.name)
+			}
+			body := w.Body.String()
+			for _, s := range []string{
+				fmt.Sprintf(`<h1 class="my-4">Error: %s</h1>`, tc.expected.Code),
+				fmt.Sprintf(`<p class="text-muted">HTTP %d %s</p>`, tc.expected.HTTPStatus, http.StatusText(tc.expected.HTTPStatus)),
+				"<p>" + template.HTMLEscapeString(tc.expected.Message) + "</p>",
+			} {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+		})
+	}
+}
+
+// flushCountingResponseWriter is an http.ResponseWriter that records
+// the response body at the time it is flushed.
+type flushCountingResponseWriter struct {
+	*httptest.ResponseRecorder
+	flushedBodies []string
+}
+
+func (w *flushCountingResponseWriter) Flush() {
+	w.flushedBodies = append(w.flushedBodies, w.Body.String())
+}
+
+func TestHandleDirectoryStreamed(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	fileDigest := cas.putBytes([]byte("Hello"))
+	newDirectory := func(filesCount int) *remoteexecution.Directory {
+		directory := &remoteexecution.Directory{
+			Directories: []*remoteexecution.DirectoryNode{
+				{Name: "subdirectory", Digest: cas.putMessage(t, &remoteexecution.Directory{}).GetProto()},
+			},
+			Symlinks: []*remoteexecution.SymlinkNode{
+				{Name: "symlink", Target: "target"},
+			},
+		}
+		for i := 0; i < filesCount; i++ {
+			directory.Files = append(directory.Files, &remoteexecution.FileNode{
+				Name:   fmt.Sprintf("file%05d", i),
+				Digest: fileDigest.GetProto(),
+			})
+		}
+		return directory
+	}
+
+	for name, tc := range map[string]struct {
+		filesCount int
+		template   string
+		flushes    int
+	}{
+		"Small": {
+			filesCount: 10,
+			template:   "page_directory.html",
+		},
+		"BelowThreshold": {
+			filesCount: streamedDirectoryMinimumEntriesCount - 3,
+			template:   "page_directory.html",
+		},
+		"AtThreshold": {
+			filesCount: streamedDirectoryMinimumEntriesCount - 2,
+			template:   "directory_streamed_footer",
+			flushes:    1 + streamedDirectoryMinimumEntriesCount/streamedDirectoryFlushInterval,
+		},
+		"Large": {
+			filesCount: 3*streamedDirectoryMinimumEntriesCount - 2,
+			template:   "directory_streamed_footer",
+			flushes:    1 + 3*streamedDirectoryMinimumEntriesCount/streamedDirectoryFlushInterval,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			directory := newDirectory(tc.filesCount)
+			directoryDigest := cas.putMessage(t, directory)
+			w := &flushCountingResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+			ts.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getURL("directory", directoryDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != tc.template {
+				t.Fatalf("Expected %s to be rendered, got status %d and template %#v", tc.template, w.Code, ts.templates.name)
+			}
+			if len(w.flushedBodies) != tc.flushes {
+				t.Errorf("Expected %d flushes, got %d", tc.flushes, len(w.flushedBodies))
+			}
+
+			// The header and the link for downloading the
+			// tarball should be flushed before any entries.
+			tarballLink := fmt.Sprintf(`href="../../directory/%s-%d/?format=tar"`, directoryDigest.GetHashString(), directoryDigest.GetSizeBytes())
+			if tc.flushes > 0 {
+				if first := w.flushedBodies[0]; !strings.Contains(first, tarballLink) || strings.Contains(first, "subdirectory") {
+					t.Error("Expected the first flush to only contain the header")
+				}
+				for i := 1; i < len(w.flushedBodies); i++ {
+					if len(w.flushedBodies[i]) <= len(w.flushedBodies[i-1]) {
+						t.Errorf("Flush %d did not contain any new entries", i)
+					}
+				}
+			}
+
+			body := w.Body.String()
+			for _, s := range []string{
+				tarballLink,
+				">subdirectory</a>/",
+				"symlink -&gt; <span style=\"word-break: break-all\">target</span>",
+				fmt.Sprintf(`>file%05d</a>`, tc.filesCount-1),
+				"</table>",
+			} {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+
+			// JSON responses contain the full Directory
+			// message, regardless of its size.
+			var got remoteexecution.Directory
+			if code := ts.serveProtoJSON(t, getURL("directory", directoryDigest, ""), &got); code != http.StatusOK || len(got.Files) != tc.filesCount {
+				t.Errorf("Expected directory with %d files, got status %d and %d files", tc.filesCount, code, len(got.Files))
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("directory", missingDigest, ""), nil))
+		if code != http.StatusNotFound || response.Code != "NotFound" {
+			t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+		}
+	})
+}
+
+func TestAllowedInstanceNames(t *testing.T) {
+	command, err := proto.Marshal(&remoteexecution.Command{Arguments: []string{"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedInstanceNames := digest.NewInstanceNameTrie()
+	allowedInstanceNames.Set(digest.MustNewInstanceName("allowed"), 0)
+	allowedInstanceNames.Set(digest.EmptyInstanceName, 0)
+
+	for name, tc := range map[string]struct {
+		allowedInstanceNames *digest.InstanceNameTrie
+		instanceName         string
+		code                 int
+		message              string
+	}{
+		"UnsetAllowsAll":   {instanceName: "other", code: http.StatusOK},
+		"UnsetAllowsEmpty": {instanceName: "", code: http.StatusOK},
+		"Allowed":          {allowedInstanceNames: allowedInstanceNames, instanceName: "allowed", code: http.StatusOK},
+		"AllowedEmpty":     {allowedInstanceNames: allowedInstanceNames, instanceName: "", code: http.StatusOK},
+		"Disallowed":       {allowedInstanceNames: allowedInstanceNames, instanceName: "other", code: http.StatusForbidden, message: "Instance name \"other\" may not be browsed"},
+		"DisallowedChild":  {allowedInstanceNames: allowedInstanceNames, instanceName: "allowed/child", code: http.StatusForbidden, message: "Instance name \"allowed/child\" may not be browsed"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestBrowserService(BrowserServiceOptions{AllowedInstanceNames: tc.allowedInstanceNames})
+			commandDigest := ts.contentAddressableStorage.putBytesWithInstanceName(tc.instanceName, command)
+			url := getURL("command", commandDigest, "")
+			if tc.instanceName != "" {
+				url = "/" + tc.instanceName + url
+			}
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d", tc.code, w.Code)
+			}
+			if tc.code == http.StatusOK {
+				if ts.templates.name != "page_command.html" {
+					t.Errorf("Expected template \"page_command.html\", got %#v", ts.templates.name)
+				}
+				var got remoteexecution.Command
+				if code := ts.serveProtoJSON(t, url, &got); code != http.StatusOK || len(got.Arguments) != 1 {
+					t.Errorf("Expected command to be returned, got status %d and %v", code, &got)
+				}
+				return
+			}
+
+			if ts.templates.name != "page_error.html" || !strings.Contains(w.Body.String(), template.HTMLEscapeString(tc.message)) {
+				t.Errorf("Expected page_error.html containing %#v, got template %#v", tc.message, ts.templates.name)
+			}
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, url, nil))
+			if code != tc.code || response.Code != "PermissionDenied" || response.Message != tc.message {
+				t.Errorf("Expected PermissionDenied error %#v, got status %d and %#v", tc.message, code, response)
+			}
+			if getCalls := ts.contentAddressableStorage.getCalls; getCalls != 0 {
+				t.Errorf("Expected storage not to be accessed, got %d calls", getCalls)
+			}
+		})
+	}
+}
+
+func TestContainerImage(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+
+	for name, tc := range map[string]struct {
+		properties []*remoteexecution.Platform_Property
+		image      *containerImageInfo
+		contains   []string
+	}{
+		"RegistryWithPortAndTag": {
+			properties: []*remoteexecution.Platform_Property{
+				{Name: "OSFamily", Value: "Linux"},
+				{Name: "container-image", Value: "docker://registry.example.com:5000/org/image:tag"},
+			},
+			image: &containerImageInfo{
+				Reference:  "docker://registry.example.com:5000/org/image:tag",
+				Registry:   "registry.example.com:5000",
+				Repository: "org/image",
+				Tag:        "tag",
+			},
+			contains: []string{
+				"<b>docker://registry.example.com:5000/org/image:tag</b>",
+				"registry=registry.example.com:5000",
+				"repository=org/image",
+				"tag=tag",
+			},
+		},
+		"DockerHubWithDigest": {
+			properties: []*remoteexecution.Platform_Property{
+				{Name: "container_image", Value: "ubuntu@sha256:0123abcd"},
+			},
+			image: &containerImageInfo{
+				Reference:  "ubuntu@sha256:0123abcd",
+				Repository: "ubuntu",
+				Digest:     "sha256:0123abcd",
+			},
+			contains: []string{
+				"<b>ubuntu@sha256:0123abcd</b>",
+				"repository=ubuntu",
+				"digest=sha256:0123abcd",
+			},
+		},
+		"Localhost": {
+			properties: []*remoteexecution.Platform_Property{
+				{Name: "docker-image", Value: "localhost/image"},
+			},
+			image: &containerImageInfo{
+				Reference:  "localhost/image",
+				Registry:   "localhost",
+				Repository: "image",
+			},
+			contains: []string{"registry=localhost", "repository=image"},
+		},
+		"PreferredPropertyName": {
+			properties: []*remoteexecution.Platform_Property{
+				{Name: "image", Value: "other"},
+				{Name: "container-image", Value: "preferred"},
+			},
+			image: &containerImageInfo{
+				Reference:  "preferred",
+				Repository: "preferred",
+			},
+			contains: []string{"<b>preferred</b>"},
+		},
+		"EmptyValue": {
+			properties: []*remoteexecution.Platform_Property{
+				{Name: "container-image", Value: ""},
+			},
+		},
+		"NoContainerImage": {
+			properties: []*remoteexecution.Platform_Property{
+				{Name: "OSFamily", Value: "Linux"},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			platform := &remoteexecution.Platform{Properties: tc.properties}
+			if image := getContainerImage(platform); !reflect.DeepEqual(image, tc.image) {
+				t.Errorf("Expected container image %#v, got %#v", tc.image, image)
+			}
+
+			commandDigest := cas.putMessage(t, &remoteexecution.Command{
+				Arguments: []string{"true"},
+				Platform:  platform,
+			})
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest:   commandDigest.GetProto(),
+				InputRootDigest: inputRootDigest.GetProto(),
+				Platform:        platform,
+			})
+			for _, page := range []struct {
+				url      string
+				template string
+			}{
+				{getURL("action", actionDigest, ""), "page_action.html"},
+				{getURL("command", commandDigest, ""), "page_command.html"},
+			} {
+				w := ts.serve(httptest.NewRequest(http.MethodGet, page.url, nil))
+				if w.Code != http.StatusOK || ts.templates.name != page.template {
+					t.Fatalf("Expected %s to be rendered, got status %d and template %#v", page.template, w.Code, ts.templates.name)
+				}
+				body := w.Body.String()
+				if tc.image == nil {
+					if strings.Contains(body, "Container image:") {
+						t.Errorf("Expected %s not to display a container image", page.template)
+					}
+					continue
+				}
+				for _, s := range append([]string{"Container image:"}, tc.contains...) {
+					if !strings.Contains(body, s) {
+						t.Errorf("Expected %s to contain %#v", page.template, s)
+					}
+				}
+			}
+
+			// The platform properties remain part of the
+			// command returned as JSON.
+			var got remoteexecution.Command
+			if code := ts.serveProtoJSON(t, getURL("command", commandDigest, ""), &got); code != http.StatusOK || !proto.Equal(got.Platform, platform) {
+				t.Errorf("Expected command with platform %v, got status %d and %v", platform, code, &got)
+			}
+		})
+	}
+
+	t.Run("MissingCommand", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("command", missingDigest, ""), nil))
+		if code != http.StatusNotFound || response.Code != "NotFound" {
+			t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+		}
+	})
+}
+
+// blockingBlobAccess is a BlobAccess that blocks reads of a single blob
+// until the context of the read is cancelled, so that tests can
+// observe operations that are in flight.
+type blockingBlobAccess struct {
+	*fakeBlobAccess
+	blockedDigest digest.Digest
+	blocked       chan struct{}
+}
+
+func (ba *blockingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if blobDigest != ba.blockedDigest {
+		return ba.fakeBlobAccess.Get(ctx, blobDigest)
+	}
+	close(ba.blocked)
+	<-ctx.Done()
+	return buffer.NewBufferFromError(status.FromContextError(ctx.Err()).Err())
+}
+
+func TestTarballGenerationCancellation(t *testing.T) {
+	allowAuthorizer := auth.NewStaticAuthorizer(func(digest.InstanceName) bool { return true })
+
+	t.Run("ListAndCancel", func(t *testing.T) {
+		startTime := time.Unix(1700000000, 0).UTC()
+		ts := newTestBrowserService(BrowserServiceOptions{
+			ListTarballGenerationsAuthorizer:  allowAuthorizer,
+			CancelTarballGenerationAuthorizer: allowAuthorizer,
+			Clock:                             &fakeClock{now: startTime},
+		})
+		cas := ts.contentAddressableStorage
+		fileDigest := cas.putBytes([]byte("Hello"))
+		rootDigest := cas.putMessage(t, &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{Name: "hello.txt", Digest: fileDigest.GetProto()},
+			},
+		})
+		blockingCAS := &blockingBlobAccess{
+			fakeBlobAccess: cas,
+			blockedDigest:  fileDigest,
+			blocked:        make(chan struct{}),
+		}
+		ts.BrowserService.contentAddressableStorage = blockingCAS
+
+		listTarballGenerations := func() []activeTarballGeneration {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, "/admin/tarballs/", nil))
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("Expected JSON with status %d, got status %d and content type %#v", http.StatusOK, w.Code, w.Header().Get("Content-Type"))
+			}
+			var generations []activeTarballGeneration
+			if err := json.Unmarshal(w.Body.Bytes(), &generations); err != nil {
+				t.Fatal(err)
+			}
+			return generations
+		}
+		if generations := listTarballGenerations(); len(generations) != 0 {
+			t.Fatalf("Expected no tarball generations, got %#v", generations)
+		}
+
+		// Start generating a tarball, which blocks while
+		// reading the contents of the file.
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, getURL("directory", rootDigest, "?format=tar&compression=none"), nil)
+			req.Header.Set("TE", "trailers")
+			done <- ts.serve(req)
+		}()
+		<-blockingCAS.blocked
+
+		generations := listTarballGenerations()
+		if len(generations) != 1 {
+			t.Fatalf("Expected a single tarball generation, got %#v", generations)
+		}
+		generation := generations[0]
+		if generation.ID == 0 || generation.InstanceName != "" || generation.Digest != rootDigest.String() || generation.Client == "" || !generation.StartTime.Equal(startTime) {
+			t.Errorf("Unexpected tarball generation %#v", generation)
+		}
+
+		w := ts.serve(httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/tarballs/%d/cancel", generation.ID), nil))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+		}
+		select {
+		case w := <-done:
+			if status := w.Result().Trailer.Get(tarballStatusTrailer); status != "incomplete" {
+				t.Errorf("Expected tarball status \"incomplete\", got %#v", status)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Tarball generation did not stop after being cancelled")
+		}
+		if generations := listTarballGenerations(); len(generations) != 0 {
+			t.Errorf("Expected no tarball generations after cancellation, got %#v", generations)
+		}
+	})
+
+	t.Run("CancelErrors", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{
+			CancelTarballGenerationAuthorizer: allowAuthorizer,
+		})
+		for name, tc := range map[string]struct {
+			id         string
+			code       int
+			statusCode string
+			message    string
+		}{
+			"InvalidID": {id: "abc", code: http.StatusBadRequest, statusCode: "InvalidArgument", message: "Invalid tarball generation ID \"abc\""},
+			"UnknownID": {id: "42", code: http.StatusNotFound, statusCode: "NotFound", message: "Tarball generation 42 not found"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectRequestError(t, func() *http.Request {
+					return httptest.NewRequest(http.MethodPost, "/admin/tarballs/"+tc.id+"/cancel", nil)
+				}, tc.code, tc.statusCode, tc.message)
+			})
+		}
+	})
+}
+
+func TestPermalink(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	blobDigest := digest.MustNewDigest("hello/world", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	canonical := fmt.Sprintf("hello/world/blobs/sha256/%%s/%s-123/", strings.Repeat("0", 64))
+
+	t.Run("Endpoint", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			pageType string
+			suffix   string
+			header   map[string]string
+			expected string
+		}{
+			"Action":                    {pageType: "action", expected: "http://example.com/" + fmt.Sprintf(canonical, "action")},
+			"Command":                   {pageType: "command", expected: "http://example.com/" + fmt.Sprintf(canonical, "command")},
+			"Directory":                 {pageType: "directory", expected: "http://example.com/" + fmt.Sprintf(canonical, "directory")},
+			"File":                      {pageType: "file", suffix: "hello%20world.txt", expected: "http://example.com/" + fmt.Sprintf(canonical, "file") + "hello%20world.txt"},
+			"HistoricalExecuteResponse": {pageType: "historical_execute_response", expected: "http://example.com/" + fmt.Sprintf(canonical, "historical_execute_response")},
+			"Log":                       {pageType: "log", expected: "http://example.com/" + fmt.Sprintf(canonical, "log")},
+			"Tree":                      {pageType: "tree", expected: "http://example.com/" + fmt.Sprintf(canonical, "tree")},
+			"TreeSubdirectory":          {pageType: "tree", suffix: "a/b%20c/", expected: "http://example.com/" + fmt.Sprintf(canonical, "tree") + "a/b%20c/"},
+			"Forwarded": {
+				pageType: "command",
+				header: map[string]string{
+					"X-Forwarded-Proto":  "https",
+					"X-Forwarded-Host":   "browser.example.com",
+					"X-Forwarded-Prefix": "/proxy/",
+				},
+				expected: "https://browser.example.com/proxy/" + fmt.Sprintf(canonical, "command"),
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				newRequest := func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "/permalink/hello/world"+getURL(tc.pageType, blobDigest, tc.suffix), nil)
+					for key, value := range tc.header {
+						req.Header.Set(key, value)
+					}
+					return req
+				}
+
+				w := ts.serve(newRequest())
+				if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+					t.Fatalf("Expected plain text with status %d, got status %d and content type %#v", http.StatusOK, w.Code, w.Header().Get("Content-Type"))
+				}
+				if body := w.Body.String(); body != tc.expected+"\n" {
+					t.Errorf("Expected permalink %#v, got %#v", tc.expected+"\n", body)
+				}
+
+				req := newRequest()
+				req.Header.Set("Accept", "application/json")
+				w = ts.serve(req)
+				var response struct {
+					URL string `json:"url"`
+				}
+				if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+					t.Fatalf("Expected JSON with status %d, got status %d and content type %#v", http.StatusOK, w.Code, w.Header().Get("Content-Type"))
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				if response.URL != tc.expected {
+					t.Errorf("Expected permalink %#v, got %#v", tc.expected, response.URL)
+				}
+			})
+		}
+	})
+
+	t.Run("EndpointErrors", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			url     string
+			message string
+		}{
+			"UnknownPageType":  {url: getURL("blob", blobDigest, ""), message: "Unknown page type \"blob\""},
+			"UnexpectedSuffix": {url: getURL("command", blobDigest, "hello.txt"), message: "Pages of type \"command\" do not accept a suffix"},
+			"MissingFilename":  {url: getURL("file", blobDigest, ""), message: "Pages of type \"file\" require a filename"},
+			"InvalidDigest":    {url: "/blobs/sha256/command/" + strings.Repeat("g", 64) + "-123/", message: "Non-hexadecimal character in digest hash"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectError(t, "/permalink"+tc.url, http.StatusBadRequest, "InvalidArgument", tc.message)
+			})
+		}
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		cas := ts.contentAddressableStorage
+		commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+		inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+		actionDigest := cas.putMessage(t, &remoteexecution.Action{
+			CommandDigest:   commandDigest.GetProto(),
+			InputRootDigest: inputRootDigest.GetProto(),
+		})
+		treeDigest := cas.putMessage(t, &remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Directories: []*remoteexecution.DirectoryNode{
+					{Name: "sub dir", Digest: inputRootDigest.GetProto()},
+				},
+			},
+			Children: []*remoteexecution.Directory{{}},
+		})
+		logDigest := cas.putBytes([]byte("Hello\n"))
+		permalink := func(pageType string, blobDigest digest.Digest, suffix string) string {
+			return "http://example.com" + getURL(pageType, blobDigest, suffix)
+		}
+
+		for name, tc := range map[string]struct {
+			url        string
+			template   string
+			permalinks map[string]string
+		}{
+			"Action": {
+				url:      getURL("action", actionDigest, ""),
+				template: "page_action.html",
+				permalinks: map[string]string{
+					"Permalink":           permalink("action", actionDigest, ""),
+					"Command.Permalink":   permalink("command", commandDigest, ""),
+					"InputRoot.Permalink": permalink("directory", inputRootDigest, ""),
+				},
+			},
+			"Command": {
+				url:        getURL("command", commandDigest, ""),
+				template:   "page_command.html",
+				permalinks: map[string]string{"Permalink": permalink("command", commandDigest, "")},
+			},
+			"Directory": {
+				url:        getURL("directory", inputRootDigest, ""),
+				template:   "page_directory.html",
+				permalinks: map[string]string{"Permalink": permalink("directory", inputRootDigest, "")},
+			},
+			"Log": {
+				url:        getURL("log", logDigest, ""),
+				template:   "page_log.html",
+				permalinks: map[string]string{"Permalink": permalink("log", logDigest, "")},
+			},
+			"TreeSubdirectory": {
+				url:        getURL("tree", treeDigest, "sub%20dir/"),
+				template:   "page_tree.html",
+				permalinks: map[string]string{"Permalink": permalink("tree", treeDigest, "sub%20dir/")},
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				w := ts.serve(httptest.NewRequest(http.MethodGet, tc.url, nil))
+				if w.Code != http.StatusOK || ts.templates.name != tc.template {
+					t.Fatalf("Expected %s to be rendered, got status %d and template %#v", tc.template, w.Code, ts.templates.name)
+				}
+				if !strings.Contains(w.Body.String(), "Copy permalink to clipboard") {
+					t.Error("Expected page to contain a button for copying the permalink")
+				}
+				for field, expected := range tc.permalinks {
+					value := reflect.Indirect(reflect.ValueOf(ts.templates.data))
+					for _, name := range strings.Split(field, ".") {
+						value = reflect.Indirect(value.FieldByName(name))
+					}
+					if got := value.String(); got != expected {
+						t.Errorf("Expected %s %#v, got %#v", field, expected, got)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestHandleBatchDownload(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	helloDigest := cas.putBytes([]byte("Hello"))
+	worldDigest := cas.putBytes([]byte("World"))
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	brokenDigest := cas.putBytes([]byte("Broken"))
+	cas.errors[brokenDigest] = status.Error(codes.Internal, "Storage failure")
+	batchDownloadURL := "/blobs/sha256/batch_download/?compression=none"
+	blobName := func(blobDigest digest.Digest) string {
+		return fmt.Sprintf("%s-%d", blobDigest.GetHashString(), blobDigest.GetSizeBytes())
+	}
+	newRequest := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, batchDownloadURL, strings.NewReader(body))
+		req.Header.Set("TE", "trailers")
+		return req
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		w := ts.serve(newRequest(fmt.Sprintf(
+			`{"digests": [{"hash": %q, "sizeBytes": 5}, {"hash": %q, "sizeBytes": 123}, {"hash": %q, "sizeBytes": 5}]}`,
+			helloDigest.GetHashString(), missingDigest.GetHashString(), worldDigest.GetHashString())))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if status := w.Result().Trailer.Get(tarballStatusTrailer); status != "complete" {
+			t.Errorf("Expected tarball status \"complete\", got %#v", status)
+		}
+
+		entries := map[string]string{}
+		var names []string
+		tarReader := tar.NewReader(w.Body)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(tarReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, header.Name)
+			entries[header.Name] = string(data)
+		}
+		if len(names) != 3 || names[0] != "manifest.json" {
+			t.Fatalf("Expected the manifest followed by two blobs, got %#v", names)
+		}
+		for blobDigest, expected := range map[digest.Digest]string{helloDigest: "Hello", worldDigest: "World"} {
+			if got := entries[blobName(blobDigest)]; got != expected {
+				t.Errorf("Expected blob %s to contain %#v, got %#v", blobName(blobDigest), expected, got)
+			}
+		}
+
+		var manifest struct {
+			Blobs        []jsonDigest `json:"blobs"`
+			MissingBlobs []jsonDigest `json:"missingBlobs"`
+		}
+		if err := json.Unmarshal([]byte(entries["manifest.json"]), &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Blobs) != 2 {
+			t.Errorf("Expected two blobs in the manifest, got %#v", manifest.Blobs)
+		}
+		if expected := []jsonDigest{newJSONDigest(missingDigest)}; !reflect.DeepEqual(manifest.MissingBlobs, expected) {
+			t.Errorf("Expected missing blobs %#v, got %#v", expected, manifest.MissingBlobs)
+		}
+	})
+
+	t.Run("ReadFailure", func(t *testing.T) {
+		// Blobs that are reported as present, but fail to be
+		// read cause the tarball to be marked as incomplete.
+		w := ts.serve(newRequest(fmt.Sprintf(`{"digests": [{"hash": %q, "sizeBytes": 6}]}`, brokenDigest.GetHashString())))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if status := w.Result().Trailer.Get(tarballStatusTrailer); status != "incomplete" {
+			t.Errorf("Expected tarball status \"incomplete\", got %#v", status)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tooManyDigests := make([]string, maximumBatchDownloadBlobsCount+1)
+		for i := range tooManyDigests {
+			tooManyDigests[i] = fmt.Sprintf(`{"hash": %q, "sizeBytes": 5}`, helloDigest.GetHashString())
+		}
+		for name, tc := range map[string]struct {
+			url     string
+			body    string
+			message string
+		}{
+			"MalformedJSON": {
+				url:     batchDownloadURL,
+				body:    "{",
+				message: "Failed to parse request",
+			},
+			"InvalidDigest": {
+				url:     batchDownloadURL,
+				body:    fmt.Sprintf(`{"digests": [{"hash": %q, "sizeBytes": 5}]}`, strings.Repeat("g", 64)),
+				message: fmt.Sprintf("Invalid digest \"%s-5\": Non-hexadecimal character in digest hash", strings.Repeat("g", 64)),
+			},
+			"TooManyDigests": {
+				url:     batchDownloadURL,
+				body:    `{"digests": [` + strings.Join(tooManyDigests, ", ") + `]}`,
+				message: fmt.Sprintf("Request contains %d digests, while at most %d digests may be downloaded at once", maximumBatchDownloadBlobsCount+1, maximumBatchDownloadBlobsCount),
+			},
+			"UnknownDigestFunction": {
+				url:     "/blobs/sha123/batch_download/",
+				body:    `{"digests": []}`,
+				message: "Unknown digest function \"sha123\"",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectRequestError(t, func() *http.Request {
+					return httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body))
+				}, http.StatusBadRequest, "InvalidArgument", tc.message)
+			})
+		}
+	})
+}
+
+func TestHandleActionTimeLocation(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   commandDigest.GetProto(),
+		InputRootDigest: inputRootDigest.GetProto(),
+	})
+	queuedTimestamp := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	actionResult := &remoteexecution.ActionResult{
+		ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+			QueuedTimestamp:      timestamppb.New(queuedTimestamp),
+			WorkerStartTimestamp: timestamppb.New(queuedTimestamp.Add(1500 * time.Millisecond)),
+		},
+	}
+	ts.putActionResult(t, actionDigest, actionResult)
+
+	for name, tc := range map[string]struct {
+		query    string
+		cookie   string
+		location string
+		error    string
+		contains []string
+	}{
+		"Default": {
+			location: "UTC",
+			contains: []string{"<b>2023-07-01T12:00:00Z</b>", "<b>2023-07-01T12:00:01.5Z</b>"},
+		},
+		"QueryParameter": {
+			query:    "?tz=Europe/Amsterdam",
+			location: "Europe/Amsterdam",
+			contains: []string{"<b>2023-07-01T14:00:00&#43;02:00</b>", "<b>2023-07-01T14:00:01.5&#43;02:00</b>", "⏱ +1.5s"},
+		},
+		"Cookie": {
+			cookie:   "Asia/Tokyo",
+			location: "Asia/Tokyo",
+			contains: []string{"<b>2023-07-01T21:00:00&#43;09:00</b>"},
+		},
+		"QueryParameterPrecedence": {
+			query:    "?tz=Europe/Amsterdam",
+			cookie:   "Asia/Tokyo",
+			location: "Europe/Amsterdam",
+			contains: []string{"<b>2023-07-01T14:00:00&#43;02:00</b>"},
+		},
+		"InvalidTimeZone": {
+			query:    "?tz=Mars/Olympus_Mons",
+			location: "UTC",
+			error:    "Unknown time zone \"Mars/Olympus_Mons\"",
+			contains: []string{
+				"<span class=\"text-danger\">Unknown time zone &#34;Mars/Olympus_Mons&#34;. Displaying timestamps in UTC instead.</span>",
+				"<b>2023-07-01T12:00:00Z</b>",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, format := range []struct {
+				suffix   string
+				template string
+			}{
+				{"", "page_action.html"},
+				{"format=report", "page_action_report.html"},
+			} {
+				query := tc.query
+				if format.suffix != "" {
+					if query == "" {
+						query = "?" + format.suffix
+					} else {
+						query += "&" + format.suffix
+					}
+				}
+				req := httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, query), nil)
+				if tc.cookie != "" {
+					req.AddCookie(&http.Cookie{Name: timeLocationCookie, Value: tc.cookie})
+				}
+				w := ts.serve(req)
+				if w.Code != http.StatusOK || ts.templates.name != format.template {
+					t.Fatalf("Expected %s to be rendered, got status %d and template %#v", format.template, w.Code, ts.templates.name)
+				}
+				data := reflect.ValueOf(ts.templates.data)
+				if location := data.FieldByName("TimeLocation").Interface().(*time.Location).String(); location != tc.location {
+					t.Errorf("Expected time zone %#v, got %#v", tc.location, location)
+				}
+				if timeLocationError := data.FieldByName("TimeLocationError").String(); timeLocationError != tc.error {
+					t.Errorf("Expected time zone error %#v, got %#v", tc.error, timeLocationError)
+				}
+				body := w.Body.String()
+				for _, s := range tc.contains {
+					if !strings.Contains(body, s) {
+						t.Errorf("Expected %s to contain %#v", format.template, s)
+					}
+				}
+				if tc.error == "" && strings.Contains(body, "Displaying timestamps in UTC instead.") {
+					t.Errorf("Expected %s not to contain a time zone error", format.template)
+				}
+			}
+
+			// Timestamps in the JSON representation of the
+			// action result are not affected by the time zone.
+			var got remoteexecution.ActionResult
+			if code := ts.serveProtoJSON(t, getURL("action", actionDigest, tc.query), &got); code != http.StatusOK || !proto.Equal(&got, actionResult) {
+				t.Errorf("Expected action result %v, got status %d and %v", actionResult, code, &got)
+			}
+		})
+	}
+}
+
+func TestResolveOutputSymlinkTarget(t *testing.T) {
+	for name, tc := range map[string]struct {
+		symlinkPath string
+		target      string
+		path        string
+		ok          bool
+	}{
+		"Sibling":           {symlinkPath: "bin/link", target: "file", path: "bin/file", ok: true},
+		"CurrentDirectory":  {symlinkPath: "bin/link", target: "./dir/file", path: "bin/dir/file", ok: true},
+		"ParentDirectory":   {symlinkPath: "bin/sub/link", target: "../file", path: "bin/file", ok: true},
+		"TopLevel":          {symlinkPath: "link", target: "file", path: "file", ok: true},
+		"RedundantSlashes":  {symlinkPath: "bin/link", target: "dir//file", path: "bin/dir/file", ok: true},
+		"Absolute":          {symlinkPath: "bin/link", target: "/etc/passwd"},
+		"EscapesWorkingDir": {symlinkPath: "bin/link", target: "../../etc/passwd"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path, ok := resolveOutputSymlinkTarget(tc.symlinkPath, tc.target)
+			if path != tc.path || ok != tc.ok {
+				t.Errorf("Expected (%#v, %v), got (%#v, %v)", tc.path, tc.ok, path, ok)
+			}
+		})
+	}
+}
+
+func TestHandleActionOutputSymlinks(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	fileDigest := cas.putBytes([]byte("Hello"))
+	treeDigest := cas.putMessage(t, &remoteexecution.Tree{Root: &remoteexecution.Directory{}})
+	fileURL := fmt.Sprintf("../../file/%s-5/lib%%20a.so", fileDigest.GetHashString())
+	treeURL := fmt.Sprintf("../../tree/%s-%d/", treeDigest.GetHashString(), treeDigest.GetSizeBytes())
+	outputFiles := []*remoteexecution.OutputFile{
+		{Path: "bin/lib a.so", Digest: fileDigest.GetProto()},
+	}
+	outputDirectories := []*remoteexecution.OutputDirectory{
+		{Path: "bin/dir", TreeDigest: treeDigest.GetProto()},
+	}
+
+	for name, tc := range map[string]struct {
+		actionResult *remoteexecution.ActionResult
+		targetURLs   []string
+		contains     []string
+	}{
+		"OutputSymlinks": {
+			actionResult: &remoteexecution.ActionResult{
+				OutputFiles:       outputFiles,
+				OutputDirectories: outputDirectories,
+				OutputSymlinks: []*remoteexecution.OutputSymlink{
+					{Path: "bin/file_link", Target: "lib a.so"},
+					{Path: "bin/sub/dir_link", Target: "../dir"},
+					{Path: "bin/dangling", Target: "missing.so"},
+					{Path: "bin/outside", Target: "../../etc/passwd"},
+					{Path: "bin/absolute", Target: "/etc/passwd"},
+				},
+			},
+			targetURLs: []string{fileURL, treeURL, "", "", ""},
+			contains: []string{
+				"<span class=\"text-success\">bin/file_link</span> -&gt; <a href=\"" + fileURL + "\">lib a.so</a>",
+				"<span class=\"text-success\">bin/sub/dir_link</span> -&gt; <a href=\"" + treeURL + "\">../dir</a>",
+				"<span class=\"text-success\">bin/dangling</span> -&gt; missing.so</td>",
+				"<span class=\"text-success\">bin/outside</span> -&gt; ../../etc/passwd</td>",
+				"<span class=\"text-success\">bin/absolute</span> -&gt; /etc/passwd</td>",
+			},
+		},
+		"LegacyOutputSymlinks": {
+			// REv2.0 stores symbolic links to directories
+			// and files separately.
+			actionResult: &remoteexecution.ActionResult{
+				OutputFiles:       outputFiles,
+				OutputDirectories: outputDirectories,
+				OutputDirectorySymlinks: []*remoteexecution.OutputSymlink{
+					{Path: "bin/dir_link", Target: "./dir"},
+				},
+				OutputFileSymlinks: []*remoteexecution.OutputSymlink{
+					{Path: "file_link", Target: "bin/lib a.so"},
+				},
+			},
+			targetURLs: []string{treeURL, fileURL},
+			contains: []string{
+				"<span class=\"text-success\">bin/dir_link</span> -&gt; <a href=\"" + treeURL + "\">./dir</a>",
+				"<span class=\"text-success\">file_link</span> -&gt; <a href=\"" + fileURL + "\">bin/lib a.so</a>",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest:   commandDigest.GetProto(),
+				InputRootDigest: inputRootDigest.GetProto(),
+				Salt:            []byte(name),
+			})
+			ts.putActionResult(t, actionDigest, tc.actionResult)
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			outputSymlinks := reflect.ValueOf(ts.templates.data).FieldByName("OutputSymlinks").Interface().([]*outputSymlinkInfo)
+			var targetURLs []string
+			for _, outputSymlink := range outputSymlinks {
+				targetURLs = append(targetURLs, outputSymlink.TargetURL)
+			}
+			if !reflect.DeepEqual(targetURLs, tc.targetURLs) {
+				t.Errorf("Expected target URLs %#v, got %#v", tc.targetURLs, targetURLs)
+			}
+			body := w.Body.String()
+			for _, s := range tc.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+
+			// The JSON representation contains the symbolic
+			// links as stored in the action result.
+			var got remoteexecution.ActionResult
+			if code := ts.serveProtoJSON(t, getURL("action", actionDigest, ""), &got); code != http.StatusOK || !proto.Equal(&got, tc.actionResult) {
+				t.Errorf("Expected action result %v, got status %d and %v", tc.actionResult, code, &got)
+			}
+		})
+	}
+}
+
+// cancellingReader is a reader that returns data in small chunks,
+// cancelling a context after a given number of reads.
+type cancellingReader struct {
+	r           io.Reader
+	cancel      context.CancelFunc
+	cancelAfter int
+	reads       int
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	r.reads++
+	if r.reads == r.cancelAfter {
+		r.cancel()
+	}
+	if len(p) > 10 {
+		p = p[:10]
+	}
+	return r.r.Read(p)
+}
+
+// failingWriter is a writer that always fails.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, status.Error(codes.Unavailable, "Client disconnected")
+}
+
+func TestCopyWithContext(t *testing.T) {
+	data := strings.Repeat("0123456789", 10)
+	for name, tc := range map[string]struct {
+		cancelAfter int
+		writer      io.Writer
+		written     int64
+		reads       int
+		err         error
+	}{
+		"Complete":        {written: 100, reads: 11},
+		"CancelledBefore": {cancelAfter: -1, written: 0, reads: 0, err: context.Canceled},
+		"CancelledDuring": {cancelAfter: 3, written: 30, reads: 3, err: context.Canceled},
+		"WriteFailure":    {writer: failingWriter{}, written: 0, reads: 1, err: status.Error(codes.Unavailable, "Client disconnected")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancelAfter < 0 {
+				cancel()
+			}
+			r := &cancellingReader{r: strings.NewReader(data), cancel: cancel, cancelAfter: tc.cancelAfter}
+			var out bytes.Buffer
+			w := tc.writer
+			if w == nil {
+				w = &out
+			}
+
+			written, err := copyWithContext(ctx, w, r)
+			if written != tc.written || r.reads != tc.reads {
+				t.Errorf("Expected %d bytes to be written using %d reads, got %d bytes using %d reads", tc.written, tc.reads, written, r.reads)
+			}
+			if (err == nil) != (tc.err == nil) || (err != nil && err.Error() != tc.err.Error()) {
+				t.Errorf("Expected error %v, got %v", tc.err, err)
+			}
+			if tc.writer == nil && out.String() != data[:tc.written] {
+				t.Errorf("Expected %#v to be written, got %#v", data[:tc.written], out.String())
+			}
+		})
+	}
+}
+
+func TestHandleFileCancellation(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	data := bytes.Repeat([]byte("Hello world\n"), 1000)
+	fileDigest := ts.contentAddressableStorage.putBytes(data)
+	fileURL := getURL("file", fileDigest, "hello.txt")
+
+	for name, tc := range map[string]struct {
+		cancelled bool
+		aborted   bool
+		body      []byte
+	}{
+		"Complete": {body: data},
+		// Only the prefix that is used for content sniffing is
+		// returned when the request is cancelled.
+		"Cancelled": {cancelled: true, aborted: true, body: data[:4096]},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancelled {
+				cancel()
+			}
+			w := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				defer func() {
+					if r := recover(); r != nil {
+						if r != http.ErrAbortHandler {
+							panic(r)
+						}
+						aborted = true
+					}
+				}()
+				ts.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fileURL, nil).WithContext(ctx))
+				return false
+			}()
+			if aborted != tc.aborted {
+				t.Errorf("Expected request to be aborted: %v, got %v", tc.aborted, aborted)
+			}
+			if !bytes.Equal(w.Body.Bytes(), tc.body) {
+				t.Errorf("Expected a body of %d bytes, got %d bytes", len(tc.body), w.Body.Len())
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, getURL("file", missingDigest, "hello.txt"), nil))
+		if code != http.StatusNotFound || response.Code != "NotFound" {
+			t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+		}
+	})
+}
+
+func TestHandleActionDigestWarnings(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+		},
+	})
+	// A SHA-1 digest, whose hash is shorter than that of SHA-256.
+	sha1Digest := &remoteexecution.Digest{Hash: strings.Repeat("0", 40), SizeBytes: 123}
+	actionResult := &remoteexecution.ActionResult{ExitCode: 1}
+
+	for name, tc := range map[string]struct {
+		commandDigest   *remoteexecution.Digest
+		inputRootDigest *remoteexecution.Digest
+		warnings        []string
+		hasCommand      bool
+		hasInputRoot    bool
+	}{
+		"Consistent": {
+			commandDigest:   commandDigest.GetProto(),
+			inputRootDigest: inputRootDigest.GetProto(),
+			hasCommand:      true,
+			hasInputRoot:    true,
+		},
+		"MismatchedCommand": {
+			commandDigest:   sha1Digest,
+			inputRootDigest: inputRootDigest.GetProto(),
+			warnings:        []string{"The command digest of this action is not valid for digest function SHA256: "},
+			hasInputRoot:    true,
+		},
+		"MismatchedInputRoot": {
+			commandDigest:   commandDigest.GetProto(),
+			inputRootDigest: sha1Digest,
+			warnings:        []string{"The input root digest of this action is not valid for digest function SHA256: "},
+			hasCommand:      true,
+		},
+		"MismatchedBoth": {
+			commandDigest:   sha1Digest,
+			inputRootDigest: sha1Digest,
+			warnings: []string{
+				"The command digest of this action is not valid for digest function SHA256: ",
+				"The input root digest of this action is not valid for digest function SHA256: ",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest:   tc.commandDigest,
+				InputRootDigest: tc.inputRootDigest,
+			})
+			ts.putActionResult(t, actionDigest, actionResult)
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			warnings := reflect.ValueOf(ts.templates.data).FieldByName("DigestWarnings").Interface().([]string)
+			if len(warnings) != len(tc.warnings) {
+				t.Fatalf("Expected warnings %#v, got %#v", tc.warnings, warnings)
+			}
+			body := w.Body.String()
+			for i, warning := range warnings {
+				if !strings.HasPrefix(warning, tc.warnings[i]) {
+					t.Errorf("Expected warning %#v to start with %#v", warning, tc.warnings[i])
+				}
+				if s := "<div class=\"alert alert-warning\" role=\"alert\">" + template.HTMLEscapeString(warning) + "</div>"; !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+			if len(warnings) == 0 && strings.Contains(body, "alert-warning") {
+				t.Error("Expected page not to contain any warnings")
+			}
+			// Messages whose digests are valid are still
+			// loaded and displayed.
+			data := reflect.ValueOf(ts.templates.data)
+			if hasCommand := !data.FieldByName("Command").IsNil(); hasCommand != tc.hasCommand {
+				t.Errorf("Expected command to be displayed: %v, got %v", tc.hasCommand, hasCommand)
+			}
+			if hasInputRoot := !data.FieldByName("InputRoot").IsNil(); hasInputRoot != tc.hasInputRoot {
+				t.Errorf("Expected input root to be displayed: %v, got %v", tc.hasInputRoot, hasInputRoot)
+			}
+			if strings.Contains(body, "hello.txt") != tc.hasInputRoot {
+				t.Errorf("Expected input root contents to be displayed: %v", tc.hasInputRoot)
+			}
+
+			// The action result can still be obtained.
+			var got remoteexecution.ActionResult
+			if code := ts.serveProtoJSON(t, getURL("action", actionDigest, ""), &got); code != http.StatusOK || !proto.Equal(&got, actionResult) {
+				t.Errorf("Expected action result %v, got status %d and %v", actionResult, code, &got)
+			}
+		})
+	}
+}
+
+func TestHandleLogRaw(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	ansiLog := []byte("\x1b[31mError:\x1b[0m <failure>\n")
+	binaryLog := []byte{0x00, 0xff, 0xfe, '\n'}
+	firstPage := strings.Repeat(strings.Repeat("a", 99)+"\n", maximumLogSizeBytes/100)
+	multiPageLog := []byte(firstPage + "bbb\n")
+
+	for name, tc := range map[string]struct {
+		log         []byte
+		query       string
+		contentType string
+		body        []byte
+	}{
+		"ANSI":       {log: ansiLog, query: "?raw=1", contentType: "text/plain; charset=utf-8", body: ansiLog},
+		"Binary":     {log: binaryLog, query: "?raw=1", contentType: "application/octet-stream", body: binaryLog},
+		"Empty":      {log: []byte{}, query: "?raw=1", contentType: "text/plain; charset=utf-8", body: []byte{}},
+		"FirstPage":  {log: multiPageLog, query: "?raw=1", contentType: "text/plain; charset=utf-8", body: []byte(firstPage)},
+		"SecondPage": {log: multiPageLog, query: "?page=1&raw=1", contentType: "text/plain; charset=utf-8", body: []byte("bbb\n")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			logDigest := cas.putBytes(tc.log)
+			ts.templates.name = ""
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("log", logDigest, tc.query), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if ts.templates.name != "" {
+				t.Errorf("Expected no template to be rendered, got %#v", ts.templates.name)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != tc.contentType {
+				t.Errorf("Expected content type %#v, got %#v", tc.contentType, contentType)
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Error("Expected content type sniffing to be disabled")
+			}
+			if !bytes.Equal(w.Body.Bytes(), tc.body) {
+				t.Errorf("Expected body %#v, got %#v", string(tc.body), w.Body.String())
+			}
+		})
+	}
+
+	t.Run("Rendered", func(t *testing.T) {
+		// Without raw=1, escape sequences are processed and
+		// the log is escaped.
+		logDigest := cas.putBytes(ansiLog)
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("log", logDigest, ""), nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_log.html" {
+			t.Fatalf("Expected page_log.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		if body := w.Body.String(); strings.Contains(body, "<failure>") || !strings.Contains(body, "&lt;failure&gt;") {
+			t.Error("Expected log to be escaped")
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		logDigest := cas.putBytes(ansiLog)
+		for name, tc := range map[string]struct {
+			url        string
+			code       int
+			statusCode string
+			message    string
+		}{
+			"NotFound":     {url: getURL("log", missingDigest, "?raw=1"), code: http.StatusNotFound, statusCode: "NotFound", message: "Object not found"},
+			"PageTooLarge": {url: getURL("log", logDigest, "?page=1&raw=1"), code: http.StatusBadRequest, statusCode: "InvalidArgument", message: "Page number 1 exceeds the number of pages of this log, which is 1"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectError(t, tc.url, tc.code, tc.statusCode, tc.message)
+			})
+		}
+	})
+}
+
+func TestHandleOutputsTarballPrefixAndFlatten(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{
+		Arguments:        []string{"true"},
+		WorkingDirectory: "src",
+	})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	helloDigest := cas.putBytes([]byte("Hello"))
+	worldDigest := cas.putBytes([]byte("World"))
+	treeDigest := cas.putMessage(t, &remoteexecution.Tree{
+		Root: &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{Name: "c.txt", Digest: helloDigest.GetProto()},
+			},
+		},
+	})
+	actionResult := &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "bin/a.txt", Digest: helloDigest.GetProto()},
+			{Path: "lib/b.txt", Digest: worldDigest.GetProto()},
+		},
+		OutputSymlinks: []*remoteexecution.OutputSymlink{
+			{Path: "bin/link", Target: "a.txt"},
+		},
+		OutputDirectories: []*remoteexecution.OutputDirectory{
+			{Path: "out/dir", TreeDigest: treeDigest.GetProto()},
+		},
+	}
+	// newActionDigest creates a distinct action, for which an
+	// action result is stored if provided.
+	newActionDigest := func(actionResult *remoteexecution.ActionResult) digest.Digest {
+		actionDigest := ts.putAction(t, commandDigest, inputRootDigest)
+		if actionResult != nil {
+			ts.putActionResult(t, actionDigest, actionResult)
+		}
+		return actionDigest
+	}
+
+	t.Run("Paths", func(t *testing.T) {
+		actionDigest := newActionDigest(actionResult)
+		for name, tc := range map[string]struct {
+			query   string
+			entries map[string]string
+		}{
+			"Default": {
+				entries: map[string]string{
+					"src/bin/a.txt":     "Hello",
+					"src/lib/b.txt":     "World",
+					"src/bin/link":      "",
+					"src/out/dir/c.txt": "Hello",
+				},
+			},
+			"Prefix": {
+				query: "&prefix=release/v1",
+				entries: map[string]string{
+					"release/v1/src/bin/a.txt":     "Hello",
+					"release/v1/src/lib/b.txt":     "World",
+					"release/v1/src/bin/link":      "",
+					"release/v1/src/out/dir/c.txt": "Hello",
+				},
+			},
+			"Flatten": {
+				query: "&flatten=1",
+				entries: map[string]string{
+					"a.txt":     "Hello",
+					"b.txt":     "World",
+					"link":      "",
+					"dir/c.txt": "Hello",
+				},
+			},
+			"PrefixAndFlatten": {
+				query: "&prefix=release&flatten=1",
+				entries: map[string]string{
+					"release/a.txt":     "Hello",
+					"release/b.txt":     "World",
+					"release/link":      "",
+					"release/dir/c.txt": "Hello",
+				},
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, "?format=tar&compression=none"+tc.query), nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+				}
+				entries := map[string]string{}
+				tarReader := tar.NewReader(w.Body)
+				for {
+					header, err := tarReader.Next()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatal(err)
+					}
+					if header.Typeflag == tar.TypeDir {
+						continue
+					}
+					data, err := io.ReadAll(tarReader)
+					if err != nil {
+						t.Fatal(err)
+					}
+					entries[header.Name] = string(data)
+				}
+				if !reflect.DeepEqual(entries, tc.entries) {
+					t.Errorf("Expected entries %#v, got %#v", tc.entries, entries)
+				}
+			})
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		collidingActionDigest := newActionDigest(&remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{Path: "bin/a.txt", Digest: helloDigest.GetProto()},
+				{Path: "lib/a.txt", Digest: worldDigest.GetProto()},
+			},
+		})
+		actionDigest := newActionDigest(actionResult)
+		for name, tc := range map[string]struct {
+			actionDigest digest.Digest
+			query        string
+			code         int
+			statusCode   string
+			message      string
+		}{
+			"FlattenCollision": {
+				actionDigest: collidingActionDigest,
+				query:        "&flatten=1",
+				code:         http.StatusBadRequest,
+				statusCode:   "InvalidArgument",
+				message:      "Outputs \"bin/a.txt\" and \"lib/a.txt\" would both be stored at \"a.txt\"",
+			},
+			"AbsolutePrefix": {
+				actionDigest: actionDigest,
+				query:        "&prefix=/release",
+				code:         http.StatusBadRequest,
+				statusCode:   "InvalidArgument",
+				message:      "Invalid prefix \"/release\"",
+			},
+			"EscapingPrefix": {
+				actionDigest: actionDigest,
+				query:        "&prefix=../release",
+				code:         http.StatusBadRequest,
+				statusCode:   "InvalidArgument",
+				message:      "Invalid prefix \"../release\"",
+			},
+			"NoActionResult": {
+				actionDigest: newActionDigest(nil),
+				query:        "&prefix=release",
+				code:         http.StatusNotFound,
+				statusCode:   "NotFound",
+				message:      "Could not find an action result",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectError(t, getURL("action", tc.actionDigest, "?format=tar"+tc.query), tc.code, tc.statusCode, tc.message)
+			})
+		}
+	})
+}
+
+func TestHandleTreeValidation(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	// Digests of child directories are computed by storing them.
+	child := &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "hello.txt", Digest: cas.putBytes([]byte("Hello")).GetProto()},
+		},
+	}
+	childDigest := cas.putMessage(t, child)
+	orphan := &remoteexecution.Directory{
+		Symlinks: []*remoteexecution.SymlinkNode{{Name: "link", Target: "target"}},
+	}
+	danglingDigest := cas.putMessage(t, orphan)
+
+	for name, tc := range map[string]struct {
+		tree     *remoteexecution.Tree
+		problems []string
+	}{
+		"Consistent": {
+			tree: &remoteexecution.Tree{
+				Root: &remoteexecution.Directory{
+					Directories: []*remoteexecution.DirectoryNode{
+						{Name: "a", Digest: childDigest.GetProto()},
+						{Name: "b", Digest: childDigest.GetProto()},
+					},
+				},
+				Children: []*remoteexecution.Directory{child},
+			},
+		},
+		"DanglingReference": {
+			tree: &remoteexecution.Tree{
+				Root: &remoteexecution.Directory{
+					Directories: []*remoteexecution.DirectoryNode{
+						{Name: "a", Digest: childDigest.GetProto()},
+						{Name: "dangling", Digest: danglingDigest.GetProto()},
+					},
+				},
+				Children: []*remoteexecution.Directory{child},
+			},
+			problems: []string{
+				fmt.Sprintf("Directory \"dangling\" refers to directory %s, which is not contained in the tree", danglingDigest.String()),
+			},
+		},
+		"OrphanedChild": {
+			tree: &remoteexecution.Tree{
+				Root: &remoteexecution.Directory{
+					Directories: []*remoteexecution.DirectoryNode{
+						{Name: "a", Digest: childDigest.GetProto()},
+					},
+				},
+				Children: []*remoteexecution.Directory{child, orphan},
+			},
+			problems: []string{
+				"The tree contains 1 child directories that cannot be reached from the root directory",
+			},
+		},
+		"InvalidDigest": {
+			tree: &remoteexecution.Tree{
+				Root: &remoteexecution.Directory{
+					Directories: []*remoteexecution.DirectoryNode{
+						{Name: "invalid", Digest: &remoteexecution.Digest{Hash: strings.Repeat("0", 64), SizeBytes: -1}},
+					},
+				},
+			},
+			problems: []string{
+				"Directory \"invalid\" has an invalid digest: Invalid digest size",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			treeDigest := cas.putMessage(t, tc.tree)
+			children, err := getTreeChildren(testDigestFunction, tc.tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Messages of digest errors are only compared
+			// by prefix, as they originate from storage.
+			equalProblems := func(problems []string) bool {
+				if len(problems) != len(tc.problems) {
+					return false
+				}
+				for i, problem := range problems {
+					if !strings.HasPrefix(problem, tc.problems[i]) {
+						return false
+					}
+				}
+				return true
+			}
+			if problems := validateTree(testDigestFunction, tc.tree, children); !equalProblems(problems) {
+				t.Errorf("Expected problems %#v, got %#v", tc.problems, problems)
+			}
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("tree", treeDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_tree.html" {
+				t.Fatalf("Expected page_tree.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			if problems := reflect.ValueOf(ts.templates.data).Elem().FieldByName("ValidationProblems").Interface().([]string); !equalProblems(problems) {
+				t.Errorf("Expected template to receive problems %#v, got %#v", tc.problems, problems)
+			}
+			body := w.Body.String()
+			if hasBanner := strings.Contains(body, "<b>This tree is inconsistent:</b>"); hasBanner != (len(tc.problems) > 0) {
+				t.Errorf("Expected page to display a warning: %v, got %v", len(tc.problems) > 0, hasBanner)
+			}
+			for _, problem := range tc.problems {
+				if s := "<li>" + template.HTMLEscapeString(problem); !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		treeDigest := cas.putMessage(t, &remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Directories: []*remoteexecution.DirectoryNode{
+					{Name: "dangling", Digest: danglingDigest.GetProto()},
+				},
+			},
+		})
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		for name, tc := range map[string]struct {
+			url        string
+			code       int
+			statusCode string
+			message    string
+		}{
+			// Dangling references can be displayed, but not
+			// be navigated into.
+			"DanglingSubdirectory": {url: getURL("tree", treeDigest, "dangling/"), code: http.StatusBadRequest, statusCode: "InvalidArgument", message: "Failed to find child node in tree"},
+			"NotFound":             {url: getURL("tree", missingDigest, ""), code: http.StatusNotFound, statusCode: "NotFound", message: "Object not found"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectError(t, tc.url, tc.code, tc.statusCode, tc.message)
+			})
+		}
+	})
+}
+
+func TestHandleCommandScript(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("No shell available to run scripts")
+	}
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	// Values containing spaces, quotes and characters that have a
+	// special meaning to the shell must be preserved.
+	values := []string{
+		"hello world",
+		"say \"hi\"",
+		"it's",
+		"$HOME `id` $(id)",
+		"back\\slash; exit 1",
+		"",
+	}
+
+	for name, tc := range map[string]struct {
+		command *remoteexecution.Command
+		output  string
+	}{
+		"Arguments": {
+			command: &remoteexecution.Command{
+				Arguments:        append([]string{"printf", "[%s]\\n"}, values...),
+				WorkingDirectory: "work dir",
+			},
+			output: "[hello world]\n[say \"hi\"]\n[it's]\n[$HOME `id` $(id)]\n[back\\slash; exit 1]\n[]\n",
+		},
+		"EnvironmentVariables": {
+			command: &remoteexecution.Command{
+				Arguments: []string{"printenv", "SPACES", "QUOTES", "APOSTROPHE", "EXPANSIONS", "SEPARATORS"},
+				EnvironmentVariables: []*remoteexecution.Command_EnvironmentVariable{
+					{Name: "APOSTROPHE", Value: values[2]},
+					{Name: "EXPANSIONS", Value: values[3]},
+					{Name: "QUOTES", Value: values[1]},
+					{Name: "SEPARATORS", Value: values[4]},
+					{Name: "SPACES", Value: values[0]},
+				},
+				WorkingDirectory: "work dir",
+			},
+			output: "hello world\nsay \"hi\"\nit's\n$HOME `id` $(id)\nback\\slash; exit 1\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			commandDigest := cas.putMessage(t, tc.command)
+			for _, format := range []string{"sh", "script"} {
+				w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("command", commandDigest, "?format="+format), nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+				}
+				if contentType := w.Header().Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+					t.Errorf("Expected content type \"text/plain; charset=utf-8\", got %#v", contentType)
+				}
+				if expected := fmt.Sprintf("attachment; filename=\"%s.sh\"", commandDigest.GetHashString()); w.Header().Get("Content-Disposition") != expected {
+					t.Errorf("Expected content disposition %#v, got %#v", expected, w.Header().Get("Content-Disposition"))
+				}
+				script := w.Body.String()
+				if !strings.HasPrefix(script, "#!/bin/sh\n") {
+					t.Errorf("Expected script to start with a shebang, got %#v", script)
+				}
+
+				// Run the script, to check that quoting
+				// preserves all values.
+				rootDirectory := t.TempDir()
+				if err := os.Mkdir(filepath.Join(rootDirectory, "work dir"), 0o777); err != nil {
+					t.Fatal(err)
+				}
+				cmd := exec.Command(shPath, "-s")
+				cmd.Dir = rootDirectory
+				cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=/nonexistent"}
+				cmd.Stdin = strings.NewReader(script)
+				output, err := cmd.Output()
+				if err != nil {
+					t.Fatalf("Failed to run script %#v: %s", script, err)
+				}
+				if string(output) != tc.output {
+					t.Errorf("Expected output %#v, got %#v", tc.output, string(output))
+				}
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		url := getURL("command", missingDigest, "?format=sh")
+		code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, url, nil))
+		if code != http.StatusNotFound || response.Code != "NotFound" {
+			t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+		}
+		w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusNotFound || ts.templates.name != "page_error.html" || w.Header().Get("Content-Disposition") != "" {
+			t.Errorf("Expected page_error.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+	})
+}
+
+func TestHandleDirectorySummary(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	emptyDirectoryDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	newFileNode := func(name string, sizeBytes int) *remoteexecution.FileNode {
+		return &remoteexecution.FileNode{
+			Name:   name,
+			Digest: cas.putBytes(bytes.Repeat([]byte("x"), sizeBytes)).GetProto(),
+		}
+	}
+	largeDirectory := &remoteexecution.Directory{}
+	for i := 0; i < streamedDirectoryMinimumEntriesCount; i++ {
+		largeDirectory.Files = append(largeDirectory.Files, newFileNode(fmt.Sprintf("file%05d", i), 1000))
+	}
+
+	for name, tc := range map[string]struct {
+		directory      *remoteexecution.Directory
+		template       string
+		filesSizeBytes int64
+		summary        string
+	}{
+		"Mixed": {
+			directory: &remoteexecution.Directory{
+				Files: []*remoteexecution.FileNode{
+					newFileNode("a.txt", 5),
+					newFileNode("b.txt", 1000),
+					newFileNode("c.txt", 2048),
+				},
+				Directories: []*remoteexecution.DirectoryNode{
+					{Name: "dir1", Digest: emptyDirectoryDigest.GetProto()},
+					{Name: "dir2", Digest: emptyDirectoryDigest.GetProto()},
+				},
+				Symlinks: []*remoteexecution.SymlinkNode{
+					{Name: "link", Target: "a.txt"},
+				},
+			},
+			template:       "page_directory.html",
+			filesSizeBytes: 3053,
+			summary:        "<p>This directory contains 3 files, having a total\nsize of 3.1 kB, 2\ndirectories and 1 symbolic links.",
+		},
+		"Empty": {
+			directory:      &remoteexecution.Directory{},
+			template:       "page_directory.html",
+			filesSizeBytes: 0,
+			summary:        "<p>This directory contains 0 files, having a total\nsize of 0 B, 0\ndirectories and 0 symbolic links.",
+		},
+		"Streamed": {
+			directory:      largeDirectory,
+			template:       "directory_streamed_footer",
+			filesSizeBytes: 1000 * streamedDirectoryMinimumEntriesCount,
+			summary:        fmt.Sprintf("<p>This directory contains %d files, having a total\nsize of ", streamedDirectoryMinimumEntriesCount),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			directoryDigest := cas.putMessage(t, tc.directory)
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("directory", directoryDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != tc.template {
+				t.Fatalf("Expected %s to be rendered, got status %d and template %#v", tc.template, w.Code, ts.templates.name)
+			}
+			info := directoryInfo{Directory: tc.directory}
+			if filesSizeBytes := info.GetFilesSizeBytes(); filesSizeBytes != tc.filesSizeBytes {
+				t.Errorf("Expected files to have a total size of %d bytes, got %d", tc.filesSizeBytes, filesSizeBytes)
+			}
+			if !strings.Contains(w.Body.String(), tc.summary) {
+				t.Errorf("Expected page to contain %#v", tc.summary)
+			}
+
+			// The summary is not part of the JSON
+			// representation of the directory.
+			var got remoteexecution.Directory
+			if code := ts.serveProtoJSON(t, getURL("directory", directoryDigest, ""), &got); code != http.StatusOK || !proto.Equal(&got, tc.directory) {
+				t.Errorf("Expected directory %v, got status %d and %v", tc.directory, code, &got)
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("directory", missingDigest, ""), nil))
+		if w.Code != http.StatusNotFound || ts.templates.name != "page_error.html" || strings.Contains(w.Body.String(), "This directory contains") {
+			t.Errorf("Expected page_error.html without a summary, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+	})
+}
+
+func TestHandleFileMarkdown(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	markdown := []byte("# Title\n\nSome *emphasis*.\n\n<script>alert(1)</script>\n\n[Click](javascript:alert(1))\n")
+	markdownDigest := cas.putBytes(markdown)
+	binaryDigest := cas.putBytes([]byte{'#', ' ', 0xff, 0xfe})
+	largeMarkdown := bytes.Repeat([]byte("# Title\n"), maximumMarkdownSizeBytes/8+1)
+	largeMarkdownDigest := cas.putBytes(largeMarkdown)
+
+	for name, tc := range map[string]struct {
+		url      string
+		rendered bool
+		body     []byte
+	}{
+		"Markdown":          {url: getURL("file", markdownDigest, "README.md"), rendered: true},
+		"MarkdownExtension": {url: getURL("file", markdownDigest, "README.markdown"), rendered: true},
+		"Raw":               {url: getURL("file", markdownDigest, "README.md?raw=1"), body: markdown},
+		"OtherExtension":    {url: getURL("file", markdownDigest, "README.txt"), body: markdown},
+		"Binary":            {url: getURL("file", binaryDigest, "README.md"), body: []byte{'#', ' ', 0xff, 0xfe}},
+		"TooLarge":          {url: getURL("file", largeMarkdownDigest, "README.md"), body: largeMarkdown},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts.templates.name = ""
+			w := ts.serve(httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if !tc.rendered {
+				if ts.templates.name != "" {
+					t.Errorf("Expected no template to be rendered, got %#v", ts.templates.name)
+				}
+				if !bytes.Equal(w.Body.Bytes(), tc.body) {
+					t.Errorf("Expected the file to be returned as is, got %d bytes", w.Body.Len())
+				}
+				return
+			}
+
+			if ts.templates.name != "page_markdown.html" {
+				t.Fatalf("Expected page_markdown.html to be rendered, got %#v", ts.templates.name)
+			}
+			body := w.Body.String()
+			for _, s := range []string{
+				"<h1>Title</h1>",
+				"<p>Some <em>emphasis</em>.</p>",
+				"<!-- raw HTML omitted -->",
+				`href="?raw=1" role="button">View raw file</a>`,
+			} {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+			// Embedded HTML and unsafe links must not end
+			// up in the page.
+			for _, s := range []string{"<script>alert(1)", "javascript:"} {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected page not to contain %#v", s)
+				}
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		ts.expectError(t, getURL("file", missingDigest, "README.md"), http.StatusNotFound, "NotFound", "Object not found")
+	})
+}
+
+func TestHandleActionOutputFilePreviews(t *testing.T) {
+	for name, tc := range map[string]struct {
+		patterns []string
+		previews []string
+	}{
+		"NoPatterns": {},
+		"Patterns": {
+			patterns: []string{"*.log", "coverage.dat"},
+			previews: []string{"out/test.log", "out/coverage.dat", "out/core.log", "out/missing.log"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestBrowserService(BrowserServiceOptions{
+				PreviewedOutputFilePatterns: tc.patterns,
+			})
+			cas := ts.contentAddressableStorage
+			commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+			inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+			actionDigest := cas.putMessage(t, &remoteexecution.Action{
+				CommandDigest:   commandDigest.GetProto(),
+				InputRootDigest: inputRootDigest.GetProto(),
+			})
+			missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+			ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "out/test.log", Digest: cas.putBytes([]byte("Test PASSED\n")).GetProto()},
+					{Path: "out/coverage.dat", Digest: cas.putBytes([]byte("SF:main.go\n")).GetProto()},
+					{Path: "out/lib.so", Digest: cas.putBytes([]byte("ELF library\n")).GetProto()},
+					{Path: "out/core.log", Digest: cas.putBytes([]byte{0xff, 0xfe, 0x00}).GetProto()},
+					{Path: "out/missing.log", Digest: missingDigest.GetProto()},
+					{Path: "out/empty.log", Digest: testDigestFunction.NewGenerator(0).Sum().GetProto()},
+				},
+			})
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+				t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			var previews []string
+			for _, preview := range reflect.ValueOf(ts.templates.data).FieldByName("OutputFilePreviews").Interface().([]*logInfo) {
+				previews = append(previews, preview.Name)
+				switch preview.Name {
+				case "out/core.log":
+					if !preview.NotHumanReadable {
+						t.Errorf("Expected %#v not to be rendered", preview.Name)
+					}
+				case "out/missing.log":
+					if !preview.NotFound {
+						t.Errorf("Expected %#v to be reported as missing", preview.Name)
+					}
+				}
+			}
+			if !reflect.DeepEqual(previews, tc.previews) {
+				t.Errorf("Expected previews %#v, got %#v", tc.previews, previews)
+			}
+
+			body := w.Body.String()
+			_, previewsSection, hasPreviews := strings.Cut(body, "<h2 class=\"my-4\">Output file previews</h2>")
+			if hasPreviews != (len(tc.previews) > 0) {
+				t.Fatalf("Expected page to contain output file previews: %v, got %v", len(tc.previews) > 0, hasPreviews)
+			}
+			if hasPreviews {
+				previewsSection, _, _ = strings.Cut(previewsSection, "<h2")
+				for _, s := range []string{"Test PASSED", "SF:main.go"} {
+					if !strings.Contains(previewsSection, s) {
+						t.Errorf("Expected previews to contain %#v", s)
+					}
+				}
+				if strings.Contains(previewsSection, "ELF library") {
+					t.Error("Expected non-matching output files not to be previewed")
+				}
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{
+			PreviewedOutputFilePatterns: []string{"*.log"},
+		})
+		cas := ts.contentAddressableStorage
+		commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+		inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+		failingDigest := cas.putBytes([]byte("Failing"))
+		cas.errors[failingDigest] = status.Error(codes.Internal, "Disk on fire")
+
+		for name, tc := range map[string]struct {
+			outputFile *remoteexecution.OutputFile
+			code       int
+			statusCode string
+			message    string
+		}{
+			"StorageFailure": {
+				outputFile: &remoteexecution.OutputFile{Path: "test.log", Digest: failingDigest.GetProto()},
+				code:       http.StatusInternalServerError,
+				statusCode: "Internal",
+				message:    "Disk on fire",
+			},
+			"InvalidDigest": {
+				outputFile: &remoteexecution.OutputFile{Path: "test.log", Digest: &remoteexecution.Digest{Hash: strings.Repeat("0", 64), SizeBytes: -1}},
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Invalid digest for output file \"test.log\": Invalid digest size",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				actionDigest := ts.putAction(t, commandDigest, inputRootDigest)
+				ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{
+					OutputFiles: []*remoteexecution.OutputFile{tc.outputFile},
+				})
+
+				ts.expectErrorPage(t, httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil), tc.code, tc.message)
+
+				// The action result is returned as JSON
+				// without loading any previews.
+				var got remoteexecution.ActionResult
+				if code := ts.serveProtoJSON(t, getURL("action", actionDigest, ""), &got); code != http.StatusOK || len(got.OutputFiles) != 1 {
+					t.Errorf("Expected action result with a single output file, got status %d and %v", code, &got)
+				}
+			})
+		}
+	})
+}
+
+func TestRedirectToTrailingSlash(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	directoryDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	actionDigest := cas.putMessage(t, &remoteexecution.Action{
+		CommandDigest:   commandDigest.GetProto(),
+		InputRootDigest: directoryDigest.GetProto(),
+	})
+	ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{})
+	historicalExecuteResponseDigest := cas.putMessage(t, &cas_proto.HistoricalExecuteResponse{
+		ActionDigest:    actionDigest.GetProto(),
+		ExecuteResponse: &remoteexecution.ExecuteResponse{Result: &remoteexecution.ActionResult{}},
+	})
+	data, err := proto.Marshal(&remoteexecution.Command{Arguments: []string{"false"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	helloCommandDigest := cas.putBytesWithInstanceName("hello", data)
+
+	for name, tc := range map[string]struct {
+		prefix   string
+		pageType string
+		digest   digest.Digest
+		query    string
+		template string
+	}{
+		"Action":                    {pageType: "action", digest: actionDigest, template: "page_action.html"},
+		"Command":                   {pageType: "command", digest: commandDigest, template: "page_command.html"},
+		"Directory":                 {pageType: "directory", digest: directoryDigest, template: "page_directory.html"},
+		"HistoricalExecuteResponse": {pageType: "historical_execute_response", digest: historicalExecuteResponseDigest, template: "page_action.html"},
+		"InstanceName":              {prefix: "/hello", pageType: "command", digest: helloCommandDigest, template: "page_command.html"},
+		"Query":                     {pageType: "command", digest: commandDigest, query: "?format=json&x=1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			requestURL := tc.prefix + strings.TrimSuffix(getURL(tc.pageType, tc.digest, ""), "/") + tc.query
+			req := httptest.NewRequest(http.MethodGet, requestURL, nil)
+			w := ts.serve(req)
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("Expected status %d, got %d", http.StatusMovedPermanently, w.Code)
+			}
+			location := fmt.Sprintf("%s-%d/%s", tc.digest.GetHashString(), tc.digest.GetSizeBytes(), tc.query)
+			if got := w.Header().Get("Location"); got != location {
+				t.Fatalf("Expected location %#v, got %#v", location, got)
+			}
+
+			// Following the redirect should yield the page
+			// that was originally requested.
+			target, err := req.URL.Parse(location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := tc.prefix + getURL(tc.pageType, tc.digest, ""); target.Path != expected {
+				t.Errorf("Expected redirect to resolve to %#v, got %#v", expected, target.Path)
+			}
+			w = ts.serve(httptest.NewRequest(http.MethodGet, target.String(), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if tc.template != "" {
+				if ts.templates.name != tc.template {
+					t.Errorf("Expected template %#v, got %#v", tc.template, ts.templates.name)
+				}
+			} else {
+				var command remoteexecution.Command
+				if code := ts.serveProtoJSON(t, target.String(), &command); code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+				}
+				if !reflect.DeepEqual(command.Arguments, []string{"true"}) {
+					t.Errorf("Expected arguments [\"true\"], got %#v", command.Arguments)
+				}
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			url        string
+			code       int
+			statusCode string
+			message    string
+		}{
+			"File": {
+				// The final pathname component of a file
+				// is its name, so it cannot be omitted.
+				url:        strings.TrimSuffix(getURL("file", commandDigest, ""), "/"),
+				code:       http.StatusNotFound,
+				statusCode: "NotFound",
+				message:    "No page exists at path",
+			},
+			"Tree": {
+				url:        strings.TrimSuffix(getURL("tree", directoryDigest, ""), "/"),
+				code:       http.StatusNotFound,
+				statusCode: "NotFound",
+				message:    "No page exists at path",
+			},
+			"UnknownPageType": {
+				url:        strings.TrimSuffix(getURL("unknown", commandDigest, ""), "/"),
+				code:       http.StatusNotFound,
+				statusCode: "NotFound",
+				message:    "No page exists at path",
+			},
+			"InvalidDigest": {
+				// Digests are only validated after
+				// redirecting.
+				url:        "/blobs/sha256/command/" + strings.Repeat("g", 64) + "-5/",
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Non-hexadecimal character in digest hash",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectError(t, tc.url, tc.code, tc.statusCode, tc.message)
+			})
+		}
+
+		t.Run("InvalidDigestRedirect", func(t *testing.T) {
+			w := ts.serve(httptest.NewRequest(http.MethodGet, "/blobs/sha256/command/"+strings.Repeat("g", 64)+"-5", nil))
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("Expected status %d, got %d", http.StatusMovedPermanently, w.Code)
+			}
+			if expected := strings.Repeat("g", 64) + "-5/"; w.Header().Get("Location") != expected {
+				t.Errorf("Expected location %#v, got %#v", expected, w.Header().Get("Location"))
+			}
+		})
+	})
+}
+
+func TestRenderErrorPage(t *testing.T) {
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+
+	for name, tc := range map[string]struct {
+		routePrefix string
+		authorizer  auth.Authorizer
+		url         string
+		code        int
+		statusCode  string
+		message     string
+		jsonMessage string
+	}{
+		"ActionNotFound": {
+			url:         getURL("action", missingDigest, ""),
+			code:        http.StatusNotFound,
+			statusCode:  "NotFound",
+			message:     "Could not find an action or action result",
+			jsonMessage: "Could not find an action result",
+		},
+		"NoRoute": {
+			url:        "/nonexistent",
+			code:       http.StatusNotFound,
+			statusCode: "NotFound",
+			message:    "No page exists at path \"/nonexistent\"",
+		},
+		"RoutePrefix": {
+			routePrefix: "/buildbarn/",
+			url:         "/buildbarn" + getURL("action", missingDigest, ""),
+			code:        http.StatusNotFound,
+			statusCode:  "NotFound",
+			message:     "Could not find an action or action result",
+			jsonMessage: "Could not find an action result",
+		},
+		"PermissionDenied": {
+			authorizer: auth.NewStaticAuthorizer(func(digest.InstanceName) bool { return false }),
+			url:        getURL("action", missingDigest, ""),
+			code:       http.StatusForbidden,
+			statusCode: "PermissionDenied",
+			message:    "Instance name \"\" may not be browsed: Permission denied",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestBrowserService(BrowserServiceOptions{
+				RoutePrefix:      tc.routePrefix,
+				BrowseAuthorizer: tc.authorizer,
+			})
+			w := ts.serve(httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if w.Code != tc.code || ts.templates.name != "page_error.html" {
+				t.Fatalf("Expected page_error.html with status %d, got status %d and template %#v", tc.code, w.Code, ts.templates.name)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+				t.Errorf("Expected content type \"text/html; charset=utf-8\", got %#v", contentType)
+			}
+			routePrefix := tc.routePrefix
+			if routePrefix == "" {
+				routePrefix = "/"
+			}
+			body := w.Body.String()
+			for _, s := range []string{
+				fmt.Sprintf(`<h1 class="my-4">Error: %s</h1>`, tc.statusCode),
+				fmt.Sprintf(`<p class="text-muted">HTTP %d %s</p>`, tc.code, http.StatusText(tc.code)),
+				"<p>" + template.HTMLEscapeString(tc.message) + "</p>",
+				fmt.Sprintf(`href="%s" role="button">Go to the welcome page</a>`, routePrefix),
+			} {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected page to contain %#v", s)
+				}
+			}
+
+			// JSON clients obtain the error without the page
+			// template. For actions only the action result is
+			// returned as JSON, so the message differs.
+			jsonMessage := tc.jsonMessage
+			if jsonMessage == "" {
+				jsonMessage = tc.message
+			}
+			code, response := ts.serveJSONError(t, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if expected := (jsonError{Code: tc.statusCode, Message: jsonMessage, HTTPStatus: tc.code}); code != tc.code || response != expected {
+				t.Errorf("Expected status %d and error %#v, got status %d and %#v", tc.code, expected, code, response)
+			}
+		})
+	}
+}
+
+func TestHandleDirectoryInstanceName(t *testing.T) {
+	largeDirectory := &remoteexecution.Directory{}
+	for i := 0; i < streamedDirectoryMinimumEntriesCount; i++ {
+		largeDirectory.Symlinks = append(largeDirectory.Symlinks, &remoteexecution.SymlinkNode{
+			Name:   fmt.Sprintf("link%05d", i),
+			Target: "target",
+		})
+	}
+	allowedInstanceNames := digest.NewInstanceNameTrie()
+	allowedInstanceNames.Set(digest.MustNewInstanceName("hello"), 0)
+
+	for name, tc := range map[string]struct {
+		instanceName string
+		pageType     string
+		message      proto.Message
+		template     string
+		header       string
+	}{
+		"DirectoryEmptyInstanceName": {
+			pageType: "directory",
+			message:  &remoteexecution.Directory{},
+			template: "page_directory.html",
+			header:   "<i>empty</i>",
+		},
+		"Directory": {
+			instanceName: "hello/world",
+			pageType:     "directory",
+			message:      &remoteexecution.Directory{},
+			template:     "page_directory.html",
+			header:       `<span class="font-monospace">hello/world</span>`,
+		},
+		"DirectoryStreamed": {
+			instanceName: "hello/world",
+			pageType:     "directory",
+			message:      largeDirectory,
+			template:     "directory_streamed_footer",
+			header:       `<span class="font-monospace">hello/world</span>`,
+		},
+		"TreeEmptyInstanceName": {
+			pageType: "tree",
+			message:  &remoteexecution.Tree{Root: &remoteexecution.Directory{}},
+			template: "page_tree.html",
+			header:   "<i>empty</i>",
+		},
+		"Tree": {
+			instanceName: "hello/world",
+			pageType:     "tree",
+			message:      &remoteexecution.Tree{Root: &remoteexecution.Directory{}},
+			template:     "page_tree.html",
+			header:       `<span class="font-monospace">hello/world</span>`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestBrowserService(BrowserServiceOptions{})
+			data, err := proto.Marshal(tc.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blobDigest := ts.contentAddressableStorage.putBytesWithInstanceName(tc.instanceName, data)
+			url := getURL(tc.pageType, blobDigest, "")
+			if tc.instanceName != "" {
+				url = "/" + tc.instanceName + url
+			}
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusOK || ts.templates.name != tc.template {
+				t.Fatalf("Expected template %#v to be rendered, got status %d and template %#v", tc.template, w.Code, ts.templates.name)
+			}
+			body := w.Body.String()
+			_, afterHeading, _ := strings.Cut(body, "</h1>")
+			if !strings.Contains(afterHeading, "<p class=\"text-muted\">Instance name:\n\n\t"+tc.header) {
+				t.Errorf("Expected page to contain instance name %#v below the heading", tc.header)
+			}
+			if tc.template != "directory_streamed_footer" {
+				got := reflect.Indirect(reflect.ValueOf(ts.templates.data)).FieldByName("InstanceName").Interface().(digest.InstanceName)
+				if got != blobDigest.GetInstanceName() {
+					t.Errorf("Expected instance name %#v, got %#v", blobDigest.GetInstanceName().String(), got.String())
+				}
+			}
+
+			// JSON clients of directory pages obtain the
+			// message itself, which doesn't contain the
+			// instance name. Tree pages have no JSON
+			// representation.
+			if tc.pageType == "directory" {
+				var got remoteexecution.Directory
+				if code := ts.serveProtoJSON(t, url, &got); code != http.StatusOK || !proto.Equal(&got, tc.message) {
+					t.Errorf("Expected directory to be returned, got status %d and %d entries", code, len(got.Symlinks))
+				}
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		ts := newTestBrowserService(BrowserServiceOptions{AllowedInstanceNames: allowedInstanceNames})
+		data, err := proto.Marshal(&remoteexecution.Directory{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, tc := range map[string]struct {
+			url        string
+			code       int
+			statusCode string
+			message    string
+		}{
+			"Disallowed": {
+				url:        "/other" + getURL("directory", ts.contentAddressableStorage.putBytesWithInstanceName("other", data), ""),
+				code:       http.StatusForbidden,
+				statusCode: "PermissionDenied",
+				message:    "Instance name \"other\" may not be browsed",
+			},
+			"NotFound": {
+				url:        "/hello" + getURL("tree", digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123), ""),
+				code:       http.StatusNotFound,
+				statusCode: "NotFound",
+				message:    "Object not found",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectError(t, tc.url, tc.code, tc.statusCode, tc.message)
+			})
+		}
+	})
+}
+
+func TestHandleActionSummary(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	fileDigest := cas.putBytes([]byte("Hello")).GetProto()
+	actionResult := &remoteexecution.ActionResult{
+		ExitCode: 1,
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "a.txt", Digest: fileDigest},
+			{Path: "b.txt", Digest: fileDigest},
+		},
+		OutputDirectories: []*remoteexecution.OutputDirectory{
+			{Path: "dir", TreeDigest: fileDigest},
+		},
+		OutputSymlinks: []*remoteexecution.OutputSymlink{
+			{Path: "link", Target: "a.txt"},
+		},
+		ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+			ExecutionStartTimestamp:     &timestamppb.Timestamp{Seconds: 1000},
+			ExecutionCompletedTimestamp: &timestamppb.Timestamp{Seconds: 1001, Nanos: 500000000},
+		},
+	}
+	legacyActionResult := &remoteexecution.ActionResult{
+		OutputFileSymlinks:      []*remoteexecution.OutputSymlink{{Path: "file_link", Target: "a.txt"}},
+		OutputDirectorySymlinks: []*remoteexecution.OutputSymlink{{Path: "dir_link", Target: "dir"}},
+	}
+
+	for name, tc := range map[string]struct {
+		pageType     string
+		actionResult *remoteexecution.ActionResult
+		cachedResult bool
+		expected     string
+		getCallsCAS  int
+		getCallsAC   int
+		accept       string
+	}{
+		"Action": {
+			pageType:     "action",
+			actionResult: actionResult,
+			expected:     `{"exitCode":1,"cached":true,"executionDurationSeconds":1.5,"outputsCount":4}`,
+			getCallsAC:   1,
+		},
+		"ActionLegacySymlinks": {
+			pageType:     "action",
+			actionResult: legacyActionResult,
+			expected:     `{"exitCode":0,"cached":true,"executionDurationSeconds":null,"outputsCount":2}`,
+			getCallsAC:   1,
+		},
+		"ActionAcceptHTML": {
+			// The summary is only available as JSON.
+			pageType:     "action",
+			actionResult: actionResult,
+			expected:     `{"exitCode":1,"cached":true,"executionDurationSeconds":1.5,"outputsCount":4}`,
+			getCallsAC:   1,
+			accept:       "text/html",
+		},
+		"HistoricalExecuteResponse": {
+			pageType:     "historical_execute_response",
+			actionResult: actionResult,
+			expected:     `{"exitCode":1,"cached":false,"executionDurationSeconds":1.5,"outputsCount":4}`,
+			getCallsCAS:  1,
+		},
+		"HistoricalExecuteResponseCached": {
+			pageType:     "historical_execute_response",
+			actionResult: legacyActionResult,
+			cachedResult: true,
+			expected:     `{"exitCode":0,"cached":true,"executionDurationSeconds":null,"outputsCount":2}`,
+			getCallsCAS:  1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			actionDigest := ts.putAction(t, commandDigest, inputRootDigest)
+			pageDigest := actionDigest
+			if tc.pageType == "action" {
+				ts.putActionResult(t, actionDigest, tc.actionResult)
+			} else {
+				pageDigest = cas.putMessage(t, &cas_proto.HistoricalExecuteResponse{
+					ActionDigest: actionDigest.GetProto(),
+					ExecuteResponse: &remoteexecution.ExecuteResponse{
+						Result:       tc.actionResult,
+						CachedResult: tc.cachedResult,
+					},
+				})
+			}
+
+			// Neither the Action, Command nor input root
+			// should be loaded from storage.
+			cas.getCalls = 0
+			ts.actionCache.getCalls = 0
+			req := httptest.NewRequest(http.MethodGet, getURL(tc.pageType, pageDigest, "?format=summary"), nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := ts.serve(req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Expected content type \"application/json\", got %#v", contentType)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tc.expected {
+				t.Errorf("Expected summary %s, got %s", tc.expected, got)
+			}
+			if cas.getCalls != tc.getCallsCAS || ts.actionCache.getCalls != tc.getCallsAC {
+				t.Errorf("Expected %d CAS and %d AC reads, got %d and %d", tc.getCallsCAS, tc.getCallsAC, cas.getCalls, ts.actionCache.getCalls)
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		missingActionResultDigest := ts.putAction(t, commandDigest, inputRootDigest)
+		failingActionResultDigest := ts.putAction(t, commandDigest, inputRootDigest)
+		ts.actionCache.errors[failingActionResultDigest] = status.Error(codes.Internal, "Disk on fire")
+		missingResultDigest := cas.putMessage(t, &cas_proto.HistoricalExecuteResponse{
+			ActionDigest:    ts.putAction(t, commandDigest, inputRootDigest).GetProto(),
+			ExecuteResponse: &remoteexecution.ExecuteResponse{},
+		})
+
+		for name, tc := range map[string]struct {
+			url        string
+			code       int
+			statusCode string
+			message    string
+		}{
+			"ActionResultNotFound": {
+				url:        getURL("action", missingActionResultDigest, "?format=summary"),
+				code:       http.StatusNotFound,
+				statusCode: "NotFound",
+				message:    "Could not find an action result",
+			},
+			"ActionCacheFailure": {
+				url:        getURL("action", failingActionResultDigest, "?format=summary"),
+				code:       http.StatusInternalServerError,
+				statusCode: "Internal",
+				message:    "Disk on fire",
+			},
+			"HistoricalExecuteResponseWithoutResult": {
+				url:        getURL("historical_execute_response", missingResultDigest, "?format=summary"),
+				code:       http.StatusNotFound,
+				statusCode: "NotFound",
+				message:    "Could not find an action result",
+			},
+			"InvalidDigest": {
+				url:        "/blobs/sha256/action/" + strings.Repeat("0", 64) + "-abc/?format=summary",
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Invalid blob size \"abc\"",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectError(t, tc.url, tc.code, tc.statusCode, tc.message)
+			})
+		}
+	})
+}
+
+func TestHandleActionProvenance(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	mainDigest := cas.putBytes([]byte("int main() {}\n"))
+	utilDigest := cas.putBytes([]byte("void util(void);\n"))
+	libDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "util.h", Digest: utilDigest.GetProto()},
+		},
+	})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{Name: "main.c", Digest: mainDigest.GetProto()},
+		},
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "lib", Digest: libDigest.GetProto()},
+		},
+		Symlinks: []*remoteexecution.SymlinkNode{
+			{Name: "link", Target: "main.c"},
+		},
+	})
+	command := &remoteexecution.Command{Arguments: []string{"cc", "-o", "main", "main.c"}}
+	commandDigest := cas.putMessage(t, command)
+	platform := &remoteexecution.Platform{
+		Properties: []*remoteexecution.Platform_Property{
+			{Name: "OSFamily", Value: "linux"},
+		},
+	}
+	missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+	newActionDigest := func(commandDigest, inputRootDigest digest.Digest) digest.Digest {
+		return ts.putActionMessage(t, &remoteexecution.Action{
+			CommandDigest:   commandDigest.GetProto(),
+			InputRootDigest: inputRootDigest.GetProto(),
+			Platform:        platform,
+		})
+	}
+	newResource := func(name string, blobDigest digest.Digest) provenanceResource {
+		return provenanceResource{
+			Name:      name,
+			Digest:    map[string]string{"sha256": blobDigest.GetHashString()},
+			SizeBytes: blobDigest.GetSizeBytes(),
+		}
+	}
+	outputDigest := cas.putBytes([]byte("ELF"))
+	treeDigest := cas.putMessage(t, &remoteexecution.Tree{Root: &remoteexecution.Directory{}})
+
+	for name, tc := range map[string]struct {
+		workingDirectory string
+		actionResult     *remoteexecution.ActionResult
+		products         []provenanceResource
+	}{
+		"NoOutputs": {
+			actionResult: &remoteexecution.ActionResult{},
+			products:     []provenanceResource{},
+		},
+		"Outputs": {
+			actionResult: &remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "main", Digest: outputDigest.GetProto()},
+				},
+				OutputDirectories: []*remoteexecution.OutputDirectory{
+					{Path: "tree", TreeDigest: treeDigest.GetProto()},
+				},
+				OutputSymlinks: []*remoteexecution.OutputSymlink{
+					{Path: "main.link", Target: "main"},
+				},
+			},
+			products: []provenanceResource{
+				newResource("main", outputDigest),
+				newResource("tree", treeDigest),
+			},
+		},
+		"OutputsInWorkingDirectory": {
+			// Products should be named relative to the
+			// input root, like materials.
+			workingDirectory: "src",
+			actionResult: &remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "main", Digest: outputDigest.GetProto()},
+				},
+				OutputDirectories: []*remoteexecution.OutputDirectory{
+					{Path: "tree", TreeDigest: treeDigest.GetProto()},
+				},
+			},
+			products: []provenanceResource{
+				newResource("src/main", outputDigest),
+				newResource("src/tree", treeDigest),
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			command := proto.Clone(command).(*remoteexecution.Command)
+			command.WorkingDirectory = tc.workingDirectory
+			actionDigest := newActionDigest(cas.putMessage(t, command), inputRootDigest)
+			ts.putActionResult(t, actionDigest, tc.actionResult)
+
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, "?format=provenance"), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Expected content type \"application/json\", got %#v", contentType)
+			}
+			var provenance struct {
+				Action             provenanceResource   `json:"action"`
+				Command            json.RawMessage      `json:"command"`
+				Platform           json.RawMessage      `json:"platform"`
+				Materials          []provenanceResource `json:"materials"`
+				MaterialsTruncated bool                 `json:"materialsTruncated"`
+				Products           []provenanceResource `json:"products"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &provenance); err != nil {
+				t.Fatal(err)
+			}
+
+			if expected := newResource("action", actionDigest); !reflect.DeepEqual(provenance.Action, expected) {
+				t.Errorf("Expected action %#v, got %#v", expected, provenance.Action)
+			}
+			var gotCommand remoteexecution.Command
+			if err := protojson.Unmarshal(provenance.Command, &gotCommand); err != nil || !proto.Equal(&gotCommand, command) {
+				t.Errorf("Expected command %v, got %s", command, provenance.Command)
+			}
+			var gotPlatform remoteexecution.Platform
+			if err := protojson.Unmarshal(provenance.Platform, &gotPlatform); err != nil || !proto.Equal(&gotPlatform, platform) {
+				t.Errorf("Expected platform %v, got %s", platform, provenance.Platform)
+			}
+			// Symbolic links are omitted, as they don't have
+			// a digest.
+			if expected := []provenanceResource{
+				newResource("lib/util.h", utilDigest),
+				newResource("main.c", mainDigest),
+			}; !reflect.DeepEqual(provenance.Materials, expected) || provenance.MaterialsTruncated {
+				t.Errorf("Expected materials %#v, got %#v (truncated: %v)", expected, provenance.Materials, provenance.MaterialsTruncated)
+			}
+			if !reflect.DeepEqual(provenance.Products, tc.products) {
+				t.Errorf("Expected products %#v, got %#v", tc.products, provenance.Products)
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			commandDigest   digest.Digest
+			inputRootDigest digest.Digest
+			actionResult    *remoteexecution.ActionResult
+			code            int
+			statusCode      string
+			message         string
+		}{
+			"ActionResultNotFound": {
+				commandDigest:   commandDigest,
+				inputRootDigest: inputRootDigest,
+				code:            http.StatusNotFound,
+				statusCode:      "NotFound",
+				message:         "Could not find an action result",
+			},
+			"CommandNotFound": {
+				commandDigest:   missingDigest,
+				inputRootDigest: inputRootDigest,
+				actionResult:    &remoteexecution.ActionResult{},
+				code:            http.StatusNotFound,
+				statusCode:      "NotFound",
+				message:         "Failed to obtain command: Object not found",
+			},
+			"InputRootNotFound": {
+				commandDigest:   commandDigest,
+				inputRootDigest: missingDigest,
+				actionResult:    &remoteexecution.ActionResult{},
+				code:            http.StatusNotFound,
+				statusCode:      "NotFound",
+				message:         "Directory \"" + missingDigest.String() + "\" is not present in the Content Addressable Storage",
+			},
+			"InvalidOutputFileDigest": {
+				commandDigest:   commandDigest,
+				inputRootDigest: inputRootDigest,
+				actionResult: &remoteexecution.ActionResult{
+					OutputFiles: []*remoteexecution.OutputFile{
+						{Path: "main", Digest: &remoteexecution.Digest{Hash: strings.Repeat("0", 64), SizeBytes: -1}},
+					},
+				},
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Invalid digest for output file \"main\": Invalid digest size",
+			},
+			"OutputDirectoryWithoutDigest": {
+				commandDigest:   commandDigest,
+				inputRootDigest: inputRootDigest,
+				actionResult: &remoteexecution.ActionResult{
+					OutputDirectories: []*remoteexecution.OutputDirectory{
+						{Path: "tree"},
+					},
+				},
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Invalid digest for output directory \"tree\": No digest provided",
+			},
+			"NonNormalizedOutputPath": {
+				commandDigest:   commandDigest,
+				inputRootDigest: inputRootDigest,
+				actionResult: &remoteexecution.ActionResult{
+					OutputFiles: []*remoteexecution.OutputFile{
+						{Path: "./main", Digest: outputDigest.GetProto()},
+					},
+				},
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Output \"./main\" has an invalid path",
+			},
+			"NonNormalizedWorkingDirectory": {
+				commandDigest: cas.putMessage(t, &remoteexecution.Command{
+					Arguments:        command.Arguments,
+					WorkingDirectory: "src/",
+				}),
+				inputRootDigest: inputRootDigest,
+				actionResult: &remoteexecution.ActionResult{
+					OutputFiles: []*remoteexecution.OutputFile{
+						{Path: "main", Digest: outputDigest.GetProto()},
+					},
+				},
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Output \"main\" has an invalid path",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				actionDigest := newActionDigest(tc.commandDigest, tc.inputRootDigest)
+				if tc.actionResult != nil {
+					ts.putActionResult(t, actionDigest, tc.actionResult)
+				}
+				ts.expectError(t, getURL("action", actionDigest, "?format=provenance"), tc.code, tc.statusCode, tc.message)
+			})
+		}
+	})
+}
+
+func TestDeclareTarballStatusTrailer(t *testing.T) {
+	for name, tc := range map[string]struct {
+		te       string
+		expected bool
+	}{
+		"Absent":          {te: "", expected: false},
+		"Trailers":        {te: "trailers", expected: true},
+		"CaseInsensitive": {te: "Trailers", expected: true},
+		"List":            {te: "gzip;q=0.5, trailers", expected: true},
+		"Parameters":      {te: "deflate, trailers;q=1", expected: true},
+		"OtherCodings":    {te: "gzip, deflate", expected: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.te != "" {
+				req.Header.Set("TE", tc.te)
+			}
+			w := httptest.NewRecorder()
+			if declared := declareTarballStatusTrailer(w, req); declared != tc.expected {
+				t.Errorf("Expected trailer to be declared: %v, got %v", tc.expected, declared)
+			}
+			expected := ""
+			if tc.expected {
+				expected = tarballStatusTrailer
+			}
+			if trailer := w.Header().Get("Trailer"); trailer != expected {
+				t.Errorf("Expected Trailer header %#v, got %#v", expected, trailer)
+			}
+		})
+	}
+}
+
+func TestTarballStatusTrailer(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	helloDigest := cas.putBytes([]byte("Hello, world!\n"))
+	failingDigest := cas.putBytes([]byte("Failing\n"))
+	cas.errors[failingDigest] = status.Error(codes.Internal, "Disk on fire")
+	newDirectory := func(fileDigest digest.Digest) digest.Digest {
+		return cas.putMessage(t, &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{Name: "file.txt", Digest: fileDigest.GetProto()},
+			},
+		})
+	}
+	directoryDigest := newDirectory(helloDigest)
+	failingDirectoryDigest := newDirectory(failingDigest)
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	newAction := func(fileDigest digest.Digest) digest.Digest {
+		actionDigest := cas.putMessage(t, &remoteexecution.Action{
+			CommandDigest:   commandDigest.GetProto(),
+			InputRootDigest: directoryDigest.GetProto(),
+			Salt:            []byte(fileDigest.GetHashString()),
+		})
+		ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{Path: "file.txt", Digest: fileDigest.GetProto()},
+			},
+		})
+		return actionDigest
+	}
+	actionDigest := newAction(helloDigest)
+	failingActionDigest := newAction(failingDigest)
+
+	for name, tc := range map[string]struct {
+		url     string
+		te      string
+		ranged  bool
+		trailer string
+		aborted bool
+	}{
+		"DirectoryComplete": {
+			url:     getURL("directory", directoryDigest, "?format=tar"),
+			te:      "trailers",
+			trailer: "complete",
+		},
+		"DirectoryIncomplete": {
+			url:     getURL("directory", failingDirectoryDigest, "?format=tar"),
+			te:      "trailers",
+			trailer: "incomplete",
+		},
+		"DirectoryWithoutTrailers": {
+			url: getURL("directory", directoryDigest, "?format=tar"),
+		},
+		"DirectoryAbortedWithoutTrailers": {
+			// Clients that don't accept trailers can
+			// only observe failures through the
+			// connection being aborted.
+			url:     getURL("directory", failingDirectoryDigest, "?format=tar"),
+			aborted: true,
+		},
+		"DirectoryRanged": {
+			// Partial responses have a Content-Length,
+			// meaning they can't carry trailers.
+			url:    getURL("directory", directoryDigest, "?format=tar&reproducible=1"),
+			te:     "trailers",
+			ranged: true,
+		},
+		"OutputsComplete": {
+			url:     getURL("action", actionDigest, "?format=tar"),
+			te:      "trailers",
+			trailer: "complete",
+		},
+		"OutputsIncomplete": {
+			url:     getURL("action", failingActionDigest, "?format=tar"),
+			te:      "gzip, trailers",
+			trailer: "incomplete",
+		},
+		"OutputsAbortedWithoutTrailers": {
+			url:     getURL("action", failingActionDigest, "?format=tar"),
+			aborted: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.te != "" {
+				req.Header.Set("TE", tc.te)
+			}
+			if tc.ranged {
+				req.Header.Set("Range", "bytes=10-")
+			}
+			w := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				defer func() {
+					if r := recover(); r != nil {
+						if r != http.ErrAbortHandler {
+							panic(r)
+						}
+						aborted = true
+					}
+				}()
+				ts.router.ServeHTTP(w, req)
+				return false
+			}()
+			if aborted != tc.aborted {
+				t.Fatalf("Expected response to be aborted: %v, got %v", tc.aborted, aborted)
+			}
+			if aborted {
+				return
+			}
+
+			expectedCode := http.StatusOK
+			if tc.ranged {
+				expectedCode = http.StatusPartialContent
+			}
+			if w.Code != expectedCode {
+				t.Fatalf("Expected status %d, got %d", expectedCode, w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/gzip" {
+				t.Errorf("Expected content type \"application/gzip\", got %#v", contentType)
+			}
+			expectedDeclaration := ""
+			if tc.trailer != "" {
+				expectedDeclaration = tarballStatusTrailer
+			}
+			if declaration := w.Header().Get("Trailer"); declaration != expectedDeclaration {
+				t.Errorf("Expected Trailer header %#v, got %#v", expectedDeclaration, declaration)
+			}
+			if trailer := w.Result().Trailer.Get(tarballStatusTrailer); trailer != tc.trailer {
+				t.Errorf("Expected tarball status %#v, got %#v", tc.trailer, trailer)
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		// Errors that occur before streaming starts are
+		// reported through the status code, without declaring
+		// a trailer.
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		for name, url := range map[string]string{
+			"Directory": getURL("directory", missingDigest, "?format=tar"),
+			"Outputs":   getURL("action", missingDigest, "?format=tar"),
+		} {
+			t.Run(name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, url, nil)
+				req.Header.Set("TE", "trailers")
+				w := ts.serve(req)
+				if w.Code != http.StatusNotFound || ts.templates.name != "page_error.html" {
+					t.Errorf("Expected page_error.html with status %d, got status %d and template %#v", http.StatusNotFound, w.Code, ts.templates.name)
+				}
+				if declaration := w.Header().Get("Trailer"); declaration != "" {
+					t.Errorf("Expected no trailer to be declared, got %#v", declaration)
+				}
+
+				req = httptest.NewRequest(http.MethodGet, url, nil)
+				req.Header.Set("TE", "trailers")
+				if code, response := ts.serveJSONError(t, req); code != http.StatusNotFound || response.Code != "NotFound" {
+					t.Errorf("Expected NotFound error, got status %d and %#v", code, response)
+				}
+			})
+		}
+	})
+}
+
+func TestComputeFileDuplicatesCounts(t *testing.T) {
+	helloDigest := testDigestFunction.NewGenerator(0).Sum().GetProto()
+	otherDigest := &remoteexecution.Digest{Hash: strings.Repeat("1", 64), SizeBytes: 5}
+	for name, tc := range map[string]struct {
+		files    []*remoteexecution.FileNode
+		expected map[string]int
+	}{
+		"Empty": {
+			expected: map[string]int{},
+		},
+		"Unique": {
+			files: []*remoteexecution.FileNode{
+				{Name: "a", Digest: helloDigest},
+				{Name: "b", Digest: otherDigest},
+			},
+			expected: map[string]int{},
+		},
+		"Identical": {
+			files: []*remoteexecution.FileNode{
+				{Name: "a", Digest: helloDigest},
+				{Name: "b", Digest: helloDigest},
+				{Name: "c", Digest: otherDigest},
+			},
+			expected: map[string]int{"a": 1, "b": 1},
+		},
+		"ExecutableBitDiffers": {
+			files: []*remoteexecution.FileNode{
+				{Name: "a", Digest: helloDigest},
+				{Name: "b", Digest: helloDigest, IsExecutable: true},
+				{Name: "c", Digest: helloDigest},
+			},
+			expected: map[string]int{"a": 1, "c": 1},
+		},
+		"SameHashDifferentSize": {
+			files: []*remoteexecution.FileNode{
+				{Name: "a", Digest: &remoteexecution.Digest{Hash: otherDigest.Hash, SizeBytes: 5}},
+				{Name: "b", Digest: &remoteexecution.Digest{Hash: otherDigest.Hash, SizeBytes: 6}},
+			},
+			expected: map[string]int{},
+		},
+		"Triplicate": {
+			files: []*remoteexecution.FileNode{
+				{Name: "a", Digest: otherDigest},
+				{Name: "b", Digest: otherDigest},
+				{Name: "c", Digest: otherDigest},
+			},
+			expected: map[string]int{"a": 2, "b": 2, "c": 2},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if counts := computeFileDuplicatesCounts(&remoteexecution.Directory{Files: tc.files}); !reflect.DeepEqual(counts, tc.expected) {
+				t.Errorf("Expected counts %#v, got %#v", tc.expected, counts)
+			}
+		})
+	}
+}
+
+func TestHandleDirectoryFileDuplicates(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	helloDigest := cas.putBytes([]byte("Hello\n")).GetProto()
+	worldDigest := cas.putBytes([]byte("World\n")).GetProto()
+	files := []*remoteexecution.FileNode{
+		{Name: "copy1.txt", Digest: helloDigest},
+		{Name: "copy2.txt", Digest: helloDigest},
+		{Name: "unique.txt", Digest: worldDigest},
+	}
+	largeDirectory := &remoteexecution.Directory{Files: files}
+	for i := 0; i < streamedDirectoryMinimumEntriesCount; i++ {
+		largeDirectory.Symlinks = append(largeDirectory.Symlinks, &remoteexecution.SymlinkNode{
+			Name:   fmt.Sprintf("link%05d", i),
+			Target: "target",
+		})
+	}
+	badge := "<span class=\"badge bg-secondary\" title=\"Other files in this directory having the same contents\">1 identical</span>"
+
+	for name, tc := range map[string]struct {
+		directory *remoteexecution.Directory
+		template  string
+	}{
+		"Regular":  {directory: &remoteexecution.Directory{Files: files}, template: "page_directory.html"},
+		"Streamed": {directory: largeDirectory, template: "directory_streamed_footer"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			directoryDigest := cas.putMessage(t, tc.directory)
+			w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("directory", directoryDigest, ""), nil))
+			if w.Code != http.StatusOK || ts.templates.name != tc.template {
+				t.Fatalf("Expected template %#v to be rendered, got status %d and template %#v", tc.template, w.Code, ts.templates.name)
+			}
+			if tc.template == "page_directory.html" {
+				counts := reflect.ValueOf(ts.templates.data).Elem().FieldByName("FileDuplicatesCounts").Interface().(map[string]int)
+				if expected := map[string]int{"copy1.txt": 1, "copy2.txt": 1}; !reflect.DeepEqual(counts, expected) {
+					t.Errorf("Expected counts %#v, got %#v", expected, counts)
+				}
+			}
+
+			// Only the files having identical contents
+			// should be marked as such.
+			body := w.Body.String()
+			if count := strings.Count(body, badge); count != 2 {
+				t.Errorf("Expected 2 badges, got %d", count)
+			}
+			for _, name := range []string{"copy1.txt", "copy2.txt"} {
+				if !regexp.MustCompile(regexp.QuoteMeta(">"+name+"</a>") + `\s*` + regexp.QuoteMeta(badge)).MatchString(body) {
+					t.Errorf("Expected %#v to be marked as having an identical file", name)
+				}
+			}
+
+			// The JSON representation is the Directory
+			// message itself.
+			var got remoteexecution.Directory
+			if code := ts.serveProtoJSON(t, getURL("directory", directoryDigest, ""), &got); code != http.StatusOK || !proto.Equal(&got, tc.directory) {
+				t.Errorf("Expected directory to be returned, got status %d", code)
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		ts.expectError(t, getURL("directory", missingDigest, ""), http.StatusNotFound, "NotFound", "Object not found")
+	})
+}
+
+func TestHandleOutputFileNavigation(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	inputRootDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	firstDigest := cas.putBytes([]byte("First\n"))
+	middleDigest := cas.putBytes([]byte("Middle\n"))
+	lastDigest := cas.putBytes([]byte("Last\n"))
+	// Output files are listed out of order, so that sorting is
+	// exercised. Two outputs have identical contents.
+	actionDigest := ts.putAction(t, commandDigest, inputRootDigest)
+	ts.putActionResult(t, actionDigest, &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "z.txt", Digest: lastDigest.GetProto()},
+			{Path: "a.txt", Digest: firstDigest.GetProto()},
+			{Path: "b/middle.txt", Digest: middleDigest.GetProto()},
+			{Path: "c.txt", Digest: lastDigest.GetProto()},
+		},
+	})
+	singleActionDigest := ts.putAction(t, commandDigest, inputRootDigest)
+	ts.putActionResult(t, singleActionDigest, &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{Path: "a.txt", Digest: firstDigest.GetProto()},
+		},
+	})
+	actionStr := fmt.Sprintf("%s-%d", actionDigest.GetHashString(), actionDigest.GetSizeBytes())
+	getLink := func(path string, fileDigest digest.Digest, name string) *outputFileNavigationLink {
+		return &outputFileNavigationLink{
+			Path: path,
+			URL:  fmt.Sprintf("../../file/%s-%d/%s?action=%s", fileDigest.GetHashString(), fileDigest.GetSizeBytes(), name, actionStr),
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		actionDigest digest.Digest
+		fileDigest   digest.Digest
+		name         string
+		path         string
+		index        int
+		count        int
+		previous     *outputFileNavigationLink
+		next         *outputFileNavigationLink
+		preview      string
+	}{
+		"First": {
+			actionDigest: actionDigest,
+			fileDigest:   firstDigest,
+			name:         "a.txt",
+			path:         "a.txt",
+			index:        1,
+			count:        4,
+			previous:     getLink("z.txt", lastDigest, "z.txt"),
+			next:         getLink("b/middle.txt", middleDigest, "middle.txt"),
+			preview:      "First",
+		},
+		"Middle": {
+			actionDigest: actionDigest,
+			fileDigest:   middleDigest,
+			name:         "middle.txt",
+			path:         "b/middle.txt",
+			index:        2,
+			count:        4,
+			previous:     getLink("a.txt", firstDigest, "a.txt"),
+			next:         getLink("c.txt", lastDigest, "c.txt"),
+			preview:      "Middle",
+		},
+		"IdenticalContents": {
+			// Files having the same contents are
+			// distinguished by their names.
+			actionDigest: actionDigest,
+			fileDigest:   lastDigest,
+			name:         "c.txt",
+			path:         "c.txt",
+			index:        3,
+			count:        4,
+			previous:     getLink("b/middle.txt", middleDigest, "middle.txt"),
+			next:         getLink("z.txt", lastDigest, "z.txt"),
+			preview:      "Last",
+		},
+		"Last": {
+			actionDigest: actionDigest,
+			fileDigest:   lastDigest,
+			name:         "z.txt",
+			path:         "z.txt",
+			index:        4,
+			count:        4,
+			previous:     getLink("c.txt", lastDigest, "c.txt"),
+			next:         getLink("a.txt", firstDigest, "a.txt"),
+			preview:      "Last",
+		},
+		"SingleOutput": {
+			actionDigest: singleActionDigest,
+			fileDigest:   firstDigest,
+			name:         "a.txt",
+			path:         "a.txt",
+			index:        1,
+			count:        1,
+			preview:      "First",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			url := getURL("file", tc.fileDigest, fmt.Sprintf("%s?action=%s-%d", tc.name, tc.actionDigest.GetHashString(), tc.actionDigest.GetSizeBytes()))
+			w := ts.serve(httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusOK || ts.templates.name != "page_output_file.html" {
+				t.Fatalf("Expected page_output_file.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+			}
+			data := ts.templates.data.(outputFileNavigationInfo)
+			if data.Path != tc.path {
+				t.Errorf("Expected path %#v, got %#v", tc.path, data.Path)
+			}
+			if data.Index != tc.index || data.Count != tc.count {
+				t.Errorf("Expected output file %d of %d, got %d of %d", tc.index, tc.count, data.Index, data.Count)
+			}
+			if data.ActionDigest != tc.actionDigest {
+				t.Errorf("Expected action digest %#v, got %#v", tc.actionDigest.String(), data.ActionDigest.String())
+			}
+			if !reflect.DeepEqual(data.Previous, tc.previous) {
+				t.Errorf("Expected previous link %#v, got %#v", tc.previous, data.Previous)
+			}
+			if !reflect.DeepEqual(data.Next, tc.next) {
+				t.Errorf("Expected next link %#v, got %#v", tc.next, data.Next)
+			}
+
+			body := w.Body.String()
+			if expected := fmt.Sprintf("Output file %d of %d of", tc.index, tc.count); !strings.Contains(body, expected) {
+				t.Errorf("Expected page to contain %#v", expected)
+			}
+			if !strings.Contains(body, tc.preview) {
+				t.Errorf("Expected page to contain a preview containing %#v", tc.preview)
+			}
+			for _, link := range []*outputFileNavigationLink{tc.previous, tc.next} {
+				if link != nil && !strings.Contains(body, fmt.Sprintf("href=\"%s\"", link.URL)) {
+					t.Errorf("Expected page to link to %#v", link.URL)
+				}
+			}
+			if hasNavigation := strings.Contains(body, "<nav class=\"mb-3\">"); hasNavigation != (tc.previous != nil) {
+				t.Errorf("Expected page to contain navigation: %v, got %v", tc.previous != nil, hasNavigation)
+			}
+		})
+	}
+
+	t.Run("ActionPage", func(t *testing.T) {
+		// Output files on the action page link to the
+		// navigation page.
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("action", actionDigest, ""), nil))
+		if w.Code != http.StatusOK || ts.templates.name != "page_action.html" {
+			t.Fatalf("Expected page_action.html to be rendered, got status %d and template %#v", w.Code, ts.templates.name)
+		}
+		if link := fmt.Sprintf("href=\"../../file/%s-%d/middle.txt?action=%s\"", middleDigest.GetHashString(), middleDigest.GetSizeBytes(), actionStr); !strings.Contains(w.Body.String(), link) {
+			t.Errorf("Expected action page to contain %#v", link)
+		}
+	})
+
+	t.Run("WithoutAction", func(t *testing.T) {
+		// Without the action parameter, the file is served
+		// as is.
+		w := ts.serve(httptest.NewRequest(http.MethodGet, getURL("file", middleDigest, "middle.txt"), nil))
+		if w.Code != http.StatusOK || w.Body.String() != "Middle\n" {
+			t.Errorf("Expected file contents, got status %d and body %#v", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		missingDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, strings.Repeat("0", 64), 123)
+		for name, tc := range map[string]struct {
+			action     string
+			name       string
+			code       int
+			statusCode string
+			message    string
+		}{
+			"MalformedAction": {
+				action:     "hello",
+				name:       "a.txt",
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Action \"hello\" is not of the form ${hash}-${size_bytes}",
+			},
+			"InvalidActionSize": {
+				action:     actionDigest.GetHashString() + "-abc",
+				name:       "a.txt",
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Invalid action size \"abc\"",
+			},
+			"InvalidActionHash": {
+				action:     strings.Repeat("g", 64) + "-123",
+				name:       "a.txt",
+				code:       http.StatusBadRequest,
+				statusCode: "InvalidArgument",
+				message:    "Invalid action digest: Non-hexadecimal character in digest hash",
+			},
+			"ActionResultNotFound": {
+				action:     fmt.Sprintf("%s-%d", missingDigest.GetHashString(), missingDigest.GetSizeBytes()),
+				name:       "a.txt",
+				code:       http.StatusNotFound,
+				statusCode: "NotFound",
+				message:    "Failed to obtain action result: Object not found",
+			},
+			"NotAnOutputFile": {
+				action:     actionStr,
+				name:       "other.txt",
+				code:       http.StatusNotFound,
+				statusCode: "NotFound",
+				message:    "File \"other.txt\" is not an output file of action \"" + actionDigest.String() + "\"",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ts.expectError(t, getURL("file", firstDigest, tc.name+"?action="+tc.action), tc.code, tc.statusCode, tc.message)
+			})
+		}
+	})
+}
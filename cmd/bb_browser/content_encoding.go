@@ -0,0 +1,200 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// contentEncodings contains the content codings that may be applied
+// to responses, in order of preference when the client assigns them
+// the same quality value.
+var contentEncodings = []string{"br", "gzip", "identity"}
+
+// parseAcceptEncoding parses the value of an Accept-Encoding header,
+// returning the quality value of each of the content codings listed.
+// Content codings are converted to lowercase.
+func parseAcceptEncoding(acceptEncoding string) map[string]float64 {
+	qualities := map[string]float64{}
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(entry, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		for _, parameter := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(parameter), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		qualities[coding] = quality
+	}
+	return qualities
+}
+
+// negotiateContentEncoding selects the content coding to apply to a
+// response, based on the quality values provided by the client in the
+// Accept-Encoding header. An empty string is returned if the response
+// should not be encoded.
+func negotiateContentEncoding(acceptEncoding string) string {
+	qualities := parseAcceptEncoding(acceptEncoding)
+	bestEncoding, bestQuality := "", 0.0
+	for _, coding := range contentEncodings {
+		quality, ok := qualities[coding]
+		if !ok {
+			if quality, ok = qualities["*"]; !ok {
+				if coding != "identity" {
+					continue
+				}
+				// The identity coding is always acceptable,
+				// unless explicitly excluded. It is only used
+				// if no other coding is acceptable.
+				quality = math.SmallestNonzeroFloat64
+			}
+		}
+		if quality > bestQuality {
+			bestEncoding, bestQuality = coding, quality
+		}
+	}
+	if bestEncoding == "identity" {
+		return ""
+	}
+	return bestEncoding
+}
+
+// isCompressibleContentType returns whether responses of a given
+// content type are likely to benefit from compression. Content that is
+// already compressed (e.g., tarballs, images) is left alone.
+func isCompressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml", "image/svg+xml":
+		return true
+	default:
+		return strings.HasPrefix(mediaType, "text/")
+	}
+}
+
+type contentEncodingHandler struct {
+	base http.Handler
+}
+
+// NewContentEncodingHandler creates a decorator for http.Handler that
+// compresses responses using Brotli or gzip, depending on the content
+// codings supported by the client.
+func NewContentEncodingHandler(base http.Handler) http.Handler {
+	return &contentEncodingHandler{
+		base: base,
+	}
+}
+
+func (h *contentEncodingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := negotiateContentEncoding(req.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		h.base.ServeHTTP(w, req)
+		return
+	}
+
+	// Don't use defer to close the compressor. Handlers may panic
+	// with http.ErrAbortHandler, in which case the response should
+	// not be terminated properly.
+	cw := &contentEncodingResponseWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+	}
+	h.base.ServeHTTP(cw, req)
+	if cw.compressor != nil {
+		if err := cw.compressor.Close(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// contentEncodingResponseWriter is a decorator for http.ResponseWriter
+// that compresses the response body. Whether compression is applied is
+// decided when the response header is written, as that is the point at
+// which the content type is known.
+type contentEncodingResponseWriter struct {
+	http.ResponseWriter
+	encoding   string
+	decided    bool
+	compressor interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (w *contentEncodingResponseWriter) decide(body []byte) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		if body == nil {
+			return
+		}
+		// Perform the same content type detection as
+		// net/http would, as it's not capable of doing this
+		// on compressed data.
+		contentType = http.DetectContentType(body)
+		header.Set("Content-Type", contentType)
+	}
+	if !isCompressibleContentType(contentType) {
+		return
+	}
+
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", w.encoding)
+	switch w.encoding {
+	case "br":
+		w.compressor = brotli.NewWriter(w.ResponseWriter)
+	case "gzip":
+		w.compressor = gzip.NewWriter(w.ResponseWriter)
+	default:
+		panic("Unknown content encoding")
+	}
+}
+
+func (w *contentEncodingResponseWriter) WriteHeader(statusCode int) {
+	w.decide(nil)
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *contentEncodingResponseWriter) Write(p []byte) (int, error) {
+	w.decide(p)
+	if w.compressor != nil {
+		return w.compressor.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *contentEncodingResponseWriter) Flush() {
+	if w.compressor != nil {
+		if err := w.compressor.Flush(); err != nil {
+			log.Print(err)
+		}
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateContentEncoding(t *testing.T) {
+	for name, tc := range map[string]struct {
+		acceptEncoding string
+		expected       string
+	}{
+		"Empty":                 {acceptEncoding: "", expected: ""},
+		"Gzip":                  {acceptEncoding: "gzip", expected: "gzip"},
+		"Brotli":                {acceptEncoding: "br", expected: "br"},
+		"PreferBrotli":          {acceptEncoding: "gzip, deflate, br", expected: "br"},
+		"QualityValues":         {acceptEncoding: "br;q=0.5, gzip;q=0.8", expected: "gzip"},
+		"Uppercase":             {acceptEncoding: "GZIP", expected: "gzip"},
+		"Whitespace":            {acceptEncoding: " gzip ; q=1 ", expected: "gzip"},
+		"Unsupported":           {acceptEncoding: "deflate, zstd", expected: ""},
+		"Excluded":              {acceptEncoding: "gzip;q=0", expected: ""},
+		"Wildcard":              {acceptEncoding: "*", expected: "br"},
+		"WildcardExclusion":     {acceptEncoding: "br;q=0, *", expected: "gzip"},
+		"IdentityPreferred":     {acceptEncoding: "gzip;q=0.5, identity", expected: ""},
+		"IdentityExcluded":      {acceptEncoding: "identity;q=0, gzip;q=0.1", expected: "gzip"},
+		"InvalidQualityIgnored": {acceptEncoding: "gzip;q=foo", expected: "gzip"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if encoding := negotiateContentEncoding(tc.acceptEncoding); encoding != tc.expected {
+				t.Errorf("Expected encoding %#v, got %#v", tc.expected, encoding)
+			}
+		})
+	}
+}
+
+func TestContentEncodingHandler(t *testing.T) {
+	const body = "Hello, world! Hello, world! Hello, world!"
+	for name, tc := range map[string]struct {
+		acceptEncoding string
+		contentType    string
+		encoding       string
+	}{
+		"NoEncoding":     {acceptEncoding: "", contentType: "text/plain; charset=utf-8", encoding: ""},
+		"Gzip":           {acceptEncoding: "gzip", contentType: "text/plain; charset=utf-8", encoding: "gzip"},
+		"Brotli":         {acceptEncoding: "br", contentType: "application/json", encoding: "br"},
+		"DetectedType":   {acceptEncoding: "gzip", contentType: "", encoding: "gzip"},
+		"Incompressible": {acceptEncoding: "gzip", contentType: "image/png", encoding: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler := NewContentEncodingHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if tc.contentType != "" {
+					w.Header().Set("Content-Type", tc.contentType)
+				}
+				io.WriteString(w, body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("Expected Vary header \"Accept-Encoding\", got %#v", vary)
+			}
+			if encoding := w.Header().Get("Content-Encoding"); encoding != tc.encoding {
+				t.Fatalf("Expected encoding %#v, got %#v", tc.encoding, encoding)
+			}
+			var r io.Reader = w.Body
+			switch tc.encoding {
+			case "br":
+				r = brotli.NewReader(r)
+			case "gzip":
+				gzipReader, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gzipReader
+			}
+			var decoded strings.Builder
+			if _, err := io.Copy(&decoded, r); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.String() != body {
+				t.Errorf("Expected body %#v, got %#v", body, decoded.String())
+			}
+		})
+	}
+}
@@ -10,6 +10,10 @@ import (
 	"path"
 	"strings"
 	"time"
+	// Embed the time zone database, so that timestamps can be
+	// displayed in any time zone, regardless of whether the
+	// database is installed on the system.
+	_ "time/tzdata"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-browser/pkg/proto/configuration/bb_browser"
@@ -17,11 +21,13 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/global"
 	"github.com/buildbarn/bb-storage/pkg/http"
 	"github.com/buildbarn/bb-storage/pkg/program"
 	auth_pb "github.com/buildbarn/bb-storage/pkg/proto/auth"
+	auth_configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/auth"
 	"github.com/buildbarn/bb-storage/pkg/proto/iscc"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/dustin/go-humanize"
@@ -31,6 +37,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -59,6 +66,153 @@ var (
 	favicon []byte
 )
 
+// newTemplateFuncMap returns the functions that may be called from
+// within the HTML templates.
+func newTemplateFuncMap() template.FuncMap {
+	faviconURL := template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(favicon))
+	return template.FuncMap{
+		"basename":    path.Base,
+		"favicon_url": func() template.URL { return faviconURL },
+		"humanize_bytes": func(v interface{}) string {
+			switch i := v.(type) {
+			case uint64:
+				return humanize.Bytes(i)
+			case int64:
+				return humanize.Bytes(uint64(i))
+			default:
+				panic("Unknown type")
+			}
+		},
+		"dec": func(n int64) int64 {
+			return n - 1
+		},
+		"inc": func(n int) int {
+			return n + 1
+		},
+		"inc64": func(n int64) int64 {
+			return n + 1
+		},
+		"output_path_in_input_root": getOutputPathInInputRoot,
+		"proto_to_json":             protojson.MarshalOptions{}.Format,
+		"proto_to_text":             prototext.MarshalOptions{Multiline: true}.Format,
+		"stylesheet":                func() template.CSS { return stylesheet },
+		"to_authentication_metadata": func(any *anypb.Any) *auth_pb.AuthenticationMetadata {
+			var pb auth_pb.AuthenticationMetadata
+			if err := any.UnmarshalTo(&pb); err != nil {
+				return nil
+			}
+			return &pb
+		},
+		"to_outcome_failed": func(previousExecution *iscc.PreviousExecution) bool {
+			_, ok := previousExecution.Outcome.(*iscc.PreviousExecution_Failed)
+			return ok
+		},
+		"to_outcome_timed_out": func(previousExecution *iscc.PreviousExecution) *time.Duration {
+			if outcome, ok := previousExecution.Outcome.(*iscc.PreviousExecution_TimedOut); ok {
+				if outcome.TimedOut.CheckValid() == nil {
+					d := outcome.TimedOut.AsDuration()
+					return &d
+				}
+			}
+			return nil
+		},
+		"to_outcome_succeeded": func(previousExecution *iscc.PreviousExecution) *time.Duration {
+			if outcome, ok := previousExecution.Outcome.(*iscc.PreviousExecution_Succeeded); ok {
+				if outcome.Succeeded.CheckValid() == nil {
+					d := outcome.Succeeded.AsDuration()
+					return &d
+				}
+			}
+			return nil
+		},
+		"to_monetary_resource_usage": func(any *anypb.Any) *resourceusage.MonetaryResourceUsage {
+			var pb resourceusage.MonetaryResourceUsage
+			if err := any.UnmarshalTo(&pb); err != nil {
+				return nil
+			}
+			return &pb
+		},
+		"to_file_pool_resource_usage": func(any *anypb.Any) *resourceusage.FilePoolResourceUsage {
+			var pb resourceusage.FilePoolResourceUsage
+			if any.UnmarshalTo(&pb) != nil {
+				return nil
+			}
+			return &pb
+		},
+		"to_input_root_resource_usage": func(any *anypb.Any) *resourceusage.InputRootResourceUsage {
+			var pb resourceusage.InputRootResourceUsage
+			if any.UnmarshalTo(&pb) != nil {
+				return nil
+			}
+			return &pb
+		},
+		"to_posix_resource_usage": func(any *anypb.Any) *resourceusage.POSIXResourceUsage {
+			var pb resourceusage.POSIXResourceUsage
+			if any.UnmarshalTo(&pb) != nil {
+				return nil
+			}
+			return &pb
+		},
+		"to_request_metadata": func(any *anypb.Any) *remoteexecution.RequestMetadata {
+			var pb remoteexecution.RequestMetadata
+			if any.UnmarshalTo(&pb) != nil {
+				return nil
+			}
+			return &pb
+		},
+		"to_worker_id": func(worker string) map[string]string {
+			var workerID map[string]string
+			if json.Unmarshal([]byte(worker), &workerID) != nil {
+				return nil
+			}
+			return workerID
+		},
+		"shellquote": shellquote.Join,
+		"timestamp_rfc3339": func(t time.Time) string {
+			// Converts a timestamp to RFC3339 format.
+			return t.Format(rfc3339Milli)
+		},
+		"timestamp_proto_delta": func(location *time.Location, pbPrevious, pbNow *timestamppb.Timestamp) *timestampDelta {
+			if err := pbNow.CheckValid(); err != nil {
+				return nil
+			}
+			tNow := pbNow.AsTime().In(location)
+			if err := pbPrevious.CheckValid(); err != nil {
+				// Time may be parsed, but no split time
+				// is available.
+				return &timestampDelta{
+					Time: tNow,
+				}
+			}
+			tPrevious := pbPrevious.AsTime()
+			if tNow.Equal(tPrevious) {
+				// Don't display the split time, as
+				// there is no difference.
+				return nil
+			}
+			return &timestampDelta{
+				Time:                 tNow,
+				DurationFromPrevious: tNow.Sub(tPrevious),
+			}
+		},
+		"timestamp_proto_rfc3339": func(pb *timestamppb.Timestamp) string {
+			// Converts a Protobuf timestamp to RFC 3339 format.
+			if pb.CheckValid() != nil {
+				return ""
+			}
+			return pb.AsTime().Format(rfc3339Milli)
+		},
+		"timestamp_proto_rfc3339_in_location": func(location *time.Location, pb *timestamppb.Timestamp) string {
+			// Converts a Protobuf timestamp to RFC 3339
+			// format, using a given time zone.
+			if pb.CheckValid() != nil {
+				return ""
+			}
+			return pb.AsTime().In(location).Format(rfc3339Milli)
+		},
+	}
+}
+
 func main() {
 	program.RunMain(func(ctx context.Context, siblingsGroup, dependenciesGroup program.Group) error {
 		if len(os.Args) != 2 {
@@ -89,8 +243,10 @@ func main() {
 			return util.StatusWrap(err, "Failed to create authorizer")
 		}
 
-		// nil the put and findMissing authorizers - bb-browser shouldn't ever use these APIs.
-		contentAddressableStorage = blobstore.NewAuthorizingBlobAccess(contentAddressableStorage, authorizer, nil, nil)
+		// nil the put authorizers - bb-browser shouldn't ever use
+		// these APIs. FindMissing() is only called against the CAS,
+		// to determine whether inputs of actions are still present.
+		contentAddressableStorage = blobstore.NewAuthorizingBlobAccess(contentAddressableStorage, authorizer, nil, authorizer)
 		actionCache = blobstore.NewAuthorizingBlobAccess(actionCache, authorizer, nil, nil)
 
 		var initialSizeClassCache blobstore.BlobAccess
@@ -129,135 +285,18 @@ func main() {
 		if !strings.HasSuffix(routePrefix, "/") {
 			routePrefix += "/"
 		}
+		basePath := strings.TrimSuffix(path.Join("/", configuration.BasePath), "/")
 
-		faviconURL := template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(favicon))
-		templates, err := template.New("templates").Funcs(template.FuncMap{
-			"basename":    path.Base,
-			"favicon_url": func() template.URL { return faviconURL },
-			"humanize_bytes": func(v interface{}) string {
-				switch i := v.(type) {
-				case uint64:
-					return humanize.Bytes(i)
-				case int64:
-					return humanize.Bytes(uint64(i))
-				default:
-					panic("Unknown type")
-				}
-			},
-			"inc": func(n int) int {
-				return n + 1
-			},
-			"proto_to_json": protojson.MarshalOptions{}.Format,
-			"stylesheet":    func() template.CSS { return stylesheet },
-			"to_authentication_metadata": func(any *anypb.Any) *auth_pb.AuthenticationMetadata {
-				var pb auth_pb.AuthenticationMetadata
-				if err := any.UnmarshalTo(&pb); err != nil {
-					return nil
-				}
-				return &pb
-			},
-			"to_outcome_failed": func(previousExecution *iscc.PreviousExecution) bool {
-				_, ok := previousExecution.Outcome.(*iscc.PreviousExecution_Failed)
-				return ok
-			},
-			"to_outcome_timed_out": func(previousExecution *iscc.PreviousExecution) *time.Duration {
-				if outcome, ok := previousExecution.Outcome.(*iscc.PreviousExecution_TimedOut); ok {
-					if outcome.TimedOut.CheckValid() == nil {
-						d := outcome.TimedOut.AsDuration()
-						return &d
-					}
-				}
-				return nil
-			},
-			"to_outcome_succeeded": func(previousExecution *iscc.PreviousExecution) *time.Duration {
-				if outcome, ok := previousExecution.Outcome.(*iscc.PreviousExecution_Succeeded); ok {
-					if outcome.Succeeded.CheckValid() == nil {
-						d := outcome.Succeeded.AsDuration()
-						return &d
-					}
-				}
-				return nil
-			},
-			"to_monetary_resource_usage": func(any *anypb.Any) *resourceusage.MonetaryResourceUsage {
-				var pb resourceusage.MonetaryResourceUsage
-				if err := any.UnmarshalTo(&pb); err != nil {
-					return nil
-				}
-				return &pb
-			},
-			"to_file_pool_resource_usage": func(any *anypb.Any) *resourceusage.FilePoolResourceUsage {
-				var pb resourceusage.FilePoolResourceUsage
-				if any.UnmarshalTo(&pb) != nil {
-					return nil
-				}
-				return &pb
-			},
-			"to_input_root_resource_usage": func(any *anypb.Any) *resourceusage.InputRootResourceUsage {
-				var pb resourceusage.InputRootResourceUsage
-				if any.UnmarshalTo(&pb) != nil {
-					return nil
-				}
-				return &pb
-			},
-			"to_posix_resource_usage": func(any *anypb.Any) *resourceusage.POSIXResourceUsage {
-				var pb resourceusage.POSIXResourceUsage
-				if any.UnmarshalTo(&pb) != nil {
-					return nil
-				}
-				return &pb
-			},
-			"to_request_metadata": func(any *anypb.Any) *remoteexecution.RequestMetadata {
-				var pb remoteexecution.RequestMetadata
-				if any.UnmarshalTo(&pb) != nil {
-					return nil
-				}
-				return &pb
-			},
-			"to_worker_id": func(worker string) map[string]string {
-				var workerID map[string]string
-				if json.Unmarshal([]byte(worker), &workerID) != nil {
-					return nil
-				}
-				return workerID
-			},
-			"shellquote": shellquote.Join,
-			"timestamp_rfc3339": func(t time.Time) string {
-				// Converts a timestamp to RFC3339 format.
-				return t.Format(rfc3339Milli)
-			},
-			"timestamp_proto_delta": func(pbPrevious, pbNow *timestamppb.Timestamp) *timestampDelta {
-				if err := pbNow.CheckValid(); err != nil {
-					return nil
-				}
-				tNow := pbNow.AsTime()
-				if err := pbPrevious.CheckValid(); err != nil {
-					// Time may be parsed, but no split time
-					// is available.
-					return &timestampDelta{
-						Time: tNow,
-					}
-				}
-				tPrevious := pbPrevious.AsTime()
-				if tNow.Equal(tPrevious) {
-					// Don't display the split time, as
-					// there is no difference.
-					return nil
-				}
-				return &timestampDelta{
-					Time:                 tNow,
-					DurationFromPrevious: tNow.Sub(tPrevious),
-				}
-			},
-			"timestamp_proto_rfc3339": func(pb *timestamppb.Timestamp) string {
-				// Converts a Protobuf timestamp to RFC 3339 format.
-				if pb.CheckValid() != nil {
-					return ""
-				}
-				return pb.AsTime().Format(rfc3339Milli)
-			},
-		}).ParseFS(templatesFS, "templates/*.html")
-		if err != nil {
-			return util.StatusWrap(err, "Failed to parse HTML templates")
+		funcMap := newTemplateFuncMap()
+		var templates TemplateExecutor
+		if templatesDirectory := configuration.DevelopmentTemplatesDirectory; templatesDirectory != "" {
+			templates = NewReloadingTemplateExecutor(os.DirFS(templatesDirectory), funcMap)
+		} else {
+			parsedTemplates, err := template.New("templates").Funcs(funcMap).ParseFS(templatesFS, "templates/*.html")
+			if err != nil {
+				return util.StatusWrap(err, "Failed to parse HTML templates")
+			}
+			templates = parsedTemplates
 		}
 
 		// Prefix to add to instance names that are placed in bb_clientd
@@ -268,9 +307,91 @@ func main() {
 		}
 		bbClientdInstanceNamePatcher := digest.NewInstanceNamePatcher(digest.EmptyInstanceName, bbClientdInstanceNamePrefix)
 
+		defaultInstanceName, err := digest.NewInstanceName(configuration.DefaultInstanceName)
+		if err != nil {
+			return util.StatusWrapf(err, "Invalid default instance name %#v", configuration.DefaultInstanceName)
+		}
+
+		var allowedInstanceNames *digest.InstanceNameTrie
+		if len(configuration.AllowedInstanceNames) > 0 {
+			allowedInstanceNames = digest.NewInstanceNameTrie()
+			for _, instanceNameStr := range configuration.AllowedInstanceNames {
+				instanceName, err := digest.NewInstanceName(instanceNameStr)
+				if err != nil {
+					return util.StatusWrapf(err, "Invalid allowed instance name %#v", instanceNameStr)
+				}
+				allowedInstanceNames.Set(instanceName, 0)
+			}
+		}
+
+		contentSniffingPrefixSizeBytes := 4096
+		if configuration.ContentSniffingPrefixSizeBytes > 0 {
+			contentSniffingPrefixSizeBytes = int(configuration.ContentSniffingPrefixSizeBytes)
+		}
+
+		maximumCompressedFileSizeBytes := int64(10 * 1024 * 1024)
+		if configuration.MaximumCompressedFileSizeBytes > 0 {
+			maximumCompressedFileSizeBytes = configuration.MaximumCompressedFileSizeBytes
+		}
+
+		for _, pattern := range configuration.PreviewedOutputFilePatterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid previewed output file pattern %#v", pattern)
+			}
+		}
+
+		if standardInputPath := configuration.StandardInputPath; standardInputPath != "" && (path.IsAbs(standardInputPath) || path.Clean(standardInputPath) != standardInputPath || standardInputPath == ".." || strings.HasPrefix(standardInputPath, "../")) {
+			return status.Errorf(codes.InvalidArgument, "Standard input path %#v is not a normalized relative path", standardInputPath)
+		}
+
+		var maximumConcurrentTarballGenerations int
+		var maximumTarballGenerationQueueingDuration time.Duration
+		if limit := configuration.TarballGenerationLimit; limit != nil {
+			maximumConcurrentTarballGenerations = int(limit.MaximumConcurrentGenerations)
+			if d := limit.MaximumQueueingDuration; d != nil {
+				if err := d.CheckValid(); err != nil {
+					return util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid maximum tarball generation queueing duration")
+				}
+				maximumTarballGenerationQueueingDuration = d.AsDuration()
+			}
+		}
+
+		// Permit browsing and downloading from any instance name,
+		// while denying access to the endpoints for managing tarball
+		// generation, unless explicitly configured otherwise.
+		operationAuthorizers := configuration.OperationAuthorizers
+		newOperationAuthorizer := func(configuration *auth_configuration.AuthorizerConfiguration, defaultAuthorizer auth.Authorizer, operation string) (auth.Authorizer, error) {
+			if configuration == nil {
+				return defaultAuthorizer, nil
+			}
+			authorizer, err := authorizerFactory.NewAuthorizerFromConfiguration(configuration)
+			if err != nil {
+				return nil, util.StatusWrapf(err, "Failed to create authorizer for operation %#v", operation)
+			}
+			return authorizer, nil
+		}
+		allowAuthorizer := auth.NewStaticAuthorizer(func(digest.InstanceName) bool { return true })
+		denyAuthorizer := auth.NewStaticAuthorizer(func(digest.InstanceName) bool { return false })
+		browseAuthorizer, err := newOperationAuthorizer(operationAuthorizers.GetBrowse(), allowAuthorizer, "browse")
+		if err != nil {
+			return err
+		}
+		downloadAuthorizer, err := newOperationAuthorizer(operationAuthorizers.GetDownload(), browseAuthorizer, "download")
+		if err != nil {
+			return err
+		}
+		listTarballGenerationsAuthorizer, err := newOperationAuthorizer(operationAuthorizers.GetListTarballGenerations(), denyAuthorizer, "list_tarball_generations")
+		if err != nil {
+			return err
+		}
+		cancelTarballGenerationAuthorizer, err := newOperationAuthorizer(operationAuthorizers.GetCancelTarballGeneration(), denyAuthorizer, "cancel_tarball_generation")
+		if err != nil {
+			return err
+		}
+
 		router := mux.NewRouter()
 		subrouter := router.PathPrefix(routePrefix).Subrouter()
-		NewBrowserService(
+		browserService := NewBrowserService(
 			contentAddressableStorage,
 			actionCache,
 			initialSizeClassCache,
@@ -278,10 +399,43 @@ func main() {
 			int(configuration.MaximumMessageSizeBytes),
 			templates,
 			bbClientdInstanceNamePatcher,
+			&BrowserServiceOptions{
+				RoutePrefix:                              routePrefix,
+				BasePath:                                 basePath,
+				CollapsedLogMinimumLines:                 int(configuration.CollapsedLogMinimumLines),
+				DefaultInstanceName:                      defaultInstanceName,
+				RememberLastInstanceName:                 configuration.RememberLastInstanceName,
+				AllowedInstanceNames:                     allowedInstanceNames,
+				ContentSniffingPrefixSizeBytes:           contentSniffingPrefixSizeBytes,
+				MaximumCompressedFileSizeBytes:           maximumCompressedFileSizeBytes,
+				PreviewedOutputFilePatterns:              configuration.PreviewedOutputFilePatterns,
+				StandardInputPath:                        configuration.StandardInputPath,
+				MaximumConcurrentTarballGenerations:      maximumConcurrentTarballGenerations,
+				MaximumTarballGenerationQueueingDuration: maximumTarballGenerationQueueingDuration,
+				BrowseAuthorizer:                         browseAuthorizer,
+				DownloadAuthorizer:                       downloadAuthorizer,
+				ListTarballGenerationsAuthorizer:         listTarballGenerationsAuthorizer,
+				CancelTarballGenerationAuthorizer:        cancelTarballGenerationAuthorizer,
+				Clock:                                    clock.SystemClock,
+			},
 			subrouter)
+		if rateLimiting := configuration.ClientRateLimiting; rateLimiting != nil {
+			pageRateLimiter, err := newClientRateLimiterFromConfiguration(rateLimiting.Pages, clock.SystemClock)
+			if err != nil {
+				return util.StatusWrap(err, "Invalid rate limit for pages")
+			}
+			downloadRateLimiter, err := newClientRateLimiterFromConfiguration(rateLimiting.Downloads, clock.SystemClock)
+			if err != nil {
+				return util.StatusWrap(err, "Invalid rate limit for downloads")
+			}
+			subrouter.Use(browserService.NewClientRateLimitingMiddleware(
+				rateLimiting.ClientAddressHeader,
+				pageRateLimiter,
+				downloadRateLimiter))
+		}
 		http.NewServersFromConfigurationAndServe(
 			configuration.HttpServers,
-			http.NewMetricsHandler(router, "BrowserUI"),
+			http.NewMetricsHandler(NewContentEncodingHandler(router), "BrowserUI"),
 			siblingsGroup,
 		)
 
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-browser/pkg/proto/configuration/bb_browser"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/gorilla/mux"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tokenBucket contains the state of a rate limiter for a single client.
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// ClientRateLimiter implements a token bucket rate limiter that keeps
+// track of a separate bucket for every client. Buckets of clients that
+// have been idle long enough for their bucket to be full again are
+// discarded, so that memory usage remains proportional to the number
+// of active clients.
+type ClientRateLimiter struct {
+	clock           clock.Clock
+	tokensPerSecond float64
+	burstSize       float64
+
+	lock       sync.Mutex
+	buckets    map[string]*tokenBucket
+	lastPruned time.Time
+}
+
+// NewClientRateLimiter creates a rate limiter that permits clients to
+// perform a sustained number of requests per second, while allowing
+// bursts of up to a given number of requests. The number of requests
+// per second must be positive.
+func NewClientRateLimiter(clock clock.Clock, tokensPerSecond float64, burstSize uint32) *ClientRateLimiter {
+	return &ClientRateLimiter{
+		clock:           clock,
+		tokensPerSecond: tokensPerSecond,
+		burstSize:       float64(burstSize),
+		buckets:         map[string]*tokenBucket{},
+		lastPruned:      clock.Now(),
+	}
+}
+
+// newClientRateLimiterFromConfiguration creates a rate limiter based
+// on options provided in the configuration file. No rate limiter is
+// returned if no configuration is provided.
+func newClientRateLimiterFromConfiguration(configuration *bb_browser.RateLimitConfiguration, clock clock.Clock) (*ClientRateLimiter, error) {
+	if configuration == nil {
+		return nil, nil
+	}
+	if !(configuration.TokensPerSecond > 0) {
+		return nil, status.Error(codes.InvalidArgument, "Tokens per second must be positive")
+	}
+	if configuration.BurstSize < 1 {
+		return nil, status.Error(codes.InvalidArgument, "Burst size must be at least 1")
+	}
+	return NewClientRateLimiter(clock, configuration.TokensPerSecond, configuration.BurstSize), nil
+}
+
+func (rl *ClientRateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens = math.Min(rl.burstSize, bucket.tokens+now.Sub(bucket.lastRefill).Seconds()*rl.tokensPerSecond)
+	bucket.lastRefill = now
+}
+
+// Take attempts to remove a single token from a client's bucket. If
+// the bucket is empty, the amount of time after which a token becomes
+// available is returned.
+func (rl *ClientRateLimiter) Take(client string) (bool, time.Duration) {
+	now := rl.clock.Now()
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	// Periodically discard buckets of clients that have become
+	// idle. Once a bucket is full, it is indistinguishable from a
+	// newly created one.
+	if now.Sub(rl.lastPruned).Seconds()*rl.tokensPerSecond >= rl.burstSize {
+		for key, bucket := range rl.buckets {
+			if rl.refill(bucket, now); bucket.tokens >= rl.burstSize {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.lastPruned = now
+	}
+
+	bucket, ok := rl.buckets[client]
+	if ok {
+		rl.refill(bucket, now)
+	} else {
+		bucket = &tokenBucket{
+			tokens:     rl.burstSize,
+			lastRefill: now,
+		}
+		rl.buckets[client] = bucket
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rl.tokensPerSecond * float64(time.Second))
+}
+
+// getClientAddress returns the address of the client that issued an
+// HTTP request. If bb_browser is placed behind a reverse proxy, the
+// address may be extracted from a header (e.g., X-Forwarded-For)
+// instead.
+func getClientAddress(req *http.Request, clientAddressHeader string) string {
+	if clientAddressHeader != "" {
+		if values := req.Header.Values(clientAddressHeader); len(values) > 0 {
+			// X-Forwarded-For may contain a list of
+			// addresses, to which every proxy appends the
+			// address of its peer. Only the last one is
+			// added by the reverse proxy in front of
+			// bb_browser. All others are provided by the
+			// client, meaning they can't be trusted.
+			value := values[len(values)-1]
+			if client := strings.TrimSpace(value[strings.LastIndexByte(value, ',')+1:]); client != "" {
+				return client
+			}
+		}
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// isDownloadRequest returns whether an HTTP request is for one of the
+// endpoints that may cause large amounts of data to be read from
+// storage, such as files, tarballs and batch downloads.
+func (s *BrowserService) isDownloadRequest(req *http.Request) bool {
+	if req.URL.Query().Get("format") == "tar" {
+		return true
+	}
+	route := mux.CurrentRoute(req)
+	return route != nil && s.downloadRoutes[route]
+}
+
+// NewClientRateLimitingMiddleware creates a middleware for the router
+// of BrowserService that rejects requests from clients that exceed
+// their rate limit with HTTP 429 "Too Many Requests". Downloads of
+// files and tarballs may be subject to a separate, stricter rate limit
+// than regular pages. Rate limiters that are nil are not enforced.
+func (s *BrowserService) NewClientRateLimitingMiddleware(clientAddressHeader string, pageRateLimiter, downloadRateLimiter *ClientRateLimiter) mux.MiddlewareFunc {
+	return func(base http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			rateLimiter := pageRateLimiter
+			if s.isDownloadRequest(req) {
+				rateLimiter = downloadRateLimiter
+			}
+			if rateLimiter != nil {
+				if ok, retryAfter := rateLimiter.Take(getClientAddress(req, clientAddressHeader)); !ok {
+					w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+					s.renderError(w, req, status.Error(codes.ResourceExhausted, "Rate limit exceeded"))
+					return
+				}
+			}
+			base.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-browser/pkg/proto/configuration/bb_browser"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClock is a clock.Clock whose time only changes when advanced
+// explicitly by the test.
+type fakeClock struct {
+	clock.Clock
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestClientRateLimiterBurst(t *testing.T) {
+	c := &fakeClock{now: time.Unix(1000, 0)}
+	rl := NewClientRateLimiter(c, 1, 3)
+
+	// A client that has been idle may perform a burst of requests,
+	// after which it gets rejected.
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.Take("client1"); !ok {
+			t.Fatalf("Request %d within burst was rejected", i)
+		}
+	}
+	if ok, _ := rl.Take("client1"); ok {
+		t.Fatal("Request beyond burst was permitted")
+	}
+
+	// Other clients have a bucket of their own.
+	if ok, _ := rl.Take("client2"); !ok {
+		t.Fatal("Request of other client was rejected")
+	}
+}
+
+func TestClientRateLimiterRefill(t *testing.T) {
+	c := &fakeClock{now: time.Unix(1000, 0)}
+	rl := NewClientRateLimiter(c, 2, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Take("client"); !ok {
+			t.Fatalf("Request %d within burst was rejected", i)
+		}
+	}
+
+	// At two tokens per second, a single token becomes available
+	// after half a second.
+	c.now = c.now.Add(499 * time.Millisecond)
+	if ok, _ := rl.Take("client"); ok {
+		t.Fatal("Request was permitted before a token was added")
+	}
+	c.now = c.now.Add(time.Millisecond)
+	if ok, _ := rl.Take("client"); !ok {
+		t.Fatal("Request was rejected after a token was added")
+	}
+	if ok, _ := rl.Take("client"); ok {
+		t.Fatal("Request was permitted without any tokens")
+	}
+
+	// Buckets never contain more tokens than the burst size.
+	c.now = c.now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Take("client"); !ok {
+			t.Fatalf("Request %d within burst was rejected", i)
+		}
+	}
+	if ok, _ := rl.Take("client"); ok {
+		t.Fatal("Request beyond burst was permitted")
+	}
+}
+
+func TestClientRateLimiterRetryAfter(t *testing.T) {
+	c := &fakeClock{now: time.Unix(1000, 0)}
+	rl := NewClientRateLimiter(c, 4, 1)
+	if ok, retryAfter := rl.Take("client"); !ok || retryAfter != 0 {
+		t.Fatalf("Expected request to be permitted, got %v, %s", ok, retryAfter)
+	}
+	if ok, retryAfter := rl.Take("client"); ok || retryAfter != 250*time.Millisecond {
+		t.Fatalf("Expected request to be rejected for 250ms, got %v, %s", ok, retryAfter)
+	}
+	c.now = c.now.Add(100 * time.Millisecond)
+	if ok, retryAfter := rl.Take("client"); ok || retryAfter != 150*time.Millisecond {
+		t.Fatalf("Expected request to be rejected for 150ms, got %v, %s", ok, retryAfter)
+	}
+}
+
+func TestClientRateLimiterPruning(t *testing.T) {
+	c := &fakeClock{now: time.Unix(1000, 0)}
+	rl := NewClientRateLimiter(c, 1, 10)
+	for _, client := range []string{"client1", "client2", "client3"} {
+		rl.Take(client)
+	}
+	if len(rl.buckets) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(rl.buckets))
+	}
+
+	// Buckets are only discarded after they have become full.
+	c.now = c.now.Add(5 * time.Second)
+	rl.Take("client1")
+	if len(rl.buckets) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(rl.buckets))
+	}
+	c.now = c.now.Add(10 * time.Second)
+	rl.Take("client1")
+	if len(rl.buckets) != 1 {
+		t.Fatalf("Expected 1 bucket, got %d", len(rl.buckets))
+	}
+}
+
+func TestNewClientRateLimiterFromConfiguration(t *testing.T) {
+	for name, tc := range map[string]struct {
+		configuration *bb_browser.RateLimitConfiguration
+		code          codes.Code
+		enabled       bool
+	}{
+		"Unset":         {configuration: nil, code: codes.OK},
+		"Valid":         {configuration: &bb_browser.RateLimitConfiguration{TokensPerSecond: 0.5, BurstSize: 1}, code: codes.OK, enabled: true},
+		"ZeroRate":      {configuration: &bb_browser.RateLimitConfiguration{TokensPerSecond: 0, BurstSize: 10}, code: codes.InvalidArgument},
+		"NegativeRate":  {configuration: &bb_browser.RateLimitConfiguration{TokensPerSecond: -1, BurstSize: 10}, code: codes.InvalidArgument},
+		"ZeroBurstSize": {configuration: &bb_browser.RateLimitConfiguration{TokensPerSecond: 1, BurstSize: 0}, code: codes.InvalidArgument},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rl, err := newClientRateLimiterFromConfiguration(tc.configuration, clock.SystemClock)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("Expected code %s, got %s", tc.code, err)
+			}
+			if enabled := rl != nil; enabled != tc.enabled {
+				t.Fatalf("Expected rate limiter to be enabled: %v, got %v", tc.enabled, enabled)
+			}
+		})
+	}
+}
+
+func TestGetClientAddress(t *testing.T) {
+	for name, tc := range map[string]struct {
+		remoteAddr          string
+		clientAddressHeader string
+		headerValues        []string
+		expected            string
+	}{
+		"RemoteAddr":              {remoteAddr: "192.0.2.1:1234", expected: "192.0.2.1"},
+		"RemoteAddrWithoutPort":   {remoteAddr: "192.0.2.1", expected: "192.0.2.1"},
+		"HeaderIgnoredIfUnset":    {remoteAddr: "192.0.2.1:1234", headerValues: []string{"198.51.100.1"}, expected: "192.0.2.1"},
+		"HeaderMissing":           {remoteAddr: "192.0.2.1:1234", clientAddressHeader: "X-Forwarded-For", expected: "192.0.2.1"},
+		"HeaderSingleAddress":     {remoteAddr: "192.0.2.1:1234", clientAddressHeader: "X-Forwarded-For", headerValues: []string{"198.51.100.1"}, expected: "198.51.100.1"},
+		"HeaderMultipleAddresses": {remoteAddr: "192.0.2.1:1234", clientAddressHeader: "X-Forwarded-For", headerValues: []string{"203.0.113.7, 198.51.100.1"}, expected: "198.51.100.1"},
+		"HeaderMultipleValues":    {remoteAddr: "192.0.2.1:1234", clientAddressHeader: "X-Forwarded-For", headerValues: []string{"203.0.113.7", "198.51.100.1"}, expected: "198.51.100.1"},
+		"HeaderTrailingSeparator": {remoteAddr: "192.0.2.1:1234", clientAddressHeader: "X-Forwarded-For", headerValues: []string{"198.51.100.1,"}, expected: "192.0.2.1"},
+		"HeaderOtherName":         {remoteAddr: "192.0.2.1:1234", clientAddressHeader: "X-Real-Ip", headerValues: []string{"198.51.100.1"}, expected: "192.0.2.1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.headerValues {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if client := getClientAddress(req, tc.clientAddressHeader); client != tc.expected {
+				t.Errorf("Expected client address %#v, got %#v", tc.expected, client)
+			}
+		})
+	}
+}
+
+func TestClientRateLimitingMiddleware(t *testing.T) {
+	c := &fakeClock{now: time.Unix(1000, 0)}
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	ts.router.Use(ts.NewClientRateLimitingMiddleware(
+		"X-Forwarded-For",
+		NewClientRateLimiter(c, 1, 2),
+		NewClientRateLimiter(c, 0.5, 1)))
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	fileDigest := cas.putBytes([]byte("Hello"))
+	pageURL := getURL("command", commandDigest, "")
+	downloadURL := getURL("file", fileDigest, "hello.txt")
+
+	serve := func(path, client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Forwarded-For", client)
+		return ts.serve(req)
+	}
+
+	// Pages and downloads use separate buckets.
+	for i := 0; i < 2; i++ {
+		if w := serve(pageURL, "198.51.100.1"); w.Code != http.StatusOK {
+			t.Fatalf("Page request %d got status %d", i, w.Code)
+		}
+	}
+	if w := serve(downloadURL, "198.51.100.1"); w.Code != http.StatusOK {
+		t.Fatalf("Download request got status %d", w.Code)
+	}
+
+	// Excess requests are rejected, providing the number of
+	// seconds after which they may be retried.
+	w := serve(pageURL, "198.51.100.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected page request to be rate limited, got status %d, Retry-After %#v and Content-Type %#v", w.Code, w.Header().Get("Retry-After"), w.Header().Get("Content-Type"))
+	}
+	w = serve(downloadURL, "198.51.100.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("Expected download request to be rate limited, got status %d and Retry-After %#v", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Requests are permitted again after the bucket has been
+	// refilled.
+	c.now = c.now.Add(time.Second)
+	if w := serve(pageURL, "198.51.100.1"); w.Code != http.StatusOK {
+		t.Fatalf("Page request after refill got status %d", w.Code)
+	}
+}
+
+func TestIsDownloadRequest(t *testing.T) {
+	ts := newTestBrowserService(BrowserServiceOptions{})
+	cas := ts.contentAddressableStorage
+	commandDigest := cas.putMessage(t, &remoteexecution.Command{Arguments: []string{"true"}})
+	directoryDigest := cas.putMessage(t, &remoteexecution.Directory{})
+	fileDigest := cas.putBytes([]byte("Hello"))
+
+	// Record whether requests were considered downloads once they
+	// have been matched against a route.
+	var isDownload bool
+	ts.router.Use(func(base http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			isDownload = ts.isDownloadRequest(req)
+			base.ServeHTTP(w, req)
+		})
+	})
+
+	for name, tc := range map[string]struct {
+		method     string
+		url        string
+		isDownload bool
+	}{
+		"Command":            {url: getURL("command", commandDigest, "")},
+		"Directory":          {url: getURL("directory", directoryDigest, "")},
+		"DirectoryTarball":   {url: getURL("directory", directoryDigest, "?format=tar"), isDownload: true},
+		"File":               {url: getURL("file", fileDigest, "hello.txt"), isDownload: true},
+		"ActionOutput":       {url: getURL("action", commandDigest, "output/hello.txt"), isDownload: true},
+		"BatchDownload":      {method: http.MethodPost, url: "/blobs/sha256/batch_download/", isDownload: true},
+		"InstanceNamedFile":  {url: "/file" + getURL("command", commandDigest, "")},
+		"InstanceNameOutput": {url: "/output" + getURL("directory", directoryDigest, "")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			isDownload = !tc.isDownload
+			ts.serve(httptest.NewRequest(method, tc.url, nil))
+			if isDownload != tc.isDownload {
+				t.Errorf("Expected download %v, got %v", tc.isDownload, isDownload)
+			}
+		})
+	}
+}
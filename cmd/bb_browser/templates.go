@@ -0,0 +1,40 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"io/fs"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// TemplateExecutor is the subset of html/template's Template that is
+// used by BrowserService to render pages.
+type TemplateExecutor interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+type reloadingTemplateExecutor struct {
+	fsys    fs.FS
+	funcMap template.FuncMap
+}
+
+// NewReloadingTemplateExecutor creates a TemplateExecutor that parses
+// the HTML templates stored in a file system every time a page is
+// rendered. This is slow, but allows changes to templates to be tested
+// without restarting bb_browser. It should only be used during
+// development.
+func NewReloadingTemplateExecutor(fsys fs.FS, funcMap template.FuncMap) TemplateExecutor {
+	return &reloadingTemplateExecutor{
+		fsys:    fsys,
+		funcMap: funcMap,
+	}
+}
+
+func (te *reloadingTemplateExecutor) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	templates, err := template.New("templates").Funcs(te.funcMap).ParseFS(te.fsys, "*.html")
+	if err != nil {
+		return util.StatusWrap(err, "Failed to parse HTML templates")
+	}
+	return templates.ExecuteTemplate(w, name, data)
+}
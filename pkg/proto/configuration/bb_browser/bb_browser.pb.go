@@ -13,6 +13,7 @@ import (
 	http "github.com/buildbarn/bb-storage/pkg/proto/configuration/http"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
 	reflect "reflect"
 	sync "sync"
 )
@@ -29,15 +30,28 @@ type ApplicationConfiguration struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Blobstore                   *blobstore.BlobstoreConfiguration  `protobuf:"bytes,1,opt,name=blobstore,proto3" json:"blobstore,omitempty"`
-	MaximumMessageSizeBytes     int64                              `protobuf:"varint,2,opt,name=maximum_message_size_bytes,json=maximumMessageSizeBytes,proto3" json:"maximum_message_size_bytes,omitempty"`
-	HttpServers                 []*http.ServerConfiguration        `protobuf:"bytes,10,rep,name=http_servers,json=httpServers,proto3" json:"http_servers,omitempty"`
-	RoutePrefix                 string                             `protobuf:"bytes,7,opt,name=route_prefix,json=routePrefix,proto3" json:"route_prefix,omitempty"`
-	Global                      *global.Configuration              `protobuf:"bytes,4,opt,name=global,proto3" json:"global,omitempty"`
-	BbClientdInstanceNamePrefix string                             `protobuf:"bytes,5,opt,name=bb_clientd_instance_name_prefix,json=bbClientdInstanceNamePrefix,proto3" json:"bb_clientd_instance_name_prefix,omitempty"`
-	InitialSizeClassCache       *blobstore.BlobAccessConfiguration `protobuf:"bytes,6,opt,name=initial_size_class_cache,json=initialSizeClassCache,proto3" json:"initial_size_class_cache,omitempty"`
-	FileSystemAccessCache       *blobstore.BlobAccessConfiguration `protobuf:"bytes,9,opt,name=file_system_access_cache,json=fileSystemAccessCache,proto3" json:"file_system_access_cache,omitempty"`
-	Authorizer                  *auth.AuthorizerConfiguration      `protobuf:"bytes,8,opt,name=authorizer,proto3" json:"authorizer,omitempty"`
+	Blobstore                      *blobstore.BlobstoreConfiguration    `protobuf:"bytes,1,opt,name=blobstore,proto3" json:"blobstore,omitempty"`
+	MaximumMessageSizeBytes        int64                                `protobuf:"varint,2,opt,name=maximum_message_size_bytes,json=maximumMessageSizeBytes,proto3" json:"maximum_message_size_bytes,omitempty"`
+	HttpServers                    []*http.ServerConfiguration          `protobuf:"bytes,10,rep,name=http_servers,json=httpServers,proto3" json:"http_servers,omitempty"`
+	RoutePrefix                    string                               `protobuf:"bytes,7,opt,name=route_prefix,json=routePrefix,proto3" json:"route_prefix,omitempty"`
+	Global                         *global.Configuration                `protobuf:"bytes,4,opt,name=global,proto3" json:"global,omitempty"`
+	BbClientdInstanceNamePrefix    string                               `protobuf:"bytes,5,opt,name=bb_clientd_instance_name_prefix,json=bbClientdInstanceNamePrefix,proto3" json:"bb_clientd_instance_name_prefix,omitempty"`
+	InitialSizeClassCache          *blobstore.BlobAccessConfiguration   `protobuf:"bytes,6,opt,name=initial_size_class_cache,json=initialSizeClassCache,proto3" json:"initial_size_class_cache,omitempty"`
+	FileSystemAccessCache          *blobstore.BlobAccessConfiguration   `protobuf:"bytes,9,opt,name=file_system_access_cache,json=fileSystemAccessCache,proto3" json:"file_system_access_cache,omitempty"`
+	Authorizer                     *auth.AuthorizerConfiguration        `protobuf:"bytes,8,opt,name=authorizer,proto3" json:"authorizer,omitempty"`
+	CollapsedLogMinimumLines       uint32                               `protobuf:"varint,11,opt,name=collapsed_log_minimum_lines,json=collapsedLogMinimumLines,proto3" json:"collapsed_log_minimum_lines,omitempty"`
+	ClientRateLimiting             *ClientRateLimitingConfiguration     `protobuf:"bytes,12,opt,name=client_rate_limiting,json=clientRateLimiting,proto3" json:"client_rate_limiting,omitempty"`
+	DevelopmentTemplatesDirectory  string                               `protobuf:"bytes,13,opt,name=development_templates_directory,json=developmentTemplatesDirectory,proto3" json:"development_templates_directory,omitempty"`
+	DefaultInstanceName            string                               `protobuf:"bytes,14,opt,name=default_instance_name,json=defaultInstanceName,proto3" json:"default_instance_name,omitempty"`
+	RememberLastInstanceName       bool                                 `protobuf:"varint,15,opt,name=remember_last_instance_name,json=rememberLastInstanceName,proto3" json:"remember_last_instance_name,omitempty"`
+	AllowedInstanceNames           []string                             `protobuf:"bytes,16,rep,name=allowed_instance_names,json=allowedInstanceNames,proto3" json:"allowed_instance_names,omitempty"`
+	ContentSniffingPrefixSizeBytes uint32                               `protobuf:"varint,17,opt,name=content_sniffing_prefix_size_bytes,json=contentSniffingPrefixSizeBytes,proto3" json:"content_sniffing_prefix_size_bytes,omitempty"`
+	OperationAuthorizers           *OperationAuthorizersConfiguration   `protobuf:"bytes,18,opt,name=operation_authorizers,json=operationAuthorizers,proto3" json:"operation_authorizers,omitempty"`
+	PreviewedOutputFilePatterns    []string                             `protobuf:"bytes,20,rep,name=previewed_output_file_patterns,json=previewedOutputFilePatterns,proto3" json:"previewed_output_file_patterns,omitempty"`
+	StandardInputPath              string                               `protobuf:"bytes,21,opt,name=standard_input_path,json=standardInputPath,proto3" json:"standard_input_path,omitempty"`
+	TarballGenerationLimit         *TarballGenerationLimitConfiguration `protobuf:"bytes,22,opt,name=tarball_generation_limit,json=tarballGenerationLimit,proto3" json:"tarball_generation_limit,omitempty"`
+	MaximumCompressedFileSizeBytes int64                                `protobuf:"varint,23,opt,name=maximum_compressed_file_size_bytes,json=maximumCompressedFileSizeBytes,proto3" json:"maximum_compressed_file_size_bytes,omitempty"`
+	BasePath                       string                               `protobuf:"bytes,24,opt,name=base_path,json=basePath,proto3" json:"base_path,omitempty"`
 }
 
 func (x *ApplicationConfiguration) Reset() {
@@ -135,6 +149,341 @@ func (x *ApplicationConfiguration) GetAuthorizer() *auth.AuthorizerConfiguration
 	return nil
 }
 
+func (x *ApplicationConfiguration) GetCollapsedLogMinimumLines() uint32 {
+	if x != nil {
+		return x.CollapsedLogMinimumLines
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetClientRateLimiting() *ClientRateLimitingConfiguration {
+	if x != nil {
+		return x.ClientRateLimiting
+	}
+	return nil
+}
+
+func (x *ApplicationConfiguration) GetDevelopmentTemplatesDirectory() string {
+	if x != nil {
+		return x.DevelopmentTemplatesDirectory
+	}
+	return ""
+}
+
+func (x *ApplicationConfiguration) GetDefaultInstanceName() string {
+	if x != nil {
+		return x.DefaultInstanceName
+	}
+	return ""
+}
+
+func (x *ApplicationConfiguration) GetRememberLastInstanceName() bool {
+	if x != nil {
+		return x.RememberLastInstanceName
+	}
+	return false
+}
+
+func (x *ApplicationConfiguration) GetAllowedInstanceNames() []string {
+	if x != nil {
+		return x.AllowedInstanceNames
+	}
+	return nil
+}
+
+func (x *ApplicationConfiguration) GetContentSniffingPrefixSizeBytes() uint32 {
+	if x != nil {
+		return x.ContentSniffingPrefixSizeBytes
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetOperationAuthorizers() *OperationAuthorizersConfiguration {
+	if x != nil {
+		return x.OperationAuthorizers
+	}
+	return nil
+}
+
+func (x *ApplicationConfiguration) GetPreviewedOutputFilePatterns() []string {
+	if x != nil {
+		return x.PreviewedOutputFilePatterns
+	}
+	return nil
+}
+
+func (x *ApplicationConfiguration) GetStandardInputPath() string {
+	if x != nil {
+		return x.StandardInputPath
+	}
+	return ""
+}
+
+func (x *ApplicationConfiguration) GetTarballGenerationLimit() *TarballGenerationLimitConfiguration {
+	if x != nil {
+		return x.TarballGenerationLimit
+	}
+	return nil
+}
+
+func (x *ApplicationConfiguration) GetMaximumCompressedFileSizeBytes() int64 {
+	if x != nil {
+		return x.MaximumCompressedFileSizeBytes
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetBasePath() string {
+	if x != nil {
+		return x.BasePath
+	}
+	return ""
+}
+
+type TarballGenerationLimitConfiguration struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	MaximumConcurrentGenerations uint32               `protobuf:"varint,1,opt,name=maximum_concurrent_generations,json=maximumConcurrentGenerations,proto3" json:"maximum_concurrent_generations,omitempty"`
+	MaximumQueueingDuration      *durationpb.Duration `protobuf:"bytes,2,opt,name=maximum_queueing_duration,json=maximumQueueingDuration,proto3" json:"maximum_queueing_duration,omitempty"`
+}
+
+func (x *TarballGenerationLimitConfiguration) Reset() {
+	*x = TarballGenerationLimitConfiguration{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TarballGenerationLimitConfiguration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TarballGenerationLimitConfiguration) ProtoMessage() {}
+
+func (x *TarballGenerationLimitConfiguration) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TarballGenerationLimitConfiguration.ProtoReflect.Descriptor instead.
+func (*TarballGenerationLimitConfiguration) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TarballGenerationLimitConfiguration) GetMaximumConcurrentGenerations() uint32 {
+	if x != nil {
+		return x.MaximumConcurrentGenerations
+	}
+	return 0
+}
+
+func (x *TarballGenerationLimitConfiguration) GetMaximumQueueingDuration() *durationpb.Duration {
+	if x != nil {
+		return x.MaximumQueueingDuration
+	}
+	return nil
+}
+
+type OperationAuthorizersConfiguration struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Browse                  *auth.AuthorizerConfiguration `protobuf:"bytes,1,opt,name=browse,proto3" json:"browse,omitempty"`
+	Download                *auth.AuthorizerConfiguration `protobuf:"bytes,2,opt,name=download,proto3" json:"download,omitempty"`
+	ListTarballGenerations  *auth.AuthorizerConfiguration `protobuf:"bytes,3,opt,name=list_tarball_generations,json=listTarballGenerations,proto3" json:"list_tarball_generations,omitempty"`
+	CancelTarballGeneration *auth.AuthorizerConfiguration `protobuf:"bytes,4,opt,name=cancel_tarball_generation,json=cancelTarballGeneration,proto3" json:"cancel_tarball_generation,omitempty"`
+}
+
+func (x *OperationAuthorizersConfiguration) Reset() {
+	*x = OperationAuthorizersConfiguration{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *OperationAuthorizersConfiguration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OperationAuthorizersConfiguration) ProtoMessage() {}
+
+func (x *OperationAuthorizersConfiguration) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OperationAuthorizersConfiguration.ProtoReflect.Descriptor instead.
+func (*OperationAuthorizersConfiguration) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *OperationAuthorizersConfiguration) GetBrowse() *auth.AuthorizerConfiguration {
+	if x != nil {
+		return x.Browse
+	}
+	return nil
+}
+
+func (x *OperationAuthorizersConfiguration) GetDownload() *auth.AuthorizerConfiguration {
+	if x != nil {
+		return x.Download
+	}
+	return nil
+}
+
+func (x *OperationAuthorizersConfiguration) GetListTarballGenerations() *auth.AuthorizerConfiguration {
+	if x != nil {
+		return x.ListTarballGenerations
+	}
+	return nil
+}
+
+func (x *OperationAuthorizersConfiguration) GetCancelTarballGeneration() *auth.AuthorizerConfiguration {
+	if x != nil {
+		return x.CancelTarballGeneration
+	}
+	return nil
+}
+
+type ClientRateLimitingConfiguration struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ClientAddressHeader string                  `protobuf:"bytes,1,opt,name=client_address_header,json=clientAddressHeader,proto3" json:"client_address_header,omitempty"`
+	Pages               *RateLimitConfiguration `protobuf:"bytes,2,opt,name=pages,proto3" json:"pages,omitempty"`
+	Downloads           *RateLimitConfiguration `protobuf:"bytes,3,opt,name=downloads,proto3" json:"downloads,omitempty"`
+}
+
+func (x *ClientRateLimitingConfiguration) Reset() {
+	*x = ClientRateLimitingConfiguration{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ClientRateLimitingConfiguration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientRateLimitingConfiguration) ProtoMessage() {}
+
+func (x *ClientRateLimitingConfiguration) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientRateLimitingConfiguration.ProtoReflect.Descriptor instead.
+func (*ClientRateLimitingConfiguration) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ClientRateLimitingConfiguration) GetClientAddressHeader() string {
+	if x != nil {
+		return x.ClientAddressHeader
+	}
+	return ""
+}
+
+func (x *ClientRateLimitingConfiguration) GetPages() *RateLimitConfiguration {
+	if x != nil {
+		return x.Pages
+	}
+	return nil
+}
+
+func (x *ClientRateLimitingConfiguration) GetDownloads() *RateLimitConfiguration {
+	if x != nil {
+		return x.Downloads
+	}
+	return nil
+}
+
+type RateLimitConfiguration struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TokensPerSecond float64 `protobuf:"fixed64,1,opt,name=tokens_per_second,json=tokensPerSecond,proto3" json:"tokens_per_second,omitempty"`
+	BurstSize       uint32  `protobuf:"varint,2,opt,name=burst_size,json=burstSize,proto3" json:"burst_size,omitempty"`
+}
+
+func (x *RateLimitConfiguration) Reset() {
+	*x = RateLimitConfiguration{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RateLimitConfiguration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RateLimitConfiguration) ProtoMessage() {}
+
+func (x *RateLimitConfiguration) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RateLimitConfiguration.ProtoReflect.Descriptor instead.
+func (*RateLimitConfiguration) Descriptor() ([]byte, []int) {
+	return file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RateLimitConfiguration) GetTokensPerSecond() float64 {
+	if x != nil {
+		return x.TokensPerSecond
+	}
+	return 0
+}
+
+func (x *RateLimitConfiguration) GetBurstSize() uint32 {
+	if x != nil {
+		return x.BurstSize
+	}
+	return 0
+}
+
 var File_pkg_proto_configuration_bb_browser_bb_browser_proto protoreflect.FileDescriptor
 
 var file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDesc = []byte{
@@ -143,7 +492,9 @@ var file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDesc = []byte{
 	0x77, 0x73, 0x65, 0x72, 0x2f, 0x62, 0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x2e,
 	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x22, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x62, 0x61, 0x72, 0x6e,
 	0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x62,
-	0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x1a, 0x27, 0x70, 0x6b, 0x67, 0x2f, 0x70,
+	0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x75, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x27, 0x70, 0x6b, 0x67, 0x2f, 0x70,
 	0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69,
 	0x6f, 0x6e, 0x2f, 0x61, 0x75, 0x74, 0x68, 0x2f, 0x61, 0x75, 0x74, 0x68, 0x2e, 0x70, 0x72, 0x6f,
 	0x74, 0x6f, 0x1a, 0x31, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x6f,
@@ -154,7 +505,7 @@ var file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDesc = []byte{
 	0x6c, 0x6f, 0x62, 0x61, 0x6c, 0x2f, 0x67, 0x6c, 0x6f, 0x62, 0x61, 0x6c, 0x2e, 0x70, 0x72, 0x6f,
 	0x74, 0x6f, 0x1a, 0x27, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x6f,
 	0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x68, 0x74, 0x74, 0x70,
-	0x2f, 0x68, 0x74, 0x74, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xfd, 0x05, 0x0a, 0x18,
+	0x2f, 0x68, 0x74, 0x74, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xce, 0x0d, 0x0a, 0x18,
 	0x41, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x6e, 0x66, 0x69,
 	0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x57, 0x0a, 0x09, 0x62, 0x6c, 0x6f, 0x62,
 	0x73, 0x74, 0x6f, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x39, 0x2e, 0x62, 0x75,
@@ -202,12 +553,135 @@ var file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDesc = []byte{
 	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x75, 0x74,
 	0x68, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66,
 	0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0a, 0x61, 0x75, 0x74, 0x68, 0x6f,
-	0x72, 0x69, 0x7a, 0x65, 0x72, 0x4a, 0x04, 0x08, 0x03, 0x10, 0x04, 0x42, 0x44, 0x5a, 0x42, 0x67,
-	0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x62,
-	0x61, 0x72, 0x6e, 0x2f, 0x62, 0x62, 0x2d, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x2f, 0x70,
-	0x6b, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75,
-	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x62, 0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65,
-	0x72, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x72, 0x69, 0x7a, 0x65, 0x72, 0x12, 0x3d, 0x0a, 0x1b, 0x63, 0x6f, 0x6c, 0x6c, 0x61, 0x70, 0x73,
+	0x65, 0x64, 0x5f, 0x6c, 0x6f, 0x67, 0x5f, 0x6d, 0x69, 0x6e, 0x69, 0x6d, 0x75, 0x6d, 0x5f, 0x6c,
+	0x69, 0x6e, 0x65, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x18, 0x63, 0x6f, 0x6c, 0x6c,
+	0x61, 0x70, 0x73, 0x65, 0x64, 0x4c, 0x6f, 0x67, 0x4d, 0x69, 0x6e, 0x69, 0x6d, 0x75, 0x6d, 0x4c,
+	0x69, 0x6e, 0x65, 0x73, 0x12, 0x75, 0x0a, 0x14, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x72,
+	0x61, 0x74, 0x65, 0x5f, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x69, 0x6e, 0x67, 0x18, 0x0c, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x43, 0x2e, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x62, 0x61, 0x72, 0x6e, 0x2e, 0x63,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x62, 0x62, 0x5f,
+	0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x61,
+	0x74, 0x65, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x69, 0x6e, 0x67, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x12, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52,
+	0x61, 0x74, 0x65, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x69, 0x6e, 0x67, 0x12, 0x46, 0x0a, 0x1f, 0x64,
+	0x65, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x6d, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x65, 0x6d, 0x70, 0x6c,
+	0x61, 0x74, 0x65, 0x73, 0x5f, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x18, 0x0d,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x1d, 0x64, 0x65, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x6d, 0x65, 0x6e,
+	0x74, 0x54, 0x65, 0x6d, 0x70, 0x6c, 0x61, 0x74, 0x65, 0x73, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74,
+	0x6f, 0x72, 0x79, 0x12, 0x32, 0x0a, 0x15, 0x64, 0x65, 0x66, 0x61, 0x75, 0x6c, 0x74, 0x5f, 0x69,
+	0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x13, 0x64, 0x65, 0x66, 0x61, 0x75, 0x6c, 0x74, 0x49, 0x6e, 0x73, 0x74, 0x61,
+	0x6e, 0x63, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x3d, 0x0a, 0x1b, 0x72, 0x65, 0x6d, 0x65, 0x6d,
+	0x62, 0x65, 0x72, 0x5f, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63,
+	0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x08, 0x52, 0x18, 0x72, 0x65,
+	0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x4c, 0x61, 0x73, 0x74, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e,
+	0x63, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x34, 0x0a, 0x16, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x65,
+	0x64, 0x5f, 0x69, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x73,
+	0x18, 0x10, 0x20, 0x03, 0x28, 0x09, 0x52, 0x14, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x65, 0x64, 0x49,
+	0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x73, 0x12, 0x4a, 0x0a, 0x22,
+	0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x5f, 0x73, 0x6e, 0x69, 0x66, 0x66, 0x69, 0x6e, 0x67,
+	0x5f, 0x70, 0x72, 0x65, 0x66, 0x69, 0x78, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x5f, 0x62, 0x79, 0x74,
+	0x65, 0x73, 0x18, 0x11, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x1e, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e,
+	0x74, 0x53, 0x6e, 0x69, 0x66, 0x66, 0x69, 0x6e, 0x67, 0x50, 0x72, 0x65, 0x66, 0x69, 0x78, 0x53,
+	0x69, 0x7a, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x7a, 0x0a, 0x15, 0x6f, 0x70, 0x65, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x61, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x72,
+	0x73, 0x18, 0x12, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x45, 0x2e, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x62,
+	0x61, 0x72, 0x6e, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x2e, 0x62, 0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x2e, 0x4f, 0x70, 0x65,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x72,
+	0x73, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x14,
+	0x6f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69,
+	0x7a, 0x65, 0x72, 0x73, 0x12, 0x43, 0x0a, 0x1e, 0x70, 0x72, 0x65, 0x76, 0x69, 0x65, 0x77, 0x65,
+	0x64, 0x5f, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x70, 0x61,
+	0x74, 0x74, 0x65, 0x72, 0x6e, 0x73, 0x18, 0x14, 0x20, 0x03, 0x28, 0x09, 0x52, 0x1b, 0x70, 0x72,
+	0x65, 0x76, 0x69, 0x65, 0x77, 0x65, 0x64, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x46, 0x69, 0x6c,
+	0x65, 0x50, 0x61, 0x74, 0x74, 0x65, 0x72, 0x6e, 0x73, 0x12, 0x2e, 0x0a, 0x13, 0x73, 0x74, 0x61,
+	0x6e, 0x64, 0x61, 0x72, 0x64, 0x5f, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x5f, 0x70, 0x61, 0x74, 0x68,
+	0x18, 0x15, 0x20, 0x01, 0x28, 0x09, 0x52, 0x11, 0x73, 0x74, 0x61, 0x6e, 0x64, 0x61, 0x72, 0x64,
+	0x49, 0x6e, 0x70, 0x75, 0x74, 0x50, 0x61, 0x74, 0x68, 0x12, 0x81, 0x01, 0x0a, 0x18, 0x74, 0x61,
+	0x72, 0x62, 0x61, 0x6c, 0x6c, 0x5f, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x5f, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x16, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x47, 0x2e, 0x62,
+	0x75, 0x69, 0x6c, 0x64, 0x62, 0x61, 0x72, 0x6e, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x62, 0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65,
+	0x72, 0x2e, 0x54, 0x61, 0x72, 0x62, 0x61, 0x6c, 0x6c, 0x47, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x16, 0x74, 0x61, 0x72, 0x62, 0x61, 0x6c, 0x6c, 0x47, 0x65,
+	0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x12, 0x4a, 0x0a,
+	0x22, 0x6d, 0x61, 0x78, 0x69, 0x6d, 0x75, 0x6d, 0x5f, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73,
+	0x73, 0x65, 0x64, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x5f, 0x62, 0x79,
+	0x74, 0x65, 0x73, 0x18, 0x17, 0x20, 0x01, 0x28, 0x03, 0x52, 0x1e, 0x6d, 0x61, 0x78, 0x69, 0x6d,
+	0x75, 0x6d, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x65, 0x64, 0x46, 0x69, 0x6c, 0x65,
+	0x53, 0x69, 0x7a, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x62, 0x61, 0x73,
+	0x65, 0x5f, 0x70, 0x61, 0x74, 0x68, 0x18, 0x18, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x62, 0x61,
+	0x73, 0x65, 0x50, 0x61, 0x74, 0x68, 0x4a, 0x04, 0x08, 0x03, 0x10, 0x04, 0x22, 0xc2, 0x01, 0x0a,
+	0x23, 0x54, 0x61, 0x72, 0x62, 0x61, 0x6c, 0x6c, 0x47, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x44, 0x0a, 0x1e, 0x6d, 0x61, 0x78, 0x69, 0x6d, 0x75, 0x6d, 0x5f,
+	0x63, 0x6f, 0x6e, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x5f, 0x67, 0x65, 0x6e, 0x65, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x1c, 0x6d, 0x61,
+	0x78, 0x69, 0x6d, 0x75, 0x6d, 0x43, 0x6f, 0x6e, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x47,
+	0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x55, 0x0a, 0x19, 0x6d, 0x61,
+	0x78, 0x69, 0x6d, 0x75, 0x6d, 0x5f, 0x71, 0x75, 0x65, 0x75, 0x65, 0x69, 0x6e, 0x67, 0x5f, 0x64,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x17, 0x6d, 0x61, 0x78, 0x69, 0x6d, 0x75,
+	0x6d, 0x51, 0x75, 0x65, 0x75, 0x65, 0x69, 0x6e, 0x67, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x22, 0xa9, 0x03, 0x0a, 0x21, 0x4f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x41,
+	0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x72, 0x73, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x4d, 0x0a, 0x06, 0x62, 0x72, 0x6f, 0x77, 0x73,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x35, 0x2e, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x62,
+	0x61, 0x72, 0x6e, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x2e, 0x61, 0x75, 0x74, 0x68, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65,
+	0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06,
+	0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x12, 0x51, 0x0a, 0x08, 0x64, 0x6f, 0x77, 0x6e, 0x6c, 0x6f,
+	0x61, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x35, 0x2e, 0x62, 0x75, 0x69, 0x6c, 0x64,
+	0x62, 0x61, 0x72, 0x6e, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x2e, 0x61, 0x75, 0x74, 0x68, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a,
+	0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52,
+	0x08, 0x64, 0x6f, 0x77, 0x6e, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x6f, 0x0a, 0x18, 0x6c, 0x69, 0x73,
+	0x74, 0x5f, 0x74, 0x61, 0x72, 0x62, 0x61, 0x6c, 0x6c, 0x5f, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x35, 0x2e, 0x62, 0x75,
+	0x69, 0x6c, 0x64, 0x62, 0x61, 0x72, 0x6e, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x75, 0x74, 0x68, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x6f,
+	0x72, 0x69, 0x7a, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x52, 0x16, 0x6c, 0x69, 0x73, 0x74, 0x54, 0x61, 0x72, 0x62, 0x61, 0x6c, 0x6c, 0x47,
+	0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x71, 0x0a, 0x19, 0x63, 0x61,
+	0x6e, 0x63, 0x65, 0x6c, 0x5f, 0x74, 0x61, 0x72, 0x62, 0x61, 0x6c, 0x6c, 0x5f, 0x67, 0x65, 0x6e,
+	0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x35, 0x2e,
+	0x62, 0x75, 0x69, 0x6c, 0x64, 0x62, 0x61, 0x72, 0x6e, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x75, 0x74, 0x68, 0x2e, 0x41, 0x75, 0x74,
+	0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x52, 0x17, 0x63, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x54, 0x61, 0x72, 0x62,
+	0x61, 0x6c, 0x6c, 0x47, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x22, 0x81, 0x02,
+	0x0a, 0x1f, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x69, 0x6d, 0x69,
+	0x74, 0x69, 0x6e, 0x67, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x12, 0x32, 0x0a, 0x15, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x61, 0x64, 0x64, 0x72,
+	0x65, 0x73, 0x73, 0x5f, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x13, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x48,
+	0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x50, 0x0a, 0x05, 0x70, 0x61, 0x67, 0x65, 0x73, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x3a, 0x2e, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x62, 0x61, 0x72, 0x6e,
+	0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x62,
+	0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x2e, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x69,
+	0x6d, 0x69, 0x74, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x52, 0x05, 0x70, 0x61, 0x67, 0x65, 0x73, 0x12, 0x58, 0x0a, 0x09, 0x64, 0x6f, 0x77, 0x6e, 0x6c,
+	0x6f, 0x61, 0x64, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x3a, 0x2e, 0x62, 0x75, 0x69,
+	0x6c, 0x64, 0x62, 0x61, 0x72, 0x6e, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x62, 0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x2e,
+	0x52, 0x61, 0x74, 0x65, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x64, 0x6f, 0x77, 0x6e, 0x6c, 0x6f, 0x61, 0x64,
+	0x73, 0x22, 0x63, 0x0a, 0x16, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x43, 0x6f,
+	0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x2a, 0x0a, 0x11, 0x74,
+	0x6f, 0x6b, 0x65, 0x6e, 0x73, 0x5f, 0x70, 0x65, 0x72, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x73, 0x50, 0x65,
+	0x72, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x75, 0x72, 0x73, 0x74,
+	0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x62, 0x75, 0x72,
+	0x73, 0x74, 0x53, 0x69, 0x7a, 0x65, 0x42, 0x44, 0x5a, 0x42, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
+	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x62, 0x61, 0x72, 0x6e, 0x2f, 0x62,
+	0x62, 0x2d, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x2f, 0x62, 0x62, 0x5f, 0x62, 0x72, 0x6f, 0x77, 0x73, 0x65, 0x72, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -222,27 +696,42 @@ func file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDescGZIP() []by
 	return file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDescData
 }
 
-var file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_pkg_proto_configuration_bb_browser_bb_browser_proto_goTypes = []interface{}{
-	(*ApplicationConfiguration)(nil),          // 0: buildbarn.configuration.bb_browser.ApplicationConfiguration
-	(*blobstore.BlobstoreConfiguration)(nil),  // 1: buildbarn.configuration.blobstore.BlobstoreConfiguration
-	(*http.ServerConfiguration)(nil),          // 2: buildbarn.configuration.http.ServerConfiguration
-	(*global.Configuration)(nil),              // 3: buildbarn.configuration.global.Configuration
-	(*blobstore.BlobAccessConfiguration)(nil), // 4: buildbarn.configuration.blobstore.BlobAccessConfiguration
-	(*auth.AuthorizerConfiguration)(nil),      // 5: buildbarn.configuration.auth.AuthorizerConfiguration
+	(*ApplicationConfiguration)(nil),            // 0: buildbarn.configuration.bb_browser.ApplicationConfiguration
+	(*TarballGenerationLimitConfiguration)(nil), // 1: buildbarn.configuration.bb_browser.TarballGenerationLimitConfiguration
+	(*OperationAuthorizersConfiguration)(nil),   // 2: buildbarn.configuration.bb_browser.OperationAuthorizersConfiguration
+	(*ClientRateLimitingConfiguration)(nil),     // 3: buildbarn.configuration.bb_browser.ClientRateLimitingConfiguration
+	(*RateLimitConfiguration)(nil),              // 4: buildbarn.configuration.bb_browser.RateLimitConfiguration
+	(*blobstore.BlobstoreConfiguration)(nil),    // 5: buildbarn.configuration.blobstore.BlobstoreConfiguration
+	(*http.ServerConfiguration)(nil),            // 6: buildbarn.configuration.http.ServerConfiguration
+	(*global.Configuration)(nil),                // 7: buildbarn.configuration.global.Configuration
+	(*blobstore.BlobAccessConfiguration)(nil),   // 8: buildbarn.configuration.blobstore.BlobAccessConfiguration
+	(*auth.AuthorizerConfiguration)(nil),        // 9: buildbarn.configuration.auth.AuthorizerConfiguration
+	(*durationpb.Duration)(nil),                 // 10: google.protobuf.Duration
 }
 var file_pkg_proto_configuration_bb_browser_bb_browser_proto_depIdxs = []int32{
-	1, // 0: buildbarn.configuration.bb_browser.ApplicationConfiguration.blobstore:type_name -> buildbarn.configuration.blobstore.BlobstoreConfiguration
-	2, // 1: buildbarn.configuration.bb_browser.ApplicationConfiguration.http_servers:type_name -> buildbarn.configuration.http.ServerConfiguration
-	3, // 2: buildbarn.configuration.bb_browser.ApplicationConfiguration.global:type_name -> buildbarn.configuration.global.Configuration
-	4, // 3: buildbarn.configuration.bb_browser.ApplicationConfiguration.initial_size_class_cache:type_name -> buildbarn.configuration.blobstore.BlobAccessConfiguration
-	4, // 4: buildbarn.configuration.bb_browser.ApplicationConfiguration.file_system_access_cache:type_name -> buildbarn.configuration.blobstore.BlobAccessConfiguration
-	5, // 5: buildbarn.configuration.bb_browser.ApplicationConfiguration.authorizer:type_name -> buildbarn.configuration.auth.AuthorizerConfiguration
-	6, // [6:6] is the sub-list for method output_type
-	6, // [6:6] is the sub-list for method input_type
-	6, // [6:6] is the sub-list for extension type_name
-	6, // [6:6] is the sub-list for extension extendee
-	0, // [0:6] is the sub-list for field type_name
+	5,  // 0: buildbarn.configuration.bb_browser.ApplicationConfiguration.blobstore:type_name -> buildbarn.configuration.blobstore.BlobstoreConfiguration
+	6,  // 1: buildbarn.configuration.bb_browser.ApplicationConfiguration.http_servers:type_name -> buildbarn.configuration.http.ServerConfiguration
+	7,  // 2: buildbarn.configuration.bb_browser.ApplicationConfiguration.global:type_name -> buildbarn.configuration.global.Configuration
+	8,  // 3: buildbarn.configuration.bb_browser.ApplicationConfiguration.initial_size_class_cache:type_name -> buildbarn.configuration.blobstore.BlobAccessConfiguration
+	8,  // 4: buildbarn.configuration.bb_browser.ApplicationConfiguration.file_system_access_cache:type_name -> buildbarn.configuration.blobstore.BlobAccessConfiguration
+	9,  // 5: buildbarn.configuration.bb_browser.ApplicationConfiguration.authorizer:type_name -> buildbarn.configuration.auth.AuthorizerConfiguration
+	3,  // 6: buildbarn.configuration.bb_browser.ApplicationConfiguration.client_rate_limiting:type_name -> buildbarn.configuration.bb_browser.ClientRateLimitingConfiguration
+	2,  // 7: buildbarn.configuration.bb_browser.ApplicationConfiguration.operation_authorizers:type_name -> buildbarn.configuration.bb_browser.OperationAuthorizersConfiguration
+	1,  // 8: buildbarn.configuration.bb_browser.ApplicationConfiguration.tarball_generation_limit:type_name -> buildbarn.configuration.bb_browser.TarballGenerationLimitConfiguration
+	10, // 9: buildbarn.configuration.bb_browser.TarballGenerationLimitConfiguration.maximum_queueing_duration:type_name -> google.protobuf.Duration
+	9,  // 10: buildbarn.configuration.bb_browser.OperationAuthorizersConfiguration.browse:type_name -> buildbarn.configuration.auth.AuthorizerConfiguration
+	9,  // 11: buildbarn.configuration.bb_browser.OperationAuthorizersConfiguration.download:type_name -> buildbarn.configuration.auth.AuthorizerConfiguration
+	9,  // 12: buildbarn.configuration.bb_browser.OperationAuthorizersConfiguration.list_tarball_generations:type_name -> buildbarn.configuration.auth.AuthorizerConfiguration
+	9,  // 13: buildbarn.configuration.bb_browser.OperationAuthorizersConfiguration.cancel_tarball_generation:type_name -> buildbarn.configuration.auth.AuthorizerConfiguration
+	4,  // 14: buildbarn.configuration.bb_browser.ClientRateLimitingConfiguration.pages:type_name -> buildbarn.configuration.bb_browser.RateLimitConfiguration
+	4,  // 15: buildbarn.configuration.bb_browser.ClientRateLimitingConfiguration.downloads:type_name -> buildbarn.configuration.bb_browser.RateLimitConfiguration
+	16, // [16:16] is the sub-list for method output_type
+	16, // [16:16] is the sub-list for method input_type
+	16, // [16:16] is the sub-list for extension type_name
+	16, // [16:16] is the sub-list for extension extendee
+	0,  // [0:16] is the sub-list for field type_name
 }
 
 func init() { file_pkg_proto_configuration_bb_browser_bb_browser_proto_init() }
@@ -263,6 +752,54 @@ func file_pkg_proto_configuration_bb_browser_bb_browser_proto_init() {
 				return nil
 			}
 		}
+		file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TarballGenerationLimitConfiguration); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*OperationAuthorizersConfiguration); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ClientRateLimitingConfiguration); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_proto_configuration_bb_browser_bb_browser_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RateLimitConfiguration); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -270,7 +807,7 @@ func file_pkg_proto_configuration_bb_browser_bb_browser_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},